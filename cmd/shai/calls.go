@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/colony-2/shai/pkg/shai"
+	"github.com/spf13/cobra"
+)
+
+func newCallsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "calls",
+		Short: "Inspect host calls made from sandboxes",
+	}
+	cmd.AddCommand(newCallsLogCmd())
+	return cmd
+}
+
+func newCallsLogCmd() *cobra.Command {
+	var (
+		auditDir string
+		session  string
+		asJSON   bool
+	)
+	cmd := &cobra.Command{
+		Use:   "log",
+		Short: "Show the host call audit log",
+		Long:  "Show every host call recorded in the audit log, oldest first. Each sandbox session writes its own JSONL file under the audit directory.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			records, err := shai.ReadCallLog(shai.CallAuditDir(auditDir), session)
+			if err != nil {
+				return fmt.Errorf("read call audit log: %w", err)
+			}
+			if asJSON {
+				return writeCallLogJSON(cmd.OutOrStdout(), records)
+			}
+			return writeCallLogTable(cmd.OutOrStdout(), records)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&auditDir, "audit-dir", "", "Audit log directory (default: $SHAI_AUDIT_DIR or ~/.local/state/shai/calls)")
+	flags.StringVar(&session, "session", "", "Only show calls from this session")
+	flags.BoolVar(&asJSON, "json", false, "Print raw JSONL records")
+	return cmd
+}
+
+func writeCallLogJSON(w io.Writer, records []shai.CallRecord) error {
+	enc := json.NewEncoder(w)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCallLogTable(w io.Writer, records []shai.CallRecord) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tSESSION\tCALL\tRESULT\tDURATION\tOUTPUT\tCOMMAND\tREASON")
+	for _, rec := range records {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%dB\t%s\t%s\n",
+			rec.Time.Local().Format(time.DateTime),
+			shortSession(rec.Session),
+			rec.Call,
+			callResult(rec),
+			(time.Duration(rec.DurationMS) * time.Millisecond).String(),
+			rec.OutputBytes,
+			strings.Join(rec.Argv, " "),
+			rec.Denied+rec.Error,
+		)
+	}
+	return tw.Flush()
+}
+
+func callResult(rec shai.CallRecord) string {
+	switch {
+	case rec.Denied != "":
+		return "denied"
+	case rec.Error != "":
+		return "error"
+	case rec.ExitCode != nil:
+		return fmt.Sprintf("exit %d", *rec.ExitCode)
+	}
+	return "-"
+}
+
+func shortSession(session string) string {
+	if len(session) > 8 {
+		return session[:8]
+	}
+	return session
+}
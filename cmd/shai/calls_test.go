@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colony-2/shai/pkg/shai"
+)
+
+func TestWriteCallLogTable(t *testing.T) {
+	zero := 0
+	records := []shai.CallRecord{
+		{Time: time.Now(), Session: "0123456789abcdef", Call: "status", Argv: []string{"git", "status"}, ExitCode: &zero, DurationMS: 1500, OutputBytes: 42},
+		{Time: time.Now(), Session: "0123456789abcdef", Call: "push", Argv: []string{"git", "push"}, Denied: "call \"push\" was denied on the host"},
+	}
+	var out bytes.Buffer
+	if err := writeCallLogTable(&out, records); err != nil {
+		t.Fatalf("writeCallLogTable: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected header and 2 rows, got %q", out.String())
+	}
+	for _, want := range []string{"01234567", "status", "exit 0", "1.5s", "42B", "git status"} {
+		if !strings.Contains(lines[1], want) {
+			t.Fatalf("row %q missing %q", lines[1], want)
+		}
+	}
+	if !strings.Contains(lines[2], "denied") || !strings.Contains(lines[2], "was denied on the host") {
+		t.Fatalf("denied row %q missing reason", lines[2])
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/colony-2/shai/pkg/shai"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the resolved shai config",
+	}
+	cmd.AddCommand(newConfigShowCmd())
+	cmd.AddCommand(newConfigExplainCmd())
+	cmd.AddCommand(newConfigValidateCmd())
+	cmd.AddCommand(newConfigSchemaCmd())
+	cmd.AddCommand(newConfigMigrateCmd())
+	return cmd
+}
+
+func newConfigShowCmd() *cobra.Command {
+	var (
+		configPath    string
+		templatePairs []string
+		sources       bool
+	)
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Print the config after merging the org, user and workspace layers",
+		Long:  "Print the config after merging the org (/etc/shai/config.yaml), user ($XDG_CONFIG_HOME/shai/config.yaml) and workspace layers. With --sources, list where each resource set, mount and http host was declared instead.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			varMap, err := parseTemplateVars(templatePairs)
+			if err != nil {
+				return err
+			}
+			workingDir, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("failed to get working directory: %w", err)
+			}
+			cfg, err := shai.LoadConfig(workingDir, configPath, varMap)
+			if err != nil {
+				return fmt.Errorf("failed to load shai config: %w", err)
+			}
+			if sources {
+				return writeConfigSources(cmd.OutOrStdout(), cfg)
+			}
+			enc := yaml.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent(2)
+			if err := enc.Encode(cfg); err != nil {
+				return err
+			}
+			return enc.Close()
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&configPath, "config", "c", "", fmt.Sprintf("Path to the workspace config (default: <workspace>/%s)", shai.DefaultConfigRelPath))
+	flags.StringArrayVarP(&templatePairs, "var", "v", nil, "Template variable (key=value)")
+	flags.BoolVar(&sources, "sources", false, "Show which layer each resource set, mount and http host came from")
+	return cmd
+}
+
+func writeConfigSources(w io.Writer, cfg *shai.Config) error {
+	names := make([]string, 0, len(cfg.Resources))
+	for name := range cfg.Resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SET\tKIND\tENTRY\tLAYER\tSOURCE")
+	row := func(set, kind, entry string, src shai.ConfigSource) {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", set, kind, entry, src.Layer, src)
+	}
+	for _, name := range names {
+		set := cfg.Resources[name]
+		row(name, "set", "-", set.Source())
+		for i, mount := range set.Mounts {
+			row(name, "mount", fmt.Sprintf("%s: %s -> %s (%s)", mount.Name, mount.Source, mount.Target, mount.Mode), set.MountSource(i))
+		}
+		for i, rule := range set.HTTP {
+			entry := rule.Host
+			if len(rule.Ports) > 0 {
+				entry += fmt.Sprintf(" (ports %s)", strings.Trim(fmt.Sprint(rule.Ports), "[]"))
+			}
+			row(name, "http", entry, set.HTTPSource(i))
+		}
+	}
+	return tw.Flush()
+}
+
+func newConfigValidateCmd() *cobra.Command {
+	var (
+		configPath    string
+		templatePairs []string
+	)
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check the config and report every problem",
+		Long:  "Load the org, user and workspace config layers and report every problem at once: unknown fields with their line and column, invalid values, and missing required settings. Exits non-zero when any are found.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			varMap, err := parseTemplateVars(templatePairs)
+			if err != nil {
+				return err
+			}
+			workingDir, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("failed to get working directory: %w", err)
+			}
+			cfg, err := shai.LoadConfig(workingDir, configPath, varMap)
+			var invalid *shai.ConfigValidationError
+			if errors.As(err, &invalid) {
+				for _, problem := range invalid.Problems {
+					fmt.Fprintln(cmd.OutOrStdout(), problem)
+				}
+				return fmt.Errorf("found %d problem(s) in shai config", len(invalid.Problems))
+			}
+			if err != nil {
+				return fmt.Errorf("failed to load shai config: %w", err)
+			}
+			for _, warning := range cfg.Warnings() {
+				fmt.Fprintf(cmd.OutOrStdout(), "warning: %s\n", warning)
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Config is valid")
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&configPath, "config", "c", "", fmt.Sprintf("Path to the workspace config (default: <workspace>/%s)", shai.DefaultConfigRelPath))
+	flags.StringArrayVarP(&templatePairs, "var", "v", nil, "Template variable (key=value)")
+	return cmd
+}
+
+func newConfigSchemaCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema for shai config files",
+		Long:  "Print the JSON Schema for shai-sandbox config files, for editor completion and validation. Save it and point your editor at it, for example with a `# yaml-language-server: $schema=<path>` comment.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			schema, err := shai.ConfigSchema()
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(cmd.OutOrStdout(), "%s\n", schema)
+			return err
+		},
+	}
+}
+
+func newConfigMigrateCmd() *cobra.Command {
+	var (
+		configPath string
+		dryRun     bool
+	)
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Rewrite a config file in the newest format",
+		Long:  fmt.Sprintf("Rewrite a config file to version %d, keeping its comments. Older versions keep loading, so migrating is optional. Included files are not rewritten; migrate each with --config.", shai.ConfigVersion),
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := configPath
+			if path == "" {
+				path = shai.DefaultConfigRelPath
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read shai config: %w", err)
+			}
+			migrated, changed, err := shai.MigrateConfig(data)
+			if err != nil {
+				return fmt.Errorf("failed to migrate %s: %w", path, err)
+			}
+			if dryRun {
+				_, err := cmd.OutOrStdout().Write(migrated)
+				return err
+			}
+			if !changed {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s is already version %d\n", path, shai.ConfigVersion)
+				return nil
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(path, migrated, info.Mode().Perm()); err != nil {
+				return fmt.Errorf("failed to write %s: %w", path, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Migrated %s to version %d\n", path, shai.ConfigVersion)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&configPath, "config", "c", "", fmt.Sprintf("Config file to migrate (default: %s)", shai.DefaultConfigRelPath))
+	flags.BoolVar(&dryRun, "dry-run", false, "Print the migrated config instead of writing it")
+	return cmd
+}
+
+func newConfigExplainCmd() *cobra.Command {
+	var (
+		configPath     string
+		templatePairs  []string
+		readWritePaths []string
+		resourceSets   []string
+		imageOverride  string
+		asJSON         bool
+	)
+	cmd := &cobra.Command{
+		Use:   "explain [--read-write <path>] [--resource-set <set>]",
+		Short: "Explain which resources a sandbox would get and why",
+		Long:  "Resolve the config the way shai would for the given paths and resource sets, without starting a sandbox. Prints the apply rules that matched and why, the chosen image and its source, the merged http hosts, ports, mounts, vars, calls and root commands with the resource set each came from, and the bootstrap arguments.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			varMap, err := parseTemplateVars(templatePairs)
+			if err != nil {
+				return err
+			}
+			workingDir, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("failed to get working directory: %w", err)
+			}
+			explanation, err := shai.ExplainSandbox(shai.SandboxConfig{
+				WorkingDir:     workingDir,
+				ConfigFile:     configPath,
+				TemplateVars:   varMap,
+				ReadWritePaths: readWritePaths,
+				ResourceSets:   resourceSets,
+				ImageOverride:  imageOverride,
+			})
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(explanation)
+			}
+			return writeExplanation(cmd.OutOrStdout(), explanation)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringArrayVar(&readWritePaths, "read-write", nil, "Path that would be mounted read-write (repeatable, alias: -rw)")
+	flags.StringArrayVar(&resourceSets, "resource-set", nil, "Resource set to activate (repeatable, alias: -rs)")
+	flags.StringVarP(&imageOverride, "image", "i", "", "Image override")
+	flags.StringVarP(&configPath, "config", "c", "", fmt.Sprintf("Path to the workspace config (default: <workspace>/%s)", shai.DefaultConfigRelPath))
+	flags.StringArrayVarP(&templatePairs, "var", "v", nil, "Template variable (key=value)")
+	flags.BoolVar(&asJSON, "json", false, "Print the explanation as JSON")
+	return cmd
+}
+
+func writeExplanation(w io.Writer, e *shai.Explanation) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	section := func(title string) {
+		fmt.Fprintf(tw, "\n%s\n", title)
+	}
+
+	fmt.Fprintln(tw, "Apply rules")
+	for _, p := range e.Paths {
+		fmt.Fprintf(tw, "  path %s\n", p.Path)
+		if len(p.Rules) == 0 {
+			fmt.Fprintln(tw, "    (no apply rules)")
+		}
+		for _, rule := range p.Rules {
+			result := "skip"
+			if rule.Matched {
+				result = "match"
+			}
+			names := append([]string(nil), rule.Resources...)
+			for _, name := range rule.Exclude {
+				names = append(names, "-"+name)
+			}
+			target := strings.Join(names, ", ")
+			if rule.Image != "" {
+				target += " (image " + rule.Image + ")"
+			}
+			fmt.Fprintf(tw, "    %s\t[%d] %s -> %s\t%s\t%s\n", result, rule.Index, rule.Path, target, rule.Reason, rule.Source)
+		}
+	}
+
+	section("Resource sets")
+	if len(e.ResourceSets) == 0 {
+		fmt.Fprintln(tw, "  (none)")
+	}
+	for _, set := range e.ResourceSets {
+		fmt.Fprintf(tw, "  %s\tvia %s\t%s %s\n", set.Name, set.Via, set.Source.Layer, set.Source)
+	}
+
+	section("Image")
+	fmt.Fprintf(tw, "  %s\tfrom %s\n", e.Image.Name, e.Image.Source)
+
+	entries := func(title string, list []shai.ExplainedEntry) {
+		if len(list) == 0 {
+			return
+		}
+		section(title)
+		for _, entry := range list {
+			fmt.Fprintf(tw, "  %s\t%s\n", entry.Value, entry.Set)
+		}
+	}
+	entries("HTTP", e.HTTP)
+	entries("Ports", e.Ports)
+	if len(e.Mounts) > 0 {
+		section("Mounts")
+		for _, m := range e.Mounts {
+			fmt.Fprintf(tw, "  %s -> %s (%s)\t%s\n", m.Source, m.Target, m.Mode, m.Set)
+		}
+	}
+	if len(e.Vars) > 0 {
+		section("Vars")
+		for _, v := range e.Vars {
+			note := ""
+			if !v.HostSet {
+				note = "\tnot set on the host"
+			}
+			target := v.Target
+			if v.As == "file" {
+				target += " (file)"
+			}
+			fmt.Fprintf(tw, "  %s -> %s\t%s%s\n", v.Source, target, v.Set, note)
+		}
+	}
+	entries("Calls", e.Calls)
+	entries("Root commands", e.RootCommands)
+	entries("Exposed ports", e.Expose)
+
+	section("Bootstrap args")
+	args := e.BootstrapArgs
+	for i := 0; i < len(args); i++ {
+		line := shellQuote(args[i])
+		if strings.HasPrefix(args[i], "--") && i+1 < len(args) && !strings.HasPrefix(args[i+1], "--") {
+			line += " " + shellQuote(args[i+1])
+			i++
+		}
+		fmt.Fprintf(tw, "  %s\n", line)
+	}
+	return tw.Flush()
+}
+
+// shellQuote quotes s for display when it contains anything a shell would
+// interpret.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=@,+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
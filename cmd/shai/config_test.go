@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/colony-2/shai/pkg/shai"
+)
+
+func TestWriteConfigSources(t *testing.T) {
+	dir := t.TempDir()
+	userPath := filepath.Join(dir, "user.yaml")
+	if err := os.WriteFile(userPath, []byte("resources:\n  personal:\n    mounts:\n      - source: /tmp\n        target: /home/shai/.claude\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	workspace := filepath.Join(dir, "ws")
+	if err := os.MkdirAll(filepath.Join(workspace, shai.ConfigDirName), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	wsConfig := "type: shai-sandbox\nversion: 1\nimage: example\nresources:\n  repo:\n    http: [api.example.com]\napply:\n  - path: ./\n    resources: [repo, personal]\n"
+	if err := os.WriteFile(filepath.Join(workspace, shai.DefaultConfigRelPath), []byte(wsConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SHAI_ORG_CONFIG", filepath.Join(dir, "missing.yaml"))
+	t.Setenv("SHAI_USER_CONFIG", userPath)
+
+	cfg, err := shai.LoadConfig(workspace, "", nil)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	var out bytes.Buffer
+	if err := writeConfigSources(&out, cfg); err != nil {
+		t.Fatalf("writeConfigSources: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected header and 4 rows, got %q", out.String())
+	}
+	for i, want := range [][]string{
+		{"personal", "set", "user", userPath + ":2"},
+		{"personal", "mount", "/tmp -> /home/shai/.claude (ro)", "user", userPath + ":4"},
+		{"repo", "set", "workspace", "config.yaml:5"},
+		{"repo", "http", "api.example.com", "workspace", "config.yaml:6"},
+	} {
+		for _, field := range want {
+			if !strings.Contains(lines[i+1], field) {
+				t.Fatalf("row %q missing %q", lines[i+1], field)
+			}
+		}
+	}
+}
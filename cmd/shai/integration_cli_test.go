@@ -26,6 +26,8 @@ const testImage = "ghcr.io/colony-2/shai-base:latest"
 
 // TestMain runs before all tests in this package to pre-pull required Docker images
 func TestMain(m *testing.M) {
+	// Tests write throwaway workspace configs that are never trusted.
+	_ = os.Setenv("SHAI_TRUST_ALL", "1")
 
 	fmt.Printf("Pre-pulling Docker image %s (this may take a while)...\n", testImage)
 	if err := pullDockerImage(testImage); err != nil {
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -26,11 +28,25 @@ var (
 func main() {
 	os.Args = normalizeLegacyArgs(os.Args)
 	if err := newRootCmd().Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(reportError(os.Stderr, err))
 	}
 }
 
+// reportError prints err and returns the status shai exits with: the
+// sandbox's own status when it exited non-zero, and 1 otherwise. A failed
+// command has already said why, so only its status is passed on.
+func reportError(w io.Writer, err error) int {
+	var exitErr *shai.ExitError
+	if errors.As(err, &exitErr) {
+		if exitErr.Kind != shai.ExitCommand {
+			fmt.Fprintf(w, "shai: %v\n", err)
+		}
+		return exitErr.Code
+	}
+	fmt.Fprintln(w, err)
+	return 1
+}
+
 func newRootCmd() *cobra.Command {
 	var (
 		readWritePaths []string
@@ -40,9 +56,14 @@ func newRootCmd() *cobra.Command {
 		imageOverride  string
 		userOverride   string
 		containerName  string
+		detach         bool
+		overlay        bool
 		privileged     bool
 		verbose        bool
 		noTTY          bool
+		approvalHook   string
+		approvalList   string
+		auditDir       string
 	)
 
 	cmd := &cobra.Command{
@@ -51,6 +72,20 @@ func newRootCmd() *cobra.Command {
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if containerName != "" {
+				if err := shai.ValidateSandboxName(containerName); err != nil {
+					return err
+				}
+			}
+			if detach {
+				if containerName == "" {
+					return fmt.Errorf("--detach needs --name")
+				}
+				if os.Getenv(supervisorEnv) == "" {
+					return startDetached(cmd.OutOrStdout(), containerName)
+				}
+			}
+
 			varMap, err := parseTemplateVars(templatePairs)
 			if err != nil {
 				return err
@@ -73,12 +108,9 @@ func newRootCmd() *cobra.Command {
 			ctx, cancel := setupSignals()
 			defer cancel()
 
-			if err := runEphemeral(ctx, workingDir, readWritePaths, verbose, postExec, configPath, varMap, resourceSets, imageOverride, userOverride, privileged); err != nil {
-				return err
-			}
-
-			_ = containerName // Flag retained for future naming support.
-			return nil
+			calls := callSettings{approvalHook: approvalHook, approvalAllowlist: approvalList, auditDir: auditDir}
+			sandbox := sandboxSettings{name: containerName, detach: detach, overlay: overlay}
+			return runEphemeral(ctx, workingDir, readWritePaths, verbose, postExec, configPath, varMap, resourceSets, imageOverride, userOverride, privileged, calls, sandbox)
 		},
 	}
 
@@ -89,13 +121,30 @@ func newRootCmd() *cobra.Command {
 	flags.StringArrayVarP(&templatePairs, "var", "v", nil, fmt.Sprintf("Template variable for %s (key=value)", shai.DefaultConfigRelPath))
 	flags.StringVarP(&imageOverride, "image", "i", "", "Override container image (highest precedence)")
 	flags.StringVarP(&userOverride, "user", "u", "", "Override target user (highest precedence)")
-	flags.StringVarP(&containerName, "name", "n", "", "Container name (optional)")
+	flags.StringVarP(&containerName, "name", "n", "", "Sandbox name, for attach and rm (optional)")
+	flags.BoolVarP(&detach, "detach", "d", false, "Run in the background; reconnect with `shai attach <name>` (needs --name)")
+	flags.BoolVar(&overlay, "overlay", false, "Make the whole workspace writable, keeping changes aside for `shai diff` and `shai apply`")
 	flags.BoolVar(&privileged, "privileged", false, "Run container in privileged mode")
 	flags.BoolVarP(&verbose, "verbose", "V", false, "Enable verbose logging")
 	flags.BoolVarP(&noTTY, "no-tty", "T", false, "Disable TTY for post-setup command")
+	flags.StringVar(&approvalHook, "approval-hook", "", "Command that approves calls requiring approval (exit 0 approves)")
+	flags.StringVar(&approvalList, "approval-allowlist", "", "File of pre-approved calls")
+	flags.StringVar(&auditDir, "audit-dir", "", "Directory for the host call audit log (default: $SHAI_AUDIT_DIR or ~/.local/state/shai/calls)")
 
 	cmd.AddCommand(newVersionCmd())
 	cmd.AddCommand(newGenerateCmd())
+	cmd.AddCommand(newCallsCmd())
+	cmd.AddCommand(newConfigCmd())
+	cmd.AddCommand(newTrustCmd())
+	cmd.AddCommand(newAttachCmd())
+	cmd.AddCommand(newExecCmd())
+	cmd.AddCommand(newRmCmd())
+	cmd.AddCommand(newPsCmd())
+	cmd.AddCommand(newStopCmd())
+	cmd.AddCommand(newLogsCmd())
+	cmd.AddCommand(newDiffCmd())
+	cmd.AddCommand(newApplyCmd())
+	cmd.AddCommand(newDiscardCmd())
 
 	return cmd
 }
@@ -164,26 +213,55 @@ func normalizeLegacyArgs(args []string) []string {
 	return out
 }
 
-func runEphemeral(ctx context.Context, workingDir string, rwPaths []string, verbose bool, postExec *shai.SandboxExec, configPath string, vars map[string]string, resourceSets []string, imageOverride, userOverride string, privileged bool) error {
+// callSettings carries the flags that govern host calls.
+type callSettings struct {
+	approvalHook      string
+	approvalAllowlist string
+	auditDir          string
+}
+
+// sandboxSettings carries the flags that name and detach the sandbox and
+// choose an overlay workspace.
+type sandboxSettings struct {
+	name    string
+	detach  bool
+	overlay bool
+}
+
+func runEphemeral(ctx context.Context, workingDir string, rwPaths []string, verbose bool, postExec *shai.SandboxExec, configPath string, vars map[string]string, resourceSets []string, imageOverride, userOverride string, privileged bool, calls callSettings, settings sandboxSettings) error {
 	sandbox, err := shai.NewSandbox(shai.SandboxConfig{
-		WorkingDir:     workingDir,
-		ConfigFile:     configPath,
-		TemplateVars:   vars,
-		ReadWritePaths: rwPaths,
-		ResourceSets:   resourceSets,
-		Verbose:        verbose,
-		PostSetupExec:  postExec,
-		ImageOverride:  imageOverride,
-		UserOverride:   userOverride,
-		Privileged:     privileged,
-		ShowProgress:   true,
+		WorkingDir:        workingDir,
+		ConfigFile:        configPath,
+		TemplateVars:      vars,
+		ReadWritePaths:    rwPaths,
+		ResourceSets:      resourceSets,
+		Verbose:           verbose,
+		PostSetupExec:     postExec,
+		ImageOverride:     imageOverride,
+		UserOverride:      userOverride,
+		Privileged:        privileged,
+		ShowProgress:      true,
+		ApprovalHook:      calls.approvalHook,
+		ApprovalAllowlist: calls.approvalAllowlist,
+		AuditDir:          calls.auditDir,
+		Name:              settings.name,
+		Detach:            settings.detach,
+		Version:           version,
+		Overlay:           settings.overlay,
 	})
 	if err != nil {
 		return err
 	}
 	defer sandbox.Close()
 
-	return sandbox.Run(ctx)
+	if settings.detach {
+		return superviseDetached(ctx, sandbox)
+	}
+	err = sandbox.Run(ctx)
+	if settings.overlay {
+		reportOverlayChanges(os.Stderr, workingDir)
+	}
+	return err
 }
 
 func generateDefaultConfig() error {
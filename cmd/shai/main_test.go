@@ -1,8 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/colony-2/shai/pkg/shai"
 )
 
 func TestParseTemplateVars(t *testing.T) {
@@ -33,3 +39,18 @@ func TestNormalizeLegacyArgs(t *testing.T) {
 		t.Fatalf("expected %v, got %v", expected, got)
 	}
 }
+
+func TestReportErrorPassesExitStatus(t *testing.T) {
+	var out bytes.Buffer
+	if code := reportError(&out, &shai.ExitError{Code: 2, Kind: shai.ExitCommand}); code != 2 || out.Len() != 0 {
+		t.Fatalf("command failure: code %d, output %q", code, out.String())
+	}
+	out.Reset()
+	if code := reportError(&out, fmt.Errorf("run: %w", &shai.ExitError{Code: 90, Kind: shai.ExitBootstrap})); code != 90 || !strings.Contains(out.String(), "setup failed") {
+		t.Fatalf("bootstrap failure: code %d, output %q", code, out.String())
+	}
+	out.Reset()
+	if code := reportError(&out, errors.New("no docker")); code != 1 || !strings.Contains(out.String(), "no docker") {
+		t.Fatalf("shai error: code %d, output %q", code, out.String())
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/colony-2/shai/pkg/shai"
+	"github.com/spf13/cobra"
+)
+
+func newDiffCmd() *cobra.Command {
+	var (
+		summary bool
+		asJSON  bool
+	)
+	cmd := &cobra.Command{
+		Use:   "diff [path...]",
+		Short: "Show the changes an overlay sandbox made to the workspace",
+		Long:  "Show the changes that sandboxes started with --overlay made to the current workspace and that are not yet applied, as a unified diff. Paths limit the diff to changes at or under them. Changes under .shai are never shown or applied.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			workingDir, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("failed to get working directory: %w", err)
+			}
+			if !summary && !asJSON {
+				return shai.WriteWorkspaceDiff(cmd.OutOrStdout(), workingDir, args)
+			}
+			changes, err := shai.WorkspaceChanges(workingDir, args)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				if changes == nil {
+					changes = []shai.WorkspaceChange{}
+				}
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(changes)
+			}
+			writeChangeSummary(cmd.OutOrStdout(), changes)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.BoolVarP(&summary, "summary", "s", false, "List the changed paths instead of the diff")
+	flags.BoolVar(&asJSON, "json", false, "Print the changed paths as JSON")
+	return cmd
+}
+
+func newApplyCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "apply [path...]",
+		Short: "Apply an overlay sandbox's changes to the workspace",
+		Long:  "Copy the pending overlay changes at or under the given paths into the current workspace, or all of them, which also clears the overlay. Changes under .shai are never applied.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			workingDir, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("failed to get working directory: %w", err)
+			}
+			applied, err := shai.ApplyWorkspaceChanges(cmd.Context(), workingDir, args)
+			writeChangeSummary(cmd.OutOrStdout(), applied)
+			return err
+		},
+	}
+}
+
+func newDiscardCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "discard",
+		Short: "Drop an overlay sandbox's changes",
+		Long:  "Drop every pending overlay change to the current workspace, leaving the workspace as it is.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			workingDir, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("failed to get working directory: %w", err)
+			}
+			changes, err := shai.WorkspaceChanges(workingDir, nil)
+			if err != nil {
+				return err
+			}
+			if err := shai.DiscardWorkspaceChanges(cmd.Context(), workingDir); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Discarded %d change(s)\n", len(changes))
+			return nil
+		},
+	}
+}
+
+// writeChangeSummary lists changes one per line, marked A, M or D as git
+// status does, with a trailing slash on directories.
+func writeChangeSummary(w io.Writer, changes []shai.WorkspaceChange) {
+	for _, change := range changes {
+		mark := "M"
+		switch change.Kind {
+		case shai.ChangeAdded:
+			mark = "A"
+		case shai.ChangeDeleted:
+			mark = "D"
+		}
+		suffix := ""
+		if change.Dir {
+			suffix = "/"
+		}
+		fmt.Fprintf(w, "%s %s%s\n", mark, change.Path, suffix)
+	}
+}
+
+// reportOverlayChanges tells the user, once an overlay session ends, how
+// many changes wait for review.
+func reportOverlayChanges(w io.Writer, workingDir string) {
+	changes, err := shai.WorkspaceChanges(workingDir, nil)
+	if err != nil || len(changes) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%d change(s) kept in the overlay; review with `shai diff`, then `shai apply` or `shai discard`\n", len(changes))
+}
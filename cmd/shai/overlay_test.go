@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/colony-2/shai/pkg/shai"
+)
+
+func TestWriteChangeSummary(t *testing.T) {
+	var out bytes.Buffer
+	writeChangeSummary(&out, []shai.WorkspaceChange{
+		{Path: "README.md", Kind: shai.ChangeModified},
+		{Path: "pkg", Kind: shai.ChangeAdded, Dir: true},
+		{Path: "pkg/new.go", Kind: shai.ChangeAdded},
+		{Path: "old", Kind: shai.ChangeDeleted, Dir: true},
+	})
+	want := "M README.md\nA pkg/\nA pkg/new.go\nD old/\n"
+	if out.String() != want {
+		t.Fatalf("writeChangeSummary = %q, want %q", out.String(), want)
+	}
+}
+
+func TestDiffOutsideWorkspace(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+	cmd := newRootCmd()
+	cmd.SetArgs([]string{"diff", "../elsewhere"})
+	cmd.SetOut(&bytes.Buffer{})
+	if err := cmd.Execute(); err == nil {
+		t.Fatal("expected diff of a path outside the workspace to fail")
+	}
+}
+
+func TestDiffWithoutOverlay(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+	var out bytes.Buffer
+	cmd := newRootCmd()
+	cmd.SetArgs([]string{"diff", "--summary"})
+	cmd.SetOut(&out)
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("diff with no overlay: %v", err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("expected no changes, got %q", out.String())
+	}
+}
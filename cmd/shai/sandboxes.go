@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/colony-2/shai/pkg/shai"
+	"github.com/spf13/cobra"
+)
+
+// supervisorEnv marks the background process that `shai --detach` starts
+// to own a detached sandbox. Its readiness pipe is supervisorReadyFD.
+const (
+	supervisorEnv     = "SHAI_DETACHED_SUPERVISOR"
+	supervisorReadyFD = 3
+)
+
+// logTailLines is how much of the supervisor log a failed start shows.
+const logTailLines = 20
+
+func newAttachCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "attach <name|id>",
+		Short: "Reconnect the terminal to a detached sandbox",
+		Long:  "Reconnect the terminal to a sandbox started with --name and --detach. Ctrl-C goes to the sandbox; detach again with ctrl-p ctrl-q.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := setupSignals()
+			defer cancel()
+			return shai.AttachSandbox(ctx, args[0])
+		},
+	}
+}
+
+func newExecCmd() *cobra.Command {
+	var (
+		workdir  string
+		envPairs []string
+		noTTY    bool
+	)
+	cmd := &cobra.Command{
+		Use:   "exec <name|id> [-- command ...]",
+		Short: "Run a command in a running sandbox",
+		Long:  "Run a command in a running sandbox as its user, in the workspace, with the same environment and egress rules as the sandbox's own command. With no command, start the user's login shell.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			env, err := parseTemplateVars(envPairs)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := setupSignals()
+			defer cancel()
+			return shai.ExecSandbox(ctx, args[0], shai.SandboxExec{
+				Command: args[1:],
+				Env:     env,
+				Workdir: workdir,
+				UseTTY:  !noTTY,
+			})
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&workdir, "workdir", "w", "", "Working directory in the sandbox (default: the workspace)")
+	flags.StringArrayVarP(&envPairs, "env", "e", nil, "Environment variable for the command (KEY=value, repeatable)")
+	flags.BoolVarP(&noTTY, "no-tty", "T", false, "Disable TTY for the command")
+	return cmd
+}
+
+func newRmCmd() *cobra.Command {
+	var force bool
+	cmd := &cobra.Command{
+		Use:   "rm <name|id>...",
+		Short: "Remove stopped sandboxes",
+		Long:  "Remove sandboxes kept after they exit, which are the ones started with --detach, along with their bootstrap files. A running sandbox is only removed with --force.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var errs []error
+			for _, name := range args {
+				if err := shai.RemoveSandbox(cmd.Context(), name, force); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), name)
+			}
+			return errors.Join(errs...)
+		},
+	}
+	cmd.Flags().BoolVarP(&force, "force", "f", false, "Stop and remove running sandboxes")
+	return cmd
+}
+
+// defaultStopTimeout matches docker stop.
+const defaultStopTimeout = 10 * time.Second
+
+func newPsCmd() *cobra.Command {
+	var (
+		all    bool
+		asJSON bool
+	)
+	cmd := &cobra.Command{
+		Use:   "ps",
+		Short: "List running sandboxes",
+		Long:  "List running sandboxes with the workspace, read-write paths, resource sets and image they were started with.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			infos, err := shai.ListSandboxes(cmd.Context(), all)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(infos)
+			}
+			return writeSandboxTable(cmd.OutOrStdout(), infos)
+		},
+	}
+	flags := cmd.Flags()
+	flags.BoolVarP(&all, "all", "a", false, "Include stopped sandboxes that were kept with --detach")
+	flags.BoolVar(&asJSON, "json", false, "Print the sandboxes as JSON")
+	return cmd
+}
+
+func writeSandboxTable(w io.Writer, infos []shai.SandboxInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tID\tSTATUS\tWORKSPACE\tREAD-WRITE\tRESOURCE SETS\tIMAGE")
+	for _, info := range infos {
+		readWrite := strings.Join(info.ReadWrite, ",")
+		if info.Overlay != "" {
+			readWrite = "overlay"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			orDash(info.Name),
+			shortID(info.ID),
+			info.Status,
+			info.Workspace,
+			orDash(readWrite),
+			orDash(strings.Join(info.ResourceSets, ",")),
+			info.Image,
+		)
+	}
+	return tw.Flush()
+}
+
+func newStopCmd() *cobra.Command {
+	var (
+		all       bool
+		workspace string
+		timeout   time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "stop [<name|id>...]",
+		Short: "Stop running sandboxes",
+		Long:  "Stop sandboxes by name or container ID, every running sandbox with --all, or those started from a workspace with --workspace (default: the current directory). Sandboxes started without --detach are removed once they stop.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			selectors := len(args)
+			if all {
+				selectors++
+			}
+			if workspace != "" {
+				selectors++
+			}
+			if selectors == 0 {
+				return errors.New("name the sandboxes to stop, or use --all or --workspace")
+			}
+			if (all || workspace != "") && selectors > 1 {
+				return errors.New("--all and --workspace can't be combined with each other or with names")
+			}
+
+			refs := args
+			if all || workspace != "" {
+				infos, err := shai.ListSandboxes(cmd.Context(), false)
+				if err != nil {
+					return err
+				}
+				if workspace != "" {
+					abs, err := filepath.Abs(workspace)
+					if err != nil {
+						return err
+					}
+					workspace = abs
+				}
+				refs = nil
+				for _, info := range sandboxesInWorkspace(infos, workspace) {
+					refs = append(refs, sandboxRef(info))
+				}
+			}
+
+			var errs []error
+			for _, ref := range refs {
+				if err := shai.StopSandbox(cmd.Context(), ref, timeout); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), ref)
+			}
+			return errors.Join(errs...)
+		},
+	}
+	flags := cmd.Flags()
+	flags.BoolVar(&all, "all", false, "Stop every running sandbox")
+	flags.StringVar(&workspace, "workspace", "", "Stop the sandboxes started from this workspace")
+	flags.Lookup("workspace").NoOptDefVal = "."
+	flags.DurationVarP(&timeout, "time", "t", defaultStopTimeout, "How long to wait for the sandbox to exit before killing it")
+	return cmd
+}
+
+// sandboxesInWorkspace returns the sandboxes started from workspace, or all
+// of them when workspace is empty.
+func sandboxesInWorkspace(infos []shai.SandboxInfo, workspace string) []shai.SandboxInfo {
+	if workspace == "" {
+		return infos
+	}
+	var out []shai.SandboxInfo
+	for _, info := range infos {
+		if info.Workspace == workspace {
+			out = append(out, info)
+		}
+	}
+	return out
+}
+
+func newLogsCmd() *cobra.Command {
+	var follow bool
+	cmd := &cobra.Command{
+		Use:   "logs <name|id>",
+		Short: "Show a sandbox's output",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return shai.SandboxLogs(cmd.Context(), args[0], follow, cmd.OutOrStdout(), cmd.ErrOrStderr())
+		},
+	}
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "Keep printing output until the sandbox exits")
+	return cmd
+}
+
+// sandboxRef is how commands refer to a sandbox: its name, or its
+// container ID when it has none.
+func sandboxRef(info shai.SandboxInfo) string {
+	if info.Name != "" {
+		return info.Name
+	}
+	return shortID(info.ID)
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// startDetached re-runs the current command line as a background process
+// in its own session, so the sandbox, its host call server and its
+// bootstrap files outlive the terminal. It returns once the container is
+// running, or with the supervisor's log if it never starts.
+func startDetached(out io.Writer, name string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate shai executable: %w", err)
+	}
+	logPath := shai.SandboxLogPath(name)
+	if err := os.MkdirAll(filepath.Dir(logPath), 0o700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(logPath), err)
+	}
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open sandbox log: %w", err)
+	}
+	defer logFile.Close()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+
+	child := exec.Command(exe, os.Args[1:]...)
+	child.Env = append(os.Environ(), supervisorEnv+"=1")
+	child.Stdout = logFile
+	child.Stderr = logFile
+	child.ExtraFiles = []*os.File{readyW}
+	child.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := child.Start(); err != nil {
+		readyW.Close()
+		return fmt.Errorf("failed to start sandbox supervisor: %w", err)
+	}
+	readyW.Close()
+
+	containerID, _ := bufio.NewReader(readyR).ReadString('\n')
+	if strings.TrimSpace(containerID) == "" {
+		_ = child.Wait()
+		data, _ := os.ReadFile(logPath)
+		return fmt.Errorf("sandbox %s failed to start:\n%s", name, logTail(string(data), logTailLines))
+	}
+	_ = child.Process.Release()
+	fmt.Fprintf(out, "Started sandbox %s; attach with `shai attach %s`, remove with `shai rm %s` (log: %s)\n", name, name, name, logPath)
+	return nil
+}
+
+// superviseDetached runs in the process startDetached launches. It reports
+// readiness once the container runs and then keeps the sandbox's host side
+// alive until the container exits.
+func superviseDetached(ctx context.Context, sandbox shai.Sandbox) error {
+	ready := os.NewFile(supervisorReadyFD, "ready")
+	defer ready.Close()
+
+	session, err := sandbox.Start(ctx)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	fmt.Fprintln(ready, session.ContainerID)
+	ready.Close()
+
+	err = session.Wait(ctx)
+	if ctx.Err() != nil {
+		// Stopped by a signal: take the container down too, since nothing
+		// would be left to serve its host calls.
+		return session.Stop(context.Background())
+	}
+	return err
+}
+
+func logTail(data string, n int) string {
+	lines := strings.Split(strings.TrimRight(data, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
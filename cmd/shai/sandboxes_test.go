@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/colony-2/shai/pkg/shai"
+)
+
+func TestDetachNeedsName(t *testing.T) {
+	cmd := newRootCmd()
+	cmd.SetArgs([]string{"--detach"})
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "--detach needs --name") {
+		t.Fatalf("expected --name error, got %v", err)
+	}
+}
+
+func TestInvalidSandboxName(t *testing.T) {
+	cmd := newRootCmd()
+	cmd.SetArgs([]string{"--name", "bad name", "--detach"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "invalid sandbox name") {
+		t.Fatalf("expected invalid name error, got %v", err)
+	}
+}
+
+func TestLogTail(t *testing.T) {
+	if got := logTail("a\nb\nc\n", 2); got != "b\nc" {
+		t.Fatalf("logTail = %q", got)
+	}
+	if got := logTail("only\n", 5); got != "only" {
+		t.Fatalf("logTail = %q", got)
+	}
+}
+
+func TestWriteSandboxTable(t *testing.T) {
+	infos := []shai.SandboxInfo{
+		{ID: "0123456789abcdef", Name: "api-agent", Status: "Up 2 minutes", Workspace: "/home/dev/api", ReadWrite: []string{"src", "tests"}, ResourceSets: []string{"base"}, Image: "shai-base"},
+		{ID: "fedcba9876543210", Status: "Up 5 seconds", Workspace: "/home/dev/web", ReadWrite: []string{}, ResourceSets: []string{}, Image: "shai-base"},
+	}
+	var out bytes.Buffer
+	if err := writeSandboxTable(&out, infos); err != nil {
+		t.Fatalf("writeSandboxTable: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected header and 2 rows, got %q", out.String())
+	}
+	for _, want := range []string{"api-agent", "0123456789ab", "Up 2 minutes", "/home/dev/api", "src,tests", "base", "shai-base"} {
+		if !strings.Contains(lines[1], want) {
+			t.Fatalf("row %q missing %q", lines[1], want)
+		}
+	}
+	if fields := strings.Fields(lines[2]); fields[0] != "-" || fields[1] != "fedcba987654" {
+		t.Fatalf("unnamed row %q", lines[2])
+	}
+}
+
+func TestSandboxesInWorkspace(t *testing.T) {
+	infos := []shai.SandboxInfo{
+		{ID: "aaaaaaaaaaaaaaaa", Name: "api", Workspace: "/home/dev/api"},
+		{ID: "bbbbbbbbbbbbbbbb", Workspace: "/home/dev/api"},
+		{ID: "cccccccccccccccc", Name: "web", Workspace: "/home/dev/web"},
+	}
+	if got := sandboxesInWorkspace(infos, ""); len(got) != 3 {
+		t.Fatalf("expected every sandbox, got %d", len(got))
+	}
+	got := sandboxesInWorkspace(infos, "/home/dev/api")
+	if len(got) != 2 || sandboxRef(got[0]) != "api" || sandboxRef(got[1]) != "bbbbbbbbbbbb" {
+		t.Fatalf("unexpected selection %+v", got)
+	}
+}
+
+func TestStopNeedsSelection(t *testing.T) {
+	for _, args := range [][]string{
+		{"stop"},
+		{"stop", "--all", "api"},
+		{"stop", "--all", "--workspace"},
+	} {
+		cmd := newRootCmd()
+		cmd.SetArgs(args)
+		if err := cmd.Execute(); err == nil {
+			t.Fatalf("%v: expected an error", args)
+		}
+	}
+}
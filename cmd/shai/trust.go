@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/colony-2/shai/pkg/shai"
+	"github.com/spf13/cobra"
+)
+
+func newTrustCmd() *cobra.Command {
+	var (
+		configPath    string
+		templatePairs []string
+		revoke        bool
+	)
+	cmd := &cobra.Command{
+		Use:   "trust",
+		Short: "Allow the workspace config to run",
+		Long:  "Show the settings in the workspace config that reach the host (mounts, calls, privileged mode, exposed ports and vars), then record the config in the trust store. shai refuses to run a workspace config until it is trusted, and again whenever it changes.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			workingDir, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("failed to get working directory: %w", err)
+			}
+			if revoke {
+				path := configPath
+				if path == "" {
+					path = shai.DefaultConfigRelPath
+				}
+				removed, err := shai.RevokeTrust(path)
+				if err != nil {
+					return err
+				}
+				if removed {
+					fmt.Fprintf(cmd.OutOrStdout(), "Revoked trust for %s\n", path)
+				} else {
+					fmt.Fprintf(cmd.OutOrStdout(), "%s was not trusted\n", path)
+				}
+				return nil
+			}
+
+			varMap, err := parseTemplateVars(templatePairs)
+			if err != nil {
+				return err
+			}
+			req, trusted, err := shai.ReviewConfig(workingDir, configPath, varMap)
+			if err != nil {
+				return fmt.Errorf("failed to load shai config: %w", err)
+			}
+			if req == nil {
+				fmt.Fprintln(cmd.OutOrStdout(), "No workspace config; nothing to trust")
+				return nil
+			}
+			writeTrustRequest(cmd.OutOrStdout(), req)
+			if trusted {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s is already trusted\n", req.ConfigPath)
+				return nil
+			}
+			if err := shai.TrustConfig(*req); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Trusted %s\n", req.ConfigPath)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&configPath, "config", "c", "", fmt.Sprintf("Path to the workspace config (default: <workspace>/%s)", shai.DefaultConfigRelPath))
+	flags.StringArrayVarP(&templatePairs, "var", "v", nil, "Template variable (key=value)")
+	flags.BoolVar(&revoke, "revoke", false, "Remove the config from the trust store")
+	return cmd
+}
+
+func writeTrustRequest(w io.Writer, req *shai.TrustRequest) {
+	if req.Summary == "" {
+		fmt.Fprintf(w, "%s asks for no mounts, calls, privileged mode, exposed ports or vars\n", req.ConfigPath)
+		return
+	}
+	fmt.Fprintf(w, "%s asks for:\n%s", req.ConfigPath, req.Summary)
+}
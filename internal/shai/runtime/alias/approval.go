@@ -0,0 +1,201 @@
+package alias
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/colony-2/shai/internal/shai/runtime/alias/mcp"
+	"github.com/colony-2/shai/internal/shai/runtime/config"
+)
+
+// ApprovalMode controls when a call must be confirmed on the host.
+type ApprovalMode string
+
+const (
+	// ApprovalNever runs the call without asking.
+	ApprovalNever ApprovalMode = "never"
+	// ApprovalOnce asks the first time the call runs with a given argv in a
+	// session.
+	ApprovalOnce ApprovalMode = "once"
+	// ApprovalAlways asks every time the call runs.
+	ApprovalAlways ApprovalMode = "always"
+)
+
+// DefaultApprovalTimeout bounds how long a call waits for a decision.
+const DefaultApprovalTimeout = 2 * time.Minute
+
+// ApprovalRequest describes a call awaiting approval. Argv is exactly what
+// will be started on the host, except that staged input and output files
+// appear as <name> instead of their per-call path.
+type ApprovalRequest struct {
+	Call string   `json:"call"`
+	Argv []string `json:"argv"`
+}
+
+// Approver decides whether a call may run. It returns nil to approve and an
+// error wrapping mcp.ErrNotApproved to deny.
+type Approver interface {
+	Approve(ctx context.Context, req ApprovalRequest) error
+}
+
+type approvalError struct {
+	msg string
+}
+
+func (e *approvalError) Error() string { return e.msg }
+
+func (e *approvalError) Unwrap() error { return mcp.ErrNotApproved }
+
+// NotApproved builds a denial error that the MCP server reports with its
+// approval-denied error code.
+func NotApproved(format string, args ...any) error {
+	return &approvalError{msg: fmt.Sprintf(format, args...)}
+}
+
+// Allowlist pre-approves calls for non-interactive runs. Each line of the
+// file is either a call name, approving any argv, or a call name followed by
+// the exact argv to approve, quoted as the approval prompt shows it. Blank
+// lines and lines starting with '#' are ignored.
+type Allowlist struct {
+	any   map[string]bool
+	exact map[string][][]string
+}
+
+// LoadAllowlist parses an allowlist file.
+func LoadAllowlist(path string) (*Allowlist, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := &Allowlist{
+		any:   make(map[string]bool),
+		exact: make(map[string][][]string),
+	}
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, rest, _ := strings.Cut(line, " ")
+		if !aliasNameRe.MatchString(name) {
+			return nil, fmt.Errorf("%s:%d: invalid call name %q", filepath.Base(path), lineNum, name)
+		}
+		if strings.TrimSpace(rest) == "" {
+			list.any[name] = true
+			continue
+		}
+		argv, err := config.SplitCommand(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: call %q: %w", filepath.Base(path), lineNum, name, err)
+		}
+		list.exact[name] = append(list.exact[name], argv)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Allows reports whether req is pre-approved.
+func (l *Allowlist) Allows(req ApprovalRequest) bool {
+	if l == nil {
+		return false
+	}
+	if l.any[req.Call] {
+		return true
+	}
+	for _, allowed := range l.exact[req.Call] {
+		if slices.Equal(allowed, req.Argv) {
+			return true
+		}
+	}
+	return false
+}
+
+// HookApprover asks an external command for a decision. The command runs via
+// "sh -c" with the request as JSON on stdin and SHAI_APPROVAL_CALL set; exit
+// status 0 approves and anything else denies.
+type HookApprover struct {
+	Command string
+	Dir     string
+	Timeout time.Duration
+}
+
+// Approve runs the hook for req.
+func (h *HookApprover) Approve(ctx context.Context, req ApprovalRequest) error {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultApprovalTimeout
+	}
+	hookCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(hookCtx, "/bin/sh", "-c", h.Command)
+	cmd.Dir = h.Dir
+	cmd.Env = append(os.Environ(), "SHAI_APPROVAL_CALL="+req.Call)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	cmd.WaitDelay = time.Second
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err = cmd.Run()
+	if err == nil {
+		return nil
+	}
+	if errors.Is(hookCtx.Err(), context.DeadlineExceeded) {
+		return NotApproved("approval hook for call %q timed out after %s", req.Call, timeout)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return NotApproved("approval hook for call %q failed: %v", req.Call, err)
+	}
+	if reason := strings.TrimSpace(output.String()); reason != "" {
+		return NotApproved("call %q was denied by the approval hook: %s", req.Call, reason)
+	}
+	return NotApproved("call %q was denied by the approval hook (exit %d)", req.Call, exitErr.ExitCode())
+}
+
+// ApprovalChain consults the allowlist first, then the hook if one is set,
+// and finally the interactive prompt.
+type ApprovalChain struct {
+	Allowlist *Allowlist
+	Hook      *HookApprover
+	Prompt    Approver
+}
+
+// Approve implements Approver.
+func (c *ApprovalChain) Approve(ctx context.Context, req ApprovalRequest) error {
+	if c.Allowlist.Allows(req) {
+		return nil
+	}
+	if c.Hook != nil {
+		return c.Hook.Approve(ctx, req)
+	}
+	if c.Prompt != nil {
+		return c.Prompt.Approve(ctx, req)
+	}
+	return NotApproved("call %q requires approval but no terminal, approval hook or allowlist entry is available", req.Call)
+}
@@ -0,0 +1,201 @@
+package alias
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/colony-2/shai/internal/shai/runtime/alias/mcp"
+)
+
+type countingApprover struct {
+	calls []ApprovalRequest
+	deny  bool
+}
+
+func (c *countingApprover) Approve(ctx context.Context, req ApprovalRequest) error {
+	c.calls = append(c.calls, req)
+	if c.deny {
+		return NotApproved("call %q was denied", req.Call)
+	}
+	return nil
+}
+
+func TestExecutorApprovalModes(t *testing.T) {
+	entry, err := NewArgvEntry("ok", "", []string{"true", "--flag"}, "^.*$")
+	if err != nil {
+		t.Fatalf("NewArgvEntry: %v", err)
+	}
+	approver := &countingApprover{}
+	executor := &Executor{WorkingDir: t.TempDir(), Timeout: 5 * time.Second, Approver: approver}
+
+	entry.Approval = ApprovalOnce
+	for i := 0; i < 2; i++ {
+		if _, err := executor.Run(context.Background(), entry, nil, Streams{}); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+	}
+	if len(approver.calls) != 1 {
+		t.Fatalf("expected one prompt for approval once, got %d", len(approver.calls))
+	}
+	if got := strings.Join(approver.calls[0].Argv, " "); got != "true --flag" {
+		t.Fatalf("unexpected argv %q", got)
+	}
+	if _, err := executor.Run(context.Background(), entry, []string{"other"}, Streams{}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(approver.calls) != 2 {
+		t.Fatalf("expected a new prompt for different arguments, got %d", len(approver.calls))
+	}
+
+	entry.Name = "always"
+	entry.Approval = ApprovalAlways
+	for i := 0; i < 2; i++ {
+		if _, err := executor.Run(context.Background(), entry, nil, Streams{}); err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+	}
+	if len(approver.calls) != 4 {
+		t.Fatalf("expected a prompt per call for approval always, got %d", len(approver.calls))
+	}
+}
+
+func TestExecutorApprovalOnceWithInputFile(t *testing.T) {
+	entry, err := NewArgvEntry("lint", "", []string{"/bin/sh", "-c", `test -f "$1"`, "lint"}, "")
+	if err != nil {
+		t.Fatalf("NewArgvEntry: %v", err)
+	}
+	if err := entry.SetFiles([]FileParam{{Name: "src", Required: true, Arg: []string{"--file={{ value }}"}}}, nil); err != nil {
+		t.Fatalf("SetFiles: %v", err)
+	}
+	entry.Approval = ApprovalOnce
+	approver := &countingApprover{}
+	executor := &Executor{WorkingDir: t.TempDir(), Timeout: 5 * time.Second, Approver: approver}
+
+	params := map[string]any{"src": base64.StdEncoding.EncodeToString([]byte("data"))}
+	var argvs []string
+	for i := 0; i < 2; i++ {
+		res, err := executor.RunParams(context.Background(), entry, params, Streams{})
+		if err != nil {
+			t.Fatalf("RunParams: %v", err)
+		}
+		argvs = append(argvs, strings.Join(res.Argv, " "))
+	}
+	if argvs[0] == argvs[1] {
+		t.Fatalf("expected each call to stage its own directory, got %q twice", argvs[0])
+	}
+	if len(approver.calls) != 1 {
+		t.Fatalf("expected one prompt for approval once, got %d", len(approver.calls))
+	}
+	want := []string{"/bin/sh", "-c", `test -f "$1"`, "lint", "--file=<src>"}
+	if got := approver.calls[0].Argv; !slices.Equal(got, want) {
+		t.Fatalf("expected staged paths replaced by names, got %q", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "allow")
+	if err := os.WriteFile(path, []byte(`lint /bin/sh -c 'test -f "$1"' lint '--file=<src>'`+"\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	list, err := LoadAllowlist(path)
+	if err != nil {
+		t.Fatalf("LoadAllowlist: %v", err)
+	}
+	executor = &Executor{WorkingDir: t.TempDir(), Timeout: 5 * time.Second, Approver: &ApprovalChain{Allowlist: list}}
+	if _, err := executor.RunParams(context.Background(), entry, params, Streams{}); err != nil {
+		t.Fatalf("expected the allowlist to match the call, got %v", err)
+	}
+}
+
+func TestExecutorApprovalDenied(t *testing.T) {
+	entry, err := NewArgvEntry("push", "", []string{"true"}, "")
+	if err != nil {
+		t.Fatalf("NewArgvEntry: %v", err)
+	}
+	entry.Approval = ApprovalAlways
+
+	executor := &Executor{WorkingDir: t.TempDir(), Approver: &countingApprover{deny: true}}
+	if _, err := executor.Run(context.Background(), entry, nil, Streams{}); !errors.Is(err, mcp.ErrNotApproved) {
+		t.Fatalf("expected ErrNotApproved, got %v", err)
+	}
+
+	executor = &Executor{WorkingDir: t.TempDir()}
+	if _, err := executor.Run(context.Background(), entry, nil, Streams{}); !errors.Is(err, mcp.ErrNotApproved) {
+		t.Fatalf("expected ErrNotApproved without an approver, got %v", err)
+	}
+}
+
+func TestLoadAllowlist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allow")
+	content := "# pre-approved\nstatus\npush git push origin   main\ntag git tag -m 'release 1'\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	list, err := LoadAllowlist(path)
+	if err != nil {
+		t.Fatalf("LoadAllowlist: %v", err)
+	}
+	cases := []struct {
+		req  ApprovalRequest
+		want bool
+	}{
+		{ApprovalRequest{Call: "status", Argv: []string{"git", "status"}}, true},
+		{ApprovalRequest{Call: "push", Argv: []string{"git", "push", "origin", "main"}}, true},
+		{ApprovalRequest{Call: "push", Argv: []string{"git", "push", "--force", "origin", "main"}}, false},
+		{ApprovalRequest{Call: "flash", Argv: []string{"flash"}}, false},
+		{ApprovalRequest{Call: "tag", Argv: []string{"git", "tag", "-m", "release 1"}}, true},
+		{ApprovalRequest{Call: "tag", Argv: []string{"git", "tag", "-m", "release", "1"}}, false},
+	}
+	for _, tc := range cases {
+		if got := list.Allows(tc.req); got != tc.want {
+			t.Fatalf("Allows(%q) = %v, want %v", tc.req, got, tc.want)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("tag git tag -m 'release\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := LoadAllowlist(path); err == nil || !strings.Contains(err.Error(), "allow:1") {
+		t.Fatalf("expected an error for the unterminated quote, got %v", err)
+	}
+}
+
+func TestHookApprover(t *testing.T) {
+	hook := &HookApprover{Command: `test "$SHAI_APPROVAL_CALL" = status || { echo "only status"; exit 1; }`}
+	if err := hook.Approve(context.Background(), ApprovalRequest{Call: "status"}); err != nil {
+		t.Fatalf("expected approval, got %v", err)
+	}
+	err := hook.Approve(context.Background(), ApprovalRequest{Call: "push"})
+	if !errors.Is(err, mcp.ErrNotApproved) || !strings.Contains(err.Error(), "only status") {
+		t.Fatalf("expected denial with hook output, got %v", err)
+	}
+
+	slow := &HookApprover{Command: "sleep 5", Timeout: 100 * time.Millisecond}
+	err = slow.Approve(context.Background(), ApprovalRequest{Call: "push"})
+	if !errors.Is(err, mcp.ErrNotApproved) || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected timeout denial, got %v", err)
+	}
+}
+
+func TestApprovalChainFallsBackToPrompt(t *testing.T) {
+	prompt := &countingApprover{}
+	chain := &ApprovalChain{Allowlist: &Allowlist{any: map[string]bool{"status": true}}, Prompt: prompt}
+	if err := chain.Approve(context.Background(), ApprovalRequest{Call: "status"}); err != nil {
+		t.Fatalf("allowlisted call: %v", err)
+	}
+	if err := chain.Approve(context.Background(), ApprovalRequest{Call: "push"}); err != nil {
+		t.Fatalf("prompted call: %v", err)
+	}
+	if len(prompt.calls) != 1 || prompt.calls[0].Call != "push" {
+		t.Fatalf("expected only push to be prompted, got %v", prompt.calls)
+	}
+	empty := &ApprovalChain{}
+	if err := empty.Approve(context.Background(), ApprovalRequest{Call: "push"}); !errors.Is(err, mcp.ErrNotApproved) {
+		t.Fatalf("expected denial without approvers, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package alias
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditRecord is one line of a session's call audit log.
+type AuditRecord struct {
+	Time        time.Time `json:"time"`
+	Session     string    `json:"session"`
+	Call        string    `json:"call"`
+	Argv        []string  `json:"argv,omitempty"`
+	ExitCode    *int      `json:"exitCode,omitempty"`
+	DurationMS  int64     `json:"durationMs"`
+	OutputBytes int64     `json:"outputBytes"`
+	Denied      string    `json:"denied,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// AuditLog appends call records for one session to a JSONL file.
+type AuditLog struct {
+	mu      sync.Mutex
+	file    *os.File
+	session string
+}
+
+const auditFileExt = ".jsonl"
+
+// DefaultAuditDir returns $XDG_STATE_HOME/shai/calls, falling back to
+// ~/.local/state/shai/calls.
+func DefaultAuditDir() string {
+	if dir := strings.TrimSpace(os.Getenv("XDG_STATE_HOME")); dir != "" {
+		return filepath.Join(dir, "shai", "calls")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "shai", "calls")
+	}
+	return filepath.Join(home, ".local", "state", "shai", "calls")
+}
+
+// OpenAuditLog creates the session's log file under dir. Files are named
+// after the session start time so a directory listing is chronological.
+func OpenAuditLog(dir, session string) (*AuditLog, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	name := time.Now().UTC().Format("20060102T150405Z") + "-" + fileSafeSession(session) + auditFileExt
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{file: file, session: session}, nil
+}
+
+// Path returns the log file location.
+func (l *AuditLog) Path() string {
+	if l == nil {
+		return ""
+	}
+	return l.file.Name()
+}
+
+// Record appends rec, filling in the session and time when unset.
+func (l *AuditLog) Record(rec AuditRecord) error {
+	if l == nil {
+		return nil
+	}
+	if rec.Session == "" {
+		rec.Session = l.session
+	}
+	if rec.Time.IsZero() {
+		rec.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the log file.
+func (l *AuditLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// ReadAuditLog returns the records in dir ordered by time. A non-empty
+// session limits the result to that session.
+func ReadAuditLog(dir, session string) ([]AuditRecord, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+auditFileExt))
+	if err != nil {
+		return nil, err
+	}
+	var records []AuditRecord
+	for _, path := range files {
+		if session != "" && !strings.HasSuffix(path, "-"+fileSafeSession(session)+auditFileExt) {
+			continue
+		}
+		fileRecords, err := readAuditFile(path)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, fileRecords...)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+	return records, nil
+}
+
+func readAuditFile(path string) ([]AuditRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var rec AuditRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filepath.Base(path), lineNum, err)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// fileSafeSession maps a base64 session ID onto characters that are safe in
+// file names.
+func fileSafeSession(session string) string {
+	return strings.TrimRight(strings.NewReplacer("/", "_", "+", "-").Replace(session), "=")
+}
@@ -0,0 +1,71 @@
+package alias
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/colony-2/shai/internal/shai/runtime/alias/mcp"
+)
+
+func TestAdapterWritesAuditLog(t *testing.T) {
+	dir := t.TempDir()
+	audit, err := OpenAuditLog(dir, "abc/def+==")
+	if err != nil {
+		t.Fatalf("OpenAuditLog: %v", err)
+	}
+	if base := filepath.Base(audit.Path()); !strings.HasSuffix(base, "-abc_def-.jsonl") {
+		t.Fatalf("unexpected log file name %q", base)
+	}
+
+	echo, err := NewArgvEntry("echo", "", []string{"echo", "hi"}, "^.*$")
+	if err != nil {
+		t.Fatalf("NewArgvEntry: %v", err)
+	}
+	push, err := NewArgvEntry("push", "", []string{"true"}, "")
+	if err != nil {
+		t.Fatalf("NewArgvEntry: %v", err)
+	}
+	push.Approval = ApprovalAlways
+
+	adapter := newAliasExecutorAdapter(&Executor{WorkingDir: dir}, []*Entry{echo, push})
+	adapter.audit = audit
+
+	if code, err := adapter.Execute(context.Background(), mcp.CallRequest{Name: "echo", Args: []string{"there"}}, mcp.Streams{}); err != nil || code != 0 {
+		t.Fatalf("Execute echo: code=%d err=%v", code, err)
+	}
+	if _, err := adapter.Execute(context.Background(), mcp.CallRequest{Name: "push"}, mcp.Streams{}); err == nil {
+		t.Fatalf("expected push to be denied without an approver")
+	}
+	if err := audit.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	records, err := ReadAuditLog(dir, "abc/def+==")
+	if err != nil {
+		t.Fatalf("ReadAuditLog: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	first := records[0]
+	if first.Session != "abc/def+==" || first.Call != "echo" || strings.Join(first.Argv, " ") != "echo hi there" {
+		t.Fatalf("unexpected first record %+v", first)
+	}
+	if first.ExitCode == nil || *first.ExitCode != 0 || first.OutputBytes != int64(len("hi there\n")) {
+		t.Fatalf("unexpected exit code or output size in %+v", first)
+	}
+	second := records[1]
+	if second.ExitCode != nil || !strings.Contains(second.Denied, "requires approval") || second.Error != "" {
+		t.Fatalf("expected a denial record, got %+v", second)
+	}
+
+	other, err := ReadAuditLog(dir, "someone-else")
+	if err != nil {
+		t.Fatalf("ReadAuditLog: %v", err)
+	}
+	if len(other) != 0 {
+		t.Fatalf("expected no records for another session, got %d", len(other))
+	}
+}
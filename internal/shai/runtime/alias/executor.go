@@ -7,15 +7,24 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
+
+	"github.com/colony-2/shai/internal/shai/runtime/alias/mcp"
 )
 
 // Streams configures stdout/stderr destinations for alias execution.
 type Streams struct {
 	Stdout io.Writer
 	Stderr io.Writer
+	// File receives each output file the command produced. Outputs are
+	// dropped when it is nil.
+	File func(name string, r io.Reader) error
 }
 
 // Executor runs alias commands on the host.
@@ -23,37 +32,106 @@ type Executor struct {
 	WorkingDir string
 	ShellPath  string
 	Timeout    time.Duration
+	// Approver confirms entries that require approval. Without one those
+	// entries are always denied.
+	Approver Approver
+
+	mu       sync.Mutex
+	approved map[string]bool
 }
 
-// RunResult captures the outcome of an alias command.
+// RunResult captures the outcome of an alias command. Argv is set once the
+// command line has been built, even if the command did not run.
 type RunResult struct {
 	ExitCode int
+	Argv     []string
 }
 
-// Run executes the provided alias entry using the configured shell.
+// Run executes the provided alias entry with free-form arguments. Argv
+// entries are started directly; shell entries are passed to the configured
+// shell as a single command line.
 func (e *Executor) Run(ctx context.Context, entry *Entry, args []string, streams Streams) (RunResult, error) {
 	if entry == nil {
 		return RunResult{}, fmt.Errorf("alias entry is nil")
 	}
+	if entry.Typed() {
+		return RunResult{}, fmt.Errorf("alias %q only accepts named parameters", entry.Name)
+	}
+	if entry.Mode == ExecArgv {
+		if err := entry.ValidateArgv(args); err != nil {
+			return RunResult{}, err
+		}
+		return e.start(ctx, entry, args, args, streams)
+	}
 	argString := strings.TrimSpace(strings.Join(args, " "))
 	if err := entry.ValidateArgs(argString); err != nil {
 		return RunResult{}, err
 	}
-
-	commandLine := entry.Command
+	var shellArgs []string
 	if argString != "" {
-		commandLine = commandLine + " " + argString
+		shellArgs = []string{argString}
 	}
+	return e.start(ctx, entry, shellArgs, shellArgs, streams)
+}
 
-	shell := e.ShellPath
-	if strings.TrimSpace(shell) == "" {
-		shell = "/bin/bash"
+// RunParams executes an entry with typed parameters rendered through the
+// entry's argument templates. Input files are written to a per-call host
+// directory that is removed once produced outputs have been sent.
+func (e *Executor) RunParams(ctx context.Context, entry *Entry, params map[string]any, streams Streams) (RunResult, error) {
+	if entry == nil {
+		return RunResult{}, fmt.Errorf("alias entry is nil")
 	}
-	if _, err := os.Stat(shell); err != nil {
-		return RunResult{}, fmt.Errorf("shell %q unavailable: %w", shell, err)
+	values := make(map[string]any, len(params))
+	for k, v := range params {
+		values[k] = v
+	}
+	files, fileArgs, err := entry.stageFiles(values)
+	if err != nil {
+		return RunResult{}, err
+	}
+	defer files.cleanup()
+	bound, err := entry.BindParams(values)
+	if err != nil {
+		return RunResult{}, err
+	}
+	args := append(slices.Clone(bound), fileArgs...)
+	shown := append(bound, files.shownArgs()...)
+	if entry.Mode != ExecArgv {
+		for i := range args {
+			args[i] = shellQuote(args[i])
+			shown[i] = shellQuote(shown[i])
+		}
+	}
+	result, err := e.start(ctx, entry, args, shown, streams)
+	if err != nil {
+		return result, err
+	}
+	if err := files.send(streams.File, writerOrDiscard(streams.Stderr)); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// start launches an already validated entry. For shell entries args are
+// appended to the command line verbatim. shownArgs are the same arguments
+// as approval sees them, with staged file paths replaced by file names.
+func (e *Executor) start(ctx context.Context, entry *Entry, args, shownArgs []string, streams Streams) (RunResult, error) {
+	argv, err := e.commandArgv(entry, args)
+	if err != nil {
+		return RunResult{}, err
+	}
+	shown, err := e.commandArgv(entry, shownArgs)
+	if err != nil {
+		return RunResult{}, err
+	}
+	if err := e.approve(ctx, entry, shown); err != nil {
+		return RunResult{Argv: argv}, err
 	}
 
 	timeout := e.Timeout
+	if entry.Timeout > 0 {
+		timeout = entry.Timeout
+	}
 	execCtx := ctx
 	var cancel context.CancelFunc
 	if timeout > 0 {
@@ -61,9 +139,13 @@ func (e *Executor) Run(ctx context.Context, entry *Entry, args []string, streams
 		defer cancel()
 	}
 
-	cmd := exec.CommandContext(execCtx, shell, "-lc", commandLine)
-	cmd.Dir = e.WorkingDir
-	cmd.Env = os.Environ()
+	dir, err := e.commandDir(entry)
+	if err != nil {
+		return RunResult{Argv: argv}, err
+	}
+	cmd := exec.CommandContext(execCtx, argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Env = entry.environ(os.Environ())
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	cmd.Stdout = writerOrDiscard(streams.Stdout)
 	cmd.Stderr = writerOrDiscard(streams.Stderr)
@@ -85,21 +167,134 @@ func (e *Executor) Run(ctx context.Context, entry *Entry, args []string, streams
 
 	if err := cmd.Start(); err != nil {
 		close(exited)
-		return RunResult{}, err
+		return RunResult{Argv: argv}, err
 	}
 	waitErr := cmd.Wait()
 	close(exited)
 
 	if waitErr == nil {
-		return RunResult{ExitCode: 0}, nil
+		return RunResult{ExitCode: 0, Argv: argv}, nil
 	}
 	if execCtx.Err() != nil && errors.Is(execCtx.Err(), context.DeadlineExceeded) {
-		return RunResult{}, fmt.Errorf("alias command timed out")
+		return RunResult{Argv: argv}, fmt.Errorf("alias command timed out after %s", timeout)
 	}
 	if exitErr, ok := waitErr.(*exec.ExitError); ok {
-		return RunResult{ExitCode: exitErr.ExitCode()}, nil
+		return RunResult{ExitCode: exitErr.ExitCode(), Argv: argv}, nil
+	}
+	return RunResult{Argv: argv}, waitErr
+}
+
+// commandArgv builds the argv that starts entry with args.
+func (e *Executor) commandArgv(entry *Entry, args []string) ([]string, error) {
+	if entry.Mode == ExecArgv {
+		if len(entry.Argv) == 0 {
+			return nil, fmt.Errorf("alias %q has no command", entry.Name)
+		}
+		return append(slices.Clone(entry.Argv), args...), nil
 	}
-	return RunResult{}, waitErr
+	commandLine := entry.Command
+	if len(args) > 0 {
+		commandLine = commandLine + " " + strings.Join(args, " ")
+	}
+
+	shell := e.ShellPath
+	if strings.TrimSpace(shell) == "" {
+		shell = "/bin/bash"
+	}
+	if _, err := os.Stat(shell); err != nil {
+		return nil, fmt.Errorf("shell %q unavailable: %w", shell, err)
+	}
+	return []string{shell, "-lc", commandLine}, nil
+}
+
+// approve asks the Approver about entries that need confirmation. Calls
+// approved under ApprovalOnce are remembered with their exact argv for the
+// executor's lifetime. Staged files appear in argv by name, so a call with
+// the same arguments matches however its files were staged.
+func (e *Executor) approve(ctx context.Context, entry *Entry, argv []string) error {
+	switch entry.Approval {
+	case "", ApprovalNever:
+		return nil
+	}
+	// Arguments cannot contain NUL, so joining on it keeps argv exact.
+	key := entry.Name + "\x00" + strings.Join(argv, "\x00")
+	e.mu.Lock()
+	done := e.approved[key]
+	e.mu.Unlock()
+	if done {
+		return nil
+	}
+	if e.Approver == nil {
+		return NotApproved("call %q requires approval but no approver is configured", entry.Name)
+	}
+	if err := e.Approver.Approve(ctx, ApprovalRequest{Call: entry.Name, Argv: argv}); err != nil {
+		if errors.Is(err, mcp.ErrNotApproved) {
+			return err
+		}
+		return NotApproved("approval for call %q failed: %v", entry.Name, err)
+	}
+	if entry.Approval == ApprovalOnce {
+		e.mu.Lock()
+		if e.approved == nil {
+			e.approved = make(map[string]bool)
+		}
+		e.approved[key] = true
+		e.mu.Unlock()
+	}
+	return nil
+}
+
+// commandDir resolves the entry's working directory, which must stay inside
+// the executor's WorkingDir.
+func (e *Executor) commandDir(entry *Entry) (string, error) {
+	if entry.Dir == "" {
+		return e.WorkingDir, nil
+	}
+	if !filepath.IsLocal(entry.Dir) {
+		return "", fmt.Errorf("alias %q working directory %q is outside the workspace", entry.Name, entry.Dir)
+	}
+	return filepath.Join(e.WorkingDir, entry.Dir), nil
+}
+
+// environ builds the command's environment from the host's.
+func (e *Entry) environ(host []string) []string {
+	out := make([]string, 0, len(host)+len(e.Env))
+	for _, kv := range host {
+		key, _, _ := strings.Cut(kv, "=")
+		if _, set := e.Env[key]; set {
+			continue
+		}
+		if e.CleanEnv && !envAllowed(e.EnvAllow, key) {
+			continue
+		}
+		out = append(out, kv)
+	}
+	keys := make([]string, 0, len(e.Env))
+	for key := range e.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		out = append(out, key+"="+e.Env[key])
+	}
+	return out
+}
+
+func envAllowed(allow []string, key string) bool {
+	for _, pattern := range allow {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
 
 func writerOrDiscard(w io.Writer) io.Writer {
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 )
@@ -60,6 +61,32 @@ func TestExecutorRunTimeout(t *testing.T) {
 	}
 }
 
+func TestExecutorEntryTimeoutOverridesDefault(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "sleep.sh")
+	writeExecutable(t, script, "#!/bin/sh\nsleep 2\n")
+
+	entry, err := NewArgvEntry("sleepy", "", []string{script}, "")
+	if err != nil {
+		t.Fatalf("NewArgvEntry: %v", err)
+	}
+	entry.Timeout = 100 * time.Millisecond
+
+	executor := &Executor{
+		WorkingDir: dir,
+		Timeout:    time.Minute,
+	}
+
+	start := time.Now()
+	_, err = executor.Run(context.Background(), entry, nil, Streams{})
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Fatalf("expected entry timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 1500*time.Millisecond {
+		t.Fatalf("entry timeout not applied, took %s", elapsed)
+	}
+}
+
 func TestExecutorRunRejectsArgs(t *testing.T) {
 	dir := t.TempDir()
 	script := filepath.Join(dir, "args.sh")
@@ -97,3 +124,97 @@ func shellPath() string {
 	}
 	return "/bin/sh"
 }
+
+func TestExecutorRunArgvPassesArgsLiterally(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "echo.sh")
+	writeExecutable(t, script, "#!/bin/sh\nfor a in \"$@\"; do echo \"ARG:$a\"; done\n")
+
+	entry, err := NewArgvEntry("echo", "", []string{script, "fixed value"}, ".*")
+	if err != nil {
+		t.Fatalf("NewArgvEntry: %v", err)
+	}
+
+	executor := &Executor{
+		WorkingDir: dir,
+		Timeout:    2 * time.Second,
+	}
+
+	var stdout bytes.Buffer
+	res, err := executor.Run(context.Background(), entry, []string{"a b", "; touch pwned", "$(id)"}, Streams{Stdout: &stdout})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.ExitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d", res.ExitCode)
+	}
+	want := "ARG:fixed value\nARG:a b\nARG:; touch pwned\nARG:$(id)\n"
+	if got := stdout.String(); got != want {
+		t.Fatalf("unexpected stdout: %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+		t.Fatalf("argument was interpreted by a shell")
+	}
+}
+
+func TestExecutorRunArgvValidatesEachArg(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "args.sh")
+	writeExecutable(t, script, "#!/bin/sh\necho \"$*\"\n")
+
+	entry, err := NewArgvEntry("restricted", "", []string{script}, "--(env|region)=[a-z0-9-]+")
+	if err != nil {
+		t.Fatalf("NewArgvEntry: %v", err)
+	}
+
+	executor := &Executor{
+		WorkingDir: dir,
+		Timeout:    time.Second,
+	}
+
+	var stdout bytes.Buffer
+	if _, err := executor.Run(context.Background(), entry, []string{"--env=dev", "--region=us-east-1"}, Streams{Stdout: &stdout}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got := stdout.String(); got != "--env=dev --region=us-east-1\n" {
+		t.Fatalf("unexpected stdout: %q", got)
+	}
+	if _, err := executor.Run(context.Background(), entry, []string{"--env=dev", "--env=dev --force"}, Streams{}); err == nil {
+		t.Fatalf("expected per-argument validation error")
+	}
+}
+
+func TestExecutorRunDirAndEnv(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	t.Setenv("SHAI_TEST_SECRET", "leaked")
+	t.Setenv("SHAI_TEST_LANG", "en")
+
+	entry, err := NewArgvEntry("show", "", []string{"/bin/sh", "-c", `pwd; echo "${SHAI_TEST_SECRET-unset} ${SHAI_TEST_LANG-unset} ${SHAI_TEST_MODE-unset}"`}, "")
+	if err != nil {
+		t.Fatalf("NewArgvEntry: %v", err)
+	}
+	entry.Dir = "sub"
+	entry.Env = map[string]string{"SHAI_TEST_MODE": "ci"}
+	entry.CleanEnv = true
+	entry.EnvAllow = []string{"SHAI_TEST_LA*"}
+
+	var stdout bytes.Buffer
+	if _, err := (&Executor{WorkingDir: dir}).Run(context.Background(), entry, nil, Streams{Stdout: &stdout}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 || filepath.Base(lines[0]) != "sub" {
+		t.Fatalf("expected the command to run in sub, got %q", stdout.String())
+	}
+	if lines[1] != "unset en ci" {
+		t.Fatalf("unexpected environment %q", lines[1])
+	}
+
+	entry.Dir = "../elsewhere"
+	if _, err := (&Executor{WorkingDir: dir}).Run(context.Background(), entry, nil, Streams{}); err == nil {
+		t.Fatalf("expected a working directory outside the workspace to be rejected")
+	}
+}
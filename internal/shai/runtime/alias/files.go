@@ -0,0 +1,257 @@
+package alias
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/colony-2/shai/internal/shai/runtime/alias/mcp"
+)
+
+// DefaultMaxFileBytes caps each input and output file when no limit is set.
+const DefaultMaxFileBytes = 10 << 20
+
+// fileParamNameRe matches names shai-remote can pass as --<name>=<path>.
+var fileParamNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// FileParam declares a file passed to or returned from an entry. The command
+// receives the file's host path through Arg, where {{ value }} is replaced
+// with the path.
+type FileParam struct {
+	Name        string
+	Description string
+	// Filename is the file's name inside the per-call directory; it
+	// defaults to Name.
+	Filename string
+	// Required applies to inputs only.
+	Required bool
+	// MaxBytes caps the file size; zero uses DefaultMaxFileBytes.
+	MaxBytes int64
+	Arg      []string
+}
+
+// SetFiles attaches file inputs and outputs to the entry. Entries with files
+// only accept named parameters.
+func (e *Entry) SetFiles(inputs, outputs []FileParam) error {
+	seen := make(map[string]bool, len(e.Params)+len(inputs)+len(outputs))
+	for _, p := range e.Params {
+		seen[p.Name] = true
+	}
+	normalize := func(kind string, files []FileParam) ([]FileParam, error) {
+		out := make([]FileParam, 0, len(files))
+		filenames := make(map[string]bool, len(files))
+		for _, f := range files {
+			if !fileParamNameRe.MatchString(f.Name) {
+				return nil, fmt.Errorf("alias %q %s %q: invalid name", e.Name, kind, f.Name)
+			}
+			if seen[f.Name] {
+				return nil, fmt.Errorf("alias %q %s %q: name already used", e.Name, kind, f.Name)
+			}
+			seen[f.Name] = true
+			if f.Filename == "" {
+				f.Filename = f.Name
+			}
+			if err := validateFilename(f.Filename); err != nil {
+				return nil, fmt.Errorf("alias %q %s %q: %w", e.Name, kind, f.Name, err)
+			}
+			if filenames[f.Filename] {
+				return nil, fmt.Errorf("alias %q %s %q: filename %q already used", e.Name, kind, f.Name, f.Filename)
+			}
+			filenames[f.Filename] = true
+			if f.MaxBytes < 0 {
+				return nil, fmt.Errorf("alias %q %s %q: max size must not be negative", e.Name, kind, f.Name)
+			}
+			if f.MaxBytes == 0 {
+				f.MaxBytes = DefaultMaxFileBytes
+			}
+			if len(f.Arg) == 0 {
+				f.Arg = []string{"--" + f.Name + "={{ value }}"}
+			}
+			out = append(out, f)
+		}
+		return out, nil
+	}
+	in, err := normalize("input", inputs)
+	if err != nil {
+		return err
+	}
+	out, err := normalize("output", outputs)
+	if err != nil {
+		return err
+	}
+	e.Inputs, e.Outputs = in, out
+	return nil
+}
+
+// validateFilename accepts a single, plain path element so files cannot be
+// placed outside the per-call directory.
+func validateFilename(name string) error {
+	switch {
+	case name == "." || name == "..":
+		return fmt.Errorf("invalid filename %q", name)
+	case strings.ContainsAny(name, `/\`):
+		return fmt.Errorf("filename %q must not contain path separators", name)
+	case strings.ContainsRune(name, 0):
+		return fmt.Errorf("filename %q contains a NUL byte", name)
+	}
+	return nil
+}
+
+// Typed reports whether the entry takes named parameters or files rather than
+// free-form arguments.
+func (e *Entry) Typed() bool {
+	return len(e.Params) > 0 || len(e.Inputs) > 0 || len(e.Outputs) > 0
+}
+
+// OutputFiles describes the entry's outputs for MCP clients.
+func (e *Entry) OutputFiles() []mcp.OutputFile {
+	if len(e.Outputs) == 0 {
+		return nil
+	}
+	out := make([]mcp.OutputFile, 0, len(e.Outputs))
+	for _, f := range e.Outputs {
+		out = append(out, mcp.OutputFile{Name: f.Name, Description: f.Description})
+	}
+	return out
+}
+
+// MaxInputBytes is the combined size limit of the entry's input files.
+func (e *Entry) MaxInputBytes() int64 {
+	var total int64
+	for _, f := range e.Inputs {
+		total += f.MaxBytes
+	}
+	return total
+}
+
+// callFiles is the per-call host directory holding inputs and outputs.
+type callFiles struct {
+	dir     string
+	outputs []FileParam
+	// shown holds the file arguments with each path replaced by <name>,
+	// which stays the same from call to call.
+	shown []string
+}
+
+// stageFiles writes base64 input values to a fresh per-call directory and
+// renders argv for every input and output. File values are removed from
+// values so the rest can be bound as ordinary parameters. The same
+// arguments with file names in place of paths are kept for approval.
+func (e *Entry) stageFiles(values map[string]any) (*callFiles, []string, error) {
+	if len(e.Inputs) == 0 && len(e.Outputs) == 0 {
+		return nil, nil, nil
+	}
+	dir, err := os.MkdirTemp("", "shai-call-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("create call directory: %w", err)
+	}
+	files := &callFiles{dir: dir, outputs: e.Outputs}
+	fail := func(err error) (*callFiles, []string, error) {
+		files.cleanup()
+		return nil, nil, err
+	}
+	for _, sub := range []string{"in", "out"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0o700); err != nil {
+			return fail(fmt.Errorf("create call directory: %w", err))
+		}
+	}
+
+	var args []string
+	for _, f := range e.Inputs {
+		raw, ok := values[f.Name]
+		delete(values, f.Name)
+		if !ok || raw == nil {
+			if f.Required {
+				return fail(fmt.Errorf("alias %q requires input file %q", e.Name, f.Name))
+			}
+			continue
+		}
+		encoded, ok := raw.(string)
+		if !ok {
+			return fail(fmt.Errorf("alias %q input file %q: expected base64 string, got %T", e.Name, f.Name, raw))
+		}
+		if int64(base64.StdEncoding.DecodedLen(len(encoded))) > f.MaxBytes+2 {
+			return fail(fmt.Errorf("alias %q input file %q exceeds %d bytes", e.Name, f.Name, f.MaxBytes))
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fail(fmt.Errorf("alias %q input file %q: invalid base64: %v", e.Name, f.Name, err))
+		}
+		if int64(len(data)) > f.MaxBytes {
+			return fail(fmt.Errorf("alias %q input file %q exceeds %d bytes", e.Name, f.Name, f.MaxBytes))
+		}
+		path := filepath.Join(dir, "in", f.Filename)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			return fail(fmt.Errorf("alias %q input file %q: %w", e.Name, f.Name, err))
+		}
+		args = append(args, renderFileArg(f, path)...)
+		files.shown = append(files.shown, renderFileArg(f, "<"+f.Name+">")...)
+	}
+	for _, f := range e.Outputs {
+		args = append(args, renderFileArg(f, filepath.Join(dir, "out", f.Filename))...)
+		files.shown = append(files.shown, renderFileArg(f, "<"+f.Name+">")...)
+	}
+	return files, args, nil
+}
+
+// shownArgs returns the file arguments as approval sees them.
+func (c *callFiles) shownArgs() []string {
+	if c == nil {
+		return nil
+	}
+	return c.shown
+}
+
+func renderFileArg(f FileParam, path string) []string {
+	out := make([]string, 0, len(f.Arg))
+	for _, tmpl := range f.Arg {
+		out = append(out, valuePlaceholder.ReplaceAllLiteralString(tmpl, path))
+	}
+	return out
+}
+
+// send passes each produced output to w. Missing, oversized or non-regular
+// outputs are skipped with a note on stderr.
+func (c *callFiles) send(w func(name string, r io.Reader) error, stderr io.Writer) error {
+	if c == nil || w == nil {
+		return nil
+	}
+	for _, f := range c.outputs {
+		path := filepath.Join(c.dir, "out", f.Filename)
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			fmt.Fprintf(stderr, "\n[shai: output file %q is not a regular file; skipped]\n", f.Name)
+			continue
+		}
+		if info.Size() > f.MaxBytes {
+			fmt.Fprintf(stderr, "\n[shai: output file %q is %d bytes, over its %d byte limit; skipped]\n", f.Name, info.Size(), f.MaxBytes)
+			continue
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = w(f.Name, io.LimitReader(file, f.MaxBytes))
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("send output file %q: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+func (c *callFiles) cleanup() {
+	if c != nil {
+		_ = os.RemoveAll(c.dir)
+	}
+}
@@ -0,0 +1,138 @@
+package alias
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetFilesValidation(t *testing.T) {
+	entry, err := NewArgvEntry("convert", "", []string{"true"}, "")
+	if err != nil {
+		t.Fatalf("NewArgvEntry: %v", err)
+	}
+	for _, tc := range []struct {
+		name    string
+		inputs  []FileParam
+		outputs []FileParam
+	}{
+		{name: "bad name", inputs: []FileParam{{Name: "Bad Name"}}},
+		{name: "traversal", inputs: []FileParam{{Name: "src", Filename: "../etc/passwd"}}},
+		{name: "dot dot", outputs: []FileParam{{Name: "out", Filename: ".."}}},
+		{name: "duplicate", inputs: []FileParam{{Name: "doc"}}, outputs: []FileParam{{Name: "doc"}}},
+		{name: "negative size", inputs: []FileParam{{Name: "doc", MaxBytes: -1}}},
+	} {
+		if err := entry.SetFiles(tc.inputs, tc.outputs); err == nil {
+			t.Fatalf("%s: expected an error", tc.name)
+		}
+	}
+
+	if err := entry.SetFiles([]FileParam{{Name: "doc"}}, nil); err != nil {
+		t.Fatalf("SetFiles: %v", err)
+	}
+	in := entry.Inputs[0]
+	if in.Filename != "doc" || in.MaxBytes != DefaultMaxFileBytes || strings.Join(in.Arg, " ") != "--doc={{ value }}" {
+		t.Fatalf("unexpected defaults %+v", in)
+	}
+	schema := entry.InputSchema()
+	if schema == nil || schema.Properties["doc"].ContentEncoding != "base64" {
+		t.Fatalf("expected a base64 input in the schema, got %+v", schema)
+	}
+	if _, err := (&Executor{}).Run(context.Background(), entry, nil, Streams{}); err == nil {
+		t.Fatalf("expected free-form arguments to be rejected")
+	}
+}
+
+func TestRunParamsTransfersFiles(t *testing.T) {
+	dir := t.TempDir()
+	entry, err := NewArgvEntry("upper", "", []string{"/bin/sh", "-c", `tr a-z A-Z < "$1" > "$2"; echo "$1"`, "upper"}, "")
+	if err != nil {
+		t.Fatalf("NewArgvEntry: %v", err)
+	}
+	err = entry.SetFiles(
+		[]FileParam{{Name: "src", Filename: "notes.txt", Required: true, Arg: []string{"{{ value }}"}}},
+		[]FileParam{{Name: "dst", Arg: []string{"{{ value }}"}}},
+	)
+	if err != nil {
+		t.Fatalf("SetFiles: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	got := map[string]string{}
+	streams := Streams{
+		Stdout: &stdout,
+		File: func(name string, r io.Reader) error {
+			data, err := io.ReadAll(r)
+			got[name] = string(data)
+			return err
+		},
+	}
+	params := map[string]any{"src": base64.StdEncoding.EncodeToString([]byte("hello"))}
+	res, err := (&Executor{WorkingDir: dir}).RunParams(context.Background(), entry, params, streams)
+	if err != nil || res.ExitCode != 0 {
+		t.Fatalf("RunParams: code=%d err=%v", res.ExitCode, err)
+	}
+	if got["dst"] != "HELLO" {
+		t.Fatalf("expected the output file to be sent, got %v", got)
+	}
+	inPath := strings.TrimSpace(stdout.String())
+	if filepath.Base(inPath) != "notes.txt" {
+		t.Fatalf("expected the input's host path as an argument, got %q", inPath)
+	}
+	if _, err := os.Stat(filepath.Dir(filepath.Dir(inPath))); !os.IsNotExist(err) {
+		t.Fatalf("expected the call directory to be removed, got %v", err)
+	}
+
+	if _, err := (&Executor{WorkingDir: dir}).RunParams(context.Background(), entry, map[string]any{}, streams); err == nil {
+		t.Fatalf("expected a missing required input to be rejected")
+	}
+}
+
+func TestRunParamsFileLimits(t *testing.T) {
+	dir := t.TempDir()
+	entry, err := NewArgvEntry("copy", "", []string{"/bin/sh", "-c", `cp "$1" "$2"; ln -s /etc/passwd "$3"`, "copy"}, "")
+	if err != nil {
+		t.Fatalf("NewArgvEntry: %v", err)
+	}
+	err = entry.SetFiles(
+		[]FileParam{{Name: "src", MaxBytes: 8, Arg: []string{"{{ value }}"}}},
+		[]FileParam{{Name: "dst", MaxBytes: 4, Arg: []string{"{{ value }}"}}, {Name: "link", Arg: []string{"{{ value }}"}}},
+	)
+	if err != nil {
+		t.Fatalf("SetFiles: %v", err)
+	}
+	exec := &Executor{WorkingDir: dir}
+
+	big := map[string]any{"src": base64.StdEncoding.EncodeToString([]byte("too large input"))}
+	if _, err := exec.RunParams(context.Background(), entry, big, Streams{}); err == nil || !strings.Contains(err.Error(), "exceeds 8 bytes") {
+		t.Fatalf("expected an oversized input to be rejected, got %v", err)
+	}
+	if _, err := exec.RunParams(context.Background(), entry, map[string]any{"src": "not base64!"}, Streams{}); err == nil {
+		t.Fatalf("expected invalid base64 to be rejected")
+	}
+
+	var stderr bytes.Buffer
+	sent := 0
+	streams := Streams{
+		Stderr: &stderr,
+		File: func(string, io.Reader) error {
+			sent++
+			return nil
+		},
+	}
+	params := map[string]any{"src": base64.StdEncoding.EncodeToString([]byte("12345"))}
+	if _, err := exec.RunParams(context.Background(), entry, params, streams); err != nil {
+		t.Fatalf("RunParams: %v", err)
+	}
+	if sent != 0 {
+		t.Fatalf("expected oversized and symlinked outputs to be skipped, sent %d", sent)
+	}
+	if !strings.Contains(stderr.String(), `"dst" is 5 bytes`) || !strings.Contains(stderr.String(), `"link" is not a regular file`) {
+		t.Fatalf("expected skip notes on stderr, got %q", stderr.String())
+	}
+}
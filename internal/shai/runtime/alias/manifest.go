@@ -7,17 +7,57 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 )
 
 var aliasNameRe = regexp.MustCompile(`^[a-z0-9_-]+$`)
 
+// ExecMode selects how an entry's command is launched on the host.
+type ExecMode string
+
+const (
+	// ExecShell runs the command and joined arguments via "<shell> -lc".
+	ExecShell ExecMode = "shell"
+	// ExecArgv runs Argv directly with each argument appended as its own element.
+	ExecArgv ExecMode = "argv"
+)
+
 // Entry represents a single alias command definition.
 type Entry struct {
 	Name        string
 	Description string
 	Command     string
 	ArgsRegex   string
-	compiledRE  *regexp.Regexp
+	Mode        ExecMode
+	Argv        []string
+	Params      []Param
+	// Inputs and Outputs are files passed to and returned from the
+	// command; see SetFiles.
+	Inputs  []FileParam
+	Outputs []FileParam
+	// MaxOutputBytes caps output returned to the caller; zero uses the
+	// server default and negative disables the cap.
+	MaxOutputBytes int
+	// Timeout overrides the executor's default timeout when positive.
+	Timeout time.Duration
+	// MaxConcurrent, Queue and RatePerMinute limit how often the entry
+	// runs; see mcp.Tool.
+	MaxConcurrent int
+	Queue         bool
+	RatePerMinute int
+	// Approval requires host-side confirmation before the entry runs.
+	// Empty means ApprovalNever.
+	Approval ApprovalMode
+	// Dir is the command's working directory relative to the executor's
+	// WorkingDir.
+	Dir string
+	// Env sets variables for the command, overriding inherited ones.
+	Env map[string]string
+	// CleanEnv drops the host environment except for variables named in
+	// EnvAllow; a trailing * matches a prefix.
+	CleanEnv   bool
+	EnvAllow   []string
+	compiledRE *regexp.Regexp
 }
 
 // Manifest represents parsed alias definitions.
@@ -78,6 +118,21 @@ func NewEntry(name, description, command, regex string) (*Entry, error) {
 	return entry, nil
 }
 
+// NewArgvEntry constructs an argv-mode alias entry. The command is never
+// interpreted by a shell; regex validates each argument individually.
+func NewArgvEntry(name, description string, argv []string, regex string) (*Entry, error) {
+	if len(argv) == 0 || strings.TrimSpace(argv[0]) == "" {
+		return nil, fmt.Errorf("missing command for alias %q", name)
+	}
+	entry, err := NewEntry(name, description, strings.Join(argv, " "), regex)
+	if err != nil {
+		return nil, err
+	}
+	entry.Mode = ExecArgv
+	entry.Argv = append([]string(nil), argv...)
+	return entry, nil
+}
+
 func parseLine(line string) (*Entry, error) {
 	aliasToken, rest := readField(line)
 	if aliasToken == "" || rest == "" {
@@ -97,6 +152,7 @@ func parseLine(line string) (*Entry, error) {
 	entry := &Entry{
 		Name:    aliasToken,
 		Command: command,
+		Mode:    ExecShell,
 	}
 	if regexToken != "-" {
 		wrapped := fmt.Sprintf("^(?:%s)$", regexToken)
@@ -136,3 +192,19 @@ func (e *Entry) ValidateArgs(argString string) error {
 	}
 	return fmt.Errorf("arguments %q do not match allowed pattern %q", argString, e.ArgsRegex)
 }
+
+// ValidateArgv ensures every argument individually matches the manifest entry.
+func (e *Entry) ValidateArgv(args []string) error {
+	if e.compiledRE == nil {
+		if len(args) > 0 {
+			return fmt.Errorf("alias %q does not accept arguments", e.Name)
+		}
+		return nil
+	}
+	for i, arg := range args {
+		if !e.compiledRE.MatchString(arg) {
+			return fmt.Errorf("argument %d %q does not match allowed pattern %q", i, arg, e.ArgsRegex)
+		}
+	}
+	return nil
+}
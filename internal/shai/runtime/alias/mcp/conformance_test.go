@@ -0,0 +1,231 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fixture is a hand-written exchange between an MCP client and the server,
+// following the message shapes each protocol revision defines. Expected
+// bodies are compared exactly, except that the string "<any>" matches any
+// value.
+type fixture struct {
+	Description string        `json:"description"`
+	Steps       []fixtureStep `json:"steps"`
+}
+
+type fixtureStep struct {
+	Name     string          `json:"name"`
+	Request  fixtureRequest  `json:"request"`
+	Response fixtureResponse `json:"response"`
+}
+
+type fixtureRequest struct {
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+	Raw     string            `json:"raw"`
+}
+
+type fixtureResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+const anyValue = "<any>"
+
+func TestConformanceFixtures(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "fixtures", "*.json"))
+	if err != nil {
+		t.Fatalf("glob fixtures: %v", err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no fixtures found")
+	}
+	for _, file := range files {
+		file := file
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".json"), func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("read fixture: %v", err)
+			}
+			var fx fixture
+			if err := json.Unmarshal(data, &fx); err != nil {
+				t.Fatalf("decode fixture: %v", err)
+			}
+			replayFixture(t, fx)
+		})
+	}
+}
+
+func replayFixture(t *testing.T, fx fixture) {
+	t.Helper()
+	server, err := NewServer(Config{
+		Token:         "secret",
+		SessionID:     "session",
+		Executor:      newFixtureExecutor(),
+		MaxConcurrent: 2,
+		ServerVersion: "test",
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	server.Start()
+	defer server.Close(context.Background())
+	endpoint := fmt.Sprintf("http://127.0.0.1:%d/mcp", server.Port())
+
+	for i, step := range fx.Steps {
+		label := fmt.Sprintf("step %d (%s)", i, step.Name)
+
+		method := step.Request.Method
+		if method == "" {
+			method = http.MethodPost
+		}
+		body := []byte(step.Request.Raw)
+		if len(step.Request.Body) > 0 {
+			body = step.Request.Body
+		}
+		req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s: build request: %v", label, err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		for k, v := range step.Request.Headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: http: %v", label, err)
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != step.Response.Status {
+			t.Fatalf("%s: expected status %d, got %d: %s", label, step.Response.Status, resp.StatusCode, respBody)
+		}
+		for k, want := range step.Response.Headers {
+			if got := resp.Header.Get(k); got != want {
+				t.Fatalf("%s: expected header %s=%q, got %q", label, k, want, got)
+			}
+		}
+		if len(step.Response.Body) == 0 {
+			if len(bytes.TrimSpace(respBody)) != 0 && step.Response.Status == http.StatusAccepted {
+				t.Fatalf("%s: expected empty body, got %s", label, respBody)
+			}
+			continue
+		}
+		var want, got any
+		if err := json.Unmarshal(step.Response.Body, &want); err != nil {
+			t.Fatalf("%s: decode expected body: %v", label, err)
+		}
+		if err := json.Unmarshal(respBody, &got); err != nil {
+			t.Fatalf("%s: decode response body %q: %v", label, respBody, err)
+		}
+		if path, ok := matchJSON(want, got, "$"); !ok {
+			t.Fatalf("%s: response mismatch at %s\nwant: %s\ngot:  %s", label, path, step.Response.Body, respBody)
+		}
+	}
+}
+
+func matchJSON(want, got any, path string) (string, bool) {
+	if s, ok := want.(string); ok && s == anyValue {
+		return "", got != nil
+	}
+	switch w := want.(type) {
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !ok {
+			return path, false
+		}
+		keys := make([]string, 0, len(w)+len(g))
+		for k := range w {
+			keys = append(keys, k)
+		}
+		for k := range g {
+			if _, ok := w[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			wv, wok := w[k]
+			gv, gok := g[k]
+			if !wok || !gok {
+				return path + "." + k, false
+			}
+			if p, ok := matchJSON(wv, gv, path+"."+k); !ok {
+				return p, false
+			}
+		}
+		return "", true
+	case []any:
+		g, ok := got.([]any)
+		if !ok || len(g) != len(w) {
+			return path, false
+		}
+		for i := range w {
+			if p, ok := matchJSON(w[i], g[i], fmt.Sprintf("%s[%d]", path, i)); !ok {
+				return p, false
+			}
+		}
+		return "", true
+	default:
+		return path, fmt.Sprint(want) == fmt.Sprint(got) && (want == nil) == (got == nil)
+	}
+}
+
+// fixtureExecutor provides deterministic tools for fixture replay.
+type fixtureExecutor struct{}
+
+func newFixtureExecutor() *fixtureExecutor {
+	return &fixtureExecutor{}
+}
+
+func (e *fixtureExecutor) Tools() []Tool {
+	noExtra := false
+	return []Tool{
+		{Name: "echo", Description: "Echo arguments"},
+		{
+			Name:        "deploy",
+			Description: "Deploy a service",
+			InputSchema: &InputSchema{
+				Type: "object",
+				Properties: map[string]*PropertySchema{
+					"env":     {Type: "string", Enum: []string{"staging", "production"}, Description: "Target environment"},
+					"dry-run": {Type: "boolean"},
+				},
+				Required:             []string{"env"},
+				AdditionalProperties: &noExtra,
+			},
+		},
+		{Name: "fail", Description: "Always fails"},
+	}
+}
+
+func (e *fixtureExecutor) Execute(ctx context.Context, req CallRequest, streams Streams) (int, error) {
+	switch req.Name {
+	case "echo":
+		fmt.Fprintln(streams.Stdout, strings.Join(req.Args, " "))
+		return 0, nil
+	case "deploy":
+		fmt.Fprintf(streams.Stdout, "deploying to %v\n", req.Params["env"])
+		if req.Params["dry-run"] == true {
+			fmt.Fprintln(streams.Stderr, "dry run: nothing changed")
+		}
+		return 0, nil
+	case "fail":
+		fmt.Fprintln(streams.Stderr, "boom")
+		return 3, nil
+	}
+	return 0, fmt.Errorf("unexpected tool %q", req.Name)
+}
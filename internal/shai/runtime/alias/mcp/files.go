@@ -0,0 +1,118 @@
+package mcp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+// fileNotification carries output file data on streamed callTool responses.
+const fileNotification = "shai/file"
+
+// fileChunkSize is the raw size of each streamed file chunk. It is a multiple
+// of 3 so every chunk is valid base64 on its own and can be decoded as it
+// arrives.
+const fileChunkSize = 48 * 1024
+
+// OutputFile declares a file a tool sends back to the caller.
+type OutputFile struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// FileWriter sends an output file to the caller. Executors call it after the
+// command finishes, once per produced file.
+type FileWriter func(name string, r io.Reader) error
+
+// FileResult is a returned file in a callTool result.
+type FileResult struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Data string `json:"data"`
+}
+
+// fileChunk is the payload of a shai/file notification. The last chunk of a
+// file has EOF set and no data.
+type fileChunk struct {
+	Name string `json:"name"`
+	Data string `json:"data,omitempty"`
+	EOF  bool   `json:"eof,omitempty"`
+}
+
+// outputFilesMetaKey lists a tool's output files in its tools/list _meta.
+const outputFilesMetaKey = "shai/outputFiles"
+
+// resourceContent is an MCP embedded resource content block.
+type resourceContent struct {
+	Type     string           `json:"type"`
+	Resource embeddedResource `json:"resource"`
+}
+
+type embeddedResource struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType"`
+	Blob     string `json:"blob"`
+}
+
+func fileResource(f FileResult) resourceContent {
+	return resourceContent{
+		Type: "resource",
+		Resource: embeddedResource{
+			URI:      "shai://files/" + f.Name,
+			MimeType: "application/octet-stream",
+			Blob:     f.Data,
+		},
+	}
+}
+
+// file reads an output file, streaming it to fileSink in chunks and keeping
+// it when the collector retains output.
+func (c *outputCollector) file(name string, r io.Reader) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var kept bytes.Buffer
+	var size int64
+	buf := make([]byte, fileChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			size += int64(n)
+			if c.retain {
+				kept.Write(buf[:n])
+			}
+			if c.fileSink != nil {
+				c.fileSink(fileChunk{Name: name, Data: base64.StdEncoding.EncodeToString(buf[:n])})
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if c.fileSink != nil {
+		c.fileSink(fileChunk{Name: name, EOF: true})
+	}
+	if c.retain {
+		c.files = append(c.files, FileResult{
+			Name: name,
+			Size: size,
+			Data: base64.StdEncoding.EncodeToString(kept.Bytes()),
+		})
+	}
+	return nil
+}
+
+func (c *outputCollector) fileResults() []FileResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.files) == 0 {
+		return nil
+	}
+	out := make([]FileResult, len(c.files))
+	copy(out, c.files)
+	return out
+}
@@ -0,0 +1,92 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fileExecutor returns a report file larger than one stream chunk.
+type fileExecutor struct {
+	report []byte
+}
+
+func (f *fileExecutor) Tools() []Tool {
+	return []Tool{{Name: "report", OutputFiles: []OutputFile{{Name: "report.xml", Description: "JUnit report"}}}}
+}
+
+func (f *fileExecutor) Execute(ctx context.Context, req CallRequest, streams Streams) (int, error) {
+	fmt.Fprint(streams.Stdout, "tests passed\n")
+	return 0, streams.File("report.xml", bytes.NewReader(f.report))
+}
+
+func TestServerStreamsOutputFiles(t *testing.T) {
+	exec := &fileExecutor{report: bytes.Repeat([]byte("<testcase/>"), fileChunkSize/5)}
+	server, endpoint := startTestServer(t, exec)
+	defer server.Close(context.Background())
+
+	resp, events := postStream(t, endpoint, `{"jsonrpc":"2.0","id":3,"method":"callTool","params":{"name":"report"}}`)
+	defer resp.Body.Close()
+
+	var got bytes.Buffer
+	chunks := 0
+	for {
+		msg := nextEvent(t, events)
+		if msg["method"] == outputNotification {
+			continue
+		}
+		if msg["method"] != fileNotification {
+			if _, ok := msg["result"].(map[string]any)["files"]; ok {
+				t.Fatalf("streamed files should not be repeated in the result: %v", msg)
+			}
+			break
+		}
+		params := msg["params"].(map[string]any)
+		if params["name"] != "report.xml" {
+			t.Fatalf("unexpected file %v", params["name"])
+		}
+		if params["eof"] == true {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(params["data"].(string))
+		if err != nil {
+			t.Fatalf("chunk %d is not standalone base64: %v", chunks, err)
+		}
+		got.Write(data)
+		chunks++
+	}
+	if chunks < 2 || !bytes.Equal(got.Bytes(), exec.report) {
+		t.Fatalf("expected the report in several chunks, got %d chunks and %d bytes", chunks, got.Len())
+	}
+}
+
+func TestServerReturnsFilesInResults(t *testing.T) {
+	exec := &fileExecutor{report: []byte("<testsuite/>")}
+	server, endpoint := startTestServer(t, exec)
+	defer server.Close(context.Background())
+
+	list := doRequest(t, endpoint, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	tool := list.Result.(map[string]any)["tools"].([]any)[0].(map[string]any)
+	outputs := tool["_meta"].(map[string]any)[outputFilesMetaKey].([]any)
+	if outputs[0].(map[string]any)["name"] != "report.xml" {
+		t.Fatalf("expected output files in _meta, got %v", tool)
+	}
+
+	legacy := doRequest(t, endpoint, `{"jsonrpc":"2.0","id":2,"method":"callTool","params":{"name":"report"}}`)
+	files := legacy.Result.(map[string]any)["files"].([]any)
+	file := files[0].(map[string]any)
+	if file["name"] != "report.xml" || file["data"] != base64.StdEncoding.EncodeToString(exec.report) {
+		t.Fatalf("unexpected callTool files %v", files)
+	}
+
+	call := doRequest(t, endpoint, `{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"report","arguments":{}}}`)
+	content := call.Result.(map[string]any)["content"].([]any)
+	last := content[len(content)-1].(map[string]any)
+	resource := last["resource"].(map[string]any)
+	if last["type"] != "resource" || !strings.HasSuffix(resource["uri"].(string), "/report.xml") || resource["blob"] != base64.StdEncoding.EncodeToString(exec.report) {
+		t.Fatalf("expected an embedded resource, got %v", last)
+	}
+}
@@ -0,0 +1,131 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const rateWindow = time.Minute
+
+// toolLimiter enforces a tool's concurrency and rate settings.
+type toolLimiter struct {
+	name  string
+	slots chan struct{}
+	wait  bool
+	rate  int
+	now   func() time.Time
+
+	mu     sync.Mutex
+	starts []time.Time
+}
+
+func newToolLimiter(tool Tool) *toolLimiter {
+	l := &toolLimiter{
+		name: tool.Name,
+		wait: tool.Queue,
+		rate: tool.RatePerMinute,
+		now:  time.Now,
+	}
+	if tool.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, tool.MaxConcurrent)
+	}
+	return l
+}
+
+// allow records a call start against the rate limit, or reports how long
+// the caller should wait before retrying.
+func (l *toolLimiter) allow() (time.Duration, bool) {
+	return l.rateCheck(true)
+}
+
+// check reports what allow would without recording a start.
+func (l *toolLimiter) check() (time.Duration, bool) {
+	return l.rateCheck(false)
+}
+
+func (l *toolLimiter) rateCheck(record bool) (time.Duration, bool) {
+	if l.rate <= 0 {
+		return 0, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	cutoff := now.Add(-rateWindow)
+	kept := l.starts[:0]
+	for _, t := range l.starts {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	l.starts = kept
+	if len(l.starts) >= l.rate {
+		return l.starts[0].Add(rateWindow).Sub(now), false
+	}
+	if record {
+		l.starts = append(l.starts, now)
+	}
+	return 0, true
+}
+
+// acquire takes a slot from sem, queueing when the tool is configured to
+// wait. A nil sem is unlimited.
+func (l *toolLimiter) acquire(ctx context.Context, sem chan struct{}, full string) (func(), *rpcError) {
+	if sem == nil {
+		return func() {}, nil
+	}
+	release := func() { <-sem }
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if !l.wait {
+		return nil, &rpcError{Code: codePoolExhausted, Message: full}
+	}
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, &rpcError{Code: codePoolExhausted, Message: fmt.Sprintf("call %q cancelled while queued: %v", l.name, ctx.Err())}
+	}
+}
+
+// admit applies the tool's rate limit, its own concurrency limit and then the
+// server-wide pool. Only calls that get both slots count against the rate.
+// The returned func releases every slot taken.
+func (s *Server) admit(ctx context.Context, name string) (func(), *rpcError) {
+	l := s.limiters[name]
+	if l == nil {
+		l = newToolLimiter(Tool{Name: name})
+	}
+	rateLimited := func(retry time.Duration) *rpcError {
+		return &rpcError{
+			Code:    codeRateLimited,
+			Message: fmt.Sprintf("call %q exceeded its rate limit of %d per minute; retry in %s", name, l.rate, retry.Round(time.Second)),
+		}
+	}
+	if retry, ok := l.check(); !ok {
+		return nil, rateLimited(retry)
+	}
+	releaseTool, rpcErr := l.acquire(ctx, l.slots, fmt.Sprintf("call %q is at its concurrency limit (%d)", name, cap(l.slots)))
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	releasePool, rpcErr := l.acquire(ctx, s.sem, "alias execution pool exhausted")
+	if rpcErr != nil {
+		releaseTool()
+		return nil, rpcErr
+	}
+	release := func() {
+		releasePool()
+		releaseTool()
+	}
+	// Calls that queued may find the rate used up by others meanwhile.
+	if retry, ok := l.allow(); !ok {
+		release()
+		return nil, rateLimited(retry)
+	}
+	return release, nil
+}
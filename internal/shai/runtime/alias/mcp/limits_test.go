@@ -0,0 +1,226 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingExecutor holds every call until release is closed.
+type blockingExecutor struct {
+	tools   []Tool
+	started chan string
+	release chan struct{}
+}
+
+func (b *blockingExecutor) Tools() []Tool {
+	return b.tools
+}
+
+func (b *blockingExecutor) Execute(ctx context.Context, req CallRequest, streams Streams) (int, error) {
+	b.started <- req.Name
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+		return 1, ctx.Err()
+	}
+	return 0, nil
+}
+
+func startLimitServer(t *testing.T, exec Executor, poolSize int) (*Server, string) {
+	t.Helper()
+	server, err := NewServer(Config{
+		Token:         "secret",
+		SessionID:     "session",
+		Executor:      exec,
+		MaxConcurrent: poolSize,
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	server.Start()
+	return server, fmt.Sprintf("http://127.0.0.1:%d/mcp", server.Port())
+}
+
+func callPayload(name string) string {
+	return `{"jsonrpc":"2.0","id":1,"method":"callTool","params":{"name":"` + name + `"}}`
+}
+
+func TestToolConcurrencyLimitRejects(t *testing.T) {
+	exec := &blockingExecutor{
+		tools:   []Tool{{Name: "deploy", MaxConcurrent: 1}, {Name: "status"}},
+		started: make(chan string, 4),
+		release: make(chan struct{}),
+	}
+	server, endpoint := startLimitServer(t, exec, 4)
+	defer server.Close(context.Background())
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		doRequest(t, endpoint, callPayload("deploy"))
+	}()
+	<-exec.started
+
+	resp := doRequest(t, endpoint, callPayload("deploy"))
+	if resp.Error == nil || resp.Error.Code != codePoolExhausted || !strings.Contains(resp.Error.Message, "concurrency limit (1)") {
+		t.Fatalf("expected per-call concurrency error, got %+v", resp.Error)
+	}
+
+	// A hung deploy must not block other calls.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if resp := doRequest(t, endpoint, callPayload("status")); resp.Error != nil {
+			t.Errorf("status call failed: %+v", resp.Error)
+		}
+	}()
+	if name := <-exec.started; name != "status" {
+		t.Fatalf("expected status to start, got %s", name)
+	}
+	close(exec.release)
+	wg.Wait()
+}
+
+func TestToolQueueWaits(t *testing.T) {
+	exec := &blockingExecutor{
+		tools:   []Tool{{Name: "deploy", MaxConcurrent: 1, Queue: true}},
+		started: make(chan string, 4),
+		release: make(chan struct{}),
+	}
+	server, endpoint := startLimitServer(t, exec, 4)
+	defer server.Close(context.Background())
+
+	results := make(chan *rpcResponse, 2)
+	for i := 0; i < 2; i++ {
+		go func() { results <- doRequest(t, endpoint, callPayload("deploy")) }()
+	}
+	<-exec.started
+	select {
+	case name := <-exec.started:
+		t.Fatalf("second %s call started while first was running", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(exec.release)
+	<-exec.started
+	for i := 0; i < 2; i++ {
+		if resp := <-results; resp.Error != nil {
+			t.Fatalf("queued call failed: %+v", resp.Error)
+		}
+	}
+}
+
+func TestToolRateLimit(t *testing.T) {
+	exec := &fakeExecutor{tools: []Tool{{Name: "status", RatePerMinute: 2}}}
+	server, endpoint := startTestServer(t, exec)
+	defer server.Close(context.Background())
+
+	for i := 0; i < 2; i++ {
+		if resp := doRequest(t, endpoint, callPayload("status")); resp.Error != nil {
+			t.Fatalf("call %d failed: %+v", i, resp.Error)
+		}
+	}
+	resp := doRequest(t, endpoint, callPayload("status"))
+	if resp.Error == nil || resp.Error.Code != codeRateLimited {
+		t.Fatalf("expected rate limit error, got %+v", resp)
+	}
+	if !strings.Contains(resp.Error.Message, "2 per minute") {
+		t.Fatalf("unexpected message %q", resp.Error.Message)
+	}
+}
+
+func TestToolLimiterWindowSlides(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newToolLimiter(Tool{Name: "status", RatePerMinute: 1})
+	l.now = func() time.Time { return now }
+
+	if _, ok := l.allow(); !ok {
+		t.Fatalf("first call should be allowed")
+	}
+	now = now.Add(30 * time.Second)
+	retry, ok := l.allow()
+	if ok || retry != 30*time.Second {
+		t.Fatalf("expected rejection with 30s retry, got ok=%v retry=%s", ok, retry)
+	}
+	now = now.Add(31 * time.Second)
+	if _, ok := l.allow(); !ok {
+		t.Fatalf("call after the window should be allowed")
+	}
+}
+
+func TestServerReportsRejectedCalls(t *testing.T) {
+	exec := &fakeExecutor{tools: []Tool{{Name: "status", RatePerMinute: 1}, {Name: "typed", InputSchema: deploySchema()}}}
+	var rejected []string
+	server, err := NewServer(Config{
+		Token:     "secret",
+		SessionID: "session",
+		Executor:  exec,
+		Rejected: func(name, reason string) {
+			rejected = append(rejected, name+": "+reason)
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	server.Start()
+	defer server.Close(context.Background())
+	endpoint := fmt.Sprintf("http://127.0.0.1:%d/mcp", server.Port())
+
+	for _, payload := range []string{
+		callPayload("status"),
+		callPayload("status"),
+		callPayload("missing"),
+		`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"typed","arguments":{"env":"qa"}}}`,
+	} {
+		doRequest(t, endpoint, payload)
+	}
+	want := []string{
+		`status: call "status" exceeded its rate limit of 1 per minute`,
+		`missing: alias "missing" not found`,
+		`typed: invalid arguments for typed`,
+	}
+	if len(rejected) != len(want) {
+		t.Fatalf("expected %d rejections, got %q", len(want), rejected)
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(rejected[i], prefix) {
+			t.Fatalf("rejection %d: expected prefix %q, got %q", i, prefix, rejected[i])
+		}
+	}
+}
+
+func TestToolRateLimitIgnoresRejectedCalls(t *testing.T) {
+	exec := &blockingExecutor{
+		tools:   []Tool{{Name: "deploy", MaxConcurrent: 1, RatePerMinute: 2}},
+		started: make(chan string, 4),
+		release: make(chan struct{}),
+	}
+	server, endpoint := startLimitServer(t, exec, 4)
+	defer server.Close(context.Background())
+
+	done := make(chan *rpcResponse)
+	go func() { done <- doRequest(t, endpoint, callPayload("deploy")) }()
+	<-exec.started
+
+	// Turned away for concurrency, so it must not use up the rate.
+	resp := doRequest(t, endpoint, callPayload("deploy"))
+	if resp.Error == nil || resp.Error.Code != codePoolExhausted {
+		t.Fatalf("expected concurrency error, got %+v", resp.Error)
+	}
+	close(exec.release)
+	if resp := <-done; resp.Error != nil {
+		t.Fatalf("first call failed: %+v", resp.Error)
+	}
+
+	if resp := doRequest(t, endpoint, callPayload("deploy")); resp.Error != nil {
+		t.Fatalf("second started call should be within the rate: %+v", resp.Error)
+	}
+	resp = doRequest(t, endpoint, callPayload("deploy"))
+	if resp.Error == nil || resp.Error.Code != codeRateLimited {
+		t.Fatalf("expected rate limit error, got %+v", resp.Error)
+	}
+}
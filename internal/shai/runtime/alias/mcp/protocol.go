@@ -0,0 +1,201 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// LatestProtocolVersion is the newest MCP revision the server implements.
+const LatestProtocolVersion = "2025-06-18"
+
+// supportedProtocolVersions lists accepted MCP revisions, newest first.
+var supportedProtocolVersions = []string{
+	LatestProtocolVersion,
+	"2025-03-26",
+	"2024-11-05",
+}
+
+// firstSessionProtocolVersion is the revision that introduced the Streamable
+// HTTP transport and its Mcp-Session-Id header. Revisions are dates, so they
+// compare as strings.
+const firstSessionProtocolVersion = "2025-03-26"
+
+const (
+	protocolVersionHeader = "Mcp-Protocol-Version"
+	sessionIDHeader       = "Mcp-Session-Id"
+	defaultServerName     = "shai"
+	defaultServerVersion  = "dev"
+)
+
+// JSON-RPC and server-defined error codes.
+const (
+	codeParseError      = -32700
+	codeInvalidRequest  = -32600
+	codeMethodNotFound  = -32601
+	codeInvalidParams   = -32602
+	codeAliasNotFound   = -32001
+	codePoolExhausted   = -32002
+	codeExecutionFailed = -32003
+	codeRateLimited     = -32004
+	codeApprovalDenied  = -32005
+)
+
+func supportedProtocolVersion(version string) bool {
+	for _, v := range supportedProtocolVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateProtocolVersion returns the requested version when supported and
+// the latest version otherwise, as required by the MCP lifecycle.
+func negotiateProtocolVersion(requested string) string {
+	if supportedProtocolVersion(requested) {
+		return requested
+	}
+	return LatestProtocolVersion
+}
+
+type initializeParams struct {
+	ProtocolVersion string         `json:"protocolVersion"`
+	Capabilities    map[string]any `json:"capabilities"`
+	ClientInfo      implementation `json:"clientInfo"`
+}
+
+type implementation struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type initializeResult struct {
+	ProtocolVersion string             `json:"protocolVersion"`
+	Capabilities    serverCapabilities `json:"capabilities"`
+	ServerInfo      implementation     `json:"serverInfo"`
+	Instructions    string             `json:"instructions,omitempty"`
+}
+
+type serverCapabilities struct {
+	Tools toolsCapability `json:"tools"`
+}
+
+type toolsCapability struct {
+	ListChanged bool `json:"listChanged"`
+}
+
+// textContent is an MCP text content block.
+type textContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// toolCallResult is the MCP tools/call result payload. Content holds
+// textContent and resourceContent blocks.
+type toolCallResult struct {
+	Content           []any          `json:"content"`
+	IsError           bool           `json:"isError"`
+	StructuredContent map[string]any `json:"structuredContent,omitempty"`
+}
+
+type toolsCallParams struct {
+	Name      string         `json:"name"`
+	Arguments map[string]any `json:"arguments"`
+	Meta      requestMeta    `json:"_meta"`
+}
+
+type requestMeta struct {
+	ProgressToken any `json:"progressToken,omitempty"`
+}
+
+type progressParams struct {
+	ProgressToken any     `json:"progressToken"`
+	Progress      float64 `json:"progress"`
+	Message       string  `json:"message,omitempty"`
+}
+
+const serverInstructions = "Tools run curated commands on the host machine outside the sandbox. Each call returns the command output and its exit code."
+
+func (s *Server) initialize(raw json.RawMessage) (any, *rpcError) {
+	var params initializeParams
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("invalid params: %v", err)}
+		}
+	}
+	if strings.TrimSpace(params.ProtocolVersion) == "" {
+		return nil, &rpcError{Code: codeInvalidParams, Message: "protocolVersion is required"}
+	}
+	if params.ClientInfo.Name != "" {
+		s.logf("alias MCP client %s %s initializing with protocol %s", params.ClientInfo.Name, params.ClientInfo.Version, params.ProtocolVersion)
+	}
+	version := s.cfg.ServerVersion
+	if strings.TrimSpace(version) == "" {
+		version = defaultServerVersion
+	}
+	return initializeResult{
+		ProtocolVersion: negotiateProtocolVersion(params.ProtocolVersion),
+		Capabilities: serverCapabilities{
+			Tools: toolsCapability{ListChanged: false},
+		},
+		ServerInfo: implementation{
+			Name:    defaultServerName,
+			Version: version,
+		},
+		Instructions: serverInstructions,
+	}, nil
+}
+
+// toolResultFromExecution converts collected output into an MCP tool result.
+// Consecutive chunks from the same stream are merged into one text block, and
+// output files follow as embedded resources.
+func toolResultFromExecution(result execution) toolCallResult {
+	exitCode := result.exitCode
+	var text []textContent
+	var stdout, stderr strings.Builder
+	lastStream := ""
+	for _, chunk := range result.chunks {
+		if chunk.Stream == "stderr" {
+			stderr.WriteString(chunk.Text)
+		} else {
+			stdout.WriteString(chunk.Text)
+		}
+		if chunk.Stream == lastStream && len(text) > 0 {
+			text[len(text)-1].Text += chunk.Text
+			continue
+		}
+		text = append(text, textContent{Type: "text", Text: chunk.Text})
+		lastStream = chunk.Stream
+	}
+	if exitCode != 0 {
+		text = append(text, textContent{Type: "text", Text: fmt.Sprintf("exit code %d", exitCode)})
+	}
+	content := make([]any, 0, len(text)+len(result.files))
+	for _, block := range text {
+		content = append(content, block)
+	}
+	for _, f := range result.files {
+		content = append(content, fileResource(f))
+	}
+	structured := map[string]any{
+		"exitCode": exitCode,
+		"stdout":   stdout.String(),
+		"stderr":   stderr.String(),
+	}
+	if result.truncated {
+		structured["truncated"] = true
+	}
+	if len(result.files) > 0 {
+		files := make([]map[string]any, 0, len(result.files))
+		for _, f := range result.files {
+			files = append(files, map[string]any{"name": f.Name, "size": f.Size})
+		}
+		structured["files"] = files
+	}
+	return toolCallResult{
+		Content:           content,
+		IsError:           exitCode != 0,
+		StructuredContent: structured,
+	}
+}
@@ -0,0 +1,175 @@
+package mcp
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// InputSchema is the JSON Schema subset used to describe tool arguments.
+type InputSchema struct {
+	Type                 string                     `json:"type"`
+	Properties           map[string]*PropertySchema `json:"properties"`
+	Required             []string                   `json:"required,omitempty"`
+	AdditionalProperties *bool                      `json:"additionalProperties,omitempty"`
+}
+
+// PropertySchema describes a single tool argument.
+type PropertySchema struct {
+	Type        string          `json:"type"`
+	Description string          `json:"description,omitempty"`
+	Enum        []string        `json:"enum,omitempty"`
+	Pattern     string          `json:"pattern,omitempty"`
+	Items       *PropertySchema `json:"items,omitempty"`
+	// ContentEncoding is "base64" for file inputs.
+	ContentEncoding string `json:"contentEncoding,omitempty"`
+}
+
+// legacyArgsSchema is advertised for tools that accept free-form arguments.
+func legacyArgsSchema() *InputSchema {
+	return &InputSchema{
+		Type: "object",
+		Properties: map[string]*PropertySchema{
+			"args": {
+				Type:  "array",
+				Items: &PropertySchema{Type: "string"},
+			},
+		},
+	}
+}
+
+// compiledSchema pairs a typed tool schema with its compiled patterns.
+type compiledSchema struct {
+	schema   *InputSchema
+	patterns map[string]*regexp.Regexp
+}
+
+func compileSchema(schema *InputSchema) (*compiledSchema, error) {
+	cs := &compiledSchema{schema: schema, patterns: map[string]*regexp.Regexp{}}
+	for name, prop := range schema.Properties {
+		if prop == nil || prop.Pattern == "" {
+			continue
+		}
+		// As in JSON Schema, a pattern matches anywhere unless it is
+		// anchored; entries publish anchored patterns.
+		re, err := regexp.Compile(prop.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("property %q: invalid pattern: %w", name, err)
+		}
+		cs.patterns[name] = re
+	}
+	return cs, nil
+}
+
+// validate checks arguments against the schema and reports every problem.
+func (cs *compiledSchema) validate(args map[string]any) error {
+	var problems []string
+	for _, name := range cs.schema.Required {
+		if v, ok := args[name]; !ok || v == nil {
+			problems = append(problems, fmt.Sprintf("missing required argument %q", name))
+		}
+	}
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := args[name]
+		prop, ok := cs.schema.Properties[name]
+		if !ok {
+			if cs.schema.AdditionalProperties != nil && !*cs.schema.AdditionalProperties {
+				problems = append(problems, fmt.Sprintf("unknown argument %q", name))
+			}
+			continue
+		}
+		if value == nil {
+			continue
+		}
+		if msg := cs.checkValue(name, prop, value); msg != "" {
+			problems = append(problems, msg)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (cs *compiledSchema) checkValue(name string, prop *PropertySchema, value any) string {
+	switch prop.Type {
+	case "integer":
+		f, ok := value.(float64)
+		if !ok || f != math.Trunc(f) {
+			return fmt.Sprintf("argument %q must be an integer", name)
+		}
+		if f < math.MinInt64 || f >= math.MaxInt64 {
+			return fmt.Sprintf("argument %q is out of range", name)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Sprintf("argument %q must be a boolean", name)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Sprintf("argument %q must be a string", name)
+		}
+		if len(prop.Enum) > 0 {
+			for _, allowed := range prop.Enum {
+				if s == allowed {
+					return ""
+				}
+			}
+			return fmt.Sprintf("argument %q must be one of: %s", name, strings.Join(prop.Enum, ", "))
+		}
+		if re := cs.patterns[name]; re != nil && !re.MatchString(s) {
+			return fmt.Sprintf("argument %q does not match pattern %q", name, prop.Pattern)
+		}
+	}
+	return ""
+}
+
+// argumentsFromFlags converts command-line style "--name=value" arguments
+// into a typed arguments object using the schema. A bare "--name" sets a
+// boolean argument to true.
+func (cs *compiledSchema) argumentsFromFlags(flags []string) (map[string]any, error) {
+	out := make(map[string]any, len(flags))
+	for _, flag := range flags {
+		if !strings.HasPrefix(flag, "--") || len(flag) == 2 {
+			return nil, fmt.Errorf("unexpected argument %q (expected --name=value)", flag)
+		}
+		name, raw, hasValue := strings.Cut(flag[2:], "=")
+		prop, ok := cs.schema.Properties[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown argument %q", name)
+		}
+		switch prop.Type {
+		case "boolean":
+			if !hasValue {
+				out[name] = true
+				continue
+			}
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				return nil, fmt.Errorf("argument %q must be a boolean", name)
+			}
+			out[name] = b
+		case "integer":
+			n, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || !hasValue {
+				return nil, fmt.Errorf("argument %q must be an integer", name)
+			}
+			out[name] = float64(n)
+		default:
+			if !hasValue {
+				return nil, fmt.Errorf("argument %q requires a value", name)
+			}
+			out[name] = raw
+		}
+	}
+	return out, nil
+}
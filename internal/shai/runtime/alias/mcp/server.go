@@ -1,6 +1,7 @@
 package mcp
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -11,24 +12,58 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 // Tool describes a single alias published via MCP.
 type Tool struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	InputSchema *InputSchema `json:"inputSchema,omitempty"`
+	// MaxOutputBytes caps combined stdout/stderr for a call. Zero uses the
+	// server default.
+	MaxOutputBytes int `json:"-"`
+	// MaxConcurrent limits simultaneous executions of this tool on top of
+	// the server-wide limit. Zero means only the server limit applies.
+	MaxConcurrent int `json:"-"`
+	// Queue makes calls wait for a free slot instead of failing fast.
+	Queue bool `json:"-"`
+	// RatePerMinute limits how many calls may start in any one-minute
+	// window. Zero disables the limit.
+	RatePerMinute int `json:"-"`
+	// OutputFiles lists files the tool returns; they are advertised in the
+	// tool's _meta so callers know where to save them.
+	OutputFiles []OutputFile `json:"-"`
+	// MaxInputBytes is the combined size of the files a call may upload.
+	// The largest across all tools sizes the server's request body limit.
+	MaxInputBytes int64 `json:"-"`
 }
 
-// Streams configures stdout/stderr writers for an execution.
+// CallRequest identifies a tool invocation. Typed tools receive validated
+// Params; tools without a schema receive free-form Args.
+type CallRequest struct {
+	Name   string
+	Args   []string
+	Params map[string]any
+}
+
+// Streams configures stdout/stderr writers for an execution. File sends
+// output files back to the caller.
 type Streams struct {
 	Stdout io.Writer
 	Stderr io.Writer
+	File   FileWriter
 }
 
+// ErrNotApproved is wrapped by executor errors when a call needed host-side
+// approval and was denied or the approval timed out. The server reports it
+// with its own JSON-RPC error code rather than as a tool failure.
+var ErrNotApproved = errors.New("call not approved")
+
 // Executor defines the interface the MCP server uses to run alias commands.
 type Executor interface {
 	Tools() []Tool
-	Execute(ctx context.Context, name string, args []string, streams Streams) (int, error)
+	Execute(ctx context.Context, req CallRequest, streams Streams) (int, error)
 }
 
 // Logger emits debug messages from the server.
@@ -44,28 +79,58 @@ type Config struct {
 	Executor      Executor
 	Logger        Logger
 	MaxConcurrent int
+	// ServerVersion is reported in the MCP initialize handshake.
+	ServerVersion string
+	// MaxOutputBytes caps combined stdout/stderr per call for tools that do
+	// not set their own limit. Zero uses DefaultMaxOutputBytes; negative
+	// disables the cap.
+	MaxOutputBytes int
+	// Rejected is told about every call turned away before it reaches the
+	// executor: unknown tools, invalid arguments, rate limits and full
+	// pools. The reason is the error message sent to the caller.
+	Rejected func(name, reason string)
 }
 
+// DefaultMaxOutputBytes is the per-call output cap used when none is set.
+const DefaultMaxOutputBytes = 1 << 20
+
+// requestOverheadBytes is the request body allowance for everything but
+// uploaded files: the JSON-RPC envelope, parameters and arguments.
+const requestOverheadBytes = 1 << 20
+
 // Server hosts alias commands as MCP tools.
 type Server struct {
 	cfg        Config
 	httpServer *http.Server
 	listener   net.Listener
 	entryMap   map[string]Tool
+	schemas    map[string]*compiledSchema
+	limiters   map[string]*toolLimiter
 	tools      []toolDescriptor
 	sem        chan struct{}
+	maxOutput  int
+	maxBody    int64
 	logger     Logger
 	executor   Executor
 
+	// sessions maps each Mcp-Session-Id issued by initialize to the
+	// protocol revision it negotiated. legacyClients is set once a client
+	// initializes on a revision without sessions, whose requests cannot
+	// carry the header.
+	sessionMu     sync.Mutex
+	sessions      map[string]string
+	legacyClients bool
+
 	mu    sync.RWMutex
 	alive bool
 }
 
-// Tool metadata presented via listTools.
+// Tool metadata presented via tools/list and listTools.
 type toolDescriptor struct {
-	Name        string                 `json:"name"`
-	Description string                 `json:"description"`
-	InputSchema map[string]interface{} `json:"inputSchema"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema *InputSchema   `json:"inputSchema"`
+	Meta        map[string]any `json:"_meta,omitempty"`
 }
 
 // OutputChunk carries command output in MCP format.
@@ -77,8 +142,10 @@ type OutputChunk struct {
 
 // CallResult models the MCP response payload.
 type CallResult struct {
-	ExitCode int           `json:"exitCode"`
-	Content  []OutputChunk `json:"content"`
+	ExitCode  int           `json:"exitCode"`
+	Content   []OutputChunk `json:"content"`
+	Truncated bool          `json:"truncated,omitempty"`
+	Files     []FileResult  `json:"files,omitempty"`
 }
 
 type rpcRequest struct {
@@ -120,7 +187,12 @@ func NewServer(cfg Config) (*Server, error) {
 		return nil, fmt.Errorf("listen on %s: %w", bindAddr, err)
 	}
 
+	// Uploads arrive base64 encoded, so the body limit allows for the
+	// encoding of the largest upload any tool accepts.
+	maxBody := int64(requestOverheadBytes)
 	entryMap := make(map[string]Tool)
+	schemas := make(map[string]*compiledSchema)
+	limiters := make(map[string]*toolLimiter)
 	executorTools := cfg.Executor.Tools()
 	tools := make([]toolDescriptor, 0, len(executorTools))
 	for _, tool := range executorTools {
@@ -128,39 +200,59 @@ func NewServer(cfg Config) (*Server, error) {
 			continue
 		}
 		entryMap[tool.Name] = tool
+		limiters[tool.Name] = newToolLimiter(tool)
+		if encoded := (tool.MaxInputBytes + 2) / 3 * 4; encoded+requestOverheadBytes > maxBody {
+			maxBody = encoded + requestOverheadBytes
+		}
 		desc := tool.Description
 		if strings.TrimSpace(desc) == "" {
 			desc = fmt.Sprintf("Runs alias %s on the host", tool.Name)
 		}
-		tools = append(tools, toolDescriptor{
+		schema := tool.InputSchema
+		if schema != nil {
+			compiled, err := compileSchema(schema)
+			if err != nil {
+				_ = ln.Close()
+				return nil, fmt.Errorf("tool %q: %w", tool.Name, err)
+			}
+			schemas[tool.Name] = compiled
+		} else {
+			schema = legacyArgsSchema()
+		}
+		descriptor := toolDescriptor{
 			Name:        tool.Name,
 			Description: desc,
-			InputSchema: map[string]interface{}{
-				"type": "object",
-				"properties": map[string]interface{}{
-					"args": map[string]interface{}{
-						"type":  "array",
-						"items": map[string]string{"type": "string"},
-					},
-				},
-			},
-		})
+			InputSchema: schema,
+		}
+		if len(tool.OutputFiles) > 0 {
+			descriptor.Meta = map[string]any{outputFilesMetaKey: tool.OutputFiles}
+		}
+		tools = append(tools, descriptor)
 	}
 
 	maxConcurrent := cfg.MaxConcurrent
 	if maxConcurrent <= 0 {
 		maxConcurrent = 4
 	}
+	maxOutput := cfg.MaxOutputBytes
+	if maxOutput == 0 {
+		maxOutput = DefaultMaxOutputBytes
+	}
 
 	server := &Server{
-		cfg:      cfg,
-		listener: ln,
-		entryMap: entryMap,
-		tools:    tools,
-		sem:      make(chan struct{}, maxConcurrent),
-		logger:   cfg.Logger,
-		executor: cfg.Executor,
-		alive:    true,
+		cfg:       cfg,
+		listener:  ln,
+		entryMap:  entryMap,
+		schemas:   schemas,
+		limiters:  limiters,
+		tools:     tools,
+		sem:       make(chan struct{}, maxConcurrent),
+		maxOutput: maxOutput,
+		maxBody:   maxBody,
+		logger:    cfg.Logger,
+		executor:  cfg.Executor,
+		sessions:  make(map[string]string),
+		alive:     true,
 	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/mcp", server.handleRPC)
@@ -211,114 +303,406 @@ func (s *Server) handleRPC(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if version := r.Header.Get(protocolVersionHeader); version != "" && !supportedProtocolVersion(version) {
+		http.Error(w, fmt.Sprintf("unsupported protocol version %q", version), http.StatusBadRequest)
+		return
+	}
+	if sid := r.Header.Get(sessionIDHeader); sid != "" && !s.knownSession(sid) {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
 	defer r.Body.Close()
 
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBody))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.writeStatusResponse(w, http.StatusRequestEntityTooLarge, rpcResponse{
+				JSONRPC: "2.0",
+				Error:   &rpcError{Code: codeInvalidRequest, Message: fmt.Sprintf("invalid request: body exceeds %d bytes", tooLarge.Limit)},
+			})
+			return
+		}
+		http.Error(w, fmt.Sprintf("read payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	trimmed := bytes.TrimSpace(body)
+
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var batch []rpcRequest
+		if err := json.Unmarshal(trimmed, &batch); err != nil || len(batch) == 0 {
+			s.writeStatusResponse(w, http.StatusBadRequest, parseErrorResponse(err))
+			return
+		}
+		if !s.checkSession(w, r, batch) {
+			return
+		}
+		var responses []rpcResponse
+		for _, req := range batch {
+			if resp, ok := s.dispatch(r.Context(), req, nil); ok {
+				s.startSession(w, req, resp)
+				responses = append(responses, resp)
+			}
+		}
+		if len(responses) == 0 {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(responses)
+		return
+	}
+
 	var req rpcRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, fmt.Sprintf("invalid payload: %v", err), http.StatusBadRequest)
+	if err := json.Unmarshal(trimmed, &req); err != nil {
+		s.writeStatusResponse(w, http.StatusBadRequest, parseErrorResponse(err))
+		return
+	}
+	if !s.checkSession(w, r, []rpcRequest{req}) {
+		return
+	}
+	if s.wantsEventStream(r, req) {
+		stream := newEventStream(w)
+		resp, _ := s.dispatch(r.Context(), req, stream.notify)
+		stream.send(resp)
 		return
 	}
+	resp, ok := s.dispatch(r.Context(), req, nil)
+	if !ok {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	s.startSession(w, req, resp)
+	s.writeResponse(w, resp)
+}
+
+// checkSession enforces the Streamable HTTP session rules on a request
+// without a known Mcp-Session-Id. initialize may not share a batch with
+// other messages. After a client has initialized on a revision with
+// sessions, requests without the header are refused unless they use the
+// pre-MCP methods shai-remote sends without initializing, or an older
+// client that cannot send the header has initialized too. A request that
+// declares a revision with sessions in Mcp-Protocol-Version always needs
+// the header.
+func (s *Server) checkSession(w http.ResponseWriter, r *http.Request, msgs []rpcRequest) bool {
+	needsSession := false
+	for _, msg := range msgs {
+		switch msg.Method {
+		case "initialize":
+			if len(msgs) > 1 {
+				s.writeStatusResponse(w, http.StatusBadRequest, rpcResponse{
+					JSONRPC: "2.0",
+					ID:      msg.ID,
+					Error:   &rpcError{Code: codeInvalidRequest, Message: "invalid request: initialize must not be batched"},
+				})
+				return false
+			}
+		case "listTools", "callTool":
+		default:
+			needsSession = true
+		}
+	}
+	if !needsSession || r.Header.Get(sessionIDHeader) != "" {
+		return true
+	}
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	if len(s.sessions) == 0 {
+		return true
+	}
+	if version := r.Header.Get(protocolVersionHeader); version < firstSessionProtocolVersion && s.legacyClients {
+		return true
+	}
+	http.Error(w, "missing "+sessionIDHeader+" header", http.StatusBadRequest)
+	return false
+}
 
+// startSession records a successful initialize. Revisions with sessions get
+// a new session ID, returned in the Mcp-Session-Id header before the
+// response is written.
+func (s *Server) startSession(w http.ResponseWriter, req rpcRequest, resp rpcResponse) {
+	result, ok := resp.Result.(initializeResult)
+	if req.Method != "initialize" || !ok {
+		return
+	}
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	if result.ProtocolVersion < firstSessionProtocolVersion {
+		s.legacyClients = true
+		return
+	}
+	id := fmt.Sprintf("%s-%d", s.cfg.SessionID, len(s.sessions)+1)
+	s.sessions[id] = result.ProtocolVersion
+	w.Header().Set(sessionIDHeader, id)
+}
+
+// knownSession reports whether id was issued by initialize or is the
+// sandbox's own SHAI_ALIAS_SESSION_ID, which shai-remote sends.
+func (s *Server) knownSession(id string) bool {
+	if id == s.cfg.SessionID {
+		return true
+	}
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	_, ok := s.sessions[id]
+	return ok
+}
+
+// dispatch routes a JSON-RPC message. It returns false for notifications,
+// which never receive a response. When notify is non-nil, call output is
+// forwarded as notifications while the command runs.
+func (s *Server) dispatch(ctx context.Context, req rpcRequest, notify notifyFunc) (rpcResponse, bool) {
+	if req.ID == nil {
+		switch req.Method {
+		case "notifications/initialized":
+			s.logf("alias MCP client initialized")
+		default:
+			s.logf("alias MCP ignoring notification %q", req.Method)
+		}
+		return rpcResponse{}, false
+	}
+
+	resp := rpcResponse{JSONRPC: "2.0", ID: req.ID}
+	if req.JSONRPC != "" && req.JSONRPC != "2.0" {
+		resp.Error = &rpcError{Code: codeInvalidRequest, Message: "invalid request: jsonrpc must be \"2.0\""}
+		return resp, true
+	}
 	switch req.Method {
-	case "listTools":
-		s.writeResponse(w, rpcResponse{
-			JSONRPC: "2.0",
-			ID:      req.ID,
-			Result: map[string]any{
-				"tools": s.tools,
-			},
-		})
+	case "initialize":
+		resp.Result, resp.Error = s.initialize(req.Params)
+	case "ping":
+		resp.Result = map[string]any{}
+	case "tools/list", "listTools":
+		resp.Result = map[string]any{
+			"tools": s.tools,
+		}
+	case "tools/call":
+		resp.Result, resp.Error = s.handleToolsCall(ctx, req.Params, notify)
 	case "callTool":
-		resp := s.handleCallTool(r.Context(), req)
-		s.writeResponse(w, resp)
+		resp.Result, resp.Error = s.handleCallTool(ctx, req.Params, notify)
 	default:
-		s.writeResponse(w, rpcResponse{
-			JSONRPC: "2.0",
-			ID:      req.ID,
-			Error: &rpcError{
-				Code:    -32601,
-				Message: "method not found",
-			},
-		})
+		resp.Error = &rpcError{
+			Code:    codeMethodNotFound,
+			Message: "method not found",
+		}
 	}
+	return resp, true
 }
 
-func (s *Server) handleCallTool(ctx context.Context, req rpcRequest) rpcResponse {
-	var params struct {
-		Name string   `json:"name"`
-		Args []string `json:"args"`
-	}
-	if err := json.Unmarshal(req.Params, &params); err != nil {
-		return rpcResponse{
-			JSONRPC: "2.0",
-			ID:      req.ID,
-			Error: &rpcError{
-				Code:    -32602,
-				Message: fmt.Sprintf("invalid params: %v", err),
-			},
-		}
+// handleToolsCall implements the MCP tools/call method. When the client
+// supplied a progress token, output is also sent as progress notifications.
+func (s *Server) handleToolsCall(ctx context.Context, raw json.RawMessage, notify notifyFunc) (any, *rpcError) {
+	var params toolsCallParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("invalid params: %v", err)}
 	}
 	tool, ok := s.entryMap[params.Name]
 	if !ok {
-		return rpcResponse{
-			JSONRPC: "2.0",
-			ID:      req.ID,
-			Error: &rpcError{
-				Code:    -32001,
-				Message: fmt.Sprintf("alias %q not found", params.Name),
-			},
+		return nil, s.reject(params.Name, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("unknown tool %q", params.Name)})
+	}
+	call, err := s.buildCallRequest(tool.Name, nil, params.Arguments)
+	if err != nil {
+		return nil, s.reject(tool.Name, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("invalid arguments for %s: %v", tool.Name, err)})
+	}
+	var sink func(OutputChunk, int)
+	if notify != nil && params.Meta.ProgressToken != nil {
+		token := params.Meta.ProgressToken
+		sink = func(chunk OutputChunk, total int) {
+			notify("notifications/progress", progressParams{
+				ProgressToken: token,
+				Progress:      float64(total),
+				Message:       chunk.Text,
+			})
 		}
 	}
+	result, rpcErr := s.execute(ctx, call, true, sink, nil)
+	if rpcErr != nil {
+		// A denied approval is a decision by the host user, not a tool
+		// failure, so it stays a protocol error the client can recognise.
+		if rpcErr.Code == codeApprovalDenied {
+			return nil, rpcErr
+		}
+		// Execution failures, full pools and rate limits are reported as
+		// tool errors so the model can see them and back off.
+		return toolCallResult{
+			Content: []any{textContent{Type: "text", Text: rpcErr.Message}},
+			IsError: true,
+		}, nil
+	}
+	return toolResultFromExecution(result), nil
+}
 
-	select {
-	case s.sem <- struct{}{}:
-	default:
-		return rpcResponse{
-			JSONRPC: "2.0",
-			ID:      req.ID,
-			Error: &rpcError{
-				Code:    -32002,
-				Message: "alias execution pool exhausted",
-			},
+// handleCallTool implements the legacy callTool method used by shai-remote.
+// When streaming, output chunks are sent as shai/output notifications and
+// the final result carries only the exit code.
+func (s *Server) handleCallTool(ctx context.Context, raw json.RawMessage, notify notifyFunc) (any, *rpcError) {
+	var params struct {
+		Name      string         `json:"name"`
+		Args      []string       `json:"args"`
+		Arguments map[string]any `json:"arguments"`
+	}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("invalid params: %v", err)}
+	}
+	tool, ok := s.entryMap[params.Name]
+	if !ok {
+		return nil, s.reject(params.Name, &rpcError{Code: codeAliasNotFound, Message: fmt.Sprintf("alias %q not found", params.Name)})
+	}
+	call, err := s.buildCallRequest(tool.Name, params.Args, params.Arguments)
+	if err != nil {
+		return nil, s.reject(tool.Name, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("invalid arguments for %s: %v", tool.Name, err)})
+	}
+	var (
+		sink     func(OutputChunk, int)
+		fileSink func(fileChunk)
+	)
+	if notify != nil {
+		sink = func(chunk OutputChunk, _ int) {
+			notify(outputNotification, chunk)
 		}
+		fileSink = func(chunk fileChunk) {
+			notify(fileNotification, chunk)
+		}
+	}
+	result, rpcErr := s.execute(ctx, call, notify == nil, sink, fileSink)
+	if rpcErr != nil {
+		return nil, rpcErr
 	}
-	defer func() { <-s.sem }()
+	content := result.chunks
+	if content == nil {
+		content = []OutputChunk{}
+	}
+	return CallResult{
+		ExitCode:  result.exitCode,
+		Content:   content,
+		Truncated: result.truncated,
+		Files:     result.files,
+	}, nil
+}
 
-	collector := newOutputCollector()
-	exitCode, err := s.executor.Execute(ctx, tool.Name, params.Args, Streams{
+// execution is the outcome of a single call.
+type execution struct {
+	exitCode  int
+	chunks    []OutputChunk
+	truncated bool
+	files     []FileResult
+}
+
+// execute runs a validated call within the concurrency limit. Output is
+// capped at the tool's limit, kept when retain is set, and passed to sink as
+// it is produced. Output files are kept the same way and streamed to
+// fileSink.
+func (s *Server) execute(ctx context.Context, call CallRequest, retain bool, sink func(OutputChunk, int), fileSink func(fileChunk)) (execution, *rpcError) {
+	release, rpcErr := s.admit(ctx, call.Name)
+	if rpcErr != nil {
+		return execution{}, s.reject(call.Name, rpcErr)
+	}
+	defer release()
+
+	limit := s.entryMap[call.Name].MaxOutputBytes
+	if limit == 0 {
+		limit = s.maxOutput
+	}
+	collector := newOutputCollector(limit, retain, sink)
+	collector.fileSink = fileSink
+	exitCode, err := s.executor.Execute(ctx, call, Streams{
 		Stdout: collector.writer("stdout"),
 		Stderr: collector.writer("stderr"),
+		File:   collector.file,
 	})
 	if err != nil {
-		return rpcResponse{
-			JSONRPC: "2.0",
-			ID:      req.ID,
-			Error: &rpcError{
-				Code:    -32003,
-				Message: err.Error(),
-			},
+		if errors.Is(err, ErrNotApproved) {
+			return execution{}, &rpcError{Code: codeApprovalDenied, Message: err.Error()}
 		}
+		return execution{}, &rpcError{Code: codeExecutionFailed, Message: err.Error()}
 	}
+	return execution{
+		exitCode:  exitCode,
+		chunks:    collector.chunks(),
+		truncated: collector.isTruncated(),
+		files:     collector.fileResults(),
+	}, nil
+}
 
-	return rpcResponse{
-		JSONRPC: "2.0",
-		ID:      req.ID,
-		Result: CallResult{
-			ExitCode: exitCode,
-			Content:  collector.chunks(),
-		},
+// buildCallRequest validates call arguments. Typed tools accept an arguments
+// object or "--name=value" args; other tools accept a list of strings.
+func (s *Server) buildCallRequest(name string, args []string, arguments map[string]any) (CallRequest, error) {
+	schema, typed := s.schemas[name]
+	if !typed {
+		if raw, ok := arguments["args"]; ok {
+			list, ok := raw.([]any)
+			if !ok {
+				return CallRequest{}, fmt.Errorf("args must be an array of strings")
+			}
+			for _, item := range list {
+				str, ok := item.(string)
+				if !ok {
+					return CallRequest{}, fmt.Errorf("args must be an array of strings")
+				}
+				args = append(args, str)
+			}
+		}
+		return CallRequest{Name: name, Args: args}, nil
+	}
+
+	values := arguments
+	if len(args) > 0 {
+		parsed, err := schema.argumentsFromFlags(args)
+		if err != nil {
+			return CallRequest{}, err
+		}
+		if values == nil {
+			values = parsed
+		} else {
+			for k, v := range parsed {
+				values[k] = v
+			}
+		}
+	}
+	if values == nil {
+		values = map[string]any{}
+	}
+	if err := schema.validate(values); err != nil {
+		return CallRequest{}, err
 	}
+	return CallRequest{Name: name, Params: values}, nil
+}
+
+// reject reports a call turned away before execution to the Rejected hook
+// and returns its error.
+func (s *Server) reject(name string, rpcErr *rpcError) *rpcError {
+	if s.cfg.Rejected != nil {
+		s.cfg.Rejected(name, rpcErr.Message)
+	}
+	return rpcErr
 }
 
 func (s *Server) writeResponse(w http.ResponseWriter, resp rpcResponse) {
+	s.writeStatusResponse(w, http.StatusOK, resp)
+}
+
+func (s *Server) writeStatusResponse(w http.ResponseWriter, status int, resp rpcResponse) {
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	enc := json.NewEncoder(w)
 	_ = enc.Encode(resp)
 }
 
+func parseErrorResponse(err error) rpcResponse {
+	msg := "parse error"
+	if err != nil {
+		msg = fmt.Sprintf("parse error: %v", err)
+	}
+	return rpcResponse{
+		JSONRPC: "2.0",
+		Error:   &rpcError{Code: codeParseError, Message: msg},
+	}
+}
+
 func (s *Server) requireAuth(w http.ResponseWriter, r *http.Request) bool {
 	auth := r.Header.Get("Authorization")
 	if !strings.HasPrefix(auth, "Bearer ") {
@@ -340,14 +724,26 @@ func (s *Server) logf(format string, args ...interface{}) {
 	s.logger.Printf(format, args...)
 }
 
+// outputCollector gathers call output up to a byte limit. Once the limit is
+// reached a truncation marker is recorded and further output is discarded.
 type outputCollector struct {
-	mu     sync.Mutex
-	values []OutputChunk
+	mu        sync.Mutex
+	values    []OutputChunk
+	limit     int
+	retain    bool
+	sink      func(OutputChunk, int)
+	total     int
+	truncated bool
+	files     []FileResult
+	fileSink  func(fileChunk)
 }
 
-func newOutputCollector() *outputCollector {
+func newOutputCollector(limit int, retain bool, sink func(OutputChunk, int)) *outputCollector {
 	return &outputCollector{
 		values: make([]OutputChunk, 0, 8),
+		limit:  limit,
+		retain: retain,
+		sink:   sink,
 	}
 }
 
@@ -356,26 +752,73 @@ func (c *outputCollector) writer(stream string) io.Writer {
 		if len(p) == 0 {
 			return 0, nil
 		}
-		text := string(p)
 		c.mu.Lock()
-		c.values = append(c.values, OutputChunk{
-			Type:   "text",
-			Stream: stream,
-			Text:   text,
-		})
-		c.mu.Unlock()
+		defer c.mu.Unlock()
+		if c.truncated {
+			return len(p), nil
+		}
+		text := p
+		overflow := false
+		if c.limit > 0 && c.total+len(p) > c.limit {
+			text = p[:runePrefix(p, c.limit-c.total)]
+			overflow = true
+		}
+		if len(text) > 0 {
+			c.total += len(text)
+			c.emit(OutputChunk{Type: "text", Stream: stream, Text: string(text)})
+		}
+		if overflow {
+			c.truncated = true
+			c.emit(OutputChunk{
+				Type:   "text",
+				Stream: "stderr",
+				Text:   fmt.Sprintf("\n[shai: output truncated after %d bytes]\n", c.limit),
+			})
+		}
 		return len(p), nil
 	})
 }
 
+// emit records a chunk; callers hold c.mu so sink calls stay ordered.
+func (c *outputCollector) emit(chunk OutputChunk) {
+	if c.retain {
+		c.values = append(c.values, chunk)
+	}
+	if c.sink != nil {
+		c.sink(chunk, c.total)
+	}
+}
+
 func (c *outputCollector) chunks() []OutputChunk {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if !c.retain {
+		return nil
+	}
 	out := make([]OutputChunk, len(c.values))
 	copy(out, c.values)
 	return out
 }
 
+func (c *outputCollector) isTruncated() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.truncated
+}
+
+// runePrefix returns the largest n <= max such that p[:n] does not split a
+// UTF-8 sequence.
+func runePrefix(p []byte, max int) int {
+	if max >= len(p) {
+		return len(p)
+	}
+	n := max
+	for n > 0 && !utf8.RuneStart(p[n]) {
+		n--
+	}
+	return n
+}
+
 type writerFunc func(p []byte) (int, error)
 
 func (f writerFunc) Write(p []byte) (int, error) {
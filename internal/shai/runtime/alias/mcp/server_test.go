@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 )
@@ -93,6 +94,119 @@ func TestServerRequiresAuth(t *testing.T) {
 	}
 }
 
+func TestServerEnforcesSessionAfterInitialize(t *testing.T) {
+	server, endpoint := startTestServer(t, &fakeExecutor{tools: []Tool{{Name: "noop"}}})
+	defer server.Close(context.Background())
+
+	post := func(payload string, header map[string]string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(payload))
+		req.Header.Set("Authorization", "Bearer secret")
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("http: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	initialize := func(version string) string {
+		return fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":%q}}`, version)
+	}
+	ping := `{"jsonrpc":"2.0","id":2,"method":"ping"}`
+
+	// Each initialize on a revision with sessions gets its own session ID,
+	// however it is sent.
+	for i, tc := range []struct {
+		payload string
+		header  map[string]string
+	}{
+		{initialize("2025-06-18"), nil},
+		{initialize("2025-06-18"), map[string]string{"Accept": "application/json, text/event-stream"}},
+		{"[" + initialize("2025-03-26") + "]", nil},
+	} {
+		want := fmt.Sprintf("session-%d", i+1)
+		if got := post(tc.payload, tc.header).Header.Get(sessionIDHeader); got != want {
+			t.Fatalf("initialize %d: expected session header %q, got %q", i, want, got)
+		}
+	}
+
+	if resp := post(ping, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 without session, got %d", resp.StatusCode)
+	}
+	if resp := post(ping, map[string]string{sessionIDHeader: "session-2"}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 with session, got %d", resp.StatusCode)
+	}
+	if resp := post(ping, map[string]string{sessionIDHeader: "session"}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the sandbox session to be accepted, got %d", resp.StatusCode)
+	}
+	if resp := post(ping, map[string]string{sessionIDHeader: "session-9"}); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for a session never issued, got %d", resp.StatusCode)
+	}
+	if resp := post(`{"jsonrpc":"2.0","id":3,"method":"listTools"}`, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected pre-MCP listTools without session, got %d", resp.StatusCode)
+	}
+	if resp := post("["+initialize("2025-06-18")+","+ping+"]", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected batched initialize to be refused, got %d", resp.StatusCode)
+	}
+
+	// An older client that cannot send the header may still call, but a
+	// request declaring a revision with sessions must carry it.
+	if got := post(initialize("2024-11-05"), nil).Header.Get(sessionIDHeader); got != "" {
+		t.Fatalf("expected no session for 2024-11-05, got %q", got)
+	}
+	if resp := post(ping, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 for the older client, got %d", resp.StatusCode)
+	}
+	if resp := post(ping, map[string]string{protocolVersionHeader: "2025-06-18"}); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a 2025-06-18 request without session, got %d", resp.StatusCode)
+	}
+	if got := post(initialize("2025-06-18"), nil).Header.Get(sessionIDHeader); got != "session-4" {
+		t.Fatalf("expected a fourth session, got %q", got)
+	}
+	if resp := post(ping, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("a later initialize must not cut off the older client, got %d", resp.StatusCode)
+	}
+}
+
+func TestServerRejectsOversizedBody(t *testing.T) {
+	// The largest upload, base64 encoded, is added to the fixed allowance.
+	exec := &fakeExecutor{tools: []Tool{{Name: "upload", MaxInputBytes: 300 << 10}, {Name: "plain"}}}
+	server, endpoint := startTestServer(t, exec)
+	defer server.Close(context.Background())
+
+	call := func(size int) string {
+		return fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"callTool","params":{"name":"upload","args":[%q]}}`, strings.Repeat("A", size))
+	}
+	if resp := doRequest(t, endpoint, call(requestOverheadBytes+(400<<10)-200)); resp.Error != nil {
+		t.Fatalf("unexpected error within the limit: %+v", resp.Error)
+	}
+
+	exec.lastName = ""
+	req, _ := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(call(requestOverheadBytes+(400<<10))))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("http: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", resp.StatusCode)
+	}
+	var decoded rpcResponse
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if decoded.Error == nil || decoded.Error.Code != codeInvalidRequest || !strings.Contains(decoded.Error.Message, "body exceeds") {
+		t.Fatalf("expected invalid request error, got %+v", decoded.Error)
+	}
+	if exec.lastName != "" {
+		t.Fatalf("oversized call should not run")
+	}
+}
+
 func startTestServer(t *testing.T, exec Executor) (*Server, string) {
 	t.Helper()
 	cfg := Config{
@@ -134,16 +248,18 @@ func doRequest(t *testing.T, endpoint, payload string) *rpcResponse {
 }
 
 type fakeExecutor struct {
-	tools    []Tool
-	lastName string
+	tools      []Tool
+	lastName   string
+	lastParams map[string]any
 }
 
 func (f *fakeExecutor) Tools() []Tool {
 	return f.tools
 }
 
-func (f *fakeExecutor) Execute(ctx context.Context, name string, args []string, streams Streams) (int, error) {
-	f.lastName = name
+func (f *fakeExecutor) Execute(ctx context.Context, req CallRequest, streams Streams) (int, error) {
+	f.lastName = req.Name
+	f.lastParams = req.Params
 	if streams.Stdout != nil {
 		fmt.Fprint(streams.Stdout, "ok")
 	}
@@ -154,3 +270,112 @@ func (f *fakeExecutor) Execute(ctx context.Context, name string, args []string,
 	}
 	return 0, nil
 }
+
+func TestServerListToolsPublishesInputSchema(t *testing.T) {
+	exec := &fakeExecutor{
+		tools: []Tool{
+			{Name: "legacy"},
+			{Name: "typed", InputSchema: deploySchema()},
+		},
+	}
+	server, endpoint := startTestServer(t, exec)
+	defer server.Close(context.Background())
+
+	resp := doRequest(t, endpoint, `{"jsonrpc":"2.0","id":1,"method":"listTools"}`)
+	if resp.Error != nil {
+		t.Fatalf("unexpected error: %+v", resp.Error)
+	}
+	tools := resp.Result.(map[string]any)["tools"].([]any)
+	legacy := tools[0].(map[string]any)["inputSchema"].(map[string]any)
+	if _, ok := legacy["properties"].(map[string]any)["args"]; !ok {
+		t.Fatalf("expected legacy args schema, got %v", legacy)
+	}
+	typed := tools[1].(map[string]any)["inputSchema"].(map[string]any)
+	env := typed["properties"].(map[string]any)["env"].(map[string]any)
+	if env["type"] != "string" || len(env["enum"].([]any)) != 2 {
+		t.Fatalf("unexpected env schema %v", env)
+	}
+	if typed["additionalProperties"] != false {
+		t.Fatalf("expected additionalProperties false, got %v", typed["additionalProperties"])
+	}
+}
+
+func TestServerCallToolValidatesArguments(t *testing.T) {
+	exec := &fakeExecutor{
+		tools: []Tool{{Name: "typed", InputSchema: deploySchema()}},
+	}
+	server, endpoint := startTestServer(t, exec)
+	defer server.Close(context.Background())
+
+	resp := doRequest(t, endpoint, `{"jsonrpc":"2.0","id":2,"method":"callTool","params":{"name":"typed","arguments":{"env":"prod","replicas":2,"tag":"v12"}}}`)
+	if resp.Error != nil {
+		t.Fatalf("unexpected error: %+v", resp.Error)
+	}
+	if exec.lastParams["env"] != "prod" || exec.lastParams["replicas"] != float64(2) {
+		t.Fatalf("unexpected params %v", exec.lastParams)
+	}
+
+	resp = doRequest(t, endpoint, `{"jsonrpc":"2.0","id":3,"method":"callTool","params":{"name":"typed","args":["--env=staging","--dry-run","--replicas=4"]}}`)
+	if resp.Error != nil {
+		t.Fatalf("unexpected error: %+v", resp.Error)
+	}
+	if exec.lastParams["env"] != "staging" || exec.lastParams["dry-run"] != true || exec.lastParams["replicas"] != float64(4) {
+		t.Fatalf("unexpected params from flags %v", exec.lastParams)
+	}
+
+	for _, payload := range []string{
+		`{"jsonrpc":"2.0","id":4,"method":"callTool","params":{"name":"typed","arguments":{}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"callTool","params":{"name":"typed","arguments":{"env":"qa"}}}`,
+		`{"jsonrpc":"2.0","id":6,"method":"callTool","params":{"name":"typed","arguments":{"env":"prod","replicas":"two"}}}`,
+		`{"jsonrpc":"2.0","id":7,"method":"callTool","params":{"name":"typed","arguments":{"env":"prod","other":1}}}`,
+		`{"jsonrpc":"2.0","id":8,"method":"callTool","params":{"name":"typed","args":["positional"]}}`,
+		`{"jsonrpc":"2.0","id":9,"method":"callTool","params":{"name":"typed","arguments":{"env":"prod","replicas":1e19}}}`,
+		`{"jsonrpc":"2.0","id":10,"method":"callTool","params":{"name":"typed","arguments":{"env":"prod","tag":"v1; rm -rf /"}}}`,
+	} {
+		resp := doRequest(t, endpoint, payload)
+		if resp.Error == nil || resp.Error.Code != -32602 {
+			t.Fatalf("expected invalid params error for %s, got %+v", payload, resp.Error)
+		}
+	}
+}
+
+func deploySchema() *InputSchema {
+	noExtra := false
+	return &InputSchema{
+		Type: "object",
+		Properties: map[string]*PropertySchema{
+			"env":      {Type: "string", Enum: []string{"staging", "prod"}},
+			"replicas": {Type: "integer"},
+			"dry-run":  {Type: "boolean"},
+			"tag":      {Type: "string", Pattern: "^(?:v[0-9]+)$"},
+		},
+		Required:             []string{"env"},
+		AdditionalProperties: &noExtra,
+	}
+}
+
+// denyingExecutor rejects every call as if the host user said no.
+type denyingExecutor struct{}
+
+func (denyingExecutor) Tools() []Tool {
+	return []Tool{{Name: "push"}}
+}
+
+func (denyingExecutor) Execute(ctx context.Context, req CallRequest, streams Streams) (int, error) {
+	return 0, fmt.Errorf("call %q: %w", req.Name, ErrNotApproved)
+}
+
+func TestServerReportsApprovalDenied(t *testing.T) {
+	server, endpoint := startTestServer(t, denyingExecutor{})
+	defer server.Close(context.Background())
+
+	for _, payload := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"callTool","params":{"name":"push"}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"push","arguments":{}}}`,
+	} {
+		resp := doRequest(t, endpoint, payload)
+		if resp.Error == nil || resp.Error.Code != codeApprovalDenied {
+			t.Fatalf("expected approval denied error for %s, got %+v", payload, resp)
+		}
+	}
+}
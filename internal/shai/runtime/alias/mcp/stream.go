@@ -0,0 +1,89 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// outputNotification carries a chunk of streamed callTool output.
+const outputNotification = "shai/output"
+
+// notifyFunc sends a JSON-RPC notification to the client mid-request.
+type notifyFunc func(method string, params any)
+
+type rpcNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+// eventStream writes JSON-RPC messages as server-sent events, as the
+// Streamable HTTP transport allows for responses to a single POST.
+type eventStream struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func newEventStream(w http.ResponseWriter) *eventStream {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	return &eventStream{w: w, flusher: flusher}
+}
+
+func (es *eventStream) notify(method string, params any) {
+	es.send(rpcNotification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (es *eventStream) send(msg any) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	fmt.Fprintf(es.w, "event: message\ndata: %s\n\n", data)
+	if es.flusher != nil {
+		es.flusher.Flush()
+	}
+}
+
+// wantsEventStream reports whether a request should be answered with an SSE
+// stream: the client must accept one and the call must have output to
+// stream. tools/call streams only when the client asked for progress.
+func (s *Server) wantsEventStream(r *http.Request, req rpcRequest) bool {
+	if req.ID == nil || !acceptsEventStream(r) {
+		return false
+	}
+	switch req.Method {
+	case "callTool":
+		return true
+	case "tools/call":
+		var params toolsCallParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return false
+		}
+		return params.Meta.ProgressToken != nil
+	}
+	return false
+}
+
+func acceptsEventStream(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(value, ",") {
+			mediaType, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+			if strings.EqualFold(strings.TrimSpace(mediaType), "text/event-stream") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gatedExecutor writes one chunk, waits for release, then writes the rest,
+// so tests can observe output before the command finishes.
+type gatedExecutor struct {
+	release chan struct{}
+	limit   int
+}
+
+func (g *gatedExecutor) Tools() []Tool {
+	return []Tool{{Name: "build", MaxOutputBytes: g.limit}}
+}
+
+func (g *gatedExecutor) Execute(ctx context.Context, req CallRequest, streams Streams) (int, error) {
+	fmt.Fprint(streams.Stdout, "compiling\n")
+	select {
+	case <-g.release:
+	case <-ctx.Done():
+		return 1, ctx.Err()
+	}
+	fmt.Fprint(streams.Stderr, "warning: slow\n")
+	fmt.Fprint(streams.Stdout, "done\n")
+	return 5, nil
+}
+
+func postStream(t *testing.T, endpoint, payload string) (*http.Response, *bufio.Reader) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewBufferString(payload))
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("http: %v", err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		resp.Body.Close()
+		t.Fatalf("expected event stream, got %q", ct)
+	}
+	return resp, bufio.NewReader(resp.Body)
+}
+
+func nextEvent(t *testing.T, r *bufio.Reader) map[string]any {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		data, ok := strings.CutPrefix(strings.TrimRight(line, "\n"), "data: ")
+		if !ok {
+			continue
+		}
+		var msg map[string]any
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			t.Fatalf("decode event %q: %v", data, err)
+		}
+		return msg
+	}
+}
+
+func TestServerCallToolStreamsOutput(t *testing.T) {
+	exec := &gatedExecutor{release: make(chan struct{})}
+	server, endpoint := startTestServer(t, exec)
+	defer server.Close(context.Background())
+
+	resp, events := postStream(t, endpoint, `{"jsonrpc":"2.0","id":7,"method":"callTool","params":{"name":"build"}}`)
+	defer resp.Body.Close()
+
+	first := nextEvent(t, events)
+	if first["method"] != outputNotification {
+		t.Fatalf("expected output notification, got %v", first)
+	}
+	if text := first["params"].(map[string]any)["text"]; text != "compiling\n" {
+		t.Fatalf("unexpected first chunk %q", text)
+	}
+	close(exec.release)
+
+	second := nextEvent(t, events)
+	if p := second["params"].(map[string]any); p["stream"] != "stderr" || p["text"] != "warning: slow\n" {
+		t.Fatalf("unexpected second chunk %v", p)
+	}
+	nextEvent(t, events)
+	final := nextEvent(t, events)
+	if final["id"] != float64(7) {
+		t.Fatalf("expected final response, got %v", final)
+	}
+	result := final["result"].(map[string]any)
+	if result["exitCode"] != float64(5) {
+		t.Fatalf("unexpected exit code %v", result["exitCode"])
+	}
+	if content := result["content"].([]any); len(content) != 0 {
+		t.Fatalf("streamed output should not be repeated in the result: %v", content)
+	}
+}
+
+func TestServerToolsCallSendsProgress(t *testing.T) {
+	exec := &gatedExecutor{release: make(chan struct{})}
+	close(exec.release)
+	server, endpoint := startTestServer(t, exec)
+	defer server.Close(context.Background())
+
+	resp, events := postStream(t, endpoint, `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"build","arguments":{},"_meta":{"progressToken":"tok"}}}`)
+	defer resp.Body.Close()
+
+	var progress []float64
+	for {
+		msg := nextEvent(t, events)
+		if msg["method"] == "notifications/progress" {
+			params := msg["params"].(map[string]any)
+			if params["progressToken"] != "tok" {
+				t.Fatalf("unexpected progress token %v", params["progressToken"])
+			}
+			progress = append(progress, params["progress"].(float64))
+			continue
+		}
+		result := msg["result"].(map[string]any)
+		if result["isError"] != true {
+			t.Fatalf("expected isError for exit 5, got %v", result)
+		}
+		break
+	}
+	if len(progress) != 3 || progress[0] >= progress[1] || progress[1] >= progress[2] {
+		t.Fatalf("expected 3 increasing progress values, got %v", progress)
+	}
+}
+
+func TestServerToolsCallWithoutProgressTokenReturnsJSON(t *testing.T) {
+	exec := &gatedExecutor{release: make(chan struct{})}
+	close(exec.release)
+	server, endpoint := startTestServer(t, exec)
+	defer server.Close(context.Background())
+
+	req, _ := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"build","arguments":{}}}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("http: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected JSON response, got %q", ct)
+	}
+}
+
+func TestServerTruncatesOutput(t *testing.T) {
+	exec := &gatedExecutor{release: make(chan struct{}), limit: 12}
+	close(exec.release)
+	server, endpoint := startTestServer(t, exec)
+	defer server.Close(context.Background())
+
+	done := make(chan *rpcResponse, 1)
+	go func() {
+		done <- doRequest(t, endpoint, `{"jsonrpc":"2.0","id":1,"method":"callTool","params":{"name":"build"}}`)
+	}()
+	var resp *rpcResponse
+	select {
+	case resp = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("call did not finish")
+	}
+	result := resp.Result.(map[string]any)
+	if result["truncated"] != true {
+		t.Fatalf("expected truncated result, got %v", result)
+	}
+	content := result["content"].([]any)
+	var text strings.Builder
+	for _, c := range content {
+		text.WriteString(c.(map[string]any)["text"].(string))
+	}
+	want := "compiling\nwa\n[shai: output truncated after 12 bytes]\n"
+	if text.String() != want {
+		t.Fatalf("unexpected output %q, want %q", text.String(), want)
+	}
+}
+
+func TestRunePrefixKeepsUTF8Intact(t *testing.T) {
+	p := []byte("aé")
+	if n := runePrefix(p, 2); n != 1 {
+		t.Fatalf("expected split before multi-byte rune, got %d", n)
+	}
+	if n := runePrefix(p, 10); n != len(p) {
+		t.Fatalf("expected full length, got %d", n)
+	}
+}
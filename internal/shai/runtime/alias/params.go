@@ -0,0 +1,222 @@
+package alias
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/colony-2/shai/internal/shai/runtime/alias/mcp"
+)
+
+// Parameter types supported by typed calls.
+const (
+	ParamString = "string"
+	ParamInt    = "int"
+	ParamBool   = "bool"
+	ParamEnum   = "enum"
+)
+
+// valuePlaceholder is replaced with the parameter value in argument templates.
+var valuePlaceholder = regexp.MustCompile(`{{\s*value\s*}}`)
+
+// anchorPattern makes a pattern match whole values. JSON Schema patterns
+// match anywhere in a string, so the anchored form is also what the input
+// schema publishes.
+func anchorPattern(pattern string) string {
+	return "^(?:" + pattern + ")$"
+}
+
+// Param declares a named, typed argument accepted by an entry.
+type Param struct {
+	Name        string
+	Type        string
+	Description string
+	Required    bool
+	Pattern     string
+	Values      []string
+	// Arg is the argv template for the parameter; {{ value }} is replaced
+	// with the supplied value. Bool parameters emit Arg only when true.
+	Arg []string
+
+	compiledRE *regexp.Regexp
+}
+
+// SetParams attaches typed parameters to the entry. Entries with parameters
+// no longer accept free-form arguments.
+func (e *Entry) SetParams(params []Param) error {
+	out := make([]Param, 0, len(params))
+	for _, p := range params {
+		p.Type = strings.ToLower(strings.TrimSpace(p.Type))
+		if p.Type == "" {
+			p.Type = ParamString
+		}
+		switch p.Type {
+		case ParamString, ParamInt, ParamBool:
+		case ParamEnum:
+			if len(p.Values) == 0 {
+				return fmt.Errorf("alias %q param %q: enum requires values", e.Name, p.Name)
+			}
+		default:
+			return fmt.Errorf("alias %q param %q: unsupported type %q", e.Name, p.Name, p.Type)
+		}
+		if p.Pattern != "" {
+			re, err := regexp.Compile(anchorPattern(p.Pattern))
+			if err != nil {
+				return fmt.Errorf("alias %q param %q: invalid pattern: %w", e.Name, p.Name, err)
+			}
+			p.compiledRE = re
+		}
+		if len(p.Arg) == 0 {
+			if p.Type == ParamBool {
+				p.Arg = []string{"--" + p.Name}
+			} else {
+				p.Arg = []string{"--" + p.Name + "={{ value }}"}
+			}
+		}
+		out = append(out, p)
+	}
+	e.Params = out
+	return nil
+}
+
+// InputSchema describes the entry's parameters as a JSON Schema. It returns
+// nil for entries that accept free-form arguments. Input files are base64
+// strings.
+func (e *Entry) InputSchema() *mcp.InputSchema {
+	if !e.Typed() {
+		return nil
+	}
+	noExtra := false
+	schema := &mcp.InputSchema{
+		Type:                 "object",
+		Properties:           make(map[string]*mcp.PropertySchema, len(e.Params)+len(e.Inputs)),
+		AdditionalProperties: &noExtra,
+	}
+	for _, p := range e.Params {
+		prop := &mcp.PropertySchema{Description: p.Description}
+		switch p.Type {
+		case ParamInt:
+			prop.Type = "integer"
+		case ParamBool:
+			prop.Type = "boolean"
+		case ParamEnum:
+			prop.Type = "string"
+			prop.Enum = append([]string(nil), p.Values...)
+		default:
+			prop.Type = "string"
+			if p.Pattern != "" {
+				prop.Pattern = anchorPattern(p.Pattern)
+			}
+		}
+		schema.Properties[p.Name] = prop
+		if p.Required {
+			schema.Required = append(schema.Required, p.Name)
+		}
+	}
+	for _, f := range e.Inputs {
+		schema.Properties[f.Name] = &mcp.PropertySchema{
+			Type:            "string",
+			Description:     f.Description,
+			ContentEncoding: "base64",
+		}
+		if f.Required {
+			schema.Required = append(schema.Required, f.Name)
+		}
+	}
+	return schema
+}
+
+// BindParams validates named values and renders them into argv elements in
+// declaration order.
+func (e *Entry) BindParams(values map[string]any) ([]string, error) {
+	known := make(map[string]bool, len(e.Params))
+	for _, p := range e.Params {
+		known[p.Name] = true
+	}
+	var unknown []string
+	for name := range values {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("alias %q does not accept parameter(s): %s", e.Name, strings.Join(unknown, ", "))
+	}
+
+	var args []string
+	for _, p := range e.Params {
+		raw, ok := values[p.Name]
+		if !ok || raw == nil {
+			if p.Required {
+				return nil, fmt.Errorf("alias %q requires parameter %q", e.Name, p.Name)
+			}
+			continue
+		}
+		value, err := p.format(raw)
+		if err != nil {
+			return nil, fmt.Errorf("alias %q parameter %q: %w", e.Name, p.Name, err)
+		}
+		if p.Type == ParamBool && value != "true" {
+			continue
+		}
+		for _, tmpl := range p.Arg {
+			args = append(args, valuePlaceholder.ReplaceAllLiteralString(tmpl, value))
+		}
+	}
+	return args, nil
+}
+
+func (p Param) format(raw any) (string, error) {
+	switch p.Type {
+	case ParamInt:
+		switch v := raw.(type) {
+		case int:
+			return strconv.Itoa(v), nil
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		case float64:
+			if v != math.Trunc(v) || math.IsInf(v, 0) {
+				return "", fmt.Errorf("expected integer, got %v", v)
+			}
+			// float64(math.MaxInt64) rounds up to 2^63, which no int64 holds.
+			if v < math.MinInt64 || v >= math.MaxInt64 {
+				return "", fmt.Errorf("integer %v is out of range", v)
+			}
+			return strconv.FormatInt(int64(v), 10), nil
+		case json.Number:
+			n, err := v.Int64()
+			if err != nil {
+				return "", fmt.Errorf("expected integer, got %q", v.String())
+			}
+			return strconv.FormatInt(n, 10), nil
+		}
+		return "", fmt.Errorf("expected integer, got %T", raw)
+	case ParamBool:
+		if v, ok := raw.(bool); ok {
+			return strconv.FormatBool(v), nil
+		}
+		return "", fmt.Errorf("expected boolean, got %T", raw)
+	}
+
+	s, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("expected string, got %T", raw)
+	}
+	if p.Type == ParamEnum {
+		for _, allowed := range p.Values {
+			if s == allowed {
+				return s, nil
+			}
+		}
+		return "", fmt.Errorf("%q is not one of %s", s, strings.Join(p.Values, ", "))
+	}
+	if p.compiledRE != nil && !p.compiledRE.MatchString(s) {
+		return "", fmt.Errorf("%q does not match pattern %q", s, p.Pattern)
+	}
+	return s, nil
+}
@@ -0,0 +1,140 @@
+package alias
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBindParamsRendersTemplates(t *testing.T) {
+	entry, err := NewArgvEntry("deploy", "", []string{"./deploy.sh"}, "")
+	if err != nil {
+		t.Fatalf("NewArgvEntry: %v", err)
+	}
+	err = entry.SetParams([]Param{
+		{Name: "env", Type: ParamEnum, Values: []string{"staging", "prod"}, Required: true},
+		{Name: "replicas", Type: ParamInt, Arg: []string{"-n", "{{ value }}"}},
+		{Name: "dry-run", Type: ParamBool},
+		{Name: "tag", Pattern: "v[0-9]+"},
+	})
+	if err != nil {
+		t.Fatalf("SetParams: %v", err)
+	}
+
+	args, err := entry.BindParams(map[string]any{
+		"env":      "prod",
+		"replicas": float64(3),
+		"dry-run":  true,
+		"tag":      "v12",
+	})
+	if err != nil {
+		t.Fatalf("BindParams: %v", err)
+	}
+	want := []string{"--env=prod", "-n", "3", "--dry-run", "--tag=v12"}
+	if !reflect.DeepEqual(args, want) {
+		t.Fatalf("unexpected args %q", args)
+	}
+
+	args, err = entry.BindParams(map[string]any{"env": "staging", "dry-run": false})
+	if err != nil {
+		t.Fatalf("BindParams: %v", err)
+	}
+	if !reflect.DeepEqual(args, []string{"--env=staging"}) {
+		t.Fatalf("unexpected args %q", args)
+	}
+}
+
+func TestBindParamsRejectsInvalidValues(t *testing.T) {
+	entry, err := NewArgvEntry("deploy", "", []string{"./deploy.sh"}, "")
+	if err != nil {
+		t.Fatalf("NewArgvEntry: %v", err)
+	}
+	if err := entry.SetParams([]Param{
+		{Name: "env", Type: ParamEnum, Values: []string{"staging", "prod"}, Required: true},
+		{Name: "replicas", Type: ParamInt},
+		{Name: "tag", Pattern: "v[0-9]+"},
+	}); err != nil {
+		t.Fatalf("SetParams: %v", err)
+	}
+
+	cases := []map[string]any{
+		{},
+		{"env": "qa"},
+		{"env": "prod", "replicas": 1.5},
+		{"env": "prod", "replicas": 1e19},
+		{"env": "prod", "replicas": -1e19},
+		{"env": "prod", "tag": "v1; rm -rf /"},
+		{"env": "prod", "extra": "x"},
+	}
+	for _, values := range cases {
+		if _, err := entry.BindParams(values); err == nil {
+			t.Fatalf("expected error for %v", values)
+		}
+	}
+}
+
+func TestInputSchemaFromParams(t *testing.T) {
+	entry, err := NewEntry("deploy", "", "./deploy.sh", "")
+	if err != nil {
+		t.Fatalf("NewEntry: %v", err)
+	}
+	if entry.InputSchema() != nil {
+		t.Fatalf("expected nil schema without params")
+	}
+	if err := entry.SetParams([]Param{
+		{Name: "env", Type: ParamEnum, Values: []string{"staging"}, Required: true, Description: "Target"},
+		{Name: "count", Type: ParamInt},
+		{Name: "tag", Pattern: "v[0-9]+"},
+	}); err != nil {
+		t.Fatalf("SetParams: %v", err)
+	}
+	schema := entry.InputSchema()
+	if schema == nil || schema.Type != "object" {
+		t.Fatalf("unexpected schema %#v", schema)
+	}
+	if got := schema.Properties["env"]; got.Type != "string" || !reflect.DeepEqual(got.Enum, []string{"staging"}) || got.Description != "Target" {
+		t.Fatalf("unexpected env property %#v", got)
+	}
+	if got := schema.Properties["count"]; got.Type != "integer" {
+		t.Fatalf("unexpected count property %#v", got)
+	}
+	if got := schema.Properties["tag"]; got.Type != "string" || got.Pattern != "^(?:v[0-9]+)$" {
+		t.Fatalf("expected the anchored pattern used for validation, got %#v", got)
+	}
+	if !reflect.DeepEqual(schema.Required, []string{"env"}) {
+		t.Fatalf("unexpected required %v", schema.Required)
+	}
+}
+
+func TestExecutorRunParamsShellQuotes(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "echo.sh")
+	writeExecutable(t, script, "#!/bin/sh\nfor a in \"$@\"; do echo \"ARG:$a\"; done\n")
+
+	entry, err := NewEntry("echo", "", script, "")
+	if err != nil {
+		t.Fatalf("NewEntry: %v", err)
+	}
+	if err := entry.SetParams([]Param{{Name: "msg", Arg: []string{"{{ value }}"}}}); err != nil {
+		t.Fatalf("SetParams: %v", err)
+	}
+
+	executor := &Executor{
+		WorkingDir: dir,
+		ShellPath:  shellPath(),
+		Timeout:    2 * time.Second,
+	}
+	var stdout bytes.Buffer
+	if _, err := executor.RunParams(context.Background(), entry, map[string]any{"msg": "it's $(id)"}, Streams{Stdout: &stdout}); err != nil {
+		t.Fatalf("RunParams: %v", err)
+	}
+	if got := stdout.String(); got != "ARG:it's $(id)\n" {
+		t.Fatalf("unexpected stdout: %q", got)
+	}
+	if _, err := executor.Run(context.Background(), entry, []string{"--msg=x"}, Streams{}); err == nil {
+		t.Fatalf("expected Run to reject free-form args for typed entry")
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/colony-2/shai/internal/shai/runtime/alias/mcp"
@@ -27,12 +30,18 @@ type Config struct {
 	Entries        []*Entry
 	DockerHostAddr string
 	MCPBindAddr    string
+	// Approver confirms entries whose Approval mode requires it.
+	Approver Approver
+	// AuditDir receives a JSONL audit log of every call in the session.
+	// Empty disables auditing.
+	AuditDir string
 }
 
 // Service manages the lifecycle of the alias MCP server.
 type Service struct {
 	env            []string
 	server         *mcp.Server
+	audit          *AuditLog
 	closeOnce      sync.Once
 	dockerHostAddr string
 }
@@ -93,16 +102,29 @@ func MaybeStart(cfg Config) (*Service, error) {
 		WorkingDir: workingDir,
 		ShellPath:  shellPath,
 		Timeout:    defaultExecTimeout,
+		Approver:   cfg.Approver,
 	}
 
+	var audit *AuditLog
+	if strings.TrimSpace(cfg.AuditDir) != "" && len(entries) > 0 {
+		audit, err = OpenAuditLog(cfg.AuditDir, sessionID)
+		if err != nil {
+			return nil, fmt.Errorf("open call audit log: %w", err)
+		}
+	}
+
+	adapter := newAliasExecutorAdapter(executor, entries)
+	adapter.audit = audit
 	server, err := mcp.NewServer(mcp.Config{
 		BindAddr:      mcpBindAddr,
 		Token:         token,
 		SessionID:     sessionID,
-		Executor:      newAliasExecutorAdapter(executor, entries),
+		Executor:      adapter,
 		MaxConcurrent: 4,
+		Rejected:      adapter.reject,
 	})
 	if err != nil {
+		_ = audit.Close()
 		return nil, fmt.Errorf("start alias MCP server: %w", err)
 	}
 	server.Start()
@@ -118,6 +140,7 @@ func MaybeStart(cfg Config) (*Service, error) {
 	return &Service{
 		env:            envList,
 		server:         server,
+		audit:          audit,
 		dockerHostAddr: dockerHostAddr,
 	}, nil
 }
@@ -129,6 +152,15 @@ func (s *Service) Env() []string {
 	return out
 }
 
+// AuditPath returns the session's audit log file, or "" when auditing is
+// off.
+func (s *Service) AuditPath() string {
+	if s == nil {
+		return ""
+	}
+	return s.audit.Path()
+}
+
 // Close terminates the MCP server.
 func (s *Service) Close() {
 	if s == nil {
@@ -138,6 +170,7 @@ func (s *Service) Close() {
 		if s.server != nil {
 			_ = s.server.Close(context.Background())
 		}
+		_ = s.audit.Close()
 	})
 }
 
@@ -153,6 +186,7 @@ type aliasExecutorAdapter struct {
 	exec    *Executor
 	entries map[string]*Entry
 	tools   []mcp.Tool
+	audit   *AuditLog
 }
 
 func newAliasExecutorAdapter(exec *Executor, entries []*Entry) *aliasExecutorAdapter {
@@ -164,8 +198,15 @@ func newAliasExecutorAdapter(exec *Executor, entries []*Entry) *aliasExecutorAda
 		}
 		entryMap[e.Name] = e
 		tools = append(tools, mcp.Tool{
-			Name:        e.Name,
-			Description: e.Description,
+			Name:           e.Name,
+			Description:    e.Description,
+			InputSchema:    e.InputSchema(),
+			OutputFiles:    e.OutputFiles(),
+			MaxInputBytes:  e.MaxInputBytes(),
+			MaxOutputBytes: e.MaxOutputBytes,
+			MaxConcurrent:  e.MaxConcurrent,
+			Queue:          e.Queue,
+			RatePerMinute:  e.RatePerMinute,
 		})
 	}
 	return &aliasExecutorAdapter{
@@ -181,17 +222,76 @@ func (a *aliasExecutorAdapter) Tools() []mcp.Tool {
 	return out
 }
 
-func (a *aliasExecutorAdapter) Execute(ctx context.Context, name string, args []string, streams mcp.Streams) (int, error) {
-	entry, ok := a.entries[name]
+func (a *aliasExecutorAdapter) Execute(ctx context.Context, req mcp.CallRequest, streams mcp.Streams) (int, error) {
+	entry, ok := a.entries[req.Name]
 	if !ok {
-		return 0, fmt.Errorf("alias %q not found", name)
+		return 0, fmt.Errorf("alias %q not found", req.Name)
 	}
-	result, err := a.exec.Run(ctx, entry, args, Streams{
-		Stdout: streams.Stdout,
-		Stderr: streams.Stderr,
-	})
+	var written int64
+	out := Streams{
+		Stdout: &countingWriter{w: writerOrDiscard(streams.Stdout), n: &written},
+		Stderr: &countingWriter{w: writerOrDiscard(streams.Stderr), n: &written},
+		File:   streams.File,
+	}
+	var (
+		result RunResult
+		err    error
+	)
+	start := time.Now()
+	if entry.Typed() {
+		result, err = a.exec.RunParams(ctx, entry, req.Params, out)
+	} else {
+		result, err = a.exec.Run(ctx, entry, req.Args, out)
+	}
+	a.record(entry.Name, result, err, time.Since(start), atomic.LoadInt64(&written))
 	if err != nil {
 		return 0, err
 	}
 	return result.ExitCode, nil
 }
+
+// record writes the outcome of a call to the audit log.
+func (a *aliasExecutorAdapter) record(name string, result RunResult, runErr error, elapsed time.Duration, outputBytes int64) {
+	if a.audit == nil {
+		return
+	}
+	rec := AuditRecord{
+		Call:        name,
+		Argv:        result.Argv,
+		DurationMS:  elapsed.Milliseconds(),
+		OutputBytes: outputBytes,
+	}
+	switch {
+	case runErr == nil:
+		exitCode := result.ExitCode
+		rec.ExitCode = &exitCode
+	case errors.Is(runErr, mcp.ErrNotApproved):
+		rec.Denied = runErr.Error()
+	default:
+		rec.Error = runErr.Error()
+	}
+	if err := a.audit.Record(rec); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to write call audit log: %v\n", err)
+	}
+}
+
+// reject records a call the MCP server turned away before it ran.
+func (a *aliasExecutorAdapter) reject(name, reason string) {
+	if a.audit == nil {
+		return
+	}
+	if err := a.audit.Record(AuditRecord{Call: name, Denied: reason}); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to write call audit log: %v\n", err)
+	}
+}
+
+// countingWriter tallies bytes written to a shared counter.
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(c.n, int64(len(p)))
+	return c.w.Write(p)
+}
@@ -1,6 +1,7 @@
 package alias
 
 import (
+	"net/http"
 	"strings"
 	"testing"
 
@@ -30,3 +31,48 @@ func TestMaybeStartInitializesWithoutEntries(t *testing.T) {
 	}
 	require.NotEmpty(t, endpoint, "expected endpoint env var")
 }
+
+func TestServiceAuditsRejectedCalls(t *testing.T) {
+	status, err := NewArgvEntry("status", "", []string{"true"}, "")
+	require.NoError(t, err)
+	status.RatePerMinute = 1
+
+	auditDir := t.TempDir()
+	svc, err := MaybeStart(Config{
+		WorkingDir:     t.TempDir(),
+		Entries:        []*Entry{status},
+		DockerHostAddr: "127.0.0.1",
+		MCPBindAddr:    "127.0.0.1:0",
+		AuditDir:       auditDir,
+	})
+	require.NoError(t, err)
+	defer svc.Close()
+
+	env := map[string]string{}
+	for _, kv := range svc.Env() {
+		key, value, _ := strings.Cut(kv, "=")
+		env[key] = value
+	}
+	call := func(name string) {
+		body := `{"jsonrpc":"2.0","id":1,"method":"callTool","params":{"name":"` + name + `"}}`
+		req, err := http.NewRequest(http.MethodPost, env["SHAI_ALIAS_ENDPOINT"], strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+env["SHAI_ALIAS_TOKEN"])
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+	call("status")
+	call("status")
+	call("missing")
+	svc.Close()
+
+	records, err := ReadAuditLog(auditDir, "")
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	require.NotNil(t, records[0].ExitCode)
+	require.Equal(t, "status", records[1].Call)
+	require.Contains(t, records[1].Denied, "rate limit of 1 per minute")
+	require.Equal(t, "missing", records[2].Call)
+	require.Contains(t, records[2].Denied, `alias "missing" not found`)
+}
@@ -0,0 +1,163 @@
+package shai
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/colony-2/shai/internal/shai/runtime/alias"
+)
+
+// approvalInput sits between the host's stdin and the container. While an
+// approval prompt is open, keystrokes go to the prompt instead of the
+// container.
+type approvalInput struct {
+	reader io.Reader
+
+	mu      sync.Mutex
+	answers chan byte
+}
+
+func newApprovalInput(r io.Reader) *approvalInput {
+	return &approvalInput{reader: r}
+}
+
+func (a *approvalInput) divert(ch chan byte) {
+	a.mu.Lock()
+	a.answers = ch
+	a.mu.Unlock()
+}
+
+func (a *approvalInput) Read(p []byte) (int, error) {
+	for {
+		n, err := a.reader.Read(p)
+		a.mu.Lock()
+		answers := a.answers
+		a.mu.Unlock()
+		if answers == nil || n == 0 {
+			return n, err
+		}
+		for _, b := range p[:n] {
+			select {
+			case answers <- b:
+			default:
+			}
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// terminalApprover asks the user on the controlling terminal. It only works
+// while a container run has attached its stdin; otherwise calls are denied.
+type terminalApprover struct {
+	timeout time.Duration
+
+	prompt sync.Mutex // one question on screen at a time
+
+	mu    sync.Mutex
+	input *approvalInput
+	out   io.Writer
+	raw   bool
+}
+
+func newTerminalApprover() *terminalApprover {
+	return &terminalApprover{timeout: alias.DefaultApprovalTimeout}
+}
+
+// attach routes prompts through input and out. raw reports whether the
+// terminal is in raw mode, where output needs explicit carriage returns and
+// typed answers are not echoed.
+func (t *terminalApprover) attach(input *approvalInput, out io.Writer, raw bool) func() {
+	t.mu.Lock()
+	t.input, t.out, t.raw = input, out, raw
+	t.mu.Unlock()
+	return func() {
+		t.mu.Lock()
+		t.input, t.out = nil, nil
+		t.mu.Unlock()
+	}
+}
+
+// Approve implements alias.Approver.
+func (t *terminalApprover) Approve(ctx context.Context, req alias.ApprovalRequest) error {
+	t.prompt.Lock()
+	defer t.prompt.Unlock()
+
+	t.mu.Lock()
+	input, out, raw := t.input, t.out, t.raw
+	t.mu.Unlock()
+	if input == nil {
+		return alias.NotApproved("call %q requires approval but no interactive terminal is attached", req.Call)
+	}
+
+	answers := make(chan byte, 64)
+	input.divert(answers)
+	defer input.divert(nil)
+
+	nl := "\n"
+	if raw {
+		nl = "\r\n"
+	}
+	fmt.Fprintf(out, "%sshai: call %q wants to run on the host:%s  %s%sAllow? [y/N] ", nl, req.Call, nl, displayArgv(req.Argv), nl)
+
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+	select {
+	case b := <-answers:
+		if b == 'y' || b == 'Y' {
+			if raw {
+				fmt.Fprint(out, "yes"+nl)
+			}
+			return nil
+		}
+		if raw {
+			fmt.Fprint(out, "no"+nl)
+		}
+		return alias.NotApproved("call %q was denied on the host", req.Call)
+	case <-timer.C:
+		fmt.Fprint(out, nl+"shai: no answer, denied"+nl)
+		return alias.NotApproved("approval for call %q timed out after %s", req.Call, t.timeout)
+	case <-ctx.Done():
+		fmt.Fprint(out, nl+"shai: call cancelled"+nl)
+		return alias.NotApproved("call %q was cancelled while awaiting approval", req.Call)
+	}
+}
+
+var plainArgRe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// displayArgv renders argv so that arguments with spaces or shell
+// metacharacters remain unambiguous.
+func displayArgv(argv []string) string {
+	parts := make([]string, len(argv))
+	for i, arg := range argv {
+		if plainArgRe.MatchString(arg) {
+			parts[i] = arg
+			continue
+		}
+		parts[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(parts, " ")
+}
+
+// buildApprover combines the allowlist and hook from cfg with the terminal
+// prompt.
+func buildApprover(cfg EphemeralConfig, prompt *terminalApprover) (alias.Approver, error) {
+	chain := &alias.ApprovalChain{Prompt: prompt}
+	if path := strings.TrimSpace(cfg.ApprovalAllowlist); path != "" {
+		list, err := alias.LoadAllowlist(path)
+		if err != nil {
+			return nil, fmt.Errorf("load approval allowlist: %w", err)
+		}
+		chain.Allowlist = list
+	}
+	if hook := strings.TrimSpace(cfg.ApprovalHook); hook != "" {
+		chain.Hook = &alias.HookApprover{Command: hook, Dir: cfg.WorkingDir}
+	}
+	return chain, nil
+}
@@ -0,0 +1,114 @@
+package shai
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/colony-2/shai/internal/shai/runtime/alias"
+	"github.com/colony-2/shai/internal/shai/runtime/alias/mcp"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// startApprovalTerminal wires a terminal approver to a pipe standing in for
+// stdin and returns what reached the container.
+func startApprovalTerminal(t *testing.T) (*terminalApprover, *io.PipeWriter, *syncBuffer, *syncBuffer) {
+	t.Helper()
+	stdinR, stdinW := io.Pipe()
+	input := newApprovalInput(stdinR)
+	approver := newTerminalApprover()
+	out := &syncBuffer{}
+	detach := approver.attach(input, out, true)
+	t.Cleanup(func() {
+		detach()
+		stdinW.Close()
+	})
+	container := &syncBuffer{}
+	go io.Copy(container, input)
+	return approver, stdinW, out, container
+}
+
+// waitForPrompt waits until n prompts have been shown.
+func waitForPrompt(t *testing.T, out *syncBuffer, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for strings.Count(out.String(), "Allow? [y/N]") < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("prompt not shown: %q", out.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTerminalApproverPromptsAndDivertsStdin(t *testing.T) {
+	approver, stdin, out, container := startApprovalTerminal(t)
+	req := alias.ApprovalRequest{Call: "push", Argv: []string{"git", "push", "origin", "feature x"}}
+
+	done := make(chan error, 1)
+	go func() { done <- approver.Approve(context.Background(), req) }()
+	waitForPrompt(t, out, 1)
+	stdin.Write([]byte("y"))
+	if err := <-done; err != nil {
+		t.Fatalf("expected approval, got %v", err)
+	}
+	if !strings.Contains(out.String(), "git push origin 'feature x'\r\n") {
+		t.Fatalf("prompt should show the exact argv: %q", out.String())
+	}
+
+	stdin.Write([]byte("ls\r"))
+	deadline := time.Now().Add(2 * time.Second)
+	for container.String() != "ls\r" {
+		if time.Now().After(deadline) {
+			t.Fatalf("stdin should reach the container after the prompt, got %q", container.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	go func() { done <- approver.Approve(context.Background(), req) }()
+	waitForPrompt(t, out, 2)
+	stdin.Write([]byte("n"))
+	if err := <-done; !errors.Is(err, mcp.ErrNotApproved) {
+		t.Fatalf("expected denial, got %v", err)
+	}
+	if strings.Contains(container.String(), "n") || strings.Contains(container.String(), "y") {
+		t.Fatalf("answers must not reach the container: %q", container.String())
+	}
+}
+
+func TestTerminalApproverTimesOut(t *testing.T) {
+	approver, _, _, _ := startApprovalTerminal(t)
+	approver.timeout = 50 * time.Millisecond
+	err := approver.Approve(context.Background(), alias.ApprovalRequest{Call: "flash", Argv: []string{"flash"}})
+	if !errors.Is(err, mcp.ErrNotApproved) || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected timeout denial, got %v", err)
+	}
+}
+
+func TestTerminalApproverWithoutTerminalDenies(t *testing.T) {
+	approver := newTerminalApprover()
+	err := approver.Approve(context.Background(), alias.ApprovalRequest{Call: "flash"})
+	if !errors.Is(err, mcp.ErrNotApproved) {
+		t.Fatalf("expected denial without a terminal, got %v", err)
+	}
+}
@@ -18,6 +18,10 @@ DNSMASQ_RUN_DIR="$SHAI_RUN_DIR/dnsmasq"
 TINYPROXY_PID_FILE="$TINYPROXY_RUN_DIR/tinyproxy.pid"
 DNSMASQ_PID_FILE="$DNSMASQ_RUN_DIR/dnsmasq.pid"
 PROXY_ENV_FILE="$SHAI_RUN_DIR/proxy-env.sh"
+SESSION_ENV_FILE="$SHAI_RUN_DIR/session.env"
+EXEC_HELPER="$SHAI_RUN_DIR/exec.sh"
+SECRETS_SRC_DIR="$BOOT_SRC_DIR/secrets"
+SECRETS_DIR="$SHAI_RUN_DIR/secrets"
 PROFILE_SNIPPET="/etc/profile.d/zz-shai-proxy.sh"
 SUPERVISOR_LOG="$SHAI_LOG_DIR/supervisord.log"
 SUPERVISOR_PID="$SHAI_RUN_DIR/supervisord.pid"
@@ -178,6 +182,7 @@ install_alias_script() {
     local alias_dest="$dest_dir/shai-remote"
     if cp "$alias_src" "$alias_dest"; then
       chmod 0755 "$alias_dest" || true
+      ALIAS_INSTALL_PATH="$alias_dest"
       log_verbose "installed shai-remote to $alias_dest"
     else
       log_verbose "failed to install shai-remote to $alias_dest"
@@ -187,6 +192,167 @@ install_alias_script() {
   fi
 }
 
+# path_is_mounted reports whether path or its parent directory is a mount
+# point, i.e. likely shared with the host. Agent configs on such paths are
+# left untouched so the sandbox never rewrites host files.
+path_is_mounted() {
+  local path=$1
+  local parent
+  parent=$(dirname "$path")
+  [ -r /proc/self/mountinfo ] || return 1
+  awk -v p="$path" -v d="$parent" '$5 == p || $5 == d { found = 1 } END { exit !found }' /proc/self/mountinfo
+}
+
+# write_json_mcp_server registers the shai stdio bridge under .mcpServers in
+# a JSON agent config, preserving any existing content.
+write_json_mcp_server() {
+  local path=$1
+  local entry=$2
+  local dir tmp
+  dir=$(dirname "$path")
+  mkdir -p "$dir" || return 1
+  tmp=$(mktemp "$dir/.shai-mcp.XXXXXX") || return 1
+  if [ -s "$path" ]; then
+    if ! jq --argjson entry "$entry" '.mcpServers.shai = $entry' "$path" >"$tmp"; then
+      rm -f "$tmp"
+      return 1
+    fi
+  else
+    jq -n --argjson entry "$entry" '{mcpServers: {shai: $entry}}' >"$tmp"
+  fi
+  chmod 0600 "$tmp" || true
+  mv "$tmp" "$path"
+}
+
+# write_codex_mcp_server appends an [mcp_servers.shai] table to the codex
+# config unless one is already present.
+write_codex_mcp_server() {
+  local path=$1
+  local command=$2
+  mkdir -p "$(dirname "$path")" || return 1
+  if [ -f "$path" ] && grep -q '^\[mcp_servers\.shai\]' "$path"; then
+    return 0
+  fi
+  cat >>"$path" <<EOF
+
+[mcp_servers.shai]
+command = "$command"
+args = ["mcp-stdio"]
+EOF
+}
+
+write_mcp_client_configs() {
+  local home_dir=$1
+  if [ ${#MCP_CLIENTS[@]} -eq 0 ]; then
+    return
+  fi
+  if [ -z "${SHAI_ALIAS_ENDPOINT:-}" ]; then
+    log_verbose "no alias endpoint; skipping MCP client configs"
+    return
+  fi
+  if [ -z "$ALIAS_INSTALL_PATH" ]; then
+    log_verbose "shai-remote not installed; skipping MCP client configs"
+    return
+  fi
+  if ! command -v jq >/dev/null 2>&1; then
+    log "warning: jq not found; skipping MCP client configs"
+    return
+  fi
+
+  local entry client path
+  entry=$(jq -nc --arg cmd "$ALIAS_INSTALL_PATH" '{type: "stdio", command: $cmd, args: ["mcp-stdio"]}')
+  for client in "${MCP_CLIENTS[@]}"; do
+    case "$client" in
+      claude) path="$home_dir/.claude.json" ;;
+      gemini) path="$home_dir/.gemini/settings.json" ;;
+      codex) path="$home_dir/.codex/config.toml" ;;
+      *)
+        log "warning: unknown MCP client $client; skipping"
+        continue
+        ;;
+    esac
+    if path_is_mounted "$path"; then
+      log_verbose "$path is mounted from the host; not registering shai MCP server"
+      continue
+    fi
+    if [ "$client" = "codex" ]; then
+      write_codex_mcp_server "$path" "$ALIAS_INSTALL_PATH" || { log "warning: failed to update $path"; continue; }
+    else
+      write_json_mcp_server "$path" "$entry" || { log "warning: failed to update $path"; continue; }
+    fi
+    if [ "$IS_ROOT" -eq 1 ]; then
+      chown "$TARGET_USER:" "$path" "$(dirname "$path")" 2>/dev/null || true
+    fi
+    log_verbose "registered shai MCP server for $client in $path"
+  done
+}
+
+# install_secrets exports the secret env vars and moves the secret files
+# onto the secrets tmpfs, readable only by the target user. The host wrote
+# them into the bootstrap mount, so the copies there are removed. Values are
+# never logged.
+install_secrets() {
+  local name
+  for name in "${SECRET_ENVS[@]}"; do
+    [ -f "$SECRETS_SRC_DIR/$name" ] || die "secret $name missing from bootstrap mount"
+    export "$name"="$(cat "$SECRETS_SRC_DIR/$name")"
+  done
+  if [ ${#SECRET_FILES[@]} -gt 0 ]; then
+    mkdir -p "$SECRETS_DIR" || die "failed to create secrets dir $SECRETS_DIR"
+    for name in "${SECRET_FILES[@]}"; do
+      [ -f "$SECRETS_SRC_DIR/$name" ] || die "secret $name missing from bootstrap mount"
+      install -m 0400 "$SECRETS_SRC_DIR/$name" "$SECRETS_DIR/$name" || die "failed to install secret $name"
+      if [ "$IS_ROOT" -eq 1 ]; then
+        chown "$TARGET_USER" "$SECRETS_DIR/$name"
+      fi
+    done
+    if [ "$IS_ROOT" -eq 1 ]; then
+      chown "$TARGET_USER" "$SECRETS_DIR"
+      chmod 0500 "$SECRETS_DIR"
+    fi
+    export SHAI_SECRETS_DIR="$SECRETS_DIR"
+  fi
+  rm -rf "$SECRETS_SRC_DIR" 2>/dev/null || true
+  log_verbose "installed ${#SECRET_ENVS[@]} secret env var(s) and ${#SECRET_FILES[@]} secret file(s)"
+}
+
+# write_exec_helper saves the environment the user's command starts with
+# and installs $EXEC_HELPER, which `shai exec` runs to start more commands
+# the same way: with that environment, in the workspace, as the target user.
+# The saved environment holds secret env vars, so only root can read it
+# when the bootstrap runs as root.
+write_exec_helper() {
+  local user_shell=$1
+  {
+    export -p
+    printf 'SHAI_EXEC_SHELL=%q\n' "$user_shell"
+  } >"$SESSION_ENV_FILE" || die "failed to write $SESSION_ENV_FILE"
+  chmod 0600 "$SESSION_ENV_FILE"
+
+  cat >"$EXEC_HELPER" <<EOF || die "failed to write $EXEC_HELPER"
+#!/bin/bash
+# Written by the shai bootstrap; see write_exec_helper.
+# Usage: $EXEC_HELPER [--env KEY=VALUE]... [--workdir DIR] -- [command...]
+. "$SESSION_ENV_FILE"
+workdir=\$WORKSPACE
+while [ \$# -gt 0 ]; do
+  case \$1 in
+    --env) export "\$2"; shift 2 ;;
+    --workdir) workdir=\$2; shift 2 ;;
+    --) shift; break ;;
+    *) break ;;
+  esac
+done
+cd "\$workdir" || exit 1
+[ \$# -gt 0 ] || set -- "\$SHAI_EXEC_SHELL" -l
+if [ "\$(id -u)" -eq 0 ]; then
+  exec runuser -u "\$TARGET_USER" -- "\$@"
+fi
+exec "\$@"
+EOF
+  chmod 0755 "$EXEC_HELPER"
+}
+
 on_exit() {
   if [ "$VERBOSE" -eq 1 ]; then
     status=$?
@@ -207,14 +373,18 @@ DNS_PORT=${DNS_PORT:-1053}
 REQUESTED_DEV_UID=${DEV_UID:-4747}
 REQUESTED_DEV_GID=${DEV_GID:-$REQUESTED_DEV_UID}
 RM_SELF="false"
+ALIAS_INSTALL_PATH=""
 
 declare -a EXEC_ENVS=()
+declare -a SECRET_ENVS=()
+declare -a SECRET_FILES=()
 declare -a EXEC_CMD=()
 declare -a HTTP_ALLOW=()
 declare -a PORT_ALLOW=()
 declare -a RESOURCE_NAMES=()
 declare -a ROOT_CMDS=()
 declare -a EXPOSE_PORTS=()
+declare -a MCP_CLIENTS=()
 
 require_arg() {
   if [ $# -lt 2 ]; then
@@ -244,6 +414,16 @@ while [ $# -gt 0 ]; do
       EXEC_ENVS+=("$2")
       shift 2
       ;;
+    --secret-env)
+      require_arg "$@"
+      SECRET_ENVS+=("$2")
+      shift 2
+      ;;
+    --secret-file)
+      require_arg "$@"
+      SECRET_FILES+=("$2")
+      shift 2
+      ;;
     --exec-cmd)
       require_arg "$@"
       EXEC_CMD+=("$2")
@@ -284,6 +464,11 @@ while [ $# -gt 0 ]; do
       EXPOSE_PORTS+=("$2")
       shift 2
       ;;
+    --mcp-client)
+      require_arg "$@"
+      MCP_CLIENTS+=("$2")
+      shift 2
+      ;;
     --verbose)
       VERBOSE=1
       shift
@@ -933,6 +1118,9 @@ EOF
     fi
     export "$key"="$value"
   done
+  install_secrets
+
+  write_mcp_client_configs "$user_home"
 
   cd "$WORKSPACE" 2>/dev/null || die "failed to enter workspace $WORKSPACE"
 
@@ -952,6 +1140,7 @@ EOF
   if [ ${#argv[@]} -eq 0 ]; then
     argv=("$user_shell" "-l")
   fi
+  write_exec_helper "$user_shell"
 
   resource_summary="none"
   if [ ${#RESOURCE_NAMES[@]} -gt 0 ]; then
@@ -3,6 +3,7 @@ set -u
 
 EX_USAGE=64
 EX_UNAVAILABLE=69
+EX_NOPERM=77
 
 endpoint=${SHAI_ALIAS_ENDPOINT-}
 token=${SHAI_ALIAS_TOKEN-}
@@ -14,7 +15,9 @@ usage() {
 	cat <<'EOF'
 Usage:
   shai-remote list [--endpoint URL] [--token TOKEN] [--session ID] [--verbose]
-  shai-remote call <name> [args...] [--endpoint URL] [--token TOKEN] [--session ID] [--verbose]
+  shai-remote call <name> [args... | --param=value...] [--endpoint URL] [--token TOKEN] [--session ID] [--verbose]
+  shai-remote usage <name> [--endpoint URL] [--token TOKEN] [--session ID] [--verbose]
+  shai-remote mcp-stdio [--endpoint URL] [--token TOKEN] [--session ID] [--verbose]
 EOF
 	exit "${1:-$EX_USAGE}"
 }
@@ -60,6 +63,18 @@ build_payload_call() {
 	' -- "$@"
 }
 
+# build_payload_call_files is build_payload_call with uploaded input files
+# read from the arguments object in $2.
+build_payload_call_files() {
+	call_name=$1
+	arguments_file=$2
+	shift 2
+	jq -nc --arg alias "$call_name" --slurpfile files "$arguments_file" --args '
+		{jsonrpc:"2.0",id:99,method:"callTool",
+		 params:{name:$alias,args:$ARGS.positional,arguments:$files[0]}}
+	' -- "$@"
+}
+
 ensure_env() {
 	if [ -z "${endpoint}" ]; then
 		die 1 "shai-remote: missing SHAI_ALIAS_ENDPOINT (set env or use --endpoint)"
@@ -81,6 +96,18 @@ mcp_post() {
 	printf '%s' "$response"
 }
 
+# mcp_post_stream posts the payload in file $1, accepting a server-sent event
+# stream, and writes the response body as it arrives.
+mcp_post_stream() {
+	debug "payload: $(cat "$1")"
+	curl --noproxy '*' -sS -N \
+		-H "Authorization: Bearer ${token}" \
+		-H "Content-Type: application/json" \
+		-H "Accept: application/json, text/event-stream" \
+		--data-binary @"$1" \
+		"${endpoint}"
+}
+
 run_list() {
 	payload=$(build_payload_list) || return 1
 	resp=$(mcp_post "$payload") || return $?
@@ -106,14 +133,70 @@ run_list() {
 	done
 }
 
+fetch_tool() {
+	call_name=$1
+	payload=$(build_payload_list) || return 1
+	resp=$(mcp_post "$payload") || return $?
+	tool=$(printf '%s' "$resp" | jq -ce --arg name "$call_name" '.result.tools[]? | select(.name == $name)' 2>/dev/null) || {
+		log_err "shai-remote: call '$call_name' not found"
+		return 1
+	}
+	printf '%s' "$tool"
+}
+
+format_usage() {
+	printf '%s' "$1" | jq -r '
+		def flag($k; $p):
+			if $p.type == "boolean" then "--\($k)"
+			elif ($p.enum // null) != null then "--\($k)=<\($p.enum | join("|"))>"
+			elif $p.type == "integer" then "--\($k)=<int>"
+			elif $p.contentEncoding == "base64" then "--\($k)=<file>"
+			else "--\($k)=<value>" end;
+		(.inputSchema.properties // {}) as $props
+		| (.inputSchema.required // []) as $req
+		| (._meta["shai/outputFiles"] // []) as $outs
+		| if ($props | keys) == ["args"] then
+			"Usage: shai-remote call \(.name) [args...]"
+		  else
+			"Usage: shai-remote call \(.name)" + ([$props | to_entries[] | .key as $k
+				| flag($k; .value) as $f
+				| if ($req | index($k)) != null then " \($f)" else " [\($f)]" end] | join(""))
+			+ ([$outs[] | " [--\(.name)=<path>]"] | join(""))
+		  end,
+		(if (.description // "") != "" then "\n\(.description)" else empty end),
+		(if ($props | keys) != ["args"] and ($props | length) > 0 then
+			"\nParameters:",
+			($props | to_entries[] | .key as $k
+				| "  \(flag($k; .value))"
+				+ (if ($req | index($k)) != null then " (required)" else "" end)
+				+ (if (.value.pattern // "") != "" then " pattern: \(.value.pattern)" else "" end)
+				+ (if (.value.description // "") != "" then "\n      \(.value.description)" else "" end))
+		 else empty end),
+		(if ($outs | length) > 0 then
+			"\nOutput files (written to ./<name> unless a path is given):",
+			($outs[] | "  --\(.name)=<path>"
+				+ (if (.description // "") != "" then "\n      \(.description)" else "" end))
+		 else empty end)
+	'
+}
+
+run_usage() {
+	tool=$(fetch_tool "$1") || return $?
+	format_usage "$tool"
+}
+
+emit_chunk() {
+	stream=$(printf '%s' "$1" | jq -r '.stream // .role // "stdout"' 2>/dev/null || printf 'stdout')
+	if [ "$stream" = "stderr" ]; then
+		printf '%s' "$1" | jq -j '.text // ""' >&2 2>/dev/null
+	else
+		printf '%s' "$1" | jq -j '.text // ""' 2>/dev/null
+	fi
+}
+
 emit_content() {
 	printf '%s' "$1" | jq -c '.result.content[]?' 2>/dev/null | while IFS= read -r chunk; do
-		role=$(printf '%s' "$chunk" | jq -r '.role // "stdout"' 2>/dev/null || printf 'stdout')
-		if [ "$role" = "stderr" ]; then
-			printf '%s' "$chunk" | jq -j '.text // ""' >&2 2>/dev/null
-		else
-			printf '%s' "$chunk" | jq -j '.text // ""' 2>/dev/null
-		fi
+		emit_chunk "$chunk"
 	done
 }
 
@@ -128,15 +211,171 @@ extract_exit_code() {
 	printf '%s' "$code"
 }
 
+# output_path prints where output file $1 is written: the path given with
+# --<name>=<path>, or ./<name>.
+output_path() {
+	dest=""
+	if [ -f "$tmp_dir/outputs" ]; then
+		dest=$(awk -F '\t' -v name="$1" '$1 == name { dest = $2 } END { print dest }' "$tmp_dir/outputs")
+	fi
+	printf '%s' "${dest:-./$1}"
+}
+
+# write_file_chunk appends one shai/file notification to its output file.
+write_file_chunk() {
+	name=$(printf '%s' "$1" | jq -r '.params.name // empty' 2>/dev/null)
+	case "$name" in
+		'' | *[!A-Za-z0-9_-]*)
+			log_err "shai-remote: ignoring output file with invalid name '$name'"
+			return 0
+			;;
+	esac
+	if [ "$(printf '%s' "$1" | jq -r '.params.eof // false')" = "true" ]; then
+		debug "received output file $name"
+		return 0
+	fi
+	dest=$(output_path "$name")
+	if [ ! -e "$tmp_dir/started-$name" ]; then
+		: >"$tmp_dir/started-$name"
+		: >"$dest" || return 1
+	fi
+	printf '%s' "$1" | jq -j '.params.data // ""' | base64 -d >>"$dest"
+}
+
+# write_result_files writes files returned inline by servers that do not
+# stream.
+write_result_files() {
+	printf '%s' "$1" | jq -r '.result.files[]?.name' 2>/dev/null | while IFS= read -r name; do
+		case "$name" in
+			'' | *[!A-Za-z0-9_-]*) continue ;;
+		esac
+		dest=$(output_path "$name")
+		printf '%s' "$1" | jq -j --arg name "$name" '.result.files[] | select(.name == $name) | .data' | base64 -d >"$dest"
+	done
+}
+
 run_call() {
 	call_name=$1
 	shift
-	payload=$(build_payload_call "$call_name" "$@") || return 1
-	resp=$(mcp_post "$payload") || return $?
+	tmp_dir=$(mktemp -d) || return 1
+	trap 'rm -rf "$tmp_dir"' EXIT
+
+	# File inputs and outputs are only known from the tool listing. Typed
+	# calls take nothing but --name=value flags, so it is fetched only when
+	# every argument is one.
+	inputs=""
+	outputs=""
+	flags_only=0
+	for arg in "$@"; do
+		case "$arg" in
+			--?*=*) flags_only=1 ;;
+			--?*) ;;
+			*)
+				flags_only=0
+				break
+				;;
+		esac
+	done
+	if [ "$flags_only" -eq 1 ] && tool=$(fetch_tool "$call_name" 2>/dev/null); then
+		inputs=$(printf '%s' "$tool" | jq -r '.inputSchema.properties // {} | to_entries[] | select(.value.contentEncoding == "base64") | .key')
+		outputs=$(printf '%s' "$tool" | jq -r '._meta["shai/outputFiles"][]?.name')
+	fi
+
+	uploads=0
+	if [ -n "$inputs" ] || [ -n "$outputs" ]; then
+		require_cmd base64
+		printf '{}' >"$tmp_dir/arguments"
+		n=$#
+		while [ "$n" -gt 0 ]; do
+			arg=$1
+			shift
+			n=$((n - 1))
+			case "$arg" in
+				--?*=*)
+					key=${arg%%=*}
+					key=${key#--}
+					value=${arg#*=}
+					if printf '%s\n' "$inputs" | grep -qxF -- "$key"; then
+						if [ ! -f "$value" ]; then
+							log_err "shai-remote: input file '$value' not found"
+							return "$EX_USAGE"
+						fi
+						base64 <"$value" | tr -d '\n' >"$tmp_dir/upload" || return 1
+						jq -c --arg key "$key" --rawfile data "$tmp_dir/upload" '. + {($key): $data}' \
+							"$tmp_dir/arguments" >"$tmp_dir/arguments.next" || return 1
+						mv "$tmp_dir/arguments.next" "$tmp_dir/arguments"
+						uploads=1
+						continue
+					fi
+					if printf '%s\n' "$outputs" | grep -qxF -- "$key"; then
+						printf '%s\t%s\n' "$key" "$value" >>"$tmp_dir/outputs"
+						continue
+					fi
+					;;
+			esac
+			set -- "$@" "$arg"
+		done
+	fi
+
+	# Large uploads stay in files rather than shell variables.
+	if [ "$uploads" -eq 1 ]; then
+		build_payload_call_files "$call_name" "$tmp_dir/arguments" "$@" >"$tmp_dir/payload" || return 1
+	else
+		build_payload_call "$call_name" "$@" >"$tmp_dir/payload" || return 1
+	fi
+
+	# Streamed output arrives as shai/output and shai/file notifications
+	# ahead of the final response; servers that do not stream reply with
+	# plain JSON.
+	{
+		mcp_post_stream "$tmp_dir/payload"
+		printf '%s' "$?" >"$tmp_dir/curl-status"
+	} | while IFS= read -r line || [ -n "$line" ]; do
+		case "$line" in
+			data:*)
+				msg=${line#data:}
+				msg=${msg# }
+				;;
+			'{'* | '['*) msg=$line ;;
+			*) continue ;;
+		esac
+		case "$(printf '%s' "$msg" | jq -r '.method? // empty' 2>/dev/null)" in
+			shai/output)
+				debug "message: $msg"
+				emit_chunk "$(printf '%s' "$msg" | jq -c '.params')"
+				;;
+			shai/file)
+				write_file_chunk "$msg" || printf '1' >"$tmp_dir/file-status"
+				;;
+			*)
+				debug "message: $msg"
+				printf '%s' "$msg" >"$tmp_dir/response"
+				;;
+		esac
+	done
+	curl_status=$(cat "$tmp_dir/curl-status" 2>/dev/null || printf '1')
+	if [ "$curl_status" != "0" ]; then
+		return "$curl_status"
+	fi
+	if [ -e "$tmp_dir/file-status" ]; then
+		log_err "shai-remote: failed to write output files"
+		return 1
+	fi
+	resp=$(cat "$tmp_dir/response" 2>/dev/null || true)
 
 	if printf '%s' "$resp" | jq -e '.error' >/dev/null 2>&1; then
 		msg=$(printf '%s' "$resp" | jq -r '.error.message // "call failed"' 2>/dev/null || printf 'call failed')
 		log_err "shai-remote: $msg"
+		code=$(printf '%s' "$resp" | jq -r '.error.code // empty' 2>/dev/null)
+		if [ "$code" = "-32602" ]; then
+			if tool=$(fetch_tool "$call_name" 2>/dev/null); then
+				format_usage "$tool" >&2
+			fi
+		fi
+		# The host user denied the call or did not answer in time.
+		if [ "$code" = "-32005" ]; then
+			return "$EX_NOPERM"
+		fi
 		return 1
 	fi
 
@@ -147,10 +386,82 @@ run_call() {
 	fi
 
 	emit_content "$resp"
+	if printf '%s' "$resp" | jq -e '.result.files' >/dev/null 2>&1; then
+		require_cmd base64
+		write_result_files "$resp"
+	fi
 	exit_code=$(extract_exit_code "$resp")
 	return "$exit_code"
 }
 
+stdio_error() {
+	printf '%s' "$1" | jq -c --arg msg "$2" '
+		if type == "object" and has("id") and .id != null then
+			{jsonrpc:"2.0",id:.id,error:{code:-32603,message:$msg}}
+		else empty end' 2>/dev/null
+}
+
+# run_mcp_stdio bridges newline-delimited JSON-RPC on stdin/stdout to the
+# alias endpoint so agents can register shai-remote as a stdio MCP server.
+# Messages from streamed (SSE) responses are forwarded as they arrive.
+run_mcp_stdio() {
+	protocol_version=""
+	tmp_dir=$(mktemp -d) || die 1 "shai-remote: unable to create temp dir"
+	trap 'rm -rf "$tmp_dir"' EXIT
+	while IFS= read -r line || [ -n "$line" ]; do
+		if [ -z "$(printf '%s' "$line" | tr -d ' \t\r')" ]; then
+			continue
+		fi
+		debug "stdio request: $line"
+		set -- -H "Authorization: Bearer ${token}" \
+			-H "Content-Type: application/json" \
+			-H "Accept: application/json, text/event-stream"
+		if [ -n "$session_id" ]; then
+			set -- "$@" -H "Mcp-Session-Id: ${session_id}"
+		fi
+		if [ -n "$protocol_version" ]; then
+			set -- "$@" -H "Mcp-Protocol-Version: ${protocol_version}"
+		fi
+		: >"$tmp_dir/messages"
+		# SSE data lines are printed immediately; a plain JSON body is held
+		# until the trailing status code shows the request succeeded.
+		printf '%s' "$line" | curl --noproxy '*' -sS -N -w '\n%{http_code}\n' \
+			"$@" --data-binary @- "${endpoint}" 2>/dev/null |
+			awk -v status_file="$tmp_dir/status" -v messages="$tmp_dir/messages" '
+				/^data:/ { sub(/^data: ?/, ""); print; fflush(); print >messages; n++; next }
+				/^[[{]/ { pending = $0; next }
+				NF { code = $0 }
+				END {
+					if (pending != "" && code ~ /^2/) { print pending; print pending >messages; n++ }
+					printf "%s %d\n", code, n >status_file
+				}'
+		status=000
+		emitted=0
+		read -r status emitted <"$tmp_dir/status" || true
+		debug "stdio response status $status ($emitted message(s))"
+		case "$status" in
+			202) continue ;;
+			000)
+				stdio_error "$line" "shai-remote: alias endpoint unreachable"
+				continue
+				;;
+			2??) ;;
+			*)
+				stdio_error "$line" "shai-remote: alias endpoint returned HTTP $status"
+				continue
+				;;
+		esac
+		if [ "$emitted" -eq 0 ]; then
+			stdio_error "$line" "shai-remote: malformed response from alias endpoint"
+			continue
+		fi
+		negotiated=$(jq -r 'if type == "object" then .result.protocolVersion // empty else empty end' "$tmp_dir/messages" 2>/dev/null | tail -n 1 || true)
+		if [ -n "$negotiated" ]; then
+			protocol_version=$negotiated
+		fi
+	done
+}
+
 main() {
 	require_cmd curl
 	require_cmd jq
@@ -197,10 +508,26 @@ main() {
 					continue
 				fi
 				;;
+			usage)
+				if [ -z "$cmd" ]; then
+					cmd="usage"
+					continue
+				fi
+				;;
+			mcp-stdio)
+				if [ -z "$cmd" ]; then
+					cmd="mcp-stdio"
+					continue
+				fi
+				;;
 			*)
-				if [ "$cmd" = "list" ]; then
+				if [ "$cmd" = "list" ] || [ "$cmd" = "mcp-stdio" ]; then
 					usage
 				fi
+				if [ "$cmd" = "usage" ] && [ -z "$call_name" ]; then
+					call_name=$arg
+					continue
+				fi
 				if [ "$cmd" = "call" ] && [ -z "$call_name" ]; then
 					call_name=$arg
 					break
@@ -221,10 +548,14 @@ main() {
 			call_name=$1
 			shift
 		fi
-	elif [ "$cmd" = "list" ]; then
+	elif [ "$cmd" = "list" ] || [ "$cmd" = "mcp-stdio" ]; then
 		if [ $# -gt 0 ]; then
 			usage
 		fi
+	elif [ "$cmd" = "usage" ]; then
+		if [ -z "$call_name" ] || [ $# -gt 0 ]; then
+			usage
+		fi
 	else
 		usage
 	fi
@@ -238,6 +569,14 @@ main() {
 		run_list
 		exit $?
 	fi
+	if [ "$cmd" = "usage" ]; then
+		run_usage "$call_name"
+		exit $?
+	fi
+	if [ "$cmd" = "mcp-stdio" ]; then
+		run_mcp_stdio
+		exit $?
+	fi
 	run_call "$call_name" "$@"
 	exit $?
 }
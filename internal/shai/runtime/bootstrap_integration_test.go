@@ -4,6 +4,7 @@ package shai
 
 import (
 	"context"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -586,6 +587,10 @@ apply:
 
 	err = runner.Run(ctx)
 	assert.Error(t, err, "Bootstrap should fail when root command fails")
+	var exitErr *ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, ExitBootstrap, exitErr.Kind)
+	assert.Equal(t, 90, exitErr.Code)
 }
 
 // Test #29: Exec environment variables are set
@@ -902,3 +907,66 @@ apply:
 	assert.Contains(t, result, "HOME_WRITABLE=true", "home directory should be writable by user")
 	assert.Contains(t, result, "HOME_CREATE_FILE=success", "user should be able to create files in home directory")
 }
+
+// Exec runs as the target user with the main command's environment.
+func TestBootstrap_ExecMatchesMainCommand(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	tmpDir := t.TempDir()
+	configContent := `
+type: shai-sandbox
+version: 1
+image: ghcr.io/colony-2/shai-base:latest
+user: shai
+`
+	configPath := filepath.Join(tmpDir, ".shai", "config.yaml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(configPath), 0755))
+	require.NoError(t, os.WriteFile(configPath, []byte(configContent), 0644))
+
+	cfg := EphemeralConfig{
+		WorkingDir:   tmpDir,
+		ConfigFile:   configPath,
+		Verbose:      testing.Verbose(),
+		ShowProgress: false,
+		Stdout:       io.Discard,
+		PostSetupExec: &ExecSpec{
+			Command: []string{"sleep", "60"},
+			UseTTY:  false,
+		},
+	}
+
+	runner, err := NewEphemeralRunner(cfg)
+	require.NoError(t, err)
+	defer runner.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	session, err := runner.Start(ctx)
+	require.NoError(t, err)
+	defer session.Close()
+	defer session.Stop(context.Background())
+
+	var output strings.Builder
+	err = session.Exec(ctx, ExecSpec{
+		Command: []string{"bash", "-c", `echo "USER=$(whoami) PWD=$PWD PROXY=$HTTP_PROXY CUSTOM=$CUSTOM"`},
+		Env:     map[string]string{"CUSTOM": "value"},
+		Stdout:  &output,
+		Stdin:   strings.NewReader(""),
+	})
+	require.NoError(t, err)
+
+	result := output.String()
+	assert.Contains(t, result, "USER=shai")
+	assert.Contains(t, result, "PWD=/src")
+	assert.Contains(t, result, "PROXY=http://127.0.0.1:")
+	assert.Contains(t, result, "CUSTOM=value")
+
+	err = session.Exec(ctx, ExecSpec{Command: []string{"false"}, Stdin: strings.NewReader("")})
+	var exitErr *ExitError
+	require.ErrorAs(t, err, &exitErr)
+	require.Equal(t, 1, exitErr.Code)
+	require.Equal(t, ExitCommand, exitErr.Kind)
+}
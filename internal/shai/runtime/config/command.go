@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// shellMetaChars are rejected outside quotes in argv mode because they would
+// only have meaning to a shell, which argv mode never invokes.
+const shellMetaChars = "|&;<>()$`"
+
+// SplitCommand tokenizes a call command into argv elements. It understands
+// single quotes, double quotes and backslash escapes, but performs no
+// expansion of any kind.
+func SplitCommand(command string) ([]string, error) {
+	var (
+		args     []string
+		current  strings.Builder
+		inWord   bool
+		inSingle bool
+		inDouble bool
+		escaped  bool
+	)
+	for _, r := range command {
+		switch {
+		case escaped:
+			if inDouble && !strings.ContainsRune("\"\\$`", r) {
+				current.WriteRune('\\')
+			}
+			current.WriteRune(r)
+			escaped = false
+		case inSingle:
+			if r == '\'' {
+				inSingle = false
+				continue
+			}
+			current.WriteRune(r)
+		case inDouble:
+			switch r {
+			case '"':
+				inDouble = false
+			case '\\':
+				escaped = true
+			default:
+				current.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inWord = true
+		case r == '\'':
+			inSingle = true
+			inWord = true
+		case r == '"':
+			inDouble = true
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inWord {
+				args = append(args, current.String())
+				current.Reset()
+				inWord = false
+			}
+		case strings.ContainsRune(shellMetaChars, r):
+			return nil, fmt.Errorf("unquoted shell metacharacter %q in command (use exec: shell for shell syntax)", r)
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+	if escaped {
+		return nil, fmt.Errorf("command ends with a dangling escape")
+	}
+	if inSingle || inDouble {
+		return nil, fmt.Errorf("command has an unterminated quote")
+	}
+	if inWord {
+		args = append(args, current.String())
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("command is empty")
+	}
+	return args, nil
+}
@@ -5,70 +5,446 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 const (
-	expectedType    = "shai-sandbox"
-	expectedVersion = 1
+	expectedType = "shai-sandbox"
+	// CurrentVersion is the config format version Load produces. Older
+	// documents are upgraded when they are parsed; see Migrate.
+	CurrentVersion = 2
 )
 
 // Config represents the parsed .shai/config.yaml configuration.
 type Config struct {
-	Type      string                  `yaml:"type"`
-	Version   int                     `yaml:"version"`
-	Image     string                  `yaml:"image"`
-	User      string                  `yaml:"user"`
-	Workspace string                  `yaml:"workspace"`
-	Resources map[string]*ResourceSet `yaml:"resources"`
-	Apply     []ApplyRule             `yaml:"apply"`
+	// Include lists configs merged underneath this one, in order: paths
+	// relative to this file, or embedded configs such as "shai:default".
+	Include   []string                `yaml:"include,omitempty"`
+	Type      string                  `yaml:"type,omitempty"`
+	Version   int                     `yaml:"version,omitempty"`
+	Image     string                  `yaml:"image,omitempty"`
+	User      string                  `yaml:"user,omitempty"`
+	Workspace string                  `yaml:"workspace,omitempty"`
+	Resources map[string]*ResourceSet `yaml:"resources,omitempty"`
+	Apply     []ApplyRule             `yaml:"apply,omitempty"`
+	// MCPClients lists agents whose in-container config should register the
+	// host calls as a stdio MCP server.
+	MCPClients []string `yaml:"mcp-clients,omitempty"`
 
 	sourcePath string
 	sourceDir  string
+	layer      string
+	includePos []Source
+	fieldPos   map[string]Source
+	files      []sourceFile
+	unknown    []error
 	resolved   []pathResources
+	warnings   []string
+	// disabled holds the resource sets dropped by their if: expression.
+	disabled map[string]bool
 }
 
 // ResourceSet groups runtime resources (env vars, mounts, calls).
 type ResourceSet struct {
-	Vars         []VarMapping    `yaml:"vars"`
-	Mounts       []Mount         `yaml:"mounts"`
-	Calls        []Call          `yaml:"calls"`
-	HTTP         []string        `yaml:"http"`
-	Ports        []Port          `yaml:"ports"`
-	Expose       []ExposedPort   `yaml:"expose"`
-	RootCommands []string        `yaml:"root-commands"`
-	Options      ResourceOptions `yaml:"options"`
+	// If is evaluated at load time; when false the set is dropped, and
+	// apply rules and extends that name it skip it.
+	If string `yaml:"if,omitempty"`
+	// Extends names resource sets whose vars, mounts, calls and http rules
+	// this set inherits; Remove drops inherited entries.
+	Extends      StringList       `yaml:"extends,omitempty"`
+	Remove       ResourceRemovals `yaml:"remove,omitempty"`
+	Vars         []VarMapping     `yaml:"vars,omitempty"`
+	Mounts       MountList        `yaml:"mounts,omitempty"`
+	Calls        CallList         `yaml:"calls,omitempty"`
+	HTTP         []HTTPRule       `yaml:"http,omitempty"`
+	Expose       []ExposedPort    `yaml:"expose,omitempty"`
+	RootCommands []string         `yaml:"root-commands,omitempty"`
+	Options      ResourceOptions  `yaml:"options,omitempty"`
+
+	source     Source
+	extendsPos Source
+}
+
+// Hosts lists the hosts the set's http rules allow through the proxy,
+// leaving out rules that only open ports.
+func (r *ResourceSet) Hosts() []string {
+	hosts := make([]string, 0, len(r.HTTP))
+	for _, rule := range r.HTTP {
+		if rule.AllowsHTTP() {
+			hosts = append(hosts, rule.Host)
+		}
+	}
+	return hosts
+}
+
+// Ports lists the direct TCP connections the set's http rules allow.
+func (r *ResourceSet) Ports() []Port {
+	var ports []Port
+	for _, rule := range r.HTTP {
+		for _, port := range rule.Ports {
+			ports = append(ports, Port{Host: rule.Host, Port: port})
+		}
+	}
+	return ports
 }
 
 // ResourceOptions contains optional resource set configuration.
 type ResourceOptions struct {
-	Privileged bool `yaml:"privileged"`
+	Privileged bool `yaml:"privileged,omitempty"`
 }
 
-// VarMapping defines a host->container variable mapping.
+// VarMapping defines a host->container variable mapping. The value comes
+// from the host env var Source, or from one of the secret sources File,
+// Command or Keyring, which are resolved when the sandbox starts.
 type VarMapping struct {
-	Source string `yaml:"source"`
-	Target string `yaml:"target"`
+	Source  string `yaml:"source,omitempty"`
+	Target  string `yaml:"target,omitempty"`
+	File    string `yaml:"file,omitempty"`
+	Command string `yaml:"command,omitempty"`
+	Keyring string `yaml:"keyring,omitempty"`
+	// As delivers the value as an env var, the default, or as a file
+	// named Target in SecretsDir.
+	As string `yaml:"as,omitempty"`
+}
+
+// Secret sources, named by the VarMapping field that selects them.
+const (
+	SecretFile    = "file"
+	SecretCommand = "command"
+	SecretKeyring = "keyring"
+)
+
+// Var delivery modes.
+const (
+	DeliverEnv  = "env"
+	DeliverFile = "file"
+)
+
+// SecretsDir is the tmpfs in the container that holds vars delivered as
+// files.
+const SecretsDir = "/run/shai/secrets"
+
+// Name returns the variable's name in the container.
+func (v VarMapping) Name() string {
+	if target := strings.TrimSpace(v.Target); target != "" {
+		return target
+	}
+	return strings.TrimSpace(v.Source)
+}
+
+// Secret returns the secret source and reference the value is read from.
+// ok is false for vars copied from the host env.
+func (v VarMapping) Secret() (source, ref string, ok bool) {
+	switch {
+	case strings.TrimSpace(v.File) != "":
+		return SecretFile, strings.TrimSpace(v.File), true
+	case strings.TrimSpace(v.Command) != "":
+		return SecretCommand, strings.TrimSpace(v.Command), true
+	case strings.TrimSpace(v.Keyring) != "":
+		return SecretKeyring, strings.TrimSpace(v.Keyring), true
+	}
+	return "", "", false
 }
 
-// Mount describes a host mount.
+// Origin describes where the value comes from: the host env var name, or
+// the secret source and reference, as in "keyring:npm/token".
+func (v VarMapping) Origin() string {
+	if source, ref, ok := v.Secret(); ok {
+		return source + ":" + ref
+	}
+	return strings.TrimSpace(v.Source)
+}
+
+// Hidden reports whether the value must stay out of the bootstrap argv:
+// vars read from a secret source or delivered as files.
+func (v VarMapping) Hidden() bool {
+	_, _, secret := v.Secret()
+	return secret || v.As == DeliverFile
+}
+
+// Mount describes a host mount. Name is its key in the set's mounts.
 type Mount struct {
-	Source string `yaml:"source"`
-	Target string `yaml:"target"`
-	Mode   string `yaml:"mode"`
+	Name   string `yaml:"-"`
+	Source string `yaml:"source,omitempty"`
+	Target string `yaml:"target,omitempty"`
+	Mode   string `yaml:"mode,omitempty"`
+	// If is evaluated at load time; when false the mount is dropped.
+	If string `yaml:"if,omitempty"`
+
+	source Source
 }
 
-// Call exposes a host command inside the container.
+// MountList is written as a mapping from mount name to mount, in order.
+type MountList []Mount
+
+// UnmarshalYAML implements custom unmarshaling from the name-keyed form.
+func (l *MountList) UnmarshalYAML(node *yaml.Node) error {
+	return decodeNamed(node, "mounts", l, func(name string, m *Mount) error {
+		m.Name = name
+		return nil
+	})
+}
+
+// MarshalYAML implements custom marshaling to the name-keyed form.
+func (l MountList) MarshalYAML() (any, error) {
+	return encodeNamed(l, func(m Mount) (string, any) { return m.Name, m })
+}
+
+// HTTPRule allows a host through the HTTP proxy and, when Ports is set,
+// direct TCP connections to those ports. Proxy set to false opens only the
+// ports, as a version 1 ports entry did. A plain string is shorthand for a
+// rule with only a host.
+type HTTPRule struct {
+	Host  string `yaml:"host,omitempty"`
+	Ports []int  `yaml:"ports,omitempty,flow"`
+	Proxy *bool  `yaml:"proxy,omitempty"`
+
+	source Source
+}
+
+// AllowsHTTP reports whether the rule lets HTTP and HTTPS to its host
+// through the proxy.
+func (r HTTPRule) AllowsHTTP() bool {
+	return r.Proxy == nil || *r.Proxy
+}
+
+// UnmarshalYAML implements custom unmarshaling to support scalar and mapping forms.
+func (r *HTTPRule) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&r.Host)
+	}
+	type rawHTTPRule HTTPRule
+	var raw rawHTTPRule
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+	*r = HTTPRule(raw)
+	return nil
+}
+
+// MarshalYAML writes rules with only a host in the scalar form.
+func (r HTTPRule) MarshalYAML() (any, error) {
+	if len(r.Ports) == 0 && r.Proxy == nil {
+		return r.Host, nil
+	}
+	type rawHTTPRule HTTPRule
+	return rawHTTPRule(r), nil
+}
+
+// Call execution modes.
+const (
+	// ExecArgv runs the tokenized command directly, appending each container
+	// argument as a discrete argv element.
+	ExecArgv = "argv"
+	// ExecShell runs the command and joined arguments through a login shell.
+	ExecShell = "shell"
+)
+
+// Call exposes a host command inside the container. Name is its key in the
+// set's calls; a name in the body is allowed but must match.
 type Call struct {
-	Name        string `yaml:"name"`
-	Description string `yaml:"description"`
-	Command     string `yaml:"command"`
-	AllowedArgs string `yaml:"allowed-args"`
+	Name        string      `yaml:"name,omitempty"`
+	Description string      `yaml:"description,omitempty"`
+	Command     string      `yaml:"command,omitempty"`
+	AllowedArgs string      `yaml:"allowed-args,omitempty"`
+	Exec        string      `yaml:"exec,omitempty"`
+	Params      []CallParam `yaml:"params,omitempty"`
+	// Inputs are files uploaded from the sandbox before the call runs;
+	// Outputs are files the call writes and sends back.
+	Inputs  []CallFile `yaml:"inputs,omitempty"`
+	Outputs []CallFile `yaml:"outputs,omitempty"`
+	// MaxOutput caps combined stdout/stderr bytes returned to the
+	// container. Zero uses the server default; -1 disables the cap.
+	MaxOutput int `yaml:"max-output,omitempty"`
+	// Timeout bounds a single execution (Go duration, e.g. "30s").
+	Timeout string `yaml:"timeout,omitempty"`
+	// MaxConcurrent limits simultaneous executions of this call.
+	MaxConcurrent int `yaml:"max-concurrent,omitempty"`
+	// Queue selects what happens when no execution slot is free: reject
+	// (default) fails immediately, wait blocks until one frees up.
+	Queue string `yaml:"queue,omitempty"`
+	// Rate limits how many executions may start per minute.
+	Rate int `yaml:"rate,omitempty"`
+	// Approval requires a human on the host to confirm the call: never
+	// (default), once per session, or always.
+	Approval string `yaml:"approval,omitempty"`
+	// Cwd is the command's working directory, relative to the workspace
+	// root. Empty runs it in the workspace root.
+	Cwd string `yaml:"cwd,omitempty"`
+	// Env sets variables for the command, overriding inherited ones.
+	Env map[string]string `yaml:"env,omitempty"`
+	// InheritEnv passes the host environment of shai to the command
+	// (default true). When false only variables named in EnvAllow are
+	// passed; a trailing * matches a prefix.
+	InheritEnv *bool    `yaml:"inherit-env,omitempty"`
+	EnvAllow   []string `yaml:"env-allow,omitempty"`
 
 	allowedRx *regexp.Regexp
+	argv      []string
+	timeout   time.Duration
+}
+
+// CallList is written as a mapping from call name to call, in order.
+type CallList []Call
+
+// UnmarshalYAML implements custom unmarshaling from the name-keyed form.
+func (l *CallList) UnmarshalYAML(node *yaml.Node) error {
+	return decodeNamed(node, "calls", l, func(name string, c *Call) error {
+		if c.Name != "" && c.Name != name {
+			return fmt.Errorf("call %q has name %q; the name must match its key", name, c.Name)
+		}
+		c.Name = name
+		return nil
+	})
+}
+
+// MarshalYAML implements custom marshaling to the name-keyed form.
+func (l CallList) MarshalYAML() (any, error) {
+	return encodeNamed(l, func(c Call) (string, any) {
+		name := c.Name
+		c.Name = ""
+		return name, c
+	})
+}
+
+// decodeNamed decodes a mapping of name to item into a list, keeping the
+// mapping's order.
+func decodeNamed[T any, L ~[]T](node *yaml.Node, kind string, list *L, setName func(string, *T) error) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: %s must be a mapping from name to entry", node.Line, kind)
+	}
+	out := make(L, 0, len(node.Content)/2)
+	seen := make(map[string]bool, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name := node.Content[i].Value
+		if seen[name] {
+			return fmt.Errorf("line %d: duplicate %s entry %q", node.Content[i].Line, kind, name)
+		}
+		seen[name] = true
+		var item T
+		if err := node.Content[i+1].Decode(&item); err != nil {
+			return err
+		}
+		if err := setName(name, &item); err != nil {
+			return fmt.Errorf("line %d: %w", node.Content[i].Line, err)
+		}
+		out = append(out, item)
+	}
+	*list = out
+	return nil
+}
+
+// encodeNamed writes a list as a mapping. split returns each item's name
+// and the value to write under it.
+func encodeNamed[T any](list []T, split func(T) (string, any)) (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, item := range list {
+		name, body := split(item)
+		var value yaml.Node
+		if err := value.Encode(body); err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}, &value)
+	}
+	return node, nil
+}
+
+// Call queue modes.
+const (
+	QueueReject = "reject"
+	QueueWait   = "wait"
+)
+
+// Call approval modes.
+const (
+	ApprovalNever  = "never"
+	ApprovalOnce   = "once"
+	ApprovalAlways = "always"
+)
+
+// InheritsEnv reports whether the call receives the host environment.
+func (c Call) InheritsEnv() bool {
+	return c.InheritEnv == nil || *c.InheritEnv
+}
+
+// TimeoutDuration returns the parsed timeout, or zero when unset.
+func (c Call) TimeoutDuration() time.Duration {
+	if c.timeout > 0 {
+		return c.timeout
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(c.Timeout))
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// Call parameter types.
+const (
+	ParamString = "string"
+	ParamInt    = "int"
+	ParamBool   = "bool"
+	ParamEnum   = "enum"
+)
+
+var paramNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+var (
+	envNameRe    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	envPatternRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*\*?$`)
+)
+
+// CallParam declares a typed, named argument accepted by a call.
+type CallParam struct {
+	Name        string     `yaml:"name,omitempty"`
+	Type        string     `yaml:"type,omitempty"`
+	Description string     `yaml:"description,omitempty"`
+	Required    bool       `yaml:"required,omitempty"`
+	Pattern     string     `yaml:"pattern,omitempty"`
+	Values      []string   `yaml:"values,omitempty"`
+	Arg         StringList `yaml:"arg,omitempty"`
+}
+
+// CallFile declares a file passed to or returned from a call. The command
+// receives the file's host path through Arg.
+type CallFile struct {
+	Name        string `yaml:"name,omitempty"`
+	Description string `yaml:"description,omitempty"`
+	// Filename names the file in the per-call host directory; it defaults
+	// to Name.
+	Filename string `yaml:"filename,omitempty"`
+	Required bool   `yaml:"required,omitempty"`
+	// MaxSize caps the file in bytes; zero uses the 10 MiB default.
+	MaxSize int64      `yaml:"max-size,omitempty"`
+	Arg     StringList `yaml:"arg,omitempty"`
+}
+
+// StringList accepts either a single string or a list of strings.
+type StringList []string
+
+// UnmarshalYAML implements custom unmarshaling to support scalar and sequence forms.
+func (l *StringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		var single string
+		if err := node.Decode(&single); err != nil {
+			return err
+		}
+		*l = StringList{single}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
 }
 
 // AllowedArgsRegexp returns the compiled regex for additional arguments (may be nil).
@@ -76,10 +452,27 @@ func (c Call) AllowedArgsRegexp() *regexp.Regexp {
 	return c.allowedRx
 }
 
+// ExecMode returns the normalized execution mode, defaulting to argv.
+func (c Call) ExecMode() string {
+	mode := strings.ToLower(strings.TrimSpace(c.Exec))
+	if mode == "" {
+		return ExecArgv
+	}
+	return mode
+}
+
+// Argv returns the tokenized command for argv mode calls.
+func (c Call) Argv() ([]string, error) {
+	if c.argv != nil {
+		return c.argv, nil
+	}
+	return SplitCommand(c.Command)
+}
+
 // Port identifies an allow-listed network endpoint.
 type Port struct {
-	Host string `yaml:"host"`
-	Port int    `yaml:"port"`
+	Host string `yaml:"host,omitempty"`
+	Port int    `yaml:"port,omitempty"`
 }
 
 // ExposedPort defines a port mapping from host to container.
@@ -87,9 +480,9 @@ type Port struct {
 //   - Simple: 8000 (maps host:8000 to container:8000, protocol tcp)
 //   - Object: {host: 8080, container: 3000, protocol: "udp"}
 type ExposedPort struct {
-	Host      int    `yaml:"host"`
-	Container int    `yaml:"container"`
-	Protocol  string `yaml:"protocol"`
+	Host      int    `yaml:"host,omitempty"`
+	Container int    `yaml:"container,omitempty"`
+	Protocol  string `yaml:"protocol,omitempty"`
 }
 
 // UnmarshalYAML implements custom unmarshaling to support both int and object formats.
@@ -109,9 +502,9 @@ func (e *ExposedPort) UnmarshalYAML(node *yaml.Node) error {
 	// Try object format
 	if node.Kind == yaml.MappingNode {
 		type rawExposedPort struct {
-			Host      int    `yaml:"host"`
-			Container int    `yaml:"container"`
-			Protocol  string `yaml:"protocol"`
+			Host      int    `yaml:"host,omitempty"`
+			Container int    `yaml:"container,omitempty"`
+			Protocol  string `yaml:"protocol,omitempty"`
 		}
 		var raw rawExposedPort
 		if err := node.Decode(&raw); err != nil {
@@ -134,17 +527,28 @@ func (e *ExposedPort) UnmarshalYAML(node *yaml.Node) error {
 	return fmt.Errorf("port must be an integer or object, got %v", node.Kind)
 }
 
-// ApplyRule maps a workspace path to resource set names.
+// ApplyRule maps a workspace path to resource set names. Path may be a
+// glob such as services/* or **/frontend, or a negated pattern such as
+// !infra/**. ExcludeResources drops sets that less specific rules give the
+// same paths.
 type ApplyRule struct {
-	Path      string   `yaml:"path"`
-	Resources []string `yaml:"resources"`
-	Image     string   `yaml:"image"`
+	Path             string   `yaml:"path,omitempty"`
+	Resources        []string `yaml:"resources,omitempty"`
+	ExcludeResources []string `yaml:"exclude-resources,omitempty"`
+	Image            string   `yaml:"image,omitempty"`
+	// If is evaluated at load time; when false the rule is dropped.
+	If string `yaml:"if,omitempty"`
+
+	source Source
 }
 
 type pathResources struct {
 	Path      string
 	Resources []*ResolvedResource
+	Exclude   []string
 	Image     string
+	pattern   pathPattern
+	source    Source
 }
 
 // ResolvedResource couples a resource set with its name.
@@ -155,175 +559,644 @@ type ResolvedResource struct {
 
 // Load parses and validates a .shai/config.yaml file with template expansion.
 func Load(path string, env map[string]string, vars map[string]string) (*Config, error) {
-	if env == nil {
-		env = map[string]string{}
-	}
-	if vars == nil {
-		vars = map[string]string{}
-	}
-
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("read shai config: %w", err)
 	}
-	return loadFromData(data, path, env, vars)
+	return loadFromFiles([]configFile{{layer: LayerWorkspace, name: path, data: data}}, env, vars)
 }
 
 func (c *Config) applyTemplates(env, vars, conf map[string]string) error {
 	var err error
 	c.Image, err = expandTemplates(c.Image, env, vars, conf)
 	if err != nil {
-		return fmt.Errorf("image: %w", err)
+		return c.fieldPos["image"].errorf("image: %v", err)
 	}
 	c.User, err = expandTemplates(c.User, env, vars, conf)
 	if err != nil {
-		return fmt.Errorf("user: %w", err)
+		return c.fieldPos["user"].errorf("user: %v", err)
 	}
 	c.Workspace, err = expandTemplates(c.Workspace, env, vars, conf)
 	if err != nil {
-		return fmt.Errorf("workspace: %w", err)
+		return c.fieldPos["workspace"].errorf("workspace: %v", err)
 	}
 
 	for name, res := range c.Resources {
 		for i := range res.Vars {
 			res.Vars[i].Source, err = expandTemplates(res.Vars[i].Source, env, vars, conf)
 			if err != nil {
-				return fmt.Errorf("resource %s var[%d] source: %w", name, i, err)
+				return res.source.errorf("resource %s var[%d] source: %v", name, i, err)
 			}
 			res.Vars[i].Target, err = expandTemplates(res.Vars[i].Target, env, vars, conf)
 			if err != nil {
-				return fmt.Errorf("resource %s var[%d] target: %w", name, i, err)
+				return res.source.errorf("resource %s var[%d] target: %v", name, i, err)
+			}
+			res.Vars[i].File, err = expandTemplates(res.Vars[i].File, env, vars, conf)
+			if err != nil {
+				return res.source.errorf("resource %s var[%d] file: %v", name, i, err)
+			}
+			res.Vars[i].Command, err = expandTemplates(res.Vars[i].Command, env, vars, conf)
+			if err != nil {
+				return res.source.errorf("resource %s var[%d] command: %v", name, i, err)
+			}
+			res.Vars[i].Keyring, err = expandTemplates(res.Vars[i].Keyring, env, vars, conf)
+			if err != nil {
+				return res.source.errorf("resource %s var[%d] keyring: %v", name, i, err)
 			}
 		}
 		for i := range res.Mounts {
 			res.Mounts[i].Source, err = expandTemplates(res.Mounts[i].Source, env, vars, conf)
 			if err != nil {
-				return fmt.Errorf("resource %s mount[%d] source: %w", name, i, err)
+				return res.Mounts[i].source.errorf("resource %s mount[%d] source: %v", name, i, err)
 			}
 			res.Mounts[i].Target, err = expandTemplates(res.Mounts[i].Target, env, vars, conf)
 			if err != nil {
-				return fmt.Errorf("resource %s mount[%d] target: %w", name, i, err)
+				return res.Mounts[i].source.errorf("resource %s mount[%d] target: %v", name, i, err)
 			}
 		}
 		for i := range res.Calls {
 			res.Calls[i].Description, err = expandTemplates(res.Calls[i].Description, env, vars, conf)
 			if err != nil {
-				return fmt.Errorf("resource %s call[%d] description: %w", name, i, err)
+				return res.source.errorf("resource %s call[%d] description: %v", name, i, err)
 			}
 			res.Calls[i].Command, err = expandTemplates(res.Calls[i].Command, env, vars, conf)
 			if err != nil {
-				return fmt.Errorf("resource %s call[%d] command: %w", name, i, err)
+				return res.source.errorf("resource %s call[%d] command: %v", name, i, err)
 			}
-		}
-		for i := range res.HTTP {
-			res.HTTP[i], err = expandTemplates(res.HTTP[i], env, vars, conf)
+			for j := range res.Calls[i].Params {
+				param := &res.Calls[i].Params[j]
+				param.Description, err = expandTemplates(param.Description, env, vars, conf)
+				if err != nil {
+					return res.source.errorf("resource %s call[%d] params[%d] description: %v", name, i, j, err)
+				}
+				param.Pattern, err = expandTemplates(param.Pattern, env, vars, conf)
+				if err != nil {
+					return res.source.errorf("resource %s call[%d] params[%d] pattern: %v", name, i, j, err)
+				}
+				for k := range param.Values {
+					param.Values[k], err = expandTemplates(param.Values[k], env, vars, conf)
+					if err != nil {
+						return res.source.errorf("resource %s call[%d] params[%d] values[%d]: %v", name, i, j, k, err)
+					}
+				}
+				for k := range param.Arg {
+					param.Arg[k], err = expandTemplates(param.Arg[k], env, vars, conf)
+					if err != nil {
+						return res.source.errorf("resource %s call[%d] params[%d] arg[%d]: %v", name, i, j, k, err)
+					}
+				}
+			}
+			res.Calls[i].Cwd, err = expandTemplates(res.Calls[i].Cwd, env, vars, conf)
 			if err != nil {
-				return fmt.Errorf("resource %s http[%d]: %w", name, i, err)
+				return res.source.errorf("resource %s call[%d] cwd: %v", name, i, err)
+			}
+			for key, value := range res.Calls[i].Env {
+				res.Calls[i].Env[key], err = expandTemplates(value, env, vars, conf)
+				if err != nil {
+					return res.source.errorf("resource %s call[%d] env %s: %v", name, i, key, err)
+				}
+			}
+			if err := expandCallFiles(res.Calls[i].Inputs, env, vars, conf); err != nil {
+				return res.source.errorf("resource %s call[%d] inputs%v", name, i, err)
+			}
+			if err := expandCallFiles(res.Calls[i].Outputs, env, vars, conf); err != nil {
+				return res.source.errorf("resource %s call[%d] outputs%v", name, i, err)
 			}
 		}
-		for i := range res.Ports {
-			res.Ports[i].Host, err = expandTemplates(res.Ports[i].Host, env, vars, conf)
+		for i := range res.HTTP {
+			res.HTTP[i].Host, err = expandTemplates(res.HTTP[i].Host, env, vars, conf)
 			if err != nil {
-				return fmt.Errorf("resource %s port[%d] host: %w", name, i, err)
+				return res.HTTP[i].source.errorf("resource %s http[%d]: %v", name, i, err)
 			}
 		}
 		for i := range res.RootCommands {
 			res.RootCommands[i], err = expandTemplates(res.RootCommands[i], env, vars, conf)
 			if err != nil {
-				return fmt.Errorf("resource %s root-commands[%d]: %w", name, i, err)
+				return res.source.errorf("resource %s root-commands[%d]: %v", name, i, err)
 			}
 		}
 	}
 	for i := range c.Apply {
 		c.Apply[i].Path, err = expandTemplates(c.Apply[i].Path, env, vars, conf)
 		if err != nil {
-			return fmt.Errorf("apply[%d] path: %w", i, err)
+			return c.Apply[i].source.errorf("apply[%d] path: %v", i, err)
 		}
 		c.Apply[i].Image, err = expandTemplates(c.Apply[i].Image, env, vars, conf)
 		if err != nil {
-			return fmt.Errorf("apply[%d] image: %w", i, err)
+			return c.Apply[i].source.errorf("apply[%d] image: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// applyConditions drops the resource sets, mounts and apply rules whose if:
+// expression is false. Every failing expression is reported.
+func (c *Config) applyConditions(env, vars, conf map[string]string) error {
+	var problems []error
+	check := func(expr string, at Source, what string) bool {
+		if strings.TrimSpace(expr) == "" {
+			return true
+		}
+		ok, err := evalCondition(expr, env, vars, conf)
+		if err != nil {
+			problems = append(problems, at.errorf("%s if: %v", what, err))
+		}
+		return ok
+	}
+
+	resources := make(map[string]*ResourceSet, len(c.Resources))
+	c.disabled = map[string]bool{}
+	for name, set := range c.Resources {
+		if set == nil {
+			resources[name] = set
+			continue
+		}
+		if !check(set.If, set.source, "resource set "+name) {
+			c.disabled[name] = true
+			continue
+		}
+		kept := *set
+		kept.Mounts = nil
+		for _, mount := range set.Mounts {
+			if check(mount.If, mount.source, fmt.Sprintf("resource %s mount %s", name, mount.Name)) {
+				kept.Mounts = append(kept.Mounts, mount)
+			}
+		}
+		resources[name] = &kept
+	}
+	c.Resources = resources
+
+	var apply []ApplyRule
+	for i, rule := range c.Apply {
+		if check(rule.If, rule.source, fmt.Sprintf("apply[%d]", i)) {
+			apply = append(apply, rule)
+		}
+	}
+	c.Apply = apply
+	return newValidationError(problems)
+}
+
+func expandCallFiles(files []CallFile, env, vars, conf map[string]string) error {
+	var err error
+	for j := range files {
+		files[j].Description, err = expandTemplates(files[j].Description, env, vars, conf)
+		if err != nil {
+			return fmt.Errorf("[%d] description: %w", j, err)
+		}
+		for k := range files[j].Arg {
+			files[j].Arg[k], err = expandTemplates(files[j].Arg[k], env, vars, conf)
+			if err != nil {
+				return fmt.Errorf("[%d] arg[%d]: %w", j, k, err)
+			}
 		}
 	}
 	return nil
 }
 
+// ValidationError lists every problem found in a config so they can be
+// fixed in one pass.
+type ValidationError struct {
+	Problems []error
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return e.Problems[0].Error()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d problems in shai config:", len(e.Problems))
+	for _, problem := range e.Problems {
+		b.WriteString("\n  - ")
+		b.WriteString(problem.Error())
+	}
+	return b.String()
+}
+
+func (e *ValidationError) Unwrap() []error {
+	return e.Problems
+}
+
+// newValidationError returns nil when there are no problems.
+func newValidationError(problems []error) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: problems}
+}
+
+// problemsOf splits err into the problems it reports.
+func problemsOf(err error) []error {
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		return verr.Problems
+	}
+	return []error{err}
+}
+
+// validate checks the merged config and normalizes modes and names. It
+// reports every problem it finds rather than stopping at the first.
 func (c *Config) validate() error {
+	var problems []error
 	if c.Type != expectedType {
-		return fmt.Errorf("unsupported config type %q (expected %q)", c.Type, expectedType)
+		problems = append(problems, fmt.Errorf("unsupported config type %q (expected %q)", c.Type, expectedType))
 	}
-	if c.Version != expectedVersion {
-		return fmt.Errorf("unsupported config version %d (expected %d)", c.Version, expectedVersion)
+	if c.Version != CurrentVersion {
+		problems = append(problems, fmt.Errorf("unsupported config version %d (expected %d)", c.Version, CurrentVersion))
 	}
 	if strings.TrimSpace(c.Image) == "" {
-		return errors.New("image is required")
+		problems = append(problems, errors.New("image is required"))
 	}
 	// User and workspace now have defaults, so they're not required in config
 	if len(c.Resources) == 0 {
-		return errors.New("resources section is required")
+		problems = append(problems, errors.New("resources section is required"))
 	}
-	for name, res := range c.Resources {
-		for i := range res.Mounts {
-			mode := strings.ToLower(strings.TrimSpace(res.Mounts[i].Mode))
-			if mode == "" {
-				mode = "ro"
-			}
-			if mode != "ro" && mode != "rw" {
-				return fmt.Errorf("resource %s mount[%d] has invalid mode %q", name, i, res.Mounts[i].Mode)
-			}
-			res.Mounts[i].Mode = mode
+	names := make([]string, 0, len(c.Resources))
+	for name := range c.Resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		res := c.Resources[name]
+		if res == nil {
+			continue
 		}
-		for i := range res.Calls {
-			if strings.TrimSpace(res.Calls[i].Name) == "" {
-				return fmt.Errorf("resource %s call[%d] missing name", name, i)
+		for _, err := range validateResourceSet(name, res, &c.warnings) {
+			if res.source.File != "" {
+				err = res.source.errorf("%v", err)
 			}
-			if strings.TrimSpace(res.Calls[i].Command) == "" {
-				return fmt.Errorf("resource %s call[%d] missing command", name, i)
+			problems = append(problems, err)
+		}
+	}
+	if len(c.Apply) == 0 {
+		problems = append(problems, errors.New("apply rules are required"))
+	}
+	for i, client := range c.MCPClients {
+		client = strings.ToLower(strings.TrimSpace(client))
+		if !isMCPClient(client) {
+			problems = append(problems, fmt.Errorf("mcp-clients[%d] has unsupported client %q (must be one of %s)", i, c.MCPClients[i], strings.Join(MCPClientNames, ", ")))
+			continue
+		}
+		c.MCPClients[i] = client
+	}
+	return newValidationError(problems)
+}
+
+func validateResourceSet(name string, res *ResourceSet, warnings *[]string) []error {
+	var problems []error
+	for i := range res.Vars {
+		if err := validateVar(&res.Vars[i]); err != nil {
+			problems = append(problems, fmt.Errorf("resource %s var[%d] %w", name, i, err))
+		}
+	}
+	for i := range res.Mounts {
+		mode := strings.ToLower(strings.TrimSpace(res.Mounts[i].Mode))
+		if mode == "" {
+			mode = "ro"
+		}
+		if mode != "ro" && mode != "rw" {
+			problems = append(problems, fmt.Errorf("resource %s mount[%d] has invalid mode %q", name, i, res.Mounts[i].Mode))
+			continue
+		}
+		res.Mounts[i].Mode = mode
+	}
+	for i := range res.Calls {
+		call := &res.Calls[i]
+		if strings.TrimSpace(call.Name) == "" {
+			problems = append(problems, fmt.Errorf("resource %s call[%d] missing name", name, i))
+			continue
+		}
+		if strings.TrimSpace(call.Command) == "" {
+			problems = append(problems, fmt.Errorf("resource %s call[%d] missing command", name, i))
+			continue
+		}
+		if err := validateCall(name, call, warnings); err != nil {
+			problems = append(problems, fmt.Errorf("resource %s call[%s] %w", name, call.Name, err))
+		}
+	}
+	for i, rule := range res.HTTP {
+		if strings.TrimSpace(rule.Host) == "" {
+			problems = append(problems, fmt.Errorf("resource %s http[%d] missing host", name, i))
+		}
+		for _, port := range rule.Ports {
+			if port < 1 || port > 65535 {
+				problems = append(problems, fmt.Errorf("resource %s http[%d] has invalid port %d (must be 1-65535)", name, i, port))
 			}
-			if res.Calls[i].AllowedArgs != "" {
-				rx, err := regexp.Compile(res.Calls[i].AllowedArgs)
-				if err != nil {
-					return fmt.Errorf("resource %s call[%s] invalid allowed-args regex: %w", name, res.Calls[i].Name, err)
+		}
+		if !rule.AllowsHTTP() && len(rule.Ports) == 0 {
+			problems = append(problems, fmt.Errorf("resource %s http[%d] has proxy: false and no ports, so it allows nothing", name, i))
+		}
+	}
+	// Track seen host ports within this resource (keyed by host:protocol)
+	seenPorts := make(map[string]int)
+	for i, exp := range res.Expose {
+		if exp.Host < 1 || exp.Host > 65535 {
+			problems = append(problems, fmt.Errorf("resource %s expose[%d] has invalid host port %d (must be 1-65535)", name, i, exp.Host))
+			continue
+		}
+		if exp.Container < 1 || exp.Container > 65535 {
+			problems = append(problems, fmt.Errorf("resource %s expose[%d] has invalid container port %d (must be 1-65535)", name, i, exp.Container))
+			continue
+		}
+		protocol := strings.ToLower(strings.TrimSpace(exp.Protocol))
+		if protocol != "tcp" && protocol != "udp" {
+			problems = append(problems, fmt.Errorf("resource %s expose[%d] has invalid protocol %q (must be tcp or udp)", name, i, exp.Protocol))
+			continue
+		}
+		res.Expose[i].Protocol = protocol
+		// Check for duplicate host port within this resource
+		portKey := fmt.Sprintf("%d/%s", exp.Host, protocol)
+		if prevIdx, exists := seenPorts[portKey]; exists {
+			problems = append(problems, fmt.Errorf("resource %s has duplicate host port %d/%s (expose[%d] and expose[%d])", name, exp.Host, protocol, prevIdx, i))
+			continue
+		}
+		seenPorts[portKey] = i
+	}
+	return problems
+}
+
+// validateVar checks that a var has exactly one source, and that vars kept
+// out of the bootstrap argv have a name usable as a file name.
+func validateVar(v *VarMapping) error {
+	var sources []string
+	for _, field := range []struct{ name, value string }{
+		{"source", v.Source},
+		{SecretFile, v.File},
+		{SecretCommand, v.Command},
+		{SecretKeyring, v.Keyring},
+	} {
+		if strings.TrimSpace(field.value) != "" {
+			sources = append(sources, field.name)
+		}
+	}
+	switch {
+	case len(sources) == 0:
+		return errors.New("missing source (set one of source, file, command or keyring)")
+	case len(sources) > 1:
+		return fmt.Errorf("sets %s; only one source is allowed", strings.Join(sources, " and "))
+	}
+	v.As = strings.ToLower(strings.TrimSpace(v.As))
+	switch v.As {
+	case "", DeliverEnv, DeliverFile:
+	default:
+		return fmt.Errorf("has invalid as %q (must be env or file)", v.As)
+	}
+	if strings.TrimSpace(v.Source) == "" && strings.TrimSpace(v.Target) == "" {
+		return fmt.Errorf("reads from %s and needs a target", sources[0])
+	}
+	if source, ref, ok := v.Secret(); ok && source == SecretCommand {
+		if _, err := SplitCommand(ref); err != nil {
+			return fmt.Errorf("command: %w", err)
+		}
+	}
+	if v.Hidden() {
+		name := v.Name()
+		if name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+			return fmt.Errorf("target %q is not a valid file name", name)
+		}
+	}
+	return nil
+}
+
+// validateCall checks a named call and normalizes its modes. Errors omit the
+// resource and call name, which the caller adds.
+func validateCall(set string, call *Call, warnings *[]string) error {
+	if call.AllowedArgs != "" {
+		rx, err := regexp.Compile(call.AllowedArgs)
+		if err != nil {
+			return fmt.Errorf("invalid allowed-args regex: %w", err)
+		}
+		call.allowedRx = rx
+	}
+	switch mode := call.ExecMode(); mode {
+	case ExecArgv:
+		argv, err := SplitCommand(call.Command)
+		if err != nil {
+			return fmt.Errorf("command: %w", err)
+		}
+		call.argv = argv
+	case ExecShell:
+		if permissiveShellPattern(call.AllowedArgs) {
+			*warnings = append(*warnings, fmt.Sprintf("resource %s call[%s] uses exec: shell with permissive allowed-args %q; arguments can inject shell syntax on the host", set, call.Name, call.AllowedArgs))
+		}
+	default:
+		return fmt.Errorf("has invalid exec mode %q (must be argv or shell)", call.Exec)
+	}
+	call.Exec = call.ExecMode()
+	if err := validateCallParams(call); err != nil {
+		return err
+	}
+	if err := validateCallFiles(call); err != nil {
+		return err
+	}
+	if err := validateCallEnv(call); err != nil {
+		return err
+	}
+	if call.MaxOutput < -1 {
+		return fmt.Errorf("has invalid max-output %d (must be -1 or greater)", call.MaxOutput)
+	}
+	if err := validateCallLimits(call); err != nil {
+		return err
+	}
+	call.Approval = strings.ToLower(strings.TrimSpace(call.Approval))
+	switch call.Approval {
+	case "":
+		call.Approval = ApprovalNever
+	case ApprovalNever, ApprovalOnce, ApprovalAlways:
+	default:
+		return fmt.Errorf("has invalid approval %q (must be always, once or never)", call.Approval)
+	}
+	return nil
+}
+
+// MCPClientNames lists the agents supported by mcp-clients.
+var MCPClientNames = []string{"claude", "codex", "gemini"}
+
+func isMCPClient(name string) bool {
+	for _, known := range MCPClientNames {
+		if name == known {
+			return true
+		}
+	}
+	return false
+}
+
+func validateCallLimits(call *Call) error {
+	if raw := strings.TrimSpace(call.Timeout); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("has invalid timeout %q (must be a positive duration such as 30s or 5m)", call.Timeout)
+		}
+		call.timeout = d
+	}
+	if call.MaxConcurrent < 0 {
+		return fmt.Errorf("has invalid max-concurrent %d (must be 0 or greater)", call.MaxConcurrent)
+	}
+	if call.Rate < 0 {
+		return fmt.Errorf("has invalid rate %d (must be 0 or greater)", call.Rate)
+	}
+	call.Queue = strings.ToLower(strings.TrimSpace(call.Queue))
+	switch call.Queue {
+	case "":
+		call.Queue = QueueReject
+	case QueueReject, QueueWait:
+	default:
+		return fmt.Errorf("has invalid queue %q (must be reject or wait)", call.Queue)
+	}
+	return nil
+}
+
+func validateCallParams(call *Call) error {
+	if len(call.Params) == 0 {
+		return nil
+	}
+	if call.AllowedArgs != "" {
+		return errors.New("cannot combine params with allowed-args")
+	}
+	seen := make(map[string]bool, len(call.Params))
+	for i := range call.Params {
+		param := &call.Params[i]
+		if !paramNameRe.MatchString(param.Name) {
+			return fmt.Errorf("params[%d] has invalid name %q", i, param.Name)
+		}
+		if param.Name == "args" {
+			return fmt.Errorf("params[%d] name %q is reserved", i, param.Name)
+		}
+		if seen[param.Name] {
+			return fmt.Errorf("has duplicate param %q", param.Name)
+		}
+		seen[param.Name] = true
+		param.Type = strings.ToLower(strings.TrimSpace(param.Type))
+		if param.Type == "" {
+			param.Type = ParamString
+		}
+		switch param.Type {
+		case ParamString:
+			if param.Pattern != "" {
+				if _, err := regexp.Compile(param.Pattern); err != nil {
+					return fmt.Errorf("param %s has invalid pattern: %w", param.Name, err)
 				}
-				res.Calls[i].allowedRx = rx
 			}
+		case ParamEnum:
+			if len(param.Values) == 0 {
+				return fmt.Errorf("param %s of type enum requires values", param.Name)
+			}
+		case ParamInt, ParamBool:
+		default:
+			return fmt.Errorf("param %s has invalid type %q (must be string, int, bool or enum)", param.Name, param.Type)
+		}
+		if param.Pattern != "" && param.Type != ParamString {
+			return fmt.Errorf("param %s: pattern is only valid for string params", param.Name)
 		}
-		// Track seen host ports within this resource (keyed by host:protocol)
-		seenPorts := make(map[string]int)
-		for i, exp := range res.Expose {
-			if exp.Host < 1 || exp.Host > 65535 {
-				return fmt.Errorf("resource %s expose[%d] has invalid host port %d (must be 1-65535)", name, i, exp.Host)
+	}
+	return nil
+}
+
+func validateCallFiles(call *Call) error {
+	if len(call.Inputs) == 0 && len(call.Outputs) == 0 {
+		return nil
+	}
+	if call.AllowedArgs != "" {
+		return errors.New("cannot combine inputs or outputs with allowed-args")
+	}
+	seen := make(map[string]bool, len(call.Params)+len(call.Inputs)+len(call.Outputs))
+	for _, param := range call.Params {
+		seen[param.Name] = true
+	}
+	check := func(kind string, files []CallFile) error {
+		for i, file := range files {
+			if !paramNameRe.MatchString(file.Name) {
+				return fmt.Errorf("%s[%d] has invalid name %q", kind, i, file.Name)
 			}
-			if exp.Container < 1 || exp.Container > 65535 {
-				return fmt.Errorf("resource %s expose[%d] has invalid container port %d (must be 1-65535)", name, i, exp.Container)
+			if file.Name == "args" {
+				return fmt.Errorf("%s[%d] name %q is reserved", kind, i, file.Name)
 			}
-			protocol := strings.ToLower(strings.TrimSpace(exp.Protocol))
-			if protocol != "tcp" && protocol != "udp" {
-				return fmt.Errorf("resource %s expose[%d] has invalid protocol %q (must be tcp or udp)", name, i, exp.Protocol)
+			if seen[file.Name] {
+				return fmt.Errorf("has duplicate param or file %q", file.Name)
 			}
-			res.Expose[i].Protocol = protocol
-			// Check for duplicate host port within this resource
-			portKey := fmt.Sprintf("%d/%s", exp.Host, protocol)
-			if prevIdx, exists := seenPorts[portKey]; exists {
-				return fmt.Errorf("resource %s has duplicate host port %d/%s (expose[%d] and expose[%d])", name, exp.Host, protocol, prevIdx, i)
+			seen[file.Name] = true
+			if file.MaxSize < 0 {
+				return fmt.Errorf("%s %s has invalid max-size %d (must be 0 or greater)", kind, file.Name, file.MaxSize)
 			}
-			seenPorts[portKey] = i
 		}
+		return nil
 	}
-	if len(c.Apply) == 0 {
-		return errors.New("apply rules are required")
+	if err := check("inputs", call.Inputs); err != nil {
+		return err
+	}
+	return check("outputs", call.Outputs)
+}
+
+func validateCallEnv(call *Call) error {
+	if call.Cwd != "" {
+		cwd := filepath.ToSlash(filepath.Clean(call.Cwd))
+		if filepath.IsAbs(call.Cwd) || !filepath.IsLocal(cwd) {
+			return fmt.Errorf("has invalid cwd %q (must be a path inside the workspace)", call.Cwd)
+		}
+		call.Cwd = cwd
+	}
+	for key := range call.Env {
+		if !envNameRe.MatchString(key) {
+			return fmt.Errorf("has invalid env name %q", key)
+		}
+	}
+	if len(call.EnvAllow) > 0 && call.InheritsEnv() {
+		return errors.New("sets env-allow but inherits the full environment (set inherit-env: false)")
+	}
+	for _, name := range call.EnvAllow {
+		if !envPatternRe.MatchString(name) {
+			return fmt.Errorf("has invalid env-allow entry %q (must be a variable name, optionally ending in *)", name)
+		}
 	}
 	return nil
 }
 
+// shellInjectionProbes are argument strings that a shell-mode allowed-args
+// pattern should never accept.
+var shellInjectionProbes = []string{
+	"; id",
+	"&& id",
+	"| id",
+	"$(id)",
+	"`id`",
+	"> /tmp/shai",
+}
+
+func permissiveShellPattern(pattern string) bool {
+	if pattern == "" {
+		return false
+	}
+	rx, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", pattern))
+	if err != nil {
+		return false
+	}
+	for _, probe := range shellInjectionProbes {
+		if rx.MatchString(probe) {
+			return true
+		}
+	}
+	return false
+}
+
+// Warnings returns non-fatal issues detected while validating the config.
+func (c *Config) Warnings() []string {
+	out := make([]string, len(c.warnings))
+	copy(out, c.warnings)
+	return out
+}
+
 func (c *Config) resolvePaths() error {
 	var resolved []pathResources
 	for _, rule := range c.Apply {
-		if len(rule.Resources) == 0 {
+		if len(rule.Resources) == 0 && len(rule.ExcludeResources) == 0 {
 			return fmt.Errorf("apply path %q has no resources", rule.Path)
 		}
-		path := normalizePath(rule.Path)
-		if path == "" {
-			path = "."
+		pattern, err := parsePathPattern(rule.Path)
+		if err != nil {
+			return fmt.Errorf("apply path %q: %w", rule.Path, err)
+		}
+		path := normalizePath(strings.TrimPrefix(strings.TrimSpace(rule.Path), "!"))
+		if pattern.negate {
+			path = "!" + path
 		}
 		image := strings.TrimSpace(rule.Image)
 		if path == "." && image != "" {
@@ -331,24 +1204,38 @@ func (c *Config) resolvePaths() error {
 		}
 		var resList []*ResolvedResource
 		for _, name := range rule.Resources {
+			if c.disabled[name] {
+				continue
+			}
 			res, ok := c.Resources[name]
 			if !ok {
 				return fmt.Errorf("apply path %q references unknown resource %q", rule.Path, name)
 			}
 			resList = append(resList, &ResolvedResource{Name: name, Spec: res})
 		}
-		resolved = append(resolved, pathResources{Path: path, Resources: resList, Image: image})
+		for _, name := range rule.ExcludeResources {
+			if _, ok := c.Resources[name]; !ok && !c.disabled[name] {
+				return fmt.Errorf("apply path %q excludes unknown resource %q", rule.Path, name)
+			}
+		}
+		resolved = append(resolved, pathResources{Path: path, Resources: resList, Exclude: rule.ExcludeResources, Image: image, pattern: pattern, source: rule.source})
 	}
 
-	// Validate call uniqueness per path.
+	// Validate call uniqueness per path. A set and one it extends may both
+	// apply, so identical inherited calls are allowed.
 	for _, pr := range resolved {
 		seen := map[string]string{}
+		calls := map[string]Call{}
 		for _, res := range pr.Resources {
 			for _, call := range res.Spec.Calls {
 				if other, exists := seen[call.Name]; exists {
+					if reflect.DeepEqual(calls[call.Name], call) {
+						continue
+					}
 					return fmt.Errorf("call %q defined in both resources %s and %s for path %s", call.Name, other, res.Name, pr.Path)
 				}
 				seen[call.Name] = res.Name
+				calls[call.Name] = call
 			}
 		}
 	}
@@ -386,79 +1273,74 @@ func normalizePath(p string) string {
 	return p
 }
 
-func pathMatches(rulePath, candidate string) bool {
-	if rulePath == "." {
-		return true
-	}
-	if candidate == rulePath {
-		return true
+// ResourcesForPath returns the resource sets applied to a workspace-relative
+// path, in apply order. A set is left out when a rule covering the path
+// excludes it, unless a more specific rule than that one adds it.
+func (c *Config) ResourcesForPath(path string) []*ResolvedResource {
+	candidate := normalizePath(path)
+	type hit struct {
+		pr   *pathResources
+		spec specificity
 	}
-	ruleParts := strings.Split(rulePath, "/")
-	candidateParts := strings.Split(candidate, "/")
-	if len(candidateParts) < len(ruleParts) {
-		return false
+	var hits []hit
+	for i := range c.resolved {
+		if spec, ok := c.resolved[i].pattern.match(candidate); ok {
+			hits = append(hits, hit{&c.resolved[i], spec})
+		}
 	}
-	for i := range ruleParts {
-		if candidateParts[i] != ruleParts[i] {
-			return false
+	excluded := func(name string, by specificity) bool {
+		for _, h := range hits {
+			if !h.spec.less(by) && slices.Contains(h.pr.Exclude, name) {
+				return true
+			}
 		}
+		return false
 	}
-	return true
-}
-
-// ResourcesForPath returns the resource sets applied to a workspace-relative path.
-func (c *Config) ResourcesForPath(path string) []*ResolvedResource {
-	candidate := normalizePath(path)
 	var out []*ResolvedResource
 	seen := make(map[string]bool)
-	for _, pr := range c.resolved {
-		if pathMatches(pr.Path, candidate) {
-			for _, res := range pr.Resources {
-				if seen[res.Name] {
-					continue
-				}
-				seen[res.Name] = true
-				out = append(out, res)
+	for _, h := range hits {
+		for _, res := range h.pr.Resources {
+			if seen[res.Name] || excluded(res.Name, h.spec) {
+				continue
 			}
+			seen[res.Name] = true
+			out = append(out, res)
 		}
 	}
 	return out
 }
 
-// ImageForPath returns the first image override that matches the provided path.
+// ImageForPath returns the image override of the most specific rule that
+// covers the provided path. The earliest rule wins a tie.
 func (c *Config) ImageForPath(path string) (string, bool) {
-	candidate := normalizePath(path)
-	var (
-		image    string
-		matched  bool
-		matchLen int
-	)
-	for _, pr := range c.resolved {
+	i := c.imageRule(normalizePath(path))
+	if i < 0 {
+		return "", false
+	}
+	return c.resolved[i].Image, true
+}
+
+// imageRule returns the index of the rule ImageForPath picks, or -1.
+func (c *Config) imageRule(candidate string) int {
+	best := -1
+	var bestSpec specificity
+	for i, pr := range c.resolved {
 		if pr.Image == "" {
 			continue
 		}
-		if pathMatches(pr.Path, candidate) {
-			length := 0
-			if pr.Path != "." {
-				length = len(strings.Split(pr.Path, "/"))
-			}
-			if !matched || length > matchLen {
-				image = pr.Image
-				matched = true
-				matchLen = length
-			}
+		spec, ok := pr.pattern.match(candidate)
+		if ok && (best < 0 || bestSpec.less(spec)) {
+			best, bestSpec = i, spec
 		}
 	}
-	return image, matched
+	return best
 }
 
-func loadFromData(data []byte, path string, env, vars map[string]string) (*Config, error) {
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("parse shai config: %w", err)
-	}
-	cfg.sourcePath = path
-	cfg.sourceDir = filepath.Dir(path)
+// finish resolves extends, applies defaults and templates, and validates a
+// merged config.
+func (c *Config) finish(env, vars map[string]string) (*Config, error) {
+	cfg := *c
+	var err error
 
 	// Apply defaults for optional fields
 	if strings.TrimSpace(cfg.User) == "" {
@@ -469,15 +1351,14 @@ func loadFromData(data []byte, path string, env, vars map[string]string) (*Confi
 	}
 
 	// First expand user and workspace fields (they can use env and vars but not conf)
-	var err error
 	emptyConf := map[string]string{}
 	cfg.User, err = expandTemplates(cfg.User, env, vars, emptyConf)
 	if err != nil {
-		return nil, fmt.Errorf("user: %w", err)
+		return nil, cfg.fieldPos["user"].errorf("user: %v", err)
 	}
 	cfg.Workspace, err = expandTemplates(cfg.Workspace, env, vars, emptyConf)
 	if err != nil {
-		return nil, fmt.Errorf("workspace: %w", err)
+		return nil, cfg.fieldPos["workspace"].errorf("workspace: %v", err)
 	}
 
 	// Build conf map with finalized user and workspace
@@ -486,6 +1367,13 @@ func loadFromData(data []byte, path string, env, vars map[string]string) (*Confi
 		"WORKSPACE":   cfg.Workspace,
 	}
 
+	if err := cfg.applyConditions(env, vars, conf); err != nil {
+		return nil, err
+	}
+	if err := cfg.flattenResources(); err != nil {
+		return nil, err
+	}
+
 	// Now expand all templates including conf references
 	if err := cfg.applyTemplates(env, vars, conf); err != nil {
 		return nil, err
@@ -499,8 +1387,12 @@ func loadFromData(data []byte, path string, env, vars map[string]string) (*Confi
 	return &cfg, nil
 }
 
-// ResolveResources returns unique resource sets for the provided workspace-relative paths.
+// ResolveResources returns unique resource sets for the provided
+// workspace-relative paths. The workspace root only counts when it is the
+// only path: rules for "." cover every other path anyway, and a negated
+// rule such as !infra/** must not reach infra through the root.
 func (c *Config) ResolveResources(paths []string) []*ResolvedResource {
+	paths = slices.DeleteFunc(slices.Clone(paths), func(p string) bool { return normalizePath(p) == "." })
 	if len(paths) == 0 {
 		paths = []string{"."}
 	}
@@ -1,9 +1,13 @@
 package config
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -502,3 +506,1473 @@ apply:
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "duplicate host port 8000/tcp")
 }
+
+func TestCallExecModeDefaultsToArgv(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 2
+image: ghcr.io/example/image:latest
+resources:
+  base:
+    calls:
+      deploy:
+        command: ./scripts/deploy.sh "--label=two words" --dry-run
+        allowed-args: --env=(dev|prod)
+      legacy:
+        command: make build && make test
+        exec: shell
+apply:
+  - path: ./
+    resources: [base]
+`)
+	cfg, err := Load(path, map[string]string{}, map[string]string{})
+	require.NoError(t, err)
+	calls := cfg.Resources["base"].Calls
+	require.Len(t, calls, 2)
+
+	assert.Equal(t, ExecArgv, calls[0].ExecMode())
+	argv, err := calls[0].Argv()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"./scripts/deploy.sh", "--label=two words", "--dry-run"}, argv)
+
+	assert.Equal(t, ExecShell, calls[1].ExecMode())
+	assert.Empty(t, cfg.Warnings())
+}
+
+func TestCallExecModeVersion1DefaultsToShell(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 1
+image: ghcr.io/example/image:latest
+resources:
+  base:
+    calls:
+      - name: chained
+        command: make build && make test
+      - name: loose
+        command: ./scripts/run.sh
+        allowed-args: .*
+      - name: direct
+        command: ./scripts/run.sh
+        exec: argv
+apply:
+  - path: ./
+    resources: [base]
+`)
+	cfg, err := Load(path, map[string]string{}, map[string]string{})
+	require.NoError(t, err)
+	calls := cfg.Resources["base"].Calls
+	require.Len(t, calls, 3)
+	assert.Equal(t, ExecShell, calls[0].ExecMode())
+	assert.Equal(t, ExecShell, calls[1].ExecMode())
+	assert.Equal(t, ExecArgv, calls[2].ExecMode())
+	require.Len(t, cfg.Warnings(), 1)
+	assert.Contains(t, cfg.Warnings()[0], "call[loose] uses exec: shell")
+}
+
+func TestCallArgvRejectsShellSyntax(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 2
+image: ghcr.io/example/image:latest
+resources:
+  base:
+    calls:
+      chained:
+        command: make build && make test
+apply:
+  - path: ./
+    resources: [base]
+`)
+	_, err := Load(path, map[string]string{}, map[string]string{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exec: shell")
+}
+
+func TestCallInvalidExecMode(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 1
+image: ghcr.io/example/image:latest
+resources:
+  base:
+    calls:
+      - name: odd
+        command: ls
+        exec: python
+apply:
+  - path: ./
+    resources: [base]
+`)
+	_, err := Load(path, map[string]string{}, map[string]string{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid exec mode")
+}
+
+func TestCallShellPermissivePatternWarns(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 1
+image: ghcr.io/example/image:latest
+resources:
+  base:
+    calls:
+      - name: loose
+        command: ./scripts/run.sh
+        exec: shell
+        allowed-args: .*
+      - name: strict
+        command: ./scripts/run.sh
+        exec: shell
+        allowed-args: ^--env=(dev|prod)$
+apply:
+  - path: ./
+    resources: [base]
+`)
+	cfg, err := Load(path, map[string]string{}, map[string]string{})
+	require.NoError(t, err)
+	warnings := cfg.Warnings()
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "call[loose]")
+}
+
+func TestSplitCommand(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{in: "git pull", want: []string{"git", "pull"}},
+		{in: `echo 'a  b' "c \"d\"" e\ f`, want: []string{"echo", "a  b", `c "d"`, "e f"}},
+		{in: `printf "%s\n" ''`, want: []string{"printf", `%s\n`, ""}},
+	}
+	for _, tc := range cases {
+		got, err := SplitCommand(tc.in)
+		require.NoError(t, err, tc.in)
+		assert.Equal(t, tc.want, got, tc.in)
+	}
+
+	for _, bad := range []string{"", "echo 'open", "ls | wc", "echo $(id)", `trailing\`} {
+		_, err := SplitCommand(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestCallParams(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 1
+image: ghcr.io/example/image:latest
+resources:
+  base:
+    calls:
+      - name: deploy
+        command: ./scripts/deploy.sh
+        params:
+          - name: env
+            type: enum
+            values: [staging, "${{ vars.PROD }}"]
+            required: true
+          - name: replicas
+            type: int
+            arg: [-n, "{{ value }}"]
+          - name: tag
+            pattern: v[0-9]+
+            arg: --tag={{ value }}
+apply:
+  - path: ./
+    resources: [base]
+`)
+	cfg, err := Load(path, map[string]string{}, map[string]string{"PROD": "production"})
+	require.NoError(t, err)
+	params := cfg.Resources["base"].Calls[0].Params
+	require.Len(t, params, 3)
+	assert.Equal(t, []string{"staging", "production"}, params[0].Values)
+	assert.Equal(t, StringList{"-n", "{{ value }}"}, params[1].Arg)
+	assert.Equal(t, ParamString, params[2].Type)
+	assert.Equal(t, StringList{"--tag={{ value }}"}, params[2].Arg)
+}
+
+func TestCallParamsInvalid(t *testing.T) {
+	cases := map[string]string{
+		"allowed-args": `
+        allowed-args: .*
+        params:
+          - name: env`,
+		"invalid type": `
+        params:
+          - name: env
+            type: float`,
+		"requires values": `
+        params:
+          - name: env
+            type: enum`,
+		"duplicate param": `
+        params:
+          - name: env
+          - name: env`,
+		"reserved": `
+        params:
+          - name: args`,
+	}
+	for want, snippet := range cases {
+		dir := t.TempDir()
+		path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 1
+image: ghcr.io/example/image:latest
+resources:
+  base:
+    calls:
+      - name: deploy
+        command: ./scripts/deploy.sh`+snippet+`
+apply:
+  - path: ./
+    resources: [base]
+`)
+		_, err := Load(path, map[string]string{}, map[string]string{})
+		require.Error(t, err, want)
+		assert.Contains(t, err.Error(), want)
+	}
+}
+
+func TestMCPClients(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 1
+image: ghcr.io/example/image:latest
+mcp-clients: [Claude, codex]
+resources:
+  base:
+    calls:
+      - name: deploy
+        command: ./scripts/deploy.sh
+apply:
+  - path: ./
+    resources: [base]
+`)
+	cfg, err := Load(path, map[string]string{}, map[string]string{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"claude", "codex"}, cfg.MCPClients)
+
+	path = writeConfig(t, dir, `
+type: shai-sandbox
+version: 1
+image: ghcr.io/example/image:latest
+mcp-clients: [vscode]
+resources:
+  base: {}
+apply:
+  - path: ./
+    resources: [base]
+`)
+	_, err = Load(path, map[string]string{}, map[string]string{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unsupported client "vscode"`)
+}
+
+func TestCallMaxOutput(t *testing.T) {
+	for value, wantErr := range map[string]bool{"4096": false, "-1": false, "-2": true} {
+		dir := t.TempDir()
+		path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 1
+image: ghcr.io/example/image:latest
+resources:
+  base:
+    calls:
+      - name: build
+        command: make
+        max-output: `+value+`
+apply:
+  - path: ./
+    resources: [base]
+`)
+		_, err := Load(path, map[string]string{}, map[string]string{})
+		if wantErr {
+			require.Error(t, err, value)
+			assert.Contains(t, err.Error(), "invalid max-output")
+		} else {
+			require.NoError(t, err, value)
+		}
+	}
+}
+
+func TestCallLimits(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 1
+image: ghcr.io/example/image:latest
+resources:
+  base:
+    calls:
+      - name: deploy
+        command: ./scripts/deploy.sh
+        timeout: 90s
+        max-concurrent: 1
+        queue: Wait
+        rate: 6
+      - name: status
+        command: ./scripts/status.sh
+apply:
+  - path: ./
+    resources: [base]
+`)
+	cfg, err := Load(path, map[string]string{}, map[string]string{})
+	require.NoError(t, err)
+	calls := cfg.Resources["base"].Calls
+	assert.Equal(t, 90*time.Second, calls[0].TimeoutDuration())
+	assert.Equal(t, 1, calls[0].MaxConcurrent)
+	assert.Equal(t, QueueWait, calls[0].Queue)
+	assert.Equal(t, 6, calls[0].Rate)
+	assert.Equal(t, time.Duration(0), calls[1].TimeoutDuration())
+	assert.Equal(t, QueueReject, calls[1].Queue)
+}
+
+func TestCallLimitsInvalid(t *testing.T) {
+	cases := map[string]string{
+		"invalid timeout":        "timeout: soon",
+		"invalid max-concurrent": "max-concurrent: -1",
+		"invalid queue":          "queue: drop",
+		"invalid rate":           "rate: -5",
+	}
+	for want, field := range cases {
+		dir := t.TempDir()
+		path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 1
+image: ghcr.io/example/image:latest
+resources:
+  base:
+    calls:
+      - name: deploy
+        command: ./scripts/deploy.sh
+        `+field+`
+apply:
+  - path: ./
+    resources: [base]
+`)
+		_, err := Load(path, map[string]string{}, map[string]string{})
+		require.Error(t, err, want)
+		assert.Contains(t, err.Error(), want)
+	}
+}
+
+func TestCallApproval(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 1
+image: ghcr.io/example/image:latest
+resources:
+  base:
+    calls:
+      - name: flash
+        command: ./scripts/flash.sh
+        approval: Always
+      - name: status
+        command: ./scripts/status.sh
+apply:
+  - path: ./
+    resources: [base]
+`)
+	cfg, err := Load(path, map[string]string{}, map[string]string{})
+	require.NoError(t, err)
+	calls := cfg.Resources["base"].Calls
+	assert.Equal(t, ApprovalAlways, calls[0].Approval)
+	assert.Equal(t, ApprovalNever, calls[1].Approval)
+
+	path = writeConfig(t, t.TempDir(), `
+type: shai-sandbox
+version: 1
+image: ghcr.io/example/image:latest
+resources:
+  base:
+    calls:
+      - name: flash
+        command: ./scripts/flash.sh
+        approval: sometimes
+apply:
+  - path: ./
+    resources: [base]
+`)
+	_, err = Load(path, map[string]string{}, map[string]string{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid approval")
+}
+
+func TestCallFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 1
+image: ghcr.io/example/image:latest
+resources:
+  base:
+    calls:
+      - name: convert
+        command: pandoc
+        params:
+          - name: format
+            type: enum
+            values: [pdf, html]
+        inputs:
+          - name: source
+            filename: doc.md
+            required: true
+            max-size: 1048576
+            arg: ["{{ value }}"]
+        outputs:
+          - name: result
+            arg: ["-o", "{{ value }}"]
+apply:
+  - path: ./
+    resources: [base]
+`)
+	cfg, err := Load(path, map[string]string{}, map[string]string{})
+	require.NoError(t, err)
+	call := cfg.Resources["base"].Calls[0]
+	require.Len(t, call.Inputs, 1)
+	assert.Equal(t, "doc.md", call.Inputs[0].Filename)
+	assert.Equal(t, int64(1048576), call.Inputs[0].MaxSize)
+	assert.Equal(t, StringList{"-o", "{{ value }}"}, call.Outputs[0].Arg)
+
+	path = writeConfig(t, t.TempDir(), `
+type: shai-sandbox
+version: 1
+image: ghcr.io/example/image:latest
+resources:
+  base:
+    calls:
+      - name: convert
+        command: pandoc
+        params:
+          - name: source
+        inputs:
+          - name: source
+apply:
+  - path: ./
+    resources: [base]
+`)
+	_, err = Load(path, map[string]string{}, map[string]string{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "duplicate param or file")
+}
+
+func TestCallFilesExpandTemplates(t *testing.T) {
+	path := writeConfig(t, t.TempDir(), `
+type: shai-sandbox
+version: 1
+image: ghcr.io/example/image:latest
+resources:
+  base:
+    calls:
+      - name: convert
+        command: pandoc
+        outputs:
+          - name: result
+            arg: ["--${{ vars.FLAG }}", "{{ value }}"]
+apply:
+  - path: ./
+    resources: [base]
+`)
+	cfg, err := Load(path, map[string]string{}, map[string]string{"FLAG": "output"})
+	require.NoError(t, err)
+	assert.Equal(t, StringList{"--output", "{{ value }}"}, cfg.Resources["base"].Calls[0].Outputs[0].Arg)
+}
+
+func TestCallEnvAndCwd(t *testing.T) {
+	path := writeConfig(t, t.TempDir(), `
+type: shai-sandbox
+version: 1
+image: ghcr.io/example/image:latest
+resources:
+  base:
+    calls:
+      - name: test
+        command: ./scripts/test.sh
+        cwd: services/api/
+        env:
+          STAGE: ${{ vars.STAGE }}
+        inherit-env: false
+        env-allow: [PATH, LC_*]
+      - name: status
+        command: ./scripts/status.sh
+apply:
+  - path: ./
+    resources: [base]
+`)
+	cfg, err := Load(path, map[string]string{}, map[string]string{"STAGE": "ci"})
+	require.NoError(t, err)
+	calls := cfg.Resources["base"].Calls
+	assert.Equal(t, "services/api", calls[0].Cwd)
+	assert.Equal(t, map[string]string{"STAGE": "ci"}, calls[0].Env)
+	assert.False(t, calls[0].InheritsEnv())
+	assert.True(t, calls[1].InheritsEnv())
+
+	for _, tc := range []struct {
+		field string
+		want  string
+	}{
+		{"cwd: ../outside", "invalid cwd"},
+		{"cwd: /etc", "invalid cwd"},
+		{"env: {\"BAD-NAME\": x}", "invalid env name"},
+		{"env-allow: [PATH]", "inherit-env: false"},
+		{"inherit-env: false\n        env-allow: [\"A*B\"]", "invalid env-allow"},
+	} {
+		path := writeConfig(t, t.TempDir(), `
+type: shai-sandbox
+version: 1
+image: ghcr.io/example/image:latest
+resources:
+  base:
+    calls:
+      - name: test
+        command: ./scripts/test.sh
+        `+tc.field+`
+apply:
+  - path: ./
+    resources: [base]
+`)
+		_, err := Load(path, map[string]string{}, map[string]string{})
+		require.Error(t, err, tc.field)
+		assert.Contains(t, err.Error(), tc.want)
+	}
+}
+
+func TestIncludeAndExtends(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".shai"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".shai", "team.yaml"), []byte(`
+resources:
+  team-tools:
+    http: [internal.example.com]
+    calls:
+      - name: lint
+        command: ./scripts/lint.sh
+      - name: deploy
+        command: ./scripts/deploy.sh
+`), 0o644))
+	path := writeConfig(t, dir, `
+include:
+  - shai:default
+  - team.yaml
+type: shai-sandbox
+version: 1
+resources:
+  repo:
+    extends: [shai-default-allow, team-tools]
+    remove:
+      http: [pypi.org]
+      calls: [deploy]
+    http: [api.example.com, github.com]
+    calls:
+      - name: lint
+        command: ./scripts/lint.sh --strict
+apply:
+  - path: ./
+    resources: [repo]
+`)
+	cfg, err := Load(path, map[string]string{"HOME": "/home/dev"}, map[string]string{})
+	require.NoError(t, err)
+
+	assert.Equal(t, "ghcr.io/colony-2/shai-mega", cfg.Image, "image comes from the embedded default")
+	repo := cfg.Resources["repo"]
+	assert.Contains(t, repo.Hosts(), "api.openai.com")
+	assert.Contains(t, repo.Hosts(), "internal.example.com")
+	assert.NotContains(t, repo.Hosts(), "pypi.org")
+	assert.Equal(t, "api.example.com", repo.HTTP[len(repo.HTTP)-1].Host)
+	assert.Len(t, repo.Mounts, len(cfg.Resources["shai-default-allow"].Mounts))
+	require.Len(t, repo.Calls, 1)
+	assert.Equal(t, "./scripts/lint.sh --strict", repo.Calls[0].Command)
+	assert.Len(t, cfg.Resources["team-tools"].Calls, 2, "parents are not modified")
+
+	names := map[string]bool{}
+	for _, res := range cfg.ResolveResources([]string{"."}) {
+		names[res.Name] = true
+	}
+	assert.True(t, names["repo"] && names["shai-default-allow"])
+}
+
+func TestIncludeAndExtendsErrors(t *testing.T) {
+	base := `
+type: shai-sandbox
+version: 1
+image: ghcr.io/example/image:latest
+apply:
+  - path: ./
+    resources: [a]
+`
+	for _, tc := range []struct {
+		name      string
+		resources string
+		want      string
+	}{
+		{"unknown parent", "resources:\n  a:\n    extends: missing\n", `config.yaml:10: resource set "a" extends unknown set "missing"`},
+		{"cycle", "resources:\n  a:\n    extends: b\n  b:\n    extends: a\n", `extends cycle: a -> b -> a`},
+		{"remove missing", "resources:\n  a:\n    extends: b\n    remove:\n      http: [nope.example.com]\n  b:\n    http: [b.example.com]\n", `config.yaml:9: resource set "a": remove.http entry "nope.example.com" is not inherited`},
+	} {
+		path := writeConfig(t, t.TempDir(), base+tc.resources)
+		_, err := Load(path, map[string]string{}, map[string]string{})
+		require.Error(t, err, tc.name)
+		assert.Contains(t, err.Error(), tc.want, tc.name)
+	}
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".shai"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".shai", "other.yaml"), []byte("include: [config.yaml]\n"), 0o644))
+	path := writeConfig(t, dir, "include:\n  - other.yaml\n"+base+"resources:\n  a: {}\n")
+	_, err := Load(path, map[string]string{}, map[string]string{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "other.yaml:1: include cycle:")
+
+	path = writeConfig(t, t.TempDir(), "include:\n  - shai:nope\n"+base+"resources:\n  a: {}\n")
+	_, err = Load(path, map[string]string{}, map[string]string{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `config.yaml:2: include "shai:nope": unknown embedded config`)
+}
+
+func TestExtendsAllowsParentAndChildOnSamePath(t *testing.T) {
+	path := writeConfig(t, t.TempDir(), `
+type: shai-sandbox
+version: 1
+image: ghcr.io/example/image:latest
+resources:
+  base:
+    calls:
+      - name: lint
+        command: ./scripts/lint.sh
+  repo:
+    extends: base
+apply:
+  - path: ./
+    resources: [base, repo]
+`)
+	_, err := Load(path, map[string]string{}, map[string]string{})
+	require.NoError(t, err)
+}
+
+func TestLoadLayered(t *testing.T) {
+	dir := t.TempDir()
+	orgPath := filepath.Join(dir, "org.yaml")
+	require.NoError(t, os.WriteFile(orgPath, []byte(`
+image: registry.example.com/shai
+resources:
+  org-egress:
+    http: [proxy.example.com]
+  team:
+    http: [org.example.com]
+apply:
+  - path: ./
+    resources: [org-egress]
+`), 0o644))
+	userPath := filepath.Join(dir, "user.yaml")
+	require.NoError(t, os.WriteFile(userPath, []byte(`
+resources:
+  personal:
+    mounts:
+      - source: /home/dev/.claude
+        target: /home/shai/.claude
+        mode: rw
+apply:
+  - path: ./
+    resources: [personal]
+`), 0o644))
+	layers := []Layer{
+		{Name: LayerOrg, Path: orgPath},
+		{Name: LayerUser, Path: userPath},
+		{Name: "missing", Path: filepath.Join(dir, "missing.yaml")},
+	}
+
+	workspace := filepath.Join(dir, "ws")
+	path := writeConfig(t, workspace, `
+type: shai-sandbox
+version: 1
+resources:
+  team:
+    http: [api.example.com]
+apply:
+  - path: ./
+    resources: [team]
+`)
+	cfg, usedDefault, err := LoadLayered(layers, path, map[string]string{}, map[string]string{})
+	require.NoError(t, err)
+	assert.False(t, usedDefault)
+	assert.Equal(t, "registry.example.com/shai", cfg.Image, "image comes from the org layer")
+	assert.Equal(t, []string{"api.example.com"}, cfg.Resources["team"].Hosts(), "workspace sets replace same-named sets")
+
+	names := map[string]bool{}
+	for _, res := range cfg.ResolveResources([]string{"."}) {
+		names[res.Name] = true
+	}
+	assert.Equal(t, map[string]bool{"org-egress": true, "personal": true, "team": true}, names)
+
+	assert.Equal(t, Source{Layer: LayerOrg, File: orgPath, Line: 4}, cfg.Resources["org-egress"].Source())
+	assert.Equal(t, Source{Layer: LayerOrg, File: orgPath, Line: 5}, cfg.Resources["org-egress"].HTTPSource(0))
+	assert.Equal(t, Source{Layer: LayerUser, File: userPath, Line: 5}, cfg.Resources["personal"].MountSource(0))
+	assert.Equal(t, LayerWorkspace, cfg.Resources["team"].HTTPSource(0).Layer)
+
+	cfg, usedDefault, err = LoadLayered(layers, filepath.Join(dir, "none", "config.yaml"), map[string]string{"HOME": "/home/dev"}, map[string]string{})
+	require.NoError(t, err)
+	assert.True(t, usedDefault)
+	assert.Equal(t, "registry.example.com/shai", cfg.Image, "layers override the embedded default")
+	assert.Equal(t, LayerDefault, cfg.Resources["shai-default-allow"].Source().Layer)
+	require.Contains(t, cfg.Resources, "personal")
+}
+
+func TestLoadLayeredSourcesFollowExtends(t *testing.T) {
+	dir := t.TempDir()
+	userPath := filepath.Join(dir, "user.yaml")
+	require.NoError(t, os.WriteFile(userPath, []byte(`
+resources:
+  personal:
+    http: [a.example.com, b.example.com]
+`), 0o644))
+	path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 1
+image: example
+resources:
+  repo:
+    extends: personal
+    remove:
+      http: [a.example.com]
+    http: [c.example.com]
+apply:
+  - path: ./
+    resources: [repo]
+`)
+	cfg, _, err := LoadLayered([]Layer{{Name: LayerUser, Path: userPath}}, path, map[string]string{}, map[string]string{})
+	require.NoError(t, err)
+	repo := cfg.Resources["repo"]
+	require.Equal(t, []string{"b.example.com", "c.example.com"}, repo.Hosts())
+	assert.Equal(t, Source{Layer: LayerUser, File: userPath, Line: 4}, repo.HTTPSource(0))
+	assert.Equal(t, LayerWorkspace, repo.HTTPSource(1).Layer)
+	assert.Equal(t, LayerWorkspace, repo.Source().Layer)
+}
+
+func TestLoadLayeredRejectsMismatchedType(t *testing.T) {
+	dir := t.TempDir()
+	orgPath := filepath.Join(dir, "org.yaml")
+	require.NoError(t, os.WriteFile(orgPath, []byte("type: other\n"), 0o644))
+	path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 1
+image: example
+resources:
+  base: {}
+`)
+	_, _, err := LoadLayered([]Layer{{Name: LayerOrg, Path: orgPath}}, path, map[string]string{}, map[string]string{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), orgPath)
+}
+
+func TestPolicyCheck(t *testing.T) {
+	dir := t.TempDir()
+	home := filepath.Join(dir, "home")
+	require.NoError(t, os.MkdirAll(filepath.Join(home, ".claude"), 0o755))
+	require.NoError(t, os.Symlink(home, filepath.Join(dir, "link")))
+	policyPath := filepath.Join(dir, "policy.yaml")
+	require.NoError(t, os.WriteFile(policyPath, []byte(`
+type: shai-policy
+version: 1
+deny:
+  privileged: true
+  calls: true
+mounts:
+  allowed-sources: ["${{ env.HOME }}/.claude"]
+  deny-rw: true
+http: [github.com, "*.npmjs.org"]
+image:
+  registries: [ghcr.io/colony-2]
+  require-digest: true
+`), 0o644))
+	policy, err := LoadPolicy(policyPath, map[string]string{"HOME": home})
+	require.NoError(t, err)
+
+	allowed := &ResourceSet{
+		Mounts: []Mount{{Source: filepath.Join(home, ".claude"), Target: "/home/shai/.claude", Mode: "ro"}},
+		HTTP:   []HTTPRule{{Host: "api.github.com"}, {Host: "registry.npmjs.org"}},
+	}
+	require.NoError(t, policy.Check(PolicyTarget{
+		Image:     "ghcr.io/colony-2/shai-mega@sha256:abc",
+		Resources: []*ResolvedResource{{Name: "ok", Spec: allowed}},
+	}))
+
+	offending := &ResourceSet{
+		Mounts: []Mount{
+			{Source: home, Target: "/home/shai", Mode: "rw"},
+			{Source: "link/.claude/../..", Target: "/escape", Mode: "ro"},
+		},
+		HTTP:    []HTTPRule{{Host: "github.com"}, {Host: "evil.example.com"}},
+		Calls:   []Call{{Name: "deploy"}},
+		Options: ResourceOptions{Privileged: true},
+	}
+	err = policy.Check(PolicyTarget{
+		Image:      "ubuntu:24.04",
+		Privileged: true,
+		Resources:  []*ResolvedResource{{Name: "repo", Spec: offending}},
+		WorkingDir: dir,
+	})
+	var policyErr *PolicyError
+	require.ErrorAs(t, err, &policyErr)
+	assert.Equal(t, []string{
+		"privileged mode is denied",
+		`image "ubuntu:24.04" is not from an allowed registry (ghcr.io/colony-2)`,
+		`image "ubuntu:24.04" is not pinned by digest`,
+		"resource repo options.privileged is denied",
+		"resource repo call[deploy] is denied: calls are not allowed",
+		"resource repo mount[0] source " + home + " is outside the allowed mount sources",
+		"resource repo mount[0] /home/shai is read-write",
+		"resource repo mount[1] source " + dir + " is outside the allowed mount sources",
+		`resource repo http[1] "evil.example.com" is not an approved host`,
+	}, policyErr.Violations)
+	assert.Contains(t, err.Error(), policyPath)
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	policy, err := LoadPolicy(filepath.Join(dir, "missing.yaml"), nil)
+	require.NoError(t, err)
+	assert.Nil(t, policy)
+	require.NoError(t, policy.Check(PolicyTarget{Privileged: true}))
+
+	for name, contents := range map[string]string{
+		"wrong type":        "type: shai-sandbox\nversion: 1\n",
+		"relative source":   "type: shai-policy\nversion: 1\nmounts:\n  allowed-sources: [home]\n",
+		"missing env":       "type: shai-policy\nversion: 1\nmounts:\n  allowed-sources: [\"${{ env.NOPE }}\"]\n",
+		"unsupported value": "type: shai-policy\nversion: 2\n",
+		"empty":             "",
+	} {
+		path := filepath.Join(dir, "policy.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+		_, err := LoadPolicy(path, map[string]string{})
+		assert.Error(t, err, name)
+	}
+}
+
+func TestLoadPolicyRejectsUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("type: shai-policy\nversion: 1\nprivilaged: deny\n"), 0o644))
+	_, err := LoadPolicy(path, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "field privilaged not found")
+	assert.Contains(t, err.Error(), path)
+}
+
+func TestDigestCoversIncludes(t *testing.T) {
+	dir := t.TempDir()
+	teamPath := filepath.Join(dir, ".shai", "team.yaml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(teamPath), 0o755))
+	require.NoError(t, os.WriteFile(teamPath, []byte("resources:\n  team:\n    http: [a.example.com]\n"), 0o644))
+	path := writeConfig(t, dir, `
+include: [team.yaml]
+type: shai-sandbox
+version: 1
+image: example
+apply:
+  - path: ./
+    resources: [team]
+`)
+	cfg, err := Load(path, map[string]string{}, map[string]string{})
+	require.NoError(t, err)
+	digest := cfg.Digest(LayerWorkspace)
+	require.Len(t, digest, 64)
+	assert.Empty(t, cfg.Digest(LayerUser))
+
+	require.NoError(t, os.WriteFile(teamPath, []byte("resources:\n  team:\n    http: [b.example.com]\n"), 0o644))
+	cfg, err = Load(path, map[string]string{}, map[string]string{})
+	require.NoError(t, err)
+	assert.NotEqual(t, digest, cfg.Digest(LayerWorkspace), "changing an include changes the digest")
+}
+
+func TestExplainPath(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 1
+image: example
+resources:
+  base: {}
+  web: {}
+apply:
+  - path: ./
+    resources: [base]
+  - path: apps/web
+    resources: [web]
+    image: node
+  - path: apps
+    resources: [base]
+    image: other
+`)
+	cfg, err := Load(path, map[string]string{}, map[string]string{})
+	require.NoError(t, err)
+
+	matches := cfg.ExplainPath("apps/web/src")
+	require.Len(t, matches, 3)
+	assert.Equal(t, "applies to every path", matches[0].Reason)
+	assert.True(t, matches[1].Matched)
+	assert.Equal(t, "apps/web/src is inside apps/web", matches[1].Reason)
+	assert.Equal(t, Source{Layer: LayerWorkspace, File: path, Line: 11}, matches[1].Source)
+
+	rule, ok := cfg.ImageRuleForPath("apps/web/src")
+	require.True(t, ok)
+	assert.Equal(t, 1, rule.Index, "the deepest matching rule picks the image")
+
+	matches = cfg.ExplainPath("apps")
+	assert.False(t, matches[1].Matched)
+	assert.Equal(t, "apps/web is below apps; rules only apply to their own subtree", matches[1].Reason)
+	assert.Equal(t, "exact match", matches[2].Reason)
+}
+
+func TestLoadReportsAllProblems(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 1
+image: example
+resources:
+  base:
+    root_commands: ["apt-get update"]
+    calls:
+      - name: build
+        comand: make
+    mounts:
+      - source: /tmp
+        target: /data
+        mode: rwx
+apply:
+  - path: ./
+    resources: [base]
+    imge: node
+`)
+	_, err := Load(path, map[string]string{}, map[string]string{})
+	require.Error(t, err)
+	var invalid *ValidationError
+	require.ErrorAs(t, err, &invalid)
+
+	var problems []string
+	for _, problem := range invalid.Problems {
+		problems = append(problems, problem.Error())
+	}
+	assert.Equal(t, []string{
+		path + `:7:5: unknown field "root_commands" in resources.base (did you mean "root-commands"?)`,
+		path + `:10:9: unknown field "comand" in resources.base.calls.build (did you mean "command"?)`,
+		path + `:18:5: unknown field "imge" in apply[0] (did you mean "image"?)`,
+		path + `:6: resource base mount[0] has invalid mode "rwx"`,
+		path + `:6: resource base call[0] missing command`,
+	}, problems)
+	assert.Contains(t, err.Error(), "5 problems in shai config:")
+}
+
+func TestLoadChecksFieldsInIncludesAndAnchors(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "shared.yaml"), []byte(`
+resources:
+  shared:
+    htpp: [example.com]
+`), 0o644))
+	path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 1
+image: example
+include: [../shared.yaml]
+x-call: &call
+  name: build
+  command: make
+resources:
+  base:
+    calls:
+      - <<: *call
+        description: ok
+apply:
+  - path: ./
+    resources: [base]
+`)
+	_, err := Load(path, map[string]string{}, map[string]string{})
+	var invalid *ValidationError
+	require.ErrorAs(t, err, &invalid)
+	require.Len(t, invalid.Problems, 1, "x- fields hold anchors and are allowed")
+	assert.Contains(t, invalid.Problems[0].Error(), `shared.yaml:4:5: unknown field "htpp" in resources.shared (did you mean "http"?)`)
+}
+
+func TestJSONSchema(t *testing.T) {
+	data, err := JSONSchema()
+	require.NoError(t, err)
+	var schema map[string]any
+	require.NoError(t, json.Unmarshal(data, &schema))
+
+	assert.Equal(t, false, schema["additionalProperties"])
+	assert.Contains(t, schema["patternProperties"], "^x-")
+	props := schema["properties"].(map[string]any)
+	assert.Equal(t, map[string]any{"type": "string", "enum": []any{"shai-sandbox"}}, props["type"])
+	assert.Equal(t, map[string]any{"$ref": "#/$defs/ResourceSet"}, props["resources"].(map[string]any)["additionalProperties"])
+
+	defs := schema["$defs"].(map[string]any)
+	set := defs["ResourceSet"].(map[string]any)["properties"].(map[string]any)
+	assert.Contains(t, set, "root-commands")
+	assert.NotContains(t, set, "source", "unexported fields stay out of the schema")
+	mount := defs["Mount"].(map[string]any)["properties"].(map[string]any)
+	assert.Equal(t, []any{"ro", "rw"}, mount["mode"].(map[string]any)["enum"])
+	assert.Len(t, set["expose"].(map[string]any)["items"].(map[string]any)["oneOf"], 2)
+
+	assert.Equal(t, map[string]any{"const": float64(CurrentVersion)}, props["version"])
+	assert.Equal(t, map[string]any{"type": "object", "additionalProperties": map[string]any{"$ref": "#/$defs/Mount"}}, set["mounts"])
+	assert.Equal(t, map[string]any{"type": "object", "additionalProperties": map[string]any{"$ref": "#/$defs/Call"}}, set["calls"])
+	assert.NotContains(t, set, "ports", "version 2 folds ports into http rules")
+}
+
+func TestMigrate(t *testing.T) {
+	v1 := `# Team sandbox
+type: shai-sandbox
+version: 1
+image: example
+resources:
+  base:
+    mounts:
+      # the keys
+      - source: /home/dev/.ssh
+        target: /home/shai/.ssh
+        mode: ro # read only
+    calls:
+      - name: build # the build
+        command: make build
+    http:
+      - github.com # code
+    ports:
+      - host: github.com
+        port: 22
+      - host: db.internal
+        port: 5432
+apply:
+  - path: ./
+    resources: [base]
+`
+	out, changed, err := Migrate([]byte(v1))
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, `# Team sandbox
+type: shai-sandbox
+version: 2
+image: example
+resources:
+  base:
+    mounts:
+      # the keys
+      ssh:
+        source: /home/dev/.ssh
+        target: /home/shai/.ssh
+        mode: ro # read only
+    calls:
+      build: # the build
+        command: make build
+        exec: shell
+    http:
+      - host: github.com # code
+        ports: [22]
+      - host: db.internal
+        ports: [5432]
+        proxy: false
+apply:
+  - path: ./
+    resources: [base]
+`, string(out))
+
+	again, changed, err := Migrate(out)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, string(out), string(again))
+
+	dir := t.TempDir()
+	fromV1, err := Load(writeConfig(t, dir, v1), map[string]string{}, map[string]string{})
+	require.NoError(t, err)
+	fromV2, err := Load(writeConfig(t, dir, string(out)), map[string]string{}, map[string]string{})
+	require.NoError(t, err)
+	assert.Equal(t, fromV2.Resources["base"].Mounts, fromV1.Resources["base"].Mounts)
+	assert.Equal(t, fromV2.Resources["base"].Calls, fromV1.Resources["base"].Calls)
+	assert.Equal(t, []Port{{Host: "github.com", Port: 22}, {Host: "db.internal", Port: 5432}}, fromV1.Resources["base"].Ports())
+	assert.Equal(t, []Port{{Host: "github.com", Port: 22}, {Host: "db.internal", Port: 5432}}, fromV2.Resources["base"].Ports())
+	assert.Equal(t, []string{"github.com"}, fromV1.Resources["base"].Hosts(), "port-only hosts stay out of the HTTP allowlist")
+	assert.Equal(t, []string{"github.com"}, fromV2.Resources["base"].Hosts())
+
+	_, _, err = Migrate([]byte("type: shai-sandbox\nversion: 3\n"))
+	assert.ErrorContains(t, err, "config version 3 is newer than this shai supports")
+}
+
+func TestLoadVersion2(t *testing.T) {
+	path := writeConfig(t, t.TempDir(), `
+type: shai-sandbox
+version: 2
+image: example
+resources:
+  base:
+    mounts:
+      ssh:
+        source: /home/dev/.ssh
+        target: /home/shai/.ssh
+      cache:
+        source: /tmp/cache
+        target: /cache
+    calls:
+      build:
+        command: make build
+    http:
+      - example.com
+      - host: github.com
+        ports: [22, 443]
+  repo:
+    extends: base
+    remove:
+      mounts: [ssh]
+      ports: ["github.com:22"]
+    http:
+      - host: github.com
+        ports: [9418]
+apply:
+  - path: ./
+    resources: [repo]
+`)
+	cfg, err := Load(path, map[string]string{}, map[string]string{})
+	require.NoError(t, err)
+
+	base := cfg.Resources["base"]
+	require.Len(t, base.Mounts, 2)
+	assert.Equal(t, "ssh", base.Mounts[0].Name)
+	assert.Equal(t, "build", base.Calls[0].Name)
+	assert.Equal(t, []string{"example.com", "github.com"}, base.Hosts())
+
+	repo := cfg.Resources["repo"]
+	require.Len(t, repo.Mounts, 1)
+	assert.Equal(t, "cache", repo.Mounts[0].Name)
+	assert.Equal(t, []Port{{Host: "github.com", Port: 443}, {Host: "github.com", Port: 9418}}, repo.Ports())
+
+	for _, tc := range []struct{ name, resources, want string }{
+		{"duplicate mount", "    mounts:\n      a: {source: /a, target: /a}\n      a: {source: /b, target: /b}\n", "duplicate"},
+		{"call name mismatch", "    calls:\n      build: {name: test, command: make}\n", "build"},
+		{"mounts as list", "    mounts:\n      - {source: /a, target: /a}\n", "mounts"},
+		{"bad port", "    http:\n      - {host: db, ports: [70000]}\n", "resource base http[0] has invalid port 70000"},
+		{"proxy off without ports", "    http:\n      - {host: db, proxy: false}\n", "resource base http[0] has proxy: false and no ports"},
+	} {
+		path := writeConfig(t, t.TempDir(), "type: shai-sandbox\nversion: 2\nimage: example\nresources:\n  base:\n"+tc.resources+"apply:\n  - path: ./\n    resources: [base]\n")
+		_, err := Load(path, map[string]string{}, map[string]string{})
+		assert.ErrorContains(t, err, tc.want, tc.name)
+	}
+}
+
+func TestApplyPatterns(t *testing.T) {
+	path := writeConfig(t, t.TempDir(), `
+type: shai-sandbox
+version: 2
+image: example
+resources:
+  base: {}
+  go: {}
+  web: {}
+  cloud: {}
+apply:
+  - path: ./
+    resources: [base]
+  - path: "!infra/**"
+    resources: [cloud]
+  - path: services/*
+    resources: [go]
+  - path: "**/frontend"
+    resources: [web]
+  - path: services/legacy
+    exclude-resources: [go, base]
+  - path: services/legacy/tools
+    resources: [go]
+`)
+	cfg, err := Load(path, map[string]string{}, map[string]string{})
+	require.NoError(t, err)
+
+	names := func(paths ...string) []string {
+		var out []string
+		for _, res := range cfg.ResolveResources(paths) {
+			out = append(out, res.Name)
+		}
+		return out
+	}
+	assert.Equal(t, []string{"base", "cloud"}, names("."))
+	assert.Equal(t, []string{"base"}, names("infra"))
+	assert.Equal(t, []string{"base"}, names(".", "infra/dns"), "the root does not count when other paths are writable")
+	assert.Equal(t, []string{"base", "cloud", "go"}, names("services/api/cmd"))
+	assert.Equal(t, []string{"base", "cloud"}, names("services"), "services/* only covers directories below services")
+	assert.Equal(t, []string{"base", "cloud", "web"}, names("frontend"))
+	assert.Equal(t, []string{"base", "cloud", "web"}, names("apps/frontend/src"))
+	assert.Equal(t, []string{"cloud"}, names("services/legacy"), "exclusions beat less specific rules")
+	assert.Equal(t, []string{"cloud", "go"}, names("services/legacy/tools"), "more specific rules add sets back")
+
+	reasons := map[string]string{}
+	for _, m := range cfg.ExplainPath("apps/frontend/src") {
+		reasons[m.Path] = m.Reason
+	}
+	assert.Equal(t, "apps/frontend/src is inside apps/frontend, which matches **/frontend", reasons["**/frontend"])
+	assert.Equal(t, "apps/frontend/src is outside infra/**", reasons["!infra/**"])
+	assert.Equal(t, "apps/frontend/src does not match services/*", reasons["services/*"])
+}
+
+func TestImageForPathSpecificity(t *testing.T) {
+	path := writeConfig(t, t.TempDir(), `
+type: shai-sandbox
+version: 2
+image: example
+resources:
+  base: {}
+apply:
+  - path: "!docs"
+    resources: [base]
+    image: negated
+  - path: services/*
+    resources: [base]
+    image: glob
+  - path: services/api
+    resources: [base]
+    image: exact
+  - path: "**/api"
+    resources: [base]
+    image: any-api
+  - path: services
+    resources: [base]
+    image: parent
+`)
+	cfg, err := Load(path, map[string]string{}, map[string]string{})
+	require.NoError(t, err)
+
+	for candidate, want := range map[string]string{
+		"src":                  "negated",
+		"services":             "parent",
+		"services/web":         "glob",
+		"services/api/handler": "exact",
+		"services/web/api":     "any-api",
+	} {
+		image, ok := cfg.ImageForPath(candidate)
+		assert.True(t, ok, candidate)
+		assert.Equal(t, want, image, candidate)
+		rule, ok := cfg.ImageRuleForPath(candidate)
+		assert.True(t, ok, candidate)
+		assert.Equal(t, want, rule.Image, candidate)
+	}
+	_, ok := cfg.ImageForPath("docs/guide")
+	assert.False(t, ok)
+}
+
+func TestApplyPatternErrors(t *testing.T) {
+	for _, tc := range []struct{ name, apply, want string }{
+		{"bad glob", "  - path: services/[a\n    resources: [base]\n", `apply path "services/[a": syntax error in pattern`},
+		{"unknown exclusion", "  - path: infra\n    exclude-resources: [nope]\n", `apply path "infra" excludes unknown resource "nope"`},
+		{"nothing to do", "  - path: infra\n", `apply path "infra" has no resources`},
+	} {
+		path := writeConfig(t, t.TempDir(), "type: shai-sandbox\nversion: 2\nimage: example\nresources:\n  base: {}\napply:\n"+tc.apply)
+		_, err := Load(path, map[string]string{}, map[string]string{})
+		assert.ErrorContains(t, err, tc.want, tc.name)
+	}
+}
+
+func TestTemplateExpressions(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("secret"), 0o644))
+	path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 2
+image: example
+resources:
+  base:
+    vars:
+      - source: REGION
+        target: ${{ env.REGION || 'us-east-1' }}
+      - source: CI
+        target: ${{ lower(vars.MODE || "CI") }}
+      - source: TAGS
+        target: ${{ join(',', env.A, env.MISSING, 'c') }}
+      - source: PLATFORM
+        target: ${{ os() }}-${{ arch() }}
+      - source: HAS_KEY
+        target: ${{ exists('`+keyFile+`') && 'yes' || 'no' }}
+      - source: HAS_AWS
+        target: ${{ exists('~/.aws') && 'yes' || 'no' }}
+      - source: KEY_SUM
+        target: ${{ sha256file('`+keyFile+`') }}
+      - source: QUOTE
+        target: ${{ 'it''s' }}
+apply:
+  - path: ./
+    resources: [base]
+`)
+	cfg, err := Load(path, map[string]string{"A": "a", "HOME": dir}, map[string]string{})
+	require.NoError(t, err)
+
+	targets := map[string]string{}
+	for _, v := range cfg.Resources["base"].Vars {
+		targets[v.Source] = v.Target
+	}
+	assert.Equal(t, "us-east-1", targets["REGION"])
+	assert.Equal(t, "ci", targets["CI"])
+	assert.Equal(t, "a,c", targets["TAGS"])
+	assert.Equal(t, runtime.GOOS+"-"+runtime.GOARCH, targets["PLATFORM"])
+	assert.Equal(t, "yes", targets["HAS_KEY"])
+	assert.Equal(t, "no", targets["HAS_AWS"])
+	assert.Equal(t, "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", targets["KEY_SUM"])
+	assert.Equal(t, "it's", targets["QUOTE"])
+}
+
+func TestConditions(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".aws"), 0o755))
+	path := writeConfig(t, dir, `
+type: shai-sandbox
+version: 2
+image: example
+resources:
+  base:
+    mounts:
+      aws:
+        if: exists('~/.aws')
+        source: ${{ env.HOME }}/.aws
+        target: /home/shai/.aws
+      kube:
+        if: ${{ exists('~/.kube') }}
+        source: ${{ env.HOME }}/.kube
+        target: /home/shai/.kube
+  ci:
+    if: env.CI == 'true'
+    vars:
+      - source: CI_TOKEN
+  child:
+    extends: [base, ci]
+apply:
+  - path: ./
+    resources: [child]
+  - path: ./
+    resources: [ci]
+  - path: app
+    if: "!env.CI"
+    resources: [base]
+    image: local
+`)
+	cfg, err := Load(path, map[string]string{"HOME": dir}, map[string]string{})
+	require.NoError(t, err)
+
+	assert.NotContains(t, cfg.Resources, "ci")
+	child := cfg.Resources["child"]
+	require.Len(t, child.Mounts, 1)
+	assert.Equal(t, "aws", child.Mounts[0].Name)
+	assert.Empty(t, child.Vars)
+	image, _ := cfg.ImageForPath("app")
+	assert.Equal(t, "local", image)
+
+	cfg, err = Load(path, map[string]string{"HOME": dir, "CI": "true"}, map[string]string{})
+	require.NoError(t, err)
+	require.Contains(t, cfg.Resources, "ci")
+	assert.Len(t, cfg.Resources["child"].Vars, 1)
+	_, ok := cfg.ImageForPath("app")
+	assert.False(t, ok, "the app rule is dropped on CI")
+}
+
+func TestTemplateErrorsIncludePosition(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "image",
+			body: "image: ${{ env.IMAGE }}\n",
+			want: `config.yaml:4: image: env "IMAGE" not found`,
+		},
+		{
+			name: "mount",
+			body: `image: example
+resources:
+  base:
+    mounts:
+      cache:
+        source: ${{ vars.CACHE }}
+        target: /cache
+`,
+			want: `config.yaml:8: resource base mount[0] source: var "CACHE" not found`,
+		},
+		{
+			name: "condition",
+			body: `image: example
+resources:
+  base:
+    if: unknown(env.X)
+`,
+			want: `config.yaml:6: resource set base if: unknown function "unknown"`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeConfig(t, t.TempDir(), "\ntype: shai-sandbox\nversion: 2\n"+tc.body)
+			_, err := Load(path, map[string]string{}, map[string]string{})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.want)
+		})
+	}
+}
+
+func TestVarSecretSources(t *testing.T) {
+	path := writeConfig(t, t.TempDir(), `
+type: shai-sandbox
+version: 2
+image: example
+resources:
+  base:
+    vars:
+      - source: PLAIN
+      - file: ${{ env.HOME }}/.config/token
+        target: API_TOKEN
+      - command: pass show npm/token
+        target: NPM_TOKEN
+        as: FILE
+      - keyring: github.com/cli
+        target: GH_TOKEN
+apply:
+  - path: ./
+    resources: [base]
+`)
+	cfg, err := Load(path, map[string]string{"HOME": "/home/dev"}, map[string]string{})
+	require.NoError(t, err)
+
+	vars := cfg.Resources["base"].Vars
+	require.Len(t, vars, 4)
+	assert.False(t, vars[0].Hidden())
+	assert.Equal(t, "PLAIN", vars[0].Origin())
+	assert.Equal(t, "file:/home/dev/.config/token", vars[1].Origin())
+	assert.Equal(t, "API_TOKEN", vars[1].Name())
+	assert.True(t, vars[1].Hidden())
+	assert.Equal(t, DeliverFile, vars[2].As)
+	source, ref, ok := vars[3].Secret()
+	assert.True(t, ok)
+	assert.Equal(t, SecretKeyring, source)
+	assert.Equal(t, "github.com/cli", ref)
+}
+
+func TestVarValidation(t *testing.T) {
+	cases := []struct {
+		vars string
+		want string
+	}{
+		{"- target: TOKEN\n", "resource base var[0] missing source"},
+		{"- source: TOKEN\n  keyring: npm\n", "resource base var[0] sets source and keyring; only one source is allowed"},
+		{"- keyring: npm\n", "resource base var[0] reads from keyring and needs a target"},
+		{"- source: TOKEN\n  as: volume\n", `resource base var[0] has invalid as "volume" (must be env or file)`},
+		{"- file: /token\n  target: ../TOKEN\n", `resource base var[0] target "../TOKEN" is not a valid file name`},
+		{"- command: pass show 'npm\n  target: TOKEN\n", "resource base var[0] command:"},
+	}
+	for _, tc := range cases {
+		vars := "      " + strings.ReplaceAll(strings.TrimSuffix(tc.vars, "\n"), "\n", "\n      ")
+		path := writeConfig(t, t.TempDir(), "type: shai-sandbox\nversion: 2\nimage: example\nresources:\n  base:\n    vars:\n"+vars+"\napply:\n  - path: ./\n    resources: [base]\n")
+		_, err := Load(path, map[string]string{}, map[string]string{})
+		assert.ErrorContains(t, err, tc.want, tc.vars)
+	}
+}
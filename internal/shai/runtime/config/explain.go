@@ -0,0 +1,81 @@
+package config
+
+import "strings"
+
+// RuleMatch explains whether an apply rule covers a path.
+type RuleMatch struct {
+	// Index is the rule's position in the merged apply list.
+	Index     int      `json:"index"`
+	Path      string   `json:"path"`
+	Resources []string `json:"resources"`
+	Exclude   []string `json:"exclude-resources,omitempty"`
+	Image     string   `json:"image,omitempty"`
+	Source    Source   `json:"source"`
+	Matched   bool     `json:"matched"`
+	Reason    string   `json:"reason"`
+}
+
+// ExplainPath reports, for every apply rule in order, whether it covers the
+// workspace-relative path and why.
+func (c *Config) ExplainPath(path string) []RuleMatch {
+	candidate := normalizePath(path)
+	out := make([]RuleMatch, 0, len(c.resolved))
+	for i, pr := range c.resolved {
+		names := make([]string, 0, len(pr.Resources))
+		for _, res := range pr.Resources {
+			names = append(names, res.Name)
+		}
+		_, matched := pr.pattern.match(candidate)
+		m := RuleMatch{
+			Index:     i,
+			Path:      pr.Path,
+			Resources: names,
+			Exclude:   pr.Exclude,
+			Image:     pr.Image,
+			Source:    pr.source,
+			Matched:   matched,
+			Reason:    matchReason(pr.Path, pr.pattern, candidate),
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func matchReason(rulePath string, pattern pathPattern, candidate string) string {
+	inner := strings.TrimPrefix(rulePath, "!")
+	depth, ok := pattern.matchDepth(candidate)
+	switch {
+	case pattern.segs == nil && !pattern.negate:
+		return "applies to every path"
+	case pattern.negate && ok:
+		return candidate + " is covered by " + inner + ", which the rule negates"
+	case pattern.negate:
+		return candidate + " is outside " + inner
+	case ok && depth == len(strings.Split(candidate, "/")):
+		if pattern.isGlob() {
+			return candidate + " matches " + rulePath
+		}
+		return "exact match"
+	case ok:
+		dir := strings.Join(strings.Split(candidate, "/")[:depth], "/")
+		if pattern.isGlob() {
+			return candidate + " is inside " + dir + ", which matches " + rulePath
+		}
+		return candidate + " is inside " + rulePath
+	case !pattern.isGlob() && (candidate == "." || strings.HasPrefix(rulePath, candidate+"/")):
+		return rulePath + " is below " + candidate + "; rules only apply to their own subtree"
+	case pattern.isGlob():
+		return candidate + " does not match " + rulePath
+	default:
+		return candidate + " is outside " + rulePath
+	}
+}
+
+// ImageRuleForPath returns the apply rule whose image ImageForPath picks.
+func (c *Config) ImageRuleForPath(path string) (RuleMatch, bool) {
+	i := c.imageRule(normalizePath(path))
+	if i < 0 {
+		return RuleMatch{}, false
+	}
+	return c.ExplainPath(path)[i], true
+}
@@ -0,0 +1,473 @@
+package config
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EmbeddedIncludePrefix marks an include that names a config built into shai,
+// such as "shai:default".
+const EmbeddedIncludePrefix = "shai:"
+
+// embeddedConfigs are the configs available to include by name.
+var embeddedConfigs = map[string][]byte{
+	"default": embeddedDefault,
+}
+
+// ResourceRemovals lists inherited entries a resource set drops from its
+// parents. Vars are matched by target, mounts by name or target, calls by
+// name and http rules by host. Ports, written host:port, drop a single port
+// from an http rule.
+type ResourceRemovals struct {
+	Vars   []string `yaml:"vars,omitempty"`
+	Mounts []string `yaml:"mounts,omitempty"`
+	Calls  []string `yaml:"calls,omitempty"`
+	HTTP   []string `yaml:"http,omitempty"`
+	Ports  []string `yaml:"ports,omitempty"`
+}
+
+func (r ResourceRemovals) empty() bool {
+	return len(r.Vars) == 0 && len(r.Mounts) == 0 && len(r.Calls) == 0 && len(r.HTTP) == 0 && len(r.Ports) == 0
+}
+
+// parseConfig decodes a single config file and records where its includes,
+// apply rules, resource sets, mounts and http hosts are declared. Unknown
+// fields are collected rather than ignored; loading fails on them once the
+// rest of the config has been checked.
+func parseConfig(data []byte, name, layer string) (*Config, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("parse shai config %s: %w", name, err)
+	}
+	cfg := &Config{sourcePath: name, layer: layer}
+	cfg.files = []sourceFile{{layer: layer, sum: sha256.Sum256(data)}}
+	if len(root.Content) == 0 {
+		return cfg, nil
+	}
+	doc := root.Content[0]
+	if _, _, err := upgradeDocument(doc); err != nil {
+		return nil, fmt.Errorf("parse shai config %s: %w", name, err)
+	}
+	if err := doc.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("parse shai config %s: %w", name, err)
+	}
+	at := func(node *yaml.Node) Source {
+		return Source{Layer: layer, File: name, Line: node.Line}
+	}
+
+	cfg.unknown = unknownFields(doc, reflect.TypeOf(Config{}), "", at)
+	for _, key := range []string{"image", "user", "workspace"} {
+		if value := mappingValue(doc, key); value != nil {
+			if cfg.fieldPos == nil {
+				cfg.fieldPos = map[string]Source{}
+			}
+			cfg.fieldPos[key] = at(value)
+		}
+	}
+	if include := mappingValue(doc, "include"); include != nil {
+		for _, item := range include.Content {
+			cfg.includePos = append(cfg.includePos, at(item))
+		}
+	}
+	if apply := mappingValue(doc, "apply"); apply != nil {
+		for i, item := range apply.Content {
+			if i < len(cfg.Apply) {
+				cfg.Apply[i].source = at(item)
+			}
+		}
+	}
+	if resources := mappingValue(doc, "resources"); resources != nil && resources.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(resources.Content); i += 2 {
+			set, ok := cfg.Resources[resources.Content[i].Value]
+			if !ok || set == nil {
+				continue
+			}
+			body := resources.Content[i+1]
+			set.source = at(resources.Content[i])
+			set.extendsPos = set.source
+			if extends := mappingValue(body, "extends"); extends != nil {
+				set.extendsPos = at(extends)
+			}
+			if mounts := mappingValue(body, "mounts"); mounts != nil {
+				for j := 0; j < len(mounts.Content)/2 && j < len(set.Mounts); j++ {
+					set.Mounts[j].source = at(mounts.Content[2*j])
+				}
+			}
+			if rules := mappingValue(body, "http"); rules != nil {
+				for j, item := range rules.Content {
+					if j < len(set.HTTP) {
+						set.HTTP[j].source = at(item)
+					}
+				}
+			}
+		}
+	}
+	return cfg, nil
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// resolveIncludes merges the config's includes underneath it, in order, so
+// later includes override earlier ones and the including file overrides
+// them all. stack holds the files currently being included, outermost first.
+func (c *Config) resolveIncludes(dir string, stack []string) error {
+	if len(c.Include) == 0 {
+		return nil
+	}
+	merged := &Config{}
+	for i, include := range c.Include {
+		pos := Source{Layer: c.layer, File: c.sourcePath}
+		if i < len(c.includePos) {
+			pos = c.includePos[i]
+		}
+		data, name, childDir, err := readInclude(include, dir)
+		if err != nil {
+			return pos.errorf("include %q: %v", include, err)
+		}
+		for _, open := range stack {
+			if open == name {
+				return pos.errorf("include cycle: %s -> %s", strings.Join(stack, " -> "), name)
+			}
+		}
+		child, err := parseConfig(data, name, c.layer)
+		if err != nil {
+			return pos.errorf("include %q: %v", include, err)
+		}
+		if child.Type != "" && child.Type != expectedType {
+			return pos.errorf("include %q has unsupported config type %q (expected %q)", include, child.Type, expectedType)
+		}
+		if child.Version != 0 && child.Version != CurrentVersion {
+			return pos.errorf("include %q has unsupported config version %d (expected %d)", include, child.Version, CurrentVersion)
+		}
+		if err := child.resolveIncludes(childDir, append(stack, name)); err != nil {
+			return err
+		}
+		merged.overlay(child)
+	}
+	merged.overlay(c)
+	merged.sourcePath = c.sourcePath
+	merged.Include = c.Include
+	*c = *merged
+	return nil
+}
+
+// readInclude loads an include relative to dir, or an embedded config. It
+// returns the name used in messages and the directory for nested includes.
+func readInclude(include, dir string) ([]byte, string, string, error) {
+	include = strings.TrimSpace(include)
+	if name, ok := strings.CutPrefix(include, EmbeddedIncludePrefix); ok {
+		data, found := embeddedConfigs[name]
+		if !found {
+			return nil, "", "", fmt.Errorf("unknown embedded config %q", name)
+		}
+		return data, include, dir, nil
+	}
+	if include == "" {
+		return nil, "", "", fmt.Errorf("empty path")
+	}
+	path := include
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return data, filepath.Clean(path), filepath.Dir(path), nil
+}
+
+// overlay merges src on top of c. Scalars set in src win, resource sets
+// replace same-named ones, and apply rules and MCP clients are appended.
+func (c *Config) overlay(src *Config) {
+	if src.Type != "" {
+		c.Type = src.Type
+	}
+	if src.Version != 0 {
+		c.Version = src.Version
+	}
+	if src.Image != "" {
+		c.Image = src.Image
+	}
+	if src.User != "" {
+		c.User = src.User
+	}
+	if src.Workspace != "" {
+		c.Workspace = src.Workspace
+	}
+	for key, pos := range src.fieldPos {
+		if c.fieldPos == nil {
+			c.fieldPos = map[string]Source{}
+		}
+		c.fieldPos[key] = pos
+	}
+	if len(src.Resources) > 0 && c.Resources == nil {
+		c.Resources = make(map[string]*ResourceSet, len(src.Resources))
+	}
+	for name, set := range src.Resources {
+		c.Resources[name] = set
+	}
+	c.Apply = append(c.Apply, src.Apply...)
+	c.files = append(c.files, src.files...)
+	c.unknown = append(c.unknown, src.unknown...)
+	for _, client := range src.MCPClients {
+		if !containsString(c.MCPClients, client) {
+			c.MCPClients = append(c.MCPClients, client)
+		}
+	}
+}
+
+// flattenResources resolves extends so every resource set carries its
+// inherited entries. Sets are processed in name order so errors are stable.
+func (c *Config) flattenResources() error {
+	names := make([]string, 0, len(c.Resources))
+	for name := range c.Resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	done := make(map[string]bool, len(names))
+	var visit func(name string, chain []string) error
+	visit = func(name string, chain []string) error {
+		if done[name] {
+			return nil
+		}
+		set := c.Resources[name]
+		if set == nil {
+			set = &ResourceSet{}
+			c.Resources[name] = set
+		}
+		if len(set.Extends) == 0 {
+			if !set.Remove.empty() {
+				return set.source.errorf("resource set %q uses remove without extends", name)
+			}
+			done[name] = true
+			return nil
+		}
+		chain = append(chain, name)
+		inherited := &ResourceSet{}
+		for _, parentName := range set.Extends {
+			for i, open := range chain {
+				if open == parentName {
+					return set.extendsPos.errorf("resource set %q has an extends cycle: %s -> %s", name, strings.Join(chain[i:], " -> "), parentName)
+				}
+			}
+			if c.disabled[parentName] {
+				continue
+			}
+			if _, ok := c.Resources[parentName]; !ok {
+				return set.extendsPos.errorf("resource set %q extends unknown set %q", name, parentName)
+			}
+			if err := visit(parentName, chain); err != nil {
+				return err
+			}
+			inherited.inherit(c.Resources[parentName])
+		}
+		if err := inherited.remove(set.Remove); err != nil {
+			return set.source.errorf("resource set %q: %v", name, err)
+		}
+		inherited.inherit(set)
+		inherited.Expose = set.Expose
+		inherited.RootCommands = set.RootCommands
+		inherited.Options = set.Options
+		inherited.Extends = set.Extends
+		inherited.Remove = set.Remove
+		inherited.source = set.source
+		inherited.extendsPos = set.extendsPos
+		c.Resources[name] = inherited
+		done[name] = true
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inherit adds src's vars, mounts, calls and http rules to r. Entries with
+// the same key as an existing one replace it in place, except that http
+// rules for the same host merge their ports.
+func (r *ResourceSet) inherit(src *ResourceSet) {
+	for _, v := range src.Vars {
+		if i := indexOf(len(r.Vars), func(i int) bool { return r.Vars[i].Target == v.Target }); i >= 0 {
+			r.Vars[i] = v
+		} else {
+			r.Vars = append(r.Vars, v)
+		}
+	}
+	for _, m := range src.Mounts {
+		if i := indexOf(len(r.Mounts), func(i int) bool {
+			return r.Mounts[i].Target == m.Target || (m.Name != "" && r.Mounts[i].Name == m.Name)
+		}); i >= 0 {
+			r.Mounts[i] = m
+		} else {
+			r.Mounts = append(r.Mounts, m)
+		}
+	}
+	for _, call := range src.Calls {
+		call = call.clone()
+		if i := indexOf(len(r.Calls), func(i int) bool { return r.Calls[i].Name == call.Name }); i >= 0 {
+			r.Calls[i] = call
+		} else {
+			r.Calls = append(r.Calls, call)
+		}
+	}
+	for _, rule := range src.HTTP {
+		i := indexOf(len(r.HTTP), func(i int) bool { return r.HTTP[i].Host == rule.Host })
+		if i < 0 {
+			rule.Ports = append([]int(nil), rule.Ports...)
+			r.HTTP = append(r.HTTP, rule)
+			continue
+		}
+		for _, port := range rule.Ports {
+			if !slices.Contains(r.HTTP[i].Ports, port) {
+				r.HTTP[i].Ports = append(r.HTTP[i].Ports, port)
+			}
+		}
+		// Either rule allowing the proxy is enough for the merged one.
+		if rule.AllowsHTTP() && !r.HTTP[i].AllowsHTTP() {
+			r.HTTP[i].Proxy = rule.Proxy
+		}
+	}
+}
+
+// remove drops inherited entries, failing on any that are not present so
+// typos do not go unnoticed.
+func (r *ResourceSet) remove(rm ResourceRemovals) error {
+	var err error
+	r.Vars, err = removeKeyed(r.Vars, rm.Vars, "vars", func(v VarMapping) string { return v.Target })
+	if err != nil {
+		return err
+	}
+	mountKeys := make([]string, len(rm.Mounts))
+	for i, key := range rm.Mounts {
+		mountKeys[i] = key
+		if j := indexOf(len(r.Mounts), func(j int) bool { return r.Mounts[j].Name == key }); j >= 0 {
+			mountKeys[i] = r.Mounts[j].Target
+		}
+	}
+	r.Mounts, err = removeKeyed(r.Mounts, mountKeys, "mounts", func(m Mount) string { return m.Target })
+	if err != nil {
+		return err
+	}
+	r.Calls, err = removeKeyed(r.Calls, rm.Calls, "calls", func(c Call) string { return c.Name })
+	if err != nil {
+		return err
+	}
+	r.HTTP, err = removeKeyed(r.HTTP, rm.HTTP, "http", func(rule HTTPRule) string { return rule.Host })
+	if err != nil {
+		return err
+	}
+	for _, key := range rm.Ports {
+		host, portText, _ := strings.Cut(key, ":")
+		port, convErr := strconv.Atoi(portText)
+		i := indexOf(len(r.HTTP), func(i int) bool { return r.HTTP[i].Host == host })
+		if convErr != nil || i < 0 || !slices.Contains(r.HTTP[i].Ports, port) {
+			return fmt.Errorf("remove.ports entry %q is not inherited", key)
+		}
+		r.HTTP[i].Ports = slices.DeleteFunc(slices.Clone(r.HTTP[i].Ports), func(p int) bool { return p == port })
+	}
+	return nil
+}
+
+func removeKeyed[T any](items []T, keys []string, kind string, key func(T) string) ([]T, error) {
+	if len(keys) == 0 {
+		return items, nil
+	}
+	drop := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		drop[k] = false
+	}
+	out := items[:0:0]
+	for _, item := range items {
+		k := key(item)
+		if _, ok := drop[k]; ok {
+			drop[k] = true
+			continue
+		}
+		out = append(out, item)
+	}
+	for _, k := range keys {
+		if !drop[k] {
+			return nil, fmt.Errorf("remove.%s entry %q is not inherited", kind, k)
+		}
+	}
+	return out, nil
+}
+
+// clone copies a call so inheriting sets do not share its slices and maps.
+func (c Call) clone() Call {
+	out := c
+	if c.Params != nil {
+		out.Params = make([]CallParam, len(c.Params))
+		for i, p := range c.Params {
+			p.Values = append([]string(nil), p.Values...)
+			p.Arg = append(StringList(nil), p.Arg...)
+			out.Params[i] = p
+		}
+	}
+	out.Inputs = cloneCallFiles(c.Inputs)
+	out.Outputs = cloneCallFiles(c.Outputs)
+	out.EnvAllow = append([]string(nil), c.EnvAllow...)
+	if c.Env != nil {
+		out.Env = make(map[string]string, len(c.Env))
+		for k, v := range c.Env {
+			out.Env[k] = v
+		}
+	}
+	if c.InheritEnv != nil {
+		inherit := *c.InheritEnv
+		out.InheritEnv = &inherit
+	}
+	return out
+}
+
+func cloneCallFiles(files []CallFile) []CallFile {
+	if files == nil {
+		return nil
+	}
+	out := make([]CallFile, len(files))
+	for i, f := range files {
+		f.Arg = append(StringList(nil), f.Arg...)
+		out[i] = f
+	}
+	return out
+}
+
+func indexOf(n int, match func(int) bool) int {
+	for i := 0; i < n; i++ {
+		if match(i) {
+			return i
+		}
+	}
+	return -1
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Config layers, lowest precedence first. The embedded default only appears
+// when there is no workspace config.
+const (
+	LayerDefault   = "default"
+	LayerOrg       = "org"
+	LayerUser      = "user"
+	LayerWorkspace = "workspace"
+)
+
+// Layer is a config file merged underneath the workspace config. Missing
+// layer files are skipped.
+type Layer struct {
+	Name string
+	Path string
+}
+
+// Source records where a resource set, mount or http host was declared.
+// Column is only set for problems that point at a single key.
+type Source struct {
+	Layer  string `json:"layer,omitempty"`
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+}
+
+func (s Source) String() string {
+	switch {
+	case s.Line == 0:
+		return s.File
+	case s.Column == 0:
+		return fmt.Sprintf("%s:%d", s.File, s.Line)
+	default:
+		return fmt.Sprintf("%s:%d:%d", s.File, s.Line, s.Column)
+	}
+}
+
+// errorf prefixes the message with the position, when one is known.
+func (s Source) errorf(format string, args ...any) error {
+	if s.File == "" {
+		return fmt.Errorf(format, args...)
+	}
+	return fmt.Errorf("%s: %s", s, fmt.Sprintf(format, args...))
+}
+
+// sourceFile is the content hash of a file that went into a config.
+type sourceFile struct {
+	layer string
+	sum   [sha256.Size]byte
+}
+
+// Digest hashes the contents of every file, includes among them, that the
+// layer contributed, in load order. It is empty when the layer had no files.
+func (c *Config) Digest(layer string) string {
+	h := sha256.New()
+	found := false
+	for _, f := range c.files {
+		if f.layer == layer {
+			h.Write(f.sum[:])
+			found = true
+		}
+	}
+	if !found {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// configFile is a config document waiting to be merged.
+type configFile struct {
+	layer string
+	name  string
+	data  []byte
+}
+
+// LoadLayered merges the given layers, in order, underneath the workspace
+// config at path. Later layers override earlier ones the same way a file
+// overrides its includes. When the workspace config is missing the embedded
+// default is merged underneath every layer instead; the returned bool reports
+// whether that happened.
+func LoadLayered(layers []Layer, path string, env, vars map[string]string) (*Config, bool, error) {
+	var files []configFile
+	for _, layer := range layers {
+		if layer.Path == "" {
+			continue
+		}
+		data, err := os.ReadFile(layer.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("read %s config: %w", layer.Name, err)
+		}
+		files = append(files, configFile{layer: layer.Name, name: filepath.Clean(layer.Path), data: data})
+	}
+
+	usedDefault := false
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		usedDefault = true
+		def := configFile{layer: LayerDefault, name: EmbeddedIncludePrefix + "default", data: embeddedDefault}
+		files = append([]configFile{def}, files...)
+	case err != nil:
+		return nil, false, fmt.Errorf("read shai config: %w", err)
+	default:
+		files = append(files, configFile{layer: LayerWorkspace, name: path, data: data})
+	}
+	cfg, err := loadFromFiles(files, env, vars)
+	return cfg, usedDefault, err
+}
+
+// loadFromFiles parses each file, resolves its includes, merges the files in
+// order and then resolves, expands and validates the result.
+func loadFromFiles(files []configFile, env, vars map[string]string) (*Config, error) {
+	if env == nil {
+		env = map[string]string{}
+	}
+	if vars == nil {
+		vars = map[string]string{}
+	}
+	cfg := &Config{}
+	for _, file := range files {
+		parsed, err := parseConfig(file.data, file.name, file.layer)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) > 1 {
+			at := Source{Layer: file.layer, File: file.name}
+			if parsed.Type != "" && parsed.Type != expectedType {
+				return nil, at.errorf("unsupported config type %q (expected %q)", parsed.Type, expectedType)
+			}
+			if parsed.Version != 0 && parsed.Version != CurrentVersion {
+				return nil, at.errorf("unsupported config version %d (expected %d)", parsed.Version, CurrentVersion)
+			}
+		}
+		dir := filepath.Dir(file.name)
+		if err := parsed.resolveIncludes(dir, []string{filepath.Clean(file.name)}); err != nil {
+			return nil, err
+		}
+		cfg.overlay(parsed)
+		cfg.sourcePath = parsed.sourcePath
+		cfg.sourceDir = dir
+	}
+	out, err := cfg.finish(env, vars)
+	if len(cfg.unknown) == 0 {
+		return out, err
+	}
+	problems := append([]error{}, cfg.unknown...)
+	if err != nil {
+		problems = append(problems, problemsOf(err)...)
+	}
+	return nil, &ValidationError{Problems: problems}
+}
+
+// Source reports where the resource set was declared.
+func (r *ResourceSet) Source() Source {
+	return r.source
+}
+
+// MountSource reports where the set's i-th mount was declared, which may be
+// in a set it extends.
+func (r *ResourceSet) MountSource(i int) Source {
+	if i < 0 || i >= len(r.Mounts) {
+		return Source{}
+	}
+	return r.Mounts[i].source
+}
+
+// HTTPSource reports where the set's i-th http rule was declared, which may
+// be in a set it extends.
+func (r *ResourceSet) HTTPSource(i int) Source {
+	if i < 0 || i >= len(r.HTTP) {
+		return Source{}
+	}
+	return r.HTTP[i].source
+}
@@ -0,0 +1,307 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Version 2 differs from version 1 in three ways:
+//
+//   - mounts and calls are mappings keyed by name instead of lists;
+//   - calls without an exec mode run as argv rather than through the shell,
+//     so version 1 calls get an explicit exec: shell;
+//   - http entries may be rules with ports, written {host: h, ports: [22]},
+//     and the separate ports list is folded into them. A host that was only
+//     in the ports list gets proxy: false, so it still gets no HTTP.
+//
+// upgradeDocument rewrites a version 1 document into that shape in place,
+// reusing the original nodes so comments and line numbers survive. A
+// document without a version, such as an include, is upgraded when it uses
+// the version 1 shapes. It returns the version the document had and
+// whether anything was rewritten.
+func upgradeDocument(doc *yaml.Node) (int, bool, error) {
+	if doc.Kind != yaml.MappingNode {
+		return 0, false, nil
+	}
+	from := 0
+	version := mappingValue(doc, "version")
+	if version != nil {
+		n, err := strconv.Atoi(version.Value)
+		if err != nil {
+			// Leave it for decoding to report.
+			return 0, false, nil
+		}
+		from = n
+	}
+	if from != 0 && from != 1 {
+		return from, false, nil
+	}
+	changed := false
+	if resources := mappingValue(doc, "resources"); resources != nil && resources.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(resources.Content); i += 2 {
+			setChanged, err := upgradeResourceSet(resources.Content[i].Value, resources.Content[i+1])
+			if err != nil {
+				return from, false, err
+			}
+			changed = changed || setChanged
+		}
+	}
+	if version != nil {
+		version.Value = strconv.Itoa(CurrentVersion)
+		changed = true
+	}
+	return from, changed, nil
+}
+
+func upgradeResourceSet(name string, set *yaml.Node) (bool, error) {
+	if set.Kind != yaml.MappingNode {
+		return false, nil
+	}
+	changed := false
+	if mounts := mappingValue(set, "mounts"); mounts != nil && mounts.Kind == yaml.SequenceNode {
+		named := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: mounts.Line, Column: mounts.Column}
+		used := map[string]bool{}
+		for _, item := range mounts.Content {
+			key := mountName(scalarValue(item, "target"), used)
+			named.Content = append(named.Content, keyFor(key, item), item)
+		}
+		replaceValue(set, "mounts", named)
+		changed = true
+	}
+	if calls := mappingValue(set, "calls"); calls != nil && calls.Kind == yaml.SequenceNode {
+		named := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: calls.Line, Column: calls.Column}
+		seen := map[string]bool{}
+		for i, item := range calls.Content {
+			key := scalarValue(item, "name")
+			if key == "" {
+				return false, fmt.Errorf("line %d: resource %s call[%d] has no name", item.Line, name, i)
+			}
+			if seen[key] {
+				return false, fmt.Errorf("line %d: resource %s has duplicate call %q", item.Line, name, key)
+			}
+			seen[key] = true
+			k := keyFor(key, item)
+			if nameNode := mappingValue(item, "name"); nameNode != nil {
+				k.LineComment = nameNode.LineComment
+			}
+			removeKey(item, "name")
+			if item.Kind == yaml.MappingNode && scalarValue(item, "exec") == "" {
+				insertAfter(item, "command", scalar("exec", item), scalar(ExecShell, item))
+			}
+			named.Content = append(named.Content, k, item)
+		}
+		replaceValue(set, "calls", named)
+		changed = true
+	}
+	if ports := mappingValue(set, "ports"); ports != nil && ports.Kind == yaml.SequenceNode {
+		// Comments on the nodes that go away are kept on the rules, so a
+		// commented-out block below the ports list survives.
+		var lead, foot string
+		rules := mappingValue(set, "http")
+		if rules == nil {
+			rules = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: ports.Line, Column: ports.Column}
+			replaceKey(set, "ports", "http", rules)
+		} else {
+			key := removeKey(set, "ports")
+			lead = joinComments(key.HeadComment, key.LineComment)
+			foot = key.FootComment
+		}
+		for _, item := range ports.Content {
+			host := mappingValue(item, "host")
+			port := mappingValue(item, "port")
+			if host == nil || port == nil {
+				return false, fmt.Errorf("line %d: resource %s ports entry needs a host and a port", item.Line, name)
+			}
+			item.HeadComment, lead = joinComments(lead, item.HeadComment), ""
+			addRulePort(rules, host, port, item)
+			for i := 0; i < len(item.Content); i += 2 {
+				k := item.Content[i]
+				foot = joinComments(foot, joinComments(k.HeadComment, joinComments(k.LineComment, k.FootComment)))
+			}
+			foot = joinComments(foot, item.FootComment)
+		}
+		if foot = joinComments(foot, ports.FootComment); foot != "" && len(rules.Content) > 0 {
+			// yaml.v3 keeps a trailing comment on the last key of a mapping.
+			at := rules.Content[len(rules.Content)-1]
+			if at.Kind == yaml.MappingNode && len(at.Content) >= 2 {
+				at = at.Content[len(at.Content)-2]
+			}
+			at.FootComment = joinComments(at.FootComment, foot)
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// addRulePort adds port to the http rule for host, turning a plain host
+// entry into a rule or appending a new port-only rule as needed.
+func addRulePort(rules, host, port, from *yaml.Node) {
+	for i, item := range rules.Content {
+		switch {
+		case item.Kind == yaml.ScalarNode && item.Value == host.Value:
+			rule := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: item.Line, Column: item.Column,
+				HeadComment: joinComments(item.HeadComment, from.HeadComment)}
+			item.HeadComment = ""
+			rule.Content = []*yaml.Node{scalar("host", item), item, scalar("ports", item), flowSeq(item, port)}
+			rules.Content[i] = rule
+			return
+		case item.Kind == yaml.MappingNode && scalarValue(item, "host") == host.Value:
+			item.HeadComment = joinComments(item.HeadComment, from.HeadComment)
+			if ports := mappingValue(item, "ports"); ports != nil {
+				ports.Content = append(ports.Content, port)
+			} else {
+				item.Content = append(item.Content, scalar("ports", item), flowSeq(item, port))
+			}
+			return
+		}
+	}
+	rule := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: from.Line, Column: from.Column, HeadComment: from.HeadComment}
+	rule.Content = []*yaml.Node{scalar("host", from), host, scalar("ports", from), flowSeq(from, port), scalar("proxy", from),
+		{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "false", Line: from.Line, Column: from.Column}}
+	rules.Content = append(rules.Content, rule)
+}
+
+func joinComments(a, b string) string {
+	if a == "" || b == "" {
+		return a + b
+	}
+	return a + "\n" + b
+}
+
+var mountNameRe = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// mountName derives a mount's name from the last element of its target.
+func mountName(target string, used map[string]bool) string {
+	target = strings.TrimRight(target, "/")
+	base := strings.Trim(mountNameRe.ReplaceAllString(path.Base(target), "-"), "-")
+	if base == "" || target == "" {
+		base = "mount"
+	}
+	name := base
+	for i := 2; used[name]; i++ {
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+	used[name] = true
+	return name
+}
+
+// scalarValue returns the scalar at key, following merge keys.
+func scalarValue(node *yaml.Node, key string) string {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if value := mappingValue(node, key); value != nil && value.Kind == yaml.ScalarNode {
+		return value.Value
+	}
+	merge := mappingValue(node, "<<")
+	if merge == nil {
+		return ""
+	}
+	sources := []*yaml.Node{merge}
+	if merge.Kind == yaml.SequenceNode {
+		sources = merge.Content
+	}
+	for _, src := range sources {
+		if value := scalarValue(src, key); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// keyFor returns a key node for item that carries its position and leading
+// comment.
+func keyFor(key string, item *yaml.Node) *yaml.Node {
+	k := scalar(key, item)
+	k.HeadComment, item.HeadComment = item.HeadComment, ""
+	return k
+}
+
+func scalar(value string, at *yaml.Node) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, Line: at.Line, Column: at.Column}
+}
+
+func flowSeq(at *yaml.Node, items ...*yaml.Node) *yaml.Node {
+	return &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle, Line: at.Line, Column: at.Column, Content: items}
+}
+
+func replaceValue(node *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = value
+		}
+	}
+}
+
+func replaceKey(node *yaml.Node, key, newKey string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i].Value = newKey
+			node.Content[i+1] = value
+		}
+	}
+}
+
+// insertAfter adds key and value to a mapping after the entry for after, or
+// at the end when there is none.
+func insertAfter(node *yaml.Node, after string, key, value *yaml.Node) {
+	at := len(node.Content)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == after {
+			at = i + 2
+			break
+		}
+	}
+	node.Content = slices.Insert(node.Content, at, key, value)
+}
+
+// removeKey deletes key from a mapping and returns the key node, or nil.
+func removeKey(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if k := node.Content[i]; k.Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return k
+		}
+	}
+	return nil
+}
+
+// Migrate rewrites a config file to the current version, keeping comments.
+// It reports whether anything changed; a file that is already current is
+// returned as is.
+func Migrate(data []byte) ([]byte, bool, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, false, err
+	}
+	if len(root.Content) == 0 {
+		return data, false, nil
+	}
+	from, changed, err := upgradeDocument(root.Content[0])
+	if err != nil {
+		return nil, false, err
+	}
+	if from > CurrentVersion {
+		return nil, false, fmt.Errorf("config version %d is newer than this shai supports (%d)", from, CurrentVersion)
+	}
+	if !changed {
+		return data, false, nil
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&root); err != nil {
+		return nil, false, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), true, nil
+}
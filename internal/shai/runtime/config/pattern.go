@@ -0,0 +1,129 @@
+package config
+
+import (
+	"path"
+	"strings"
+)
+
+// pathPattern is a parsed apply rule path. A rule covers the paths its
+// pattern matches and everything below them. Segments may use path.Match
+// wildcards, "**" matches any number of segments, and a leading "!"
+// negates the pattern so the rule covers every path the rest does not.
+type pathPattern struct {
+	negate bool
+	// segs is nil for ".", which covers the whole workspace.
+	segs []string
+}
+
+func parsePathPattern(p string) (pathPattern, error) {
+	var pat pathPattern
+	p = strings.TrimSpace(p)
+	if strings.HasPrefix(p, "!") {
+		pat.negate = true
+		p = p[1:]
+	}
+	p = normalizePath(p)
+	if p == "." {
+		return pat, nil
+	}
+	pat.segs = strings.Split(p, "/")
+	for _, seg := range pat.segs {
+		if _, err := path.Match(seg, ""); err != nil {
+			return pat, err
+		}
+	}
+	return pat, nil
+}
+
+// match reports whether the pattern covers candidate, a normalized
+// workspace-relative path, and how specific the match is.
+func (p pathPattern) match(candidate string) (specificity, bool) {
+	depth, ok := p.matchDepth(candidate)
+	if p.negate {
+		return specificity{}, !ok
+	}
+	if !ok {
+		return specificity{}, false
+	}
+	return specificity{depth: depth, literals: p.literals()}, true
+}
+
+// matchDepth returns the number of segments in the deepest ancestor of
+// candidate, or candidate itself, that the pattern matches, ignoring
+// negation.
+func (p pathPattern) matchDepth(candidate string) (int, bool) {
+	if p.segs == nil {
+		return 0, true
+	}
+	if candidate == "." {
+		return 0, false
+	}
+	parts := strings.Split(candidate, "/")
+	for depth := len(parts); depth > 0; depth-- {
+		if matchSegments(p.segs, parts[:depth]) {
+			return depth, true
+		}
+	}
+	return 0, false
+}
+
+func (p pathPattern) literals() int {
+	n := 0
+	for _, seg := range p.segs {
+		if !hasWildcard(seg) {
+			n++
+		}
+	}
+	return n
+}
+
+func (p pathPattern) isGlob() bool {
+	if p.negate {
+		return true
+	}
+	for _, seg := range p.segs {
+		if hasWildcard(seg) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], parts[0])
+	return ok && matchSegments(pattern[1:], parts[1:])
+}
+
+func hasWildcard(seg string) bool {
+	return strings.ContainsAny(seg, `*?[\`)
+}
+
+// specificity ranks the apply rules that cover a path. A rule that matches
+// a deeper directory of the path is more specific; between rules that match
+// at the same depth, the one with more literal segments wins, so
+// services/api beats services/*. Negated rules and "." rank lowest.
+type specificity struct {
+	depth    int
+	literals int
+}
+
+func (s specificity) less(o specificity) bool {
+	if s.depth != o.depth {
+		return s.depth < o.depth
+	}
+	return s.literals < o.literals
+}
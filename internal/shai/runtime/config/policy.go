@@ -0,0 +1,251 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	expectedPolicyType    = "shai-policy"
+	expectedPolicyVersion = 1
+)
+
+// Policy constrains what a sandbox may ask for, whatever its config says. It
+// is normally maintained by an organization and checked after the config is
+// loaded.
+type Policy struct {
+	Type    string      `yaml:"type"`
+	Version int         `yaml:"version"`
+	Deny    PolicyDeny  `yaml:"deny"`
+	Mounts  PolicyMount `yaml:"mounts"`
+	// HTTP lists the hosts resource sets may allow; each entry also covers
+	// its subdomains. Empty allows any host.
+	HTTP  []string    `yaml:"http"`
+	Image PolicyImage `yaml:"image"`
+
+	path string
+}
+
+// PolicyDeny forbids features outright.
+type PolicyDeny struct {
+	Privileged   bool `yaml:"privileged"`
+	RootCommands bool `yaml:"root-commands"`
+	Calls        bool `yaml:"calls"`
+}
+
+// PolicyMount restricts mount sources.
+type PolicyMount struct {
+	// AllowedSources lists host path prefixes mounts may come from. Empty
+	// allows any source.
+	AllowedSources []string `yaml:"allowed-sources"`
+	// DenyRW rejects read-write mounts.
+	DenyRW bool `yaml:"deny-rw"`
+}
+
+// PolicyImage restricts the container image.
+type PolicyImage struct {
+	// Registries lists allowed image prefixes, such as "ghcr.io" or
+	// "ghcr.io/colony-2". Empty allows any registry.
+	Registries []string `yaml:"registries"`
+	// RequireDigest rejects images not pinned with @sha256:.
+	RequireDigest bool `yaml:"require-digest"`
+}
+
+// PolicyTarget is what a sandbox is about to use.
+type PolicyTarget struct {
+	Image      string
+	Privileged bool
+	Resources  []*ResolvedResource
+	// WorkingDir resolves relative mount sources.
+	WorkingDir string
+}
+
+// PolicyError lists every part of a sandbox a policy rejects.
+type PolicyError struct {
+	Path       string
+	Violations []string
+}
+
+func (e *PolicyError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "config violates policy %s:", e.Path)
+	for _, v := range e.Violations {
+		b.WriteString("\n  - ")
+		b.WriteString(v)
+	}
+	return b.String()
+}
+
+// LoadPolicy reads the policy at path. Templates in mount sources may use
+// env. A missing file yields a nil policy and no error.
+func LoadPolicy(path string, env map[string]string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read shai policy: %w", err)
+	}
+	// Unknown fields are errors: a misspelled rule would otherwise be
+	// dropped and its check silently turned off.
+	var p Policy
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&p); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parse shai policy %s: %w", path, err)
+	}
+	if p.Type != expectedPolicyType {
+		return nil, fmt.Errorf("%s: unsupported policy type %q (expected %q)", path, p.Type, expectedPolicyType)
+	}
+	if p.Version != expectedPolicyVersion {
+		return nil, fmt.Errorf("%s: unsupported policy version %d (expected %d)", path, p.Version, expectedPolicyVersion)
+	}
+	for i, src := range p.Mounts.AllowedSources {
+		expanded, err := expandTemplates(src, env, map[string]string{}, map[string]string{})
+		if err != nil {
+			return nil, fmt.Errorf("%s: mounts.allowed-sources[%d]: %w", path, i, err)
+		}
+		if !filepath.IsAbs(expanded) {
+			return nil, fmt.Errorf("%s: mounts.allowed-sources[%d] %q must be absolute", path, i, expanded)
+		}
+		p.Mounts.AllowedSources[i] = realPath(expanded)
+	}
+	for i, host := range p.HTTP {
+		p.HTTP[i] = normalizeHost(host)
+	}
+	p.path = path
+	return &p, nil
+}
+
+// Check reports every way target breaks the policy as a *PolicyError.
+func (p *Policy) Check(target PolicyTarget) error {
+	if p == nil {
+		return nil
+	}
+	var violations []string
+	add := func(format string, args ...any) {
+		violations = append(violations, fmt.Sprintf(format, args...))
+	}
+
+	if p.Deny.Privileged && target.Privileged {
+		add("privileged mode is denied")
+	}
+	if image := strings.TrimSpace(target.Image); image != "" {
+		if len(p.Image.Registries) > 0 && !imageAllowed(image, p.Image.Registries) {
+			add("image %q is not from an allowed registry (%s)", image, strings.Join(p.Image.Registries, ", "))
+		}
+		if p.Image.RequireDigest && !strings.Contains(image, "@sha256:") {
+			add("image %q is not pinned by digest", image)
+		}
+	}
+
+	for _, res := range target.Resources {
+		if res == nil || res.Spec == nil {
+			continue
+		}
+		set := res.Spec
+		if p.Deny.Privileged && set.Options.Privileged {
+			add("resource %s options.privileged is denied", res.Name)
+		}
+		if p.Deny.RootCommands && len(set.RootCommands) > 0 {
+			add("resource %s root-commands are denied", res.Name)
+		}
+		if p.Deny.Calls {
+			for _, call := range set.Calls {
+				add("resource %s call[%s] is denied: calls are not allowed", res.Name, call.Name)
+			}
+		}
+		for i, m := range set.Mounts {
+			source := m.Source
+			if !filepath.IsAbs(source) {
+				source = filepath.Join(target.WorkingDir, source)
+			}
+			source = realPath(source)
+			if len(p.Mounts.AllowedSources) > 0 && !underAnyPrefix(source, p.Mounts.AllowedSources) {
+				add("resource %s mount[%d] source %s is outside the allowed mount sources", res.Name, i, source)
+			}
+			if p.Mounts.DenyRW && m.Mode == "rw" {
+				add("resource %s mount[%d] %s is read-write", res.Name, i, m.Target)
+			}
+		}
+		if len(p.HTTP) > 0 {
+			for i, rule := range set.HTTP {
+				if !hostAllowed(normalizeHost(rule.Host), p.HTTP) {
+					add("resource %s http[%d] %q is not an approved host", res.Name, i, rule.Host)
+				}
+			}
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	return &PolicyError{Path: p.path, Violations: violations}
+}
+
+// realPath resolves symlinks where the path exists so a link cannot be used
+// to escape an allowed prefix.
+func realPath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return filepath.Clean(path)
+}
+
+func underAnyPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeHost reduces an http entry to the domain the sandbox's DNS
+// allowlist forwards, which also covers its subdomains.
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	host = strings.TrimPrefix(host, "http://")
+	host = strings.TrimPrefix(host, "https://")
+	host = strings.TrimPrefix(host, "*.")
+	return strings.TrimPrefix(host, ".")
+}
+
+func hostAllowed(host string, allowed []string) bool {
+	for _, a := range allowed {
+		if host == a || strings.HasSuffix(host, "."+a) {
+			return true
+		}
+	}
+	return false
+}
+
+func imageAllowed(image string, registries []string) bool {
+	image = qualifiedImage(image)
+	for _, r := range registries {
+		r = strings.TrimSuffix(strings.TrimSpace(r), "/")
+		if image == r || strings.HasPrefix(image, r+"/") || strings.HasPrefix(image, r+":") || strings.HasPrefix(image, r+"@") {
+			return true
+		}
+	}
+	return false
+}
+
+// qualifiedImage spells out the Docker Hub registry for short image names so
+// "ubuntu" is matched as "docker.io/library/ubuntu".
+func qualifiedImage(image string) string {
+	first, rest, found := strings.Cut(image, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return image
+	}
+	if !found {
+		return "docker.io/library/" + image
+	}
+	return "docker.io/" + first + "/" + rest
+}
@@ -0,0 +1,253 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaID identifies the JSON Schema printed by `shai config schema`.
+const SchemaID = "https://github.com/colony-2/shai/schema/shai-sandbox.json"
+
+// extensionPrefix marks keys that shai ignores, as in Docker Compose, so
+// configs have somewhere to keep YAML anchors.
+const extensionPrefix = "x-"
+
+// schemaEnums lists the accepted values of string fields, keyed by Go type
+// and yaml key. For lists the values apply to each item.
+var schemaEnums = map[string][]string{
+	"Config.type":          {expectedType},
+	"Config.mcp-clients":   MCPClientNames,
+	"Mount.mode":           {"ro", "rw"},
+	"VarMapping.as":        {DeliverEnv, DeliverFile},
+	"Call.exec":            {ExecArgv, ExecShell},
+	"Call.queue":           {QueueReject, QueueWait},
+	"Call.approval":        {ApprovalNever, ApprovalOnce, ApprovalAlways},
+	"CallParam.type":       {ParamString, ParamInt, ParamBool, ParamEnum},
+	"ExposedPort.protocol": {"tcp", "udp"},
+}
+
+// JSONSchema returns a JSON Schema for shai-sandbox config files, generated
+// from the Config types so it cannot drift from what Load accepts.
+func JSONSchema() ([]byte, error) {
+	b := &schemaBuilder{defs: map[string]any{}}
+	root := b.object(reflect.TypeOf(Config{}))
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["$id"] = SchemaID
+	root["title"] = "shai sandbox config"
+	root["properties"].(map[string]any)["version"] = map[string]any{"const": CurrentVersion}
+	root["$defs"] = b.defs
+	return json.MarshalIndent(root, "", "  ")
+}
+
+type schemaBuilder struct {
+	defs map[string]any
+}
+
+var (
+	stringListType  = reflect.TypeOf(StringList{})
+	exposedPortType = reflect.TypeOf(ExposedPort{})
+	httpRuleType    = reflect.TypeOf(HTTPRule{})
+	mountListType   = reflect.TypeOf(MountList{})
+	callListType    = reflect.TypeOf(CallList{})
+)
+
+func (b *schemaBuilder) schemaFor(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case stringListType:
+		return map[string]any{"oneOf": []any{
+			map[string]any{"type": "string"},
+			map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		}}
+	case exposedPortType:
+		return map[string]any{"oneOf": []any{
+			map[string]any{"type": "integer", "minimum": 1, "maximum": 65535},
+			b.ref(t),
+		}}
+	case httpRuleType:
+		return map[string]any{"oneOf": []any{
+			map[string]any{"type": "string"},
+			b.ref(t),
+		}}
+	case mountListType, callListType:
+		return map[string]any{"type": "object", "additionalProperties": b.ref(t.Elem())}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return b.ref(t)
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schemaFor(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	default:
+		panic(fmt.Sprintf("config: no JSON Schema for %s", t))
+	}
+}
+
+func (b *schemaBuilder) ref(t reflect.Type) map[string]any {
+	if _, ok := b.defs[t.Name()]; !ok {
+		b.defs[t.Name()] = nil
+		b.defs[t.Name()] = b.object(t)
+	}
+	return map[string]any{"$ref": "#/$defs/" + t.Name()}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) map[string]any {
+	keys, fields := yamlFields(t)
+	props := make(map[string]any, len(keys))
+	for _, key := range keys {
+		prop := b.schemaFor(fields[key])
+		if values, ok := schemaEnums[t.Name()+"."+key]; ok {
+			if items, ok := prop["items"].(map[string]any); ok {
+				items["enum"] = values
+			} else {
+				prop["enum"] = values
+			}
+		}
+		props[key] = prop
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"patternProperties":    map[string]any{"^" + extensionPrefix: map[string]any{}},
+		"additionalProperties": false,
+	}
+}
+
+// yamlFields returns the yaml keys of a struct type in declaration order,
+// with the type of each.
+func yamlFields(t reflect.Type) ([]string, map[string]reflect.Type) {
+	var keys []string
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		keys = append(keys, name)
+		fields[name] = field.Type
+	}
+	return keys, fields
+}
+
+// unknownFields walks node against t and reports every mapping key that t
+// does not declare, other than x- keys, at its line and column. path names
+// node in messages, e.g. resources.base.calls[0].
+func unknownFields(node *yaml.Node, t reflect.Type, path string, at func(*yaml.Node) Source) []error {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node == nil {
+		return nil
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var problems []error
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		keys, fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" {
+				merged := []*yaml.Node{value}
+				if value.Kind == yaml.SequenceNode {
+					merged = value.Content
+				}
+				for _, m := range merged {
+					problems = append(problems, unknownFields(m, t, path, at)...)
+				}
+				continue
+			}
+			if strings.HasPrefix(key.Value, extensionPrefix) {
+				continue
+			}
+			field, ok := fields[key.Value]
+			if !ok {
+				pos := at(key)
+				pos.Column = key.Column
+				msg := fmt.Sprintf("unknown field %q", key.Value)
+				if path != "" {
+					msg += " in " + path
+				}
+				if hint := closestKey(key.Value, keys); hint != "" {
+					msg += fmt.Sprintf(" (did you mean %q?)", hint)
+				}
+				problems = append(problems, pos.errorf("%s", msg))
+				continue
+			}
+			problems = append(problems, unknownFields(value, field, joinPath(path, key.Value), at)...)
+		}
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			problems = append(problems, unknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), at)...)
+		}
+	case (t.Kind() == reflect.Map || t.Kind() == reflect.Slice) && node.Kind == yaml.MappingNode:
+		// Maps, and lists written as name-keyed mappings such as CallList.
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			problems = append(problems, unknownFields(node.Content[i+1], t.Elem(), joinPath(path, node.Content[i].Value), at)...)
+		}
+	}
+	return problems
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// closestKey suggests the known key a typo was probably meant to be: one
+// that differs only in case, dashes and underscores, or by at most two edits.
+func closestKey(key string, known []string) string {
+	normalize := func(s string) string {
+		return strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(s))
+	}
+	best, bestDist := "", 3
+	for _, candidate := range known {
+		if normalize(candidate) == normalize(key) {
+			return candidate
+		}
+		if d := editDistance(key, candidate); d < bestDist {
+			best, bestDist = candidate, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -1,17 +1,28 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 )
 
-var templateExpr = regexp.MustCompile(`\${{\s*([^}]+)\s*}}`)
+var templateExpr = regexp.MustCompile(`\${{\s*(.+?)\s*}}`)
 
+// expandTemplates replaces each ${{ expr }} in input with the value of the
+// expression. Expressions reference env.NAME, vars.NAME and conf.NAME, and
+// may use string literals, ||, &&, !, == and != and the functions in
+// templateFuncs. A reference that is not set is an error unless something
+// such as a || default takes its place.
 func expandTemplates(input string, env, vars, conf map[string]string) (string, error) {
 	if input == "" {
 		return "", nil
 	}
+	scope := templateScope{env: env, vars: vars, conf: conf}
 	var expandErr error
 	out := templateExpr.ReplaceAllStringFunc(input, func(match string) string {
 		if expandErr != nil {
@@ -22,36 +33,16 @@ func expandTemplates(input string, env, vars, conf map[string]string) (string, e
 			expandErr = fmt.Errorf("malformed template %q", match)
 			return match
 		}
-		key := strings.TrimSpace(groups[1])
-		parts := strings.SplitN(key, ".", 2)
-		if len(parts) != 2 {
-			expandErr = fmt.Errorf("template %q missing scope", match)
+		val, err := scope.eval(groups[1], match)
+		if err != nil {
+			expandErr = err
 			return match
 		}
-		scope, name := parts[0], parts[1]
-		switch scope {
-		case "env":
-			if val, ok := env[name]; ok {
-				return val
-			}
-			expandErr = fmt.Errorf("env %q not found for template %q", name, match)
-			return match
-		case "vars":
-			if val, ok := vars[name]; ok {
-				return val
-			}
-			expandErr = fmt.Errorf("var %q not found for template %q", name, match)
-			return match
-		case "conf":
-			if val, ok := conf[name]; ok {
-				return val
-			}
-			expandErr = fmt.Errorf("conf %q not found for template %q", name, match)
-			return match
-		default:
-			expandErr = fmt.Errorf("unknown template scope %q in %q", scope, match)
+		if !val.defined {
+			expandErr = fmt.Errorf("%s not found for template %q", val.missing, match)
 			return match
 		}
+		return val.s
 	})
 	if expandErr != nil {
 		return "", expandErr
@@ -61,3 +52,377 @@ func expandTemplates(input string, env, vars, conf map[string]string) (string, e
 	}
 	return out, nil
 }
+
+// evalCondition evaluates an if: expression, written bare or wrapped in
+// ${{ }}. Unset references are false rather than errors.
+func evalCondition(expr string, env, vars, conf map[string]string) (bool, error) {
+	expr = strings.TrimSpace(expr)
+	if groups := templateExpr.FindStringSubmatch(expr); groups != nil && groups[0] == expr {
+		expr = groups[1]
+	}
+	scope := templateScope{env: env, vars: vars, conf: conf}
+	val, err := scope.eval(expr, expr)
+	if err != nil {
+		return false, err
+	}
+	return val.truthy(), nil
+}
+
+// templateValue is the result of an expression. An unset reference is
+// undefined, and missing describes it for error messages.
+type templateValue struct {
+	s       string
+	defined bool
+	missing string
+}
+
+func defined(s string) templateValue {
+	return templateValue{s: s, defined: true}
+}
+
+func boolValue(b bool) templateValue {
+	return defined(fmt.Sprint(b))
+}
+
+// truthy reports whether v counts as true: it is set and is not "", "false"
+// or "0".
+func (v templateValue) truthy() bool {
+	return v.defined && v.s != "" && v.s != "false" && v.s != "0"
+}
+
+type templateScope struct {
+	env, vars, conf map[string]string
+}
+
+// templateFuncs are the functions expressions may call. Functions receive
+// their evaluated arguments.
+var templateFuncs = map[string]func(s templateScope, args []templateValue) (templateValue, error){
+	"lower": func(_ templateScope, args []templateValue) (templateValue, error) {
+		if len(args) != 1 {
+			return templateValue{}, fmt.Errorf("lower takes 1 argument, got %d", len(args))
+		}
+		if !args[0].defined {
+			return args[0], nil
+		}
+		return defined(strings.ToLower(args[0].s)), nil
+	},
+	"join": func(_ templateScope, args []templateValue) (templateValue, error) {
+		if len(args) == 0 {
+			return templateValue{}, fmt.Errorf("join needs a separator")
+		}
+		var parts []string
+		for _, arg := range args[1:] {
+			if arg.defined && arg.s != "" {
+				parts = append(parts, arg.s)
+			}
+		}
+		return defined(strings.Join(parts, args[0].s)), nil
+	},
+	"exists": func(s templateScope, args []templateValue) (templateValue, error) {
+		if len(args) != 1 {
+			return templateValue{}, fmt.Errorf("exists takes 1 argument, got %d", len(args))
+		}
+		if !args[0].defined {
+			return boolValue(false), nil
+		}
+		path, err := s.hostPath(args[0].s)
+		if err != nil {
+			return templateValue{}, err
+		}
+		_, err = os.Stat(path)
+		return boolValue(err == nil), nil
+	},
+	"sha256file": func(s templateScope, args []templateValue) (templateValue, error) {
+		if len(args) != 1 {
+			return templateValue{}, fmt.Errorf("sha256file takes 1 argument, got %d", len(args))
+		}
+		if !args[0].defined {
+			return args[0], nil
+		}
+		path, err := s.hostPath(args[0].s)
+		if err != nil {
+			return templateValue{}, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return templateValue{}, fmt.Errorf("sha256file: %w", err)
+		}
+		sum := sha256.Sum256(data)
+		return defined(hex.EncodeToString(sum[:])), nil
+	},
+	"os": func(_ templateScope, args []templateValue) (templateValue, error) {
+		if len(args) != 0 {
+			return templateValue{}, fmt.Errorf("os takes no arguments")
+		}
+		return defined(runtime.GOOS), nil
+	},
+	"arch": func(_ templateScope, args []templateValue) (templateValue, error) {
+		if len(args) != 0 {
+			return templateValue{}, fmt.Errorf("arch takes no arguments")
+		}
+		return defined(runtime.GOARCH), nil
+	},
+}
+
+// hostPath expands a leading ~/ with env.HOME. Paths must be absolute, since
+// templates are expanded before the workspace is known.
+func (s templateScope) hostPath(path string) (string, error) {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, ok := s.env["HOME"]
+		if !ok {
+			return "", fmt.Errorf("env %q not found for path %q", "HOME", path)
+		}
+		path = filepath.Join(home, rest)
+	}
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("path %q must be absolute or start with ~/", path)
+	}
+	return path, nil
+}
+
+// eval parses and evaluates a single expression. match is the text quoted
+// in errors.
+func (s templateScope) eval(expr, match string) (templateValue, error) {
+	p := &templateParser{scope: s, match: match}
+	if err := p.tokenize(expr); err != nil {
+		return templateValue{}, err
+	}
+	val, err := p.or()
+	if err != nil {
+		return templateValue{}, err
+	}
+	if tok := p.peek(); tok != "" {
+		return templateValue{}, fmt.Errorf("unexpected %q in template %q", tok, match)
+	}
+	return val, nil
+}
+
+// templateParser is a recursive descent parser over the grammar
+//
+//	or      = and { "||" and }
+//	and     = compare { "&&" compare }
+//	compare = unary [ ("==" | "!=") unary ]
+//	unary   = "!" unary | primary
+//	primary = string | "true" | "false" | scope "." name
+//	        | func "(" [ or { "," or } ] ")" | "(" or ")"
+//
+// evaluating as it goes.
+type templateParser struct {
+	scope  templateScope
+	match  string
+	tokens []string
+	pos    int
+}
+
+func (p *templateParser) tokenize(expr string) error {
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '\'' || c == '"':
+			// A quote is escaped by doubling it, as in 'it''s'.
+			j := i + 1
+			for {
+				if j >= len(expr) {
+					return fmt.Errorf("unterminated string in template %q", p.match)
+				}
+				if expr[j] == c {
+					if j+1 < len(expr) && expr[j+1] == c {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			p.tokens = append(p.tokens, expr[i:j+1])
+			i = j + 1
+		case strings.HasPrefix(expr[i:], "||"), strings.HasPrefix(expr[i:], "&&"),
+			strings.HasPrefix(expr[i:], "=="), strings.HasPrefix(expr[i:], "!="):
+			p.tokens = append(p.tokens, expr[i:i+2])
+			i += 2
+		case strings.ContainsRune("!().,", rune(c)):
+			p.tokens = append(p.tokens, string(c))
+			i++
+		case isNameByte(c):
+			j := i
+			for j < len(expr) && isNameByte(expr[j]) {
+				j++
+			}
+			p.tokens = append(p.tokens, expr[i:j])
+			i = j
+		default:
+			return fmt.Errorf("unexpected %q in template %q", string(c), p.match)
+		}
+	}
+	return nil
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func (p *templateParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *templateParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *templateParser) expect(tok string) error {
+	if got := p.next(); got != tok {
+		if got == "" {
+			got = "end of expression"
+		}
+		return fmt.Errorf("expected %q, got %q in template %q", tok, got, p.match)
+	}
+	return nil
+}
+
+func (p *templateParser) or() (templateValue, error) {
+	left, err := p.and()
+	if err != nil {
+		return left, err
+	}
+	for p.peek() == "||" {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return right, err
+		}
+		if !left.truthy() {
+			left = right
+		}
+	}
+	return left, nil
+}
+
+func (p *templateParser) and() (templateValue, error) {
+	left, err := p.compare()
+	if err != nil {
+		return left, err
+	}
+	for p.peek() == "&&" {
+		p.next()
+		right, err := p.compare()
+		if err != nil {
+			return right, err
+		}
+		if left.truthy() {
+			left = right
+		}
+	}
+	return left, nil
+}
+
+func (p *templateParser) compare() (templateValue, error) {
+	left, err := p.unary()
+	if err != nil {
+		return left, err
+	}
+	if op := p.peek(); op == "==" || op == "!=" {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return right, err
+		}
+		equal := left.defined == right.defined && left.s == right.s
+		return boolValue(equal == (op == "==")), nil
+	}
+	return left, nil
+}
+
+func (p *templateParser) unary() (templateValue, error) {
+	if p.peek() == "!" {
+		p.next()
+		val, err := p.unary()
+		if err != nil {
+			return val, err
+		}
+		return boolValue(!val.truthy()), nil
+	}
+	return p.primary()
+}
+
+func (p *templateParser) primary() (templateValue, error) {
+	tok := p.next()
+	switch {
+	case tok == "":
+		return templateValue{}, fmt.Errorf("incomplete template %q", p.match)
+	case tok == "(":
+		val, err := p.or()
+		if err != nil {
+			return val, err
+		}
+		return val, p.expect(")")
+	case tok[0] == '\'' || tok[0] == '"':
+		quote := tok[:1]
+		return defined(strings.ReplaceAll(tok[1:len(tok)-1], quote+quote, quote)), nil
+	case tok == "true" || tok == "false":
+		return defined(tok), nil
+	case !isNameByte(tok[0]):
+		return templateValue{}, fmt.Errorf("unexpected %q in template %q", tok, p.match)
+	case p.peek() == "(":
+		return p.call(tok)
+	case p.peek() != ".":
+		return templateValue{}, fmt.Errorf("template %q missing scope", p.match)
+	}
+	p.next()
+	name := p.next()
+	if name == "" || !isNameByte(name[0]) {
+		return templateValue{}, fmt.Errorf("template %q missing name after %s.", p.match, tok)
+	}
+	var values map[string]string
+	switch tok {
+	case "env":
+		values = p.scope.env
+	case "vars":
+		values = p.scope.vars
+	case "conf":
+		values = p.scope.conf
+	default:
+		return templateValue{}, fmt.Errorf("unknown template scope %q in %q", tok, p.match)
+	}
+	if val, ok := values[name]; ok {
+		return defined(val), nil
+	}
+	kind := map[string]string{"env": "env", "vars": "var", "conf": "conf"}[tok]
+	return templateValue{missing: fmt.Sprintf("%s %q", kind, name)}, nil
+}
+
+func (p *templateParser) call(name string) (templateValue, error) {
+	fn, ok := templateFuncs[name]
+	if !ok {
+		return templateValue{}, fmt.Errorf("unknown function %q in template %q", name, p.match)
+	}
+	p.next()
+	var args []templateValue
+	if p.peek() != ")" {
+		for {
+			arg, err := p.or()
+			if err != nil {
+				return arg, err
+			}
+			args = append(args, arg)
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return templateValue{}, err
+	}
+	val, err := fn(p.scope, args)
+	if err != nil {
+		return templateValue{}, fmt.Errorf("%w in template %q", err, p.match)
+	}
+	return val, nil
+}
@@ -1,8 +1,121 @@
 package shai
 
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/colony-2/shai/internal/shai/runtime/alias"
+	configpkg "github.com/colony-2/shai/internal/shai/runtime/config"
+)
+
 const (
 	// ConfigDirName is the hidden directory that stores workspace-local Shai metadata.
 	ConfigDirName = ".shai"
 	// DefaultConfigRelPath is the default relative path to the Shai config file.
 	DefaultConfigRelPath = ConfigDirName + "/config.yaml"
+	// DefaultOrgConfigPath is the machine-wide config merged underneath every
+	// workspace config.
+	DefaultOrgConfigPath = "/etc/shai/config.yaml"
+	// DefaultPolicyPath is the policy every sandbox on the machine is
+	// checked against.
+	DefaultPolicyPath = "/etc/shai/policy.yaml"
 )
+
+// AuditDir resolves where call audit logs are written: override, then
+// $SHAI_AUDIT_DIR, then alias.DefaultAuditDir.
+func AuditDir(override string) string {
+	if dir := strings.TrimSpace(override); dir != "" {
+		return dir
+	}
+	if dir := strings.TrimSpace(os.Getenv("SHAI_AUDIT_DIR")); dir != "" {
+		return dir
+	}
+	return alias.DefaultAuditDir()
+}
+
+// SandboxStateDir is where detached sandboxes keep their supervisor logs:
+// $XDG_STATE_HOME/shai/sandboxes, falling back to
+// ~/.local/state/shai/sandboxes.
+func SandboxStateDir() string {
+	return stateDir("sandboxes")
+}
+
+// OverlayStateDir is where overlay workspaces keep their pending changes:
+// $XDG_STATE_HOME/shai/overlays, falling back to
+// ~/.local/state/shai/overlays.
+func OverlayStateDir() string {
+	return stateDir("overlays")
+}
+
+func stateDir(name string) string {
+	if dir := strings.TrimSpace(os.Getenv("XDG_STATE_HOME")); dir != "" {
+		return filepath.Join(dir, "shai", name)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "shai", name)
+	}
+	return filepath.Join(home, ".local", "state", "shai", name)
+}
+
+// OrgConfigPath resolves the org config layer: $SHAI_ORG_CONFIG, then
+// DefaultOrgConfigPath.
+func OrgConfigPath() string {
+	if path := strings.TrimSpace(os.Getenv("SHAI_ORG_CONFIG")); path != "" {
+		return path
+	}
+	return DefaultOrgConfigPath
+}
+
+// UserConfigPath resolves the user config layer: $SHAI_USER_CONFIG, then
+// $XDG_CONFIG_HOME/shai/config.yaml, then ~/.config/shai/config.yaml.
+func UserConfigPath() string {
+	if path := strings.TrimSpace(os.Getenv("SHAI_USER_CONFIG")); path != "" {
+		return path
+	}
+	if dir := strings.TrimSpace(os.Getenv("XDG_CONFIG_HOME")); dir != "" {
+		return filepath.Join(dir, "shai", "config.yaml")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "shai", "config.yaml")
+}
+
+// PolicyPath resolves the policy file: override, then $SHAI_POLICY, then
+// DefaultPolicyPath.
+func PolicyPath(override string) string {
+	if path := strings.TrimSpace(override); path != "" {
+		return path
+	}
+	if path := strings.TrimSpace(os.Getenv("SHAI_POLICY")); path != "" {
+		return path
+	}
+	return DefaultPolicyPath
+}
+
+// ConfigLayers returns the layers merged underneath the workspace config,
+// lowest precedence first.
+func ConfigLayers() []configpkg.Layer {
+	return []configpkg.Layer{
+		{Name: configpkg.LayerOrg, Path: OrgConfigPath()},
+		{Name: configpkg.LayerUser, Path: UserConfigPath()},
+	}
+}
+
+// LoadConfig loads the layered config for a workspace. configFile overrides
+// the workspace layer's path, which defaults to DefaultConfigRelPath under
+// workingDir.
+func LoadConfig(workingDir, configFile string, vars map[string]string) (*configpkg.Config, error) {
+	cfg, _, err := configpkg.LoadLayered(ConfigLayers(), workspaceConfigPath(workingDir, configFile), hostEnvMap(), vars)
+	return cfg, err
+}
+
+func workspaceConfigPath(workingDir, configFile string) string {
+	if configFile == "" {
+		return filepath.Join(workingDir, DefaultConfigRelPath)
+	}
+	return configFile
+}
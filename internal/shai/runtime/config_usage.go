@@ -83,10 +83,39 @@ func callEntriesFromResources(resources []*configpkg.ResolvedResource) ([]*alias
 			if seen[callDef.Name] {
 				continue
 			}
-			entry, err := alias.NewEntry(callDef.Name, callDef.Description, callDef.Command, callDef.AllowedArgs)
+			var (
+				entry *alias.Entry
+				err   error
+			)
+			switch callDef.ExecMode() {
+			case configpkg.ExecShell:
+				entry, err = alias.NewEntry(callDef.Name, callDef.Description, callDef.Command, callDef.AllowedArgs)
+			default:
+				var argv []string
+				argv, err = callDef.Argv()
+				if err == nil {
+					entry, err = alias.NewArgvEntry(callDef.Name, callDef.Description, argv, callDef.AllowedArgs)
+				}
+			}
+			if err == nil {
+				err = entry.SetParams(aliasParams(callDef.Params))
+			}
+			if err == nil {
+				err = entry.SetFiles(aliasFiles(callDef.Inputs), aliasFiles(callDef.Outputs))
+			}
 			if err != nil {
 				return nil, fmt.Errorf("invalid call %q: %w", callDef.Name, err)
 			}
+			entry.MaxOutputBytes = callDef.MaxOutput
+			entry.Timeout = callDef.TimeoutDuration()
+			entry.MaxConcurrent = callDef.MaxConcurrent
+			entry.Queue = callDef.Queue == configpkg.QueueWait
+			entry.RatePerMinute = callDef.Rate
+			entry.Approval = alias.ApprovalMode(callDef.Approval)
+			entry.Dir = callDef.Cwd
+			entry.Env = callDef.Env
+			entry.CleanEnv = !callDef.InheritsEnv()
+			entry.EnvAllow = callDef.EnvAllow
 			entries = append(entries, entry)
 			seen[callDef.Name] = true
 		}
@@ -94,6 +123,43 @@ func callEntriesFromResources(resources []*configpkg.ResolvedResource) ([]*alias
 	return entries, nil
 }
 
+func aliasParams(params []configpkg.CallParam) []alias.Param {
+	if len(params) == 0 {
+		return nil
+	}
+	out := make([]alias.Param, 0, len(params))
+	for _, p := range params {
+		out = append(out, alias.Param{
+			Name:        p.Name,
+			Type:        p.Type,
+			Description: p.Description,
+			Required:    p.Required,
+			Pattern:     p.Pattern,
+			Values:      p.Values,
+			Arg:         p.Arg,
+		})
+	}
+	return out
+}
+
+func aliasFiles(files []configpkg.CallFile) []alias.FileParam {
+	if len(files) == 0 {
+		return nil
+	}
+	out := make([]alias.FileParam, 0, len(files))
+	for _, f := range files {
+		out = append(out, alias.FileParam{
+			Name:        f.Name,
+			Description: f.Description,
+			Filename:    f.Filename,
+			Required:    f.Required,
+			MaxBytes:    f.MaxSize,
+			Arg:         f.Arg,
+		})
+	}
+	return out
+}
+
 func selectImageOverride(cfg *configpkg.Config, orderedPaths []string) string {
 	if cfg == nil {
 		return ""
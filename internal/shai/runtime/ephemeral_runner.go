@@ -15,6 +15,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -54,14 +55,52 @@ type EphemeralConfig struct {
 	HostGID             string
 	Privileged          bool
 	ShowProgress        bool
+	// ApprovalHook is a shell command consulted for calls that require
+	// approval; exit status 0 approves.
+	ApprovalHook string
+	// ApprovalAllowlist names a file of pre-approved calls.
+	ApprovalAllowlist string
+	// AuditDir receives the per-session call audit log. Empty falls
+	// back to AuditDir's defaults.
+	AuditDir string
+	// PolicyFile names the policy the sandbox must satisfy. Empty falls
+	// back to PolicyPath's defaults; a missing file means no policy.
+	PolicyFile string
+	// Trust decides whether to run a workspace config that is not in the
+	// trust store. Nil refuses such configs with an *UntrustedConfigError.
+	Trust func(TrustRequest) TrustDecision
+	// SecretProviders replaces the built-in providers for vars that read
+	// from file, command or keyring, keyed by that field name.
+	SecretProviders map[string]SecretProvider
+	// Name names the sandbox so it can be found with AttachSandbox and
+	// RemoveSandbox. Unnamed sandboxes get a random container name.
+	Name string
+	// Detach starts the container without attaching the terminal. Run
+	// then serves host calls until the container exits, and the stopped
+	// container is kept until RemoveSandbox. Detach requires a Name.
+	Detach bool
+	// Version identifies the shai build starting the sandbox, for
+	// ListSandboxes.
+	Version string
+	// Overlay makes the whole workspace writable through an overlayfs whose
+	// changes collect in OverlayDir instead of the workspace, for review
+	// with WorkspaceChanges before ApplyWorkspaceChanges. It can't be
+	// combined with ReadWritePaths.
+	Overlay bool
 }
 
-// ExecSpec describes a command to run post-setup.
+// ExecSpec describes a command to run post-setup, or in a running sandbox
+// with ExecSandbox or Session.Exec.
 type ExecSpec struct {
 	Command []string
 	Env     map[string]string
 	Workdir string
 	UseTTY  bool
+	// Stdin, Stdout and Stderr connect a command started with Exec; nil
+	// uses the process's own. They are not used for PostSetupExec.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
 }
 
 // EphemeralRunner launches ephemeral containers using .shai/config.yaml.
@@ -75,6 +114,7 @@ type EphemeralRunner struct {
 	docker             *client.Client
 	mountBuilder       *MountBuilder
 	aliasSvc           *alias.Service
+	approver           *terminalApprover
 	currentContainerID string
 	hostEnv            map[string]string
 	hostUID            string
@@ -82,6 +122,7 @@ type EphemeralRunner struct {
 	bootstrapDir       string
 	bootstrapMount     string
 	dockerHostAddr     string
+	secretProviders    map[string]SecretProvider
 }
 
 func (r *EphemeralRunner) workspaceDir() string {
@@ -107,6 +148,13 @@ func NewEphemeralRunner(cfg EphemeralConfig) (*EphemeralRunner, error) {
 	if !cfg.Verbose && os.Getenv("SHAI_FORCE_VERBOSE") == "1" {
 		cfg.Verbose = true
 	}
+	if cfg.Name != "" {
+		if err := ValidateSandboxName(cfg.Name); err != nil {
+			return nil, err
+		}
+	} else if cfg.Detach {
+		return nil, errors.New("a detached sandbox needs a name")
+	}
 
 	hostEnv := hostEnvMap()
 	if strings.TrimSpace(cfg.HostUID) == "" || strings.TrimSpace(cfg.HostGID) == "" {
@@ -118,24 +166,27 @@ func NewEphemeralRunner(cfg EphemeralConfig) (*EphemeralRunner, error) {
 			cfg.HostGID = gid
 		}
 	}
-	configPath := cfg.ConfigFile
-	if configPath == "" {
-		configPath = filepath.Join(cfg.WorkingDir, DefaultConfigRelPath)
-	}
-	shaiCfg, _, err := configpkg.LoadOrDefault(configPath, hostEnv, cfg.TemplateVars)
+	shaiCfg, err := LoadConfig(cfg.WorkingDir, cfg.ConfigFile, cfg.TemplateVars)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load shai config: %w", err)
 	}
-
-	dockerClient, err := newDockerClient()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create docker client: %w", err)
+	for _, warning := range shaiCfg.Warnings() {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
 	}
 
 	mountBuilder, err := NewMountBuilder(cfg.WorkingDir, cfg.ReadWritePaths)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create mount builder: %w", err)
 	}
+	if cfg.Overlay {
+		dir, err := OverlayDir(cfg.WorkingDir)
+		if err != nil {
+			return nil, err
+		}
+		if err := mountBuilder.UseOverlay(dir); err != nil {
+			return nil, err
+		}
+	}
 
 	workspace := effectiveWorkspace(shaiCfg.Workspace, mountBuilder.ReadWritePaths)
 	shaiCfg.Workspace = workspace
@@ -144,10 +195,42 @@ func NewEphemeralRunner(cfg EphemeralConfig) (*EphemeralRunner, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve resources: %w", err)
 	}
+	image, imageSource := chooseImage(shaiCfg.Image, cfg.ImageOverride, applyImageOverride)
+	policy, err := configpkg.LoadPolicy(PolicyPath(cfg.PolicyFile), hostEnv)
+	if err != nil {
+		return nil, err
+	}
+	err = policy.Check(configpkg.PolicyTarget{
+		Image:      image,
+		Privileged: cfg.Privileged,
+		Resources:  resources,
+		WorkingDir: cfg.WorkingDir,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyTrust(workspaceConfigPath(cfg.WorkingDir, cfg.ConfigFile), shaiCfg, cfg.Trust); err != nil {
+		return nil, err
+	}
 	callEntries, err := callEntriesFromResources(resources)
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve calls: %w", err)
 	}
+	providers, err := secretProviders(cfg.WorkingDir, hostEnv, cfg.SecretProviders)
+	if err != nil {
+		return nil, err
+	}
+
+	dockerClient, err := newDockerClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create docker client: %w", err)
+	}
+
+	prompt := newTerminalApprover()
+	approver, err := buildApprover(cfg, prompt)
+	if err != nil {
+		return nil, err
+	}
 
 	mcpBindAddr := getMCPServerBindAddr(context.Background(), dockerClient)
 	dockerHostAddr := getDockerHostAddress()
@@ -158,12 +241,16 @@ func NewEphemeralRunner(cfg EphemeralConfig) (*EphemeralRunner, error) {
 		Entries:        callEntries,
 		DockerHostAddr: dockerHostAddr,
 		MCPBindAddr:    mcpBindAddr,
+		Approver:       approver,
+		AuditDir:       AuditDir(cfg.AuditDir),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize alias service: %w", err)
 	}
+	if cfg.Verbose && aliasSvc.AuditPath() != "" {
+		fmt.Fprintf(os.Stderr, "shai: recording calls to %s\n", aliasSvc.AuditPath())
+	}
 
-	image, imageSource := chooseImage(shaiCfg.Image, cfg.ImageOverride, applyImageOverride)
 	if cfg.Verbose {
 		switch imageSource {
 		case "cli":
@@ -174,19 +261,21 @@ func NewEphemeralRunner(cfg EphemeralConfig) (*EphemeralRunner, error) {
 	}
 
 	runner := &EphemeralRunner{
-		config:         cfg,
-		shaiConfig:     shaiCfg,
-		resources:      resources,
-		resourceNames:  resourceNames,
-		image:          image,
-		workspace:      workspace,
-		docker:         dockerClient,
-		mountBuilder:   mountBuilder,
-		aliasSvc:       aliasSvc,
-		hostEnv:        hostEnv,
-		hostUID:        cfg.HostUID,
-		hostGID:        cfg.HostGID,
-		dockerHostAddr: dockerHostAddr,
+		config:          cfg,
+		shaiConfig:      shaiCfg,
+		resources:       resources,
+		resourceNames:   resourceNames,
+		image:           image,
+		workspace:       workspace,
+		docker:          dockerClient,
+		mountBuilder:    mountBuilder,
+		aliasSvc:        aliasSvc,
+		approver:        prompt,
+		hostEnv:         hostEnv,
+		hostUID:         cfg.HostUID,
+		hostGID:         cfg.HostGID,
+		dockerHostAddr:  dockerHostAddr,
+		secretProviders: providers,
 	}
 	if cfg.Verbose {
 		if len(resourceNames) > 0 {
@@ -208,6 +297,12 @@ func (r *EphemeralRunner) Run(ctx context.Context) error {
 func (r *EphemeralRunner) Start(ctx context.Context) (*Session, error) {
 	useTTY := r.shouldUseTTY()
 
+	// Pull before the creation timeout starts, so a slow pull isn't taken
+	// for a hung create.
+	if err := r.ensureImage(ctx, r.image); err != nil {
+		return nil, err
+	}
+
 	sctx, cancel := context.WithCancel(ctx)
 	done := make(chan error, 1)
 	idCh := make(chan string, 1)
@@ -271,7 +366,28 @@ func (r *EphemeralRunner) runEphemeralContainer(ctx context.Context, useTTY bool
 
 func (r *EphemeralRunner) runEphemeralContainerWithID(ctx context.Context, useTTY bool, idCh chan<- string) error {
 	containerName := generateContainerName()
+	if r.config.Name != "" {
+		if _, err := findSandbox(ctx, r.docker, r.config.Name); err == nil {
+			return fmt.Errorf("sandbox %s already exists; attach with `shai attach %s` or remove it with `shai rm %s`", r.config.Name, r.config.Name, r.config.Name)
+		}
+		containerName = sandboxContainerName(r.config.Name)
+	}
+	if dir := r.mountBuilder.OverlayDir; dir != "" {
+		user, err := overlayUser(ctx, r.docker, dir)
+		if err != nil {
+			return err
+		}
+		if user != "" {
+			return fmt.Errorf("sandbox %s is already using this workspace's overlay", user)
+		}
+		if err := prepareOverlayDir(r.mountBuilder.WorkingDir, dir); err != nil {
+			return err
+		}
+	}
 
+	if err := r.writeSecrets(ctx); err != nil {
+		return err
+	}
 	containerCfg, hostCfg, err := r.buildDockerConfigs(useTTY, containerName)
 	if err != nil {
 		return err
@@ -288,6 +404,9 @@ func (r *EphemeralRunner) runEphemeralContainerWithID(ctx context.Context, useTT
 	r.currentContainerID = resp.ID
 
 	if err := r.docker.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		if r.mountBuilder.OverlayDir != "" {
+			return fmt.Errorf("start container: %w (overlay workspaces need a Docker daemon that can mount overlayfs over the workspace)", err)
+		}
 		return fmt.Errorf("start container: %w", err)
 	}
 
@@ -296,36 +415,79 @@ func (r *EphemeralRunner) runEphemeralContainerWithID(ctx context.Context, useTT
 	default:
 	}
 
+	if r.config.Detach {
+		return waitContainer(ctx, r.docker, resp.ID)
+	}
+	return attachContainer(ctx, r.docker, resp.ID, attachSpec{
+		useTTY:   useTTY,
+		marker:   r.buildStartMarker(),
+		stdout:   r.config.Stdout,
+		stderr:   r.config.Stderr,
+		approver: r.approver,
+	})
+}
+
+// attachSpec configures attachContainer.
+type attachSpec struct {
+	useTTY bool
+	// marker is printed by the bootstrap when the user's command starts;
+	// Ctrl-C only reaches the container after it. started skips the wait
+	// when reattaching to a sandbox that is already past it.
+	marker  string
+	started bool
+	stdout  io.Writer
+	stderr  io.Writer
+	// approver, when set, borrows stdin for call approval prompts.
+	approver *terminalApprover
+	// detachable returns as soon as the stream ends while the container
+	// keeps running, as it does after the detach keys.
+	detachable bool
+}
+
+// attachContainer connects the terminal to a started container and blocks
+// until it exits.
+func attachContainer(ctx context.Context, docker *client.Client, id string, spec attachSpec) error {
 	attachOpts := container.AttachOptions{
 		Stream: true,
 		Stdin:  true,
 		Stdout: true,
 		Stderr: true,
 	}
-	hijacked, err := r.docker.ContainerAttach(ctx, resp.ID, attachOpts)
+	hijacked, err := docker.ContainerAttach(ctx, id, attachOpts)
 	if err != nil {
 		return fmt.Errorf("attach container: %w", err)
 	}
 	defer hijacked.Close()
+	oom := watchOOM(ctx, docker, id)
+	defer oom.Stop()
 
 	stdinFD := os.Stdin.Fd()
-	interactiveTTY := useTTY && term.IsTerminal(stdinFD)
+	interactiveTTY := spec.useTTY && term.IsTerminal(stdinFD)
 
 	var resizeStop func()
 	if interactiveTTY {
 		if st, err := term.MakeRaw(stdinFD); err == nil {
 			defer term.RestoreTerminal(stdinFD, st)
 		}
-		resizeStop = r.startTTYResizeWatcher(ctx, stdinFD, resp.ID)
+		resizeStop = watchTTYResize(ctx, stdinFD, func(size container.ResizeOptions) {
+			_ = docker.ContainerResize(context.Background(), id, size)
+		})
 	}
 	if resizeStop != nil {
 		defer resizeStop()
 	}
 
+	// Approval prompts borrow stdin from the container while they are open,
+	// so they sit in front of the Ctrl-C filter.
+	approvalIn := newApprovalInput(os.Stdin)
+	if spec.approver != nil && term.IsTerminal(stdinFD) {
+		defer spec.approver.attach(approvalIn, os.Stderr, interactiveTTY)()
+	}
+
 	var ctrlFilter *ctrlCFilter
-	stdinReader := io.Reader(os.Stdin)
+	stdinReader := io.Reader(approvalIn)
 	if interactiveTTY {
-		ctrlFilter = newCtrlCFilter(os.Stdin)
+		ctrlFilter = newCtrlCFilter(approvalIn)
 		stdinReader = ctrlFilter
 	}
 
@@ -333,6 +495,9 @@ func (r *EphemeralRunner) runEphemeralContainerWithID(ctx context.Context, useTT
 	if ctrlFilter != nil {
 		enableCtrlC = ctrlFilter.Enable
 		defer ctrlFilter.Enable()
+		if spec.started {
+			ctrlFilter.Enable()
+		}
 	}
 
 	errCh := make(chan error, 2)
@@ -341,46 +506,59 @@ func (r *EphemeralRunner) runEphemeralContainerWithID(ctx context.Context, useTT
 		errCh <- err
 	}()
 
-	startMarker := r.buildStartMarker()
+	// started tells a failed bootstrap from a failed command.
+	var started atomic.Bool
+	started.Store(spec.started)
+	onStart := func() {
+		started.Store(true)
+		enableCtrlC()
+	}
+
+	startMarker := spec.marker
+	outDone := make(chan struct{})
+	outputDone := func(err error) {
+		errCh <- err
+		close(outDone)
+	}
 
 	if interactiveTTY {
-		writer := newExecStartDetector(os.Stdout, startMarker, enableCtrlC)
+		writer := newExecStartDetector(os.Stdout, startMarker, onStart)
 		go func() {
 			_, err := io.Copy(writer, hijacked.Conn)
 			if closeErr := writer.Close(); err == nil {
 				err = closeErr
 			}
-			errCh <- err
+			outputDone(err)
 		}()
-	} else if useTTY {
-		writer := newExecStartDetector(os.Stdout, startMarker, nil)
+	} else if spec.useTTY {
+		writer := newExecStartDetector(os.Stdout, startMarker, onStart)
 		go func() {
 			_, err := io.Copy(writer, hijacked.Conn)
 			if closeErr := writer.Close(); err == nil {
 				err = closeErr
 			}
-			errCh <- err
+			outputDone(err)
 		}()
 	} else {
 		go func() {
-			stdout := r.config.Stdout
+			stdout := spec.stdout
 			if stdout == nil {
 				stdout = os.Stdout
 			}
-			stderr := r.config.Stderr
+			stderr := spec.stderr
 			if stderr == nil {
 				stderr = os.Stderr
 			}
-			writer := newExecStartDetector(stdout, startMarker, nil)
+			writer := newExecStartDetector(stdout, startMarker, onStart)
 			_, err := stdcopy.StdCopy(writer, stderr, hijacked.Reader)
 			if closeErr := writer.Close(); err == nil {
 				err = closeErr
 			}
-			errCh <- err
+			outputDone(err)
 		}()
 	}
 
-	waitCh, errChWait := r.docker.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	waitCh, errChWait := docker.ContainerWait(ctx, id, container.WaitConditionNotRunning)
 	select {
 	case err := <-errCh:
 		if err != nil && !errors.Is(err, io.EOF) {
@@ -389,6 +567,21 @@ func (r *EphemeralRunner) runEphemeralContainerWithID(ctx context.Context, useTT
 	default:
 	}
 
+	if spec.detachable {
+		select {
+		case <-outDone:
+			if info, err := docker.ContainerInspect(ctx, id); err == nil && info.State != nil && info.State.Running {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errChWait:
+			return err
+		case status := <-waitCh:
+			return exitStatusError(status, started.Load(), oom)
+		}
+	}
+
 	var status container.WaitResponse
 	select {
 	case <-ctx.Done():
@@ -401,13 +594,40 @@ func (r *EphemeralRunner) runEphemeralContainerWithID(ctx context.Context, useTT
 	case status = <-waitCh:
 	}
 
+	return exitStatusError(status, started.Load(), oom)
+}
+
+// waitContainer blocks until the container stops. Nothing reads its output
+// meanwhile, so whether the bootstrap finished comes from its logs, which
+// a detached container keeps.
+func waitContainer(ctx context.Context, docker *client.Client, id string) error {
+	oom := watchOOM(ctx, docker, id)
+	defer oom.Stop()
+	waitCh, errCh := docker.ContainerWait(ctx, id, container.WaitConditionNotRunning)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	case status := <-waitCh:
+		started := true
+		if status.StatusCode == bootstrapExitCode {
+			started, _ = bootstrapStarted(ctx, docker, id)
+		}
+		return exitStatusError(status, started, oom)
+	}
+}
+
+// exitStatusError turns the container's exit into an *ExitError, or nil
+// when it succeeded.
+func exitStatusError(status container.WaitResponse, started bool, oom *oomWatch) error {
 	if status.Error != nil {
 		return errors.New(status.Error.Message)
 	}
-	if status.StatusCode != 0 {
-		return fmt.Errorf("container exited with status %d", status.StatusCode)
+	if status.StatusCode == 0 {
+		return nil
 	}
-	return nil
+	return classifyExit(int(status.StatusCode), started, oom.Killed())
 }
 
 const (
@@ -497,6 +717,7 @@ func (r *EphemeralRunner) buildDockerConfigs(useTTY bool, containerName string)
 		OpenStdin:    true,
 		Env:          env,
 		ExposedPorts: portSet,
+		Labels:       r.containerLabels(),
 	}
 
 	mounts := r.mountBuilder.BuildMounts()
@@ -516,16 +737,47 @@ func (r *EphemeralRunner) buildDockerConfigs(useTTY bool, containerName string)
 	privileged := r.config.Privileged || r.hasPrivilegedResource()
 
 	hostCfg := &container.HostConfig{
-		AutoRemove:   true,
+		AutoRemove:   !r.config.Detach,
 		Mounts:       mounts,
 		ExtraHosts:   []string{fmt.Sprintf("%s:host-gateway", r.dockerHostAddr)},
 		CapAdd:       []string{"NET_ADMIN"},
 		Privileged:   privileged,
 		PortBindings: portBindings,
 	}
+	if r.hasSecretFiles() {
+		hostCfg.Tmpfs = map[string]string{configpkg.SecretsDir: "mode=0700,noexec,nosuid,nodev"}
+	}
 	return cfg, hostCfg, nil
 }
 
+// containerLabels identifies the container as a shai sandbox and records
+// how it was started, for ListSandboxes and the commands that find a
+// sandbox by name.
+func (r *EphemeralRunner) containerLabels() map[string]string {
+	workspace := r.config.WorkingDir
+	if abs, err := filepath.Abs(workspace); err == nil {
+		workspace = abs
+	}
+	labels := map[string]string{
+		LabelSandbox:      "true",
+		LabelBootstrapDir: r.bootstrapDir,
+		LabelWorkspace:    workspace,
+		LabelReadWrite:    listLabel(r.mountBuilder.ReadWritePaths),
+		LabelResourceSets: listLabel(r.resourceNames),
+		LabelImage:        r.image,
+	}
+	if r.config.Name != "" {
+		labels[LabelName] = r.config.Name
+	}
+	if r.config.Version != "" {
+		labels[LabelVersion] = r.config.Version
+	}
+	if r.mountBuilder.OverlayDir != "" {
+		labels[LabelOverlay] = r.mountBuilder.OverlayDir
+	}
+	return labels
+}
+
 // hasPrivilegedResource checks if any active resource set has options.privileged:true
 func (r *EphemeralRunner) hasPrivilegedResource() bool {
 	for _, res := range r.resources {
@@ -536,8 +788,23 @@ func (r *EphemeralRunner) hasPrivilegedResource() bool {
 	return false
 }
 
+// hasSecretFiles reports whether any active var is delivered as a file.
+func (r *EphemeralRunner) hasSecretFiles() bool {
+	for _, res := range r.resources {
+		if res == nil || res.Spec == nil {
+			continue
+		}
+		for _, vm := range res.Spec.Vars {
+			if vm.As == configpkg.DeliverFile {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (r *EphemeralRunner) buildBootstrapArgs() ([]string, error) {
-	envMap, err := r.collectEnvMappings()
+	envMap, secrets, err := r.collectEnvMappings()
 	if err != nil {
 		return nil, err
 	}
@@ -573,6 +840,14 @@ func (r *EphemeralRunner) buildBootstrapArgs() ([]string, error) {
 	for _, pair := range orderedKeyValuePairs(envMap) {
 		args = append(args, "--exec-env", pair)
 	}
+	// Only names: the values reach the bootstrap through its mount.
+	for _, vm := range secrets {
+		if vm.As == configpkg.DeliverFile {
+			args = append(args, "--secret-file", vm.Name())
+		} else {
+			args = append(args, "--secret-env", vm.Name())
+		}
+	}
 
 	if exec != nil && len(exec.Command) > 0 {
 		for _, arg := range exec.Command {
@@ -602,6 +877,13 @@ func (r *EphemeralRunner) buildBootstrapArgs() ([]string, error) {
 		args = append(args, "--expose", portSpec)
 	}
 
+	// Agent configs only make sense when there are host calls to publish.
+	if r.hasCalls() {
+		for _, client := range r.shaiConfig.MCPClients {
+			args = append(args, "--mcp-client", client)
+		}
+	}
+
 	if r.config.Verbose {
 		args = append(args, "--verbose")
 	}
@@ -609,34 +891,56 @@ func (r *EphemeralRunner) buildBootstrapArgs() ([]string, error) {
 	return args, nil
 }
 
-func (r *EphemeralRunner) collectEnvMappings() (map[string]string, error) {
+// hasCalls reports whether any selected resource set publishes host calls.
+func (r *EphemeralRunner) hasCalls() bool {
+	for _, res := range r.resources {
+		if res != nil && res.Spec != nil && len(res.Spec.Calls) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// collectEnvMappings returns the host env vars passed to the bootstrap as
+// arguments, and the hidden vars, in order, whose values must not be. A
+// later var for the same name replaces an earlier one.
+func (r *EphemeralRunner) collectEnvMappings() (map[string]string, []configpkg.VarMapping, error) {
 	envs := map[string]string{}
+	var secrets []configpkg.VarMapping
 	for _, res := range r.resources {
 		if res == nil || res.Spec == nil {
 			continue
 		}
 		for _, vm := range res.Spec.Vars {
+			target := vm.Name()
+			delete(envs, target)
+			secrets = slices.DeleteFunc(secrets, func(s configpkg.VarMapping) bool { return s.Name() == target })
+			if _, _, ok := vm.Secret(); ok {
+				secrets = append(secrets, vm)
+				continue
+			}
 			source := strings.TrimSpace(vm.Source)
 			if source == "" {
-				return nil, errors.New("vars entry missing source")
+				return nil, nil, errors.New("vars entry missing source")
 			}
 			value, ok := r.hostEnv[source]
 			if !ok {
-				return nil, fmt.Errorf("host env %q not set", source)
+				return nil, nil, fmt.Errorf("host env %q not set", source)
 			}
-			target := strings.TrimSpace(vm.Target)
-			if target == "" {
-				target = source
+			if vm.Hidden() {
+				secrets = append(secrets, vm)
+				continue
 			}
 			envs[target] = value
 		}
 	}
-	return envs, nil
+	return envs, secrets, nil
 }
 
 func (r *EphemeralRunner) resourceMounts() ([]mount.Mount, error) {
 	var mounts []mount.Mount
 	var skippedMounts []string
+	seenTargets := make(map[string]int)
 	for _, res := range r.resources {
 		if res == nil || res.Spec == nil {
 			continue
@@ -653,12 +957,20 @@ func (r *EphemeralRunner) resourceMounts() ([]mount.Mount, error) {
 				}
 				return nil, fmt.Errorf("resource mount %s: %w", source, err)
 			}
-			mounts = append(mounts, mount.Mount{
+			bind := mount.Mount{
 				Type:     mount.TypeBind,
 				Source:   source,
 				Target:   m.Target,
 				ReadOnly: m.Mode != "rw",
-			})
+			}
+			// A set and one it extends can both apply; the later mount
+			// for a target wins rather than failing as a duplicate.
+			if i, ok := seenTargets[m.Target]; ok {
+				mounts[i] = bind
+				continue
+			}
+			seenTargets[m.Target] = len(mounts)
+			mounts = append(mounts, bind)
 		}
 	}
 
@@ -830,19 +1142,21 @@ func (d *execStartDetector) Close() error {
 	return nil
 }
 
-func (r *EphemeralRunner) startTTYResizeWatcher(ctx context.Context, fd uintptr, containerID string) func() {
+// watchTTYResize calls resize with the size of the terminal on fd now and
+// whenever it changes, until the returned function is called.
+func watchTTYResize(ctx context.Context, fd uintptr, resize func(container.ResizeOptions)) func() {
 	if !term.IsTerminal(fd) {
 		return nil
 	}
-	resize := func() {
+	update := func() {
 		if ws, err := term.GetWinsize(fd); err == nil && ws != nil {
-			_ = r.docker.ContainerResize(context.Background(), containerID, container.ResizeOptions{
+			resize(container.ResizeOptions{
 				Height: uint(ws.Height),
 				Width:  uint(ws.Width),
 			})
 		}
 	}
-	resize()
+	update()
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGWINCH)
@@ -857,7 +1171,7 @@ func (r *EphemeralRunner) startTTYResizeWatcher(ctx context.Context, fd uintptr,
 			case <-done:
 				return
 			case <-sigCh:
-				resize()
+				update()
 			}
 		}
 	}()
@@ -890,7 +1204,7 @@ func uniqueHTTPHosts(resources []*configpkg.ResolvedResource) []string {
 		if res == nil || res.Spec == nil {
 			continue
 		}
-		for _, host := range res.Spec.HTTP {
+		for _, host := range res.Spec.Hosts() {
 			trimmed := strings.TrimSpace(host)
 			if trimmed == "" || seen[trimmed] {
 				continue
@@ -910,7 +1224,7 @@ func uniquePortEntries(resources []*configpkg.ResolvedResource) []string {
 		if res == nil || res.Spec == nil {
 			continue
 		}
-		for _, p := range res.Spec.Ports {
+		for _, p := range res.Spec.Ports() {
 			host := strings.TrimSpace(p.Host)
 			if host == "" || p.Port == 0 {
 				continue
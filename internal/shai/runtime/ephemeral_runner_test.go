@@ -5,11 +5,13 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/colony-2/shai/internal/shai/runtime/alias"
 	configpkg "github.com/colony-2/shai/internal/shai/runtime/config"
 	"github.com/stretchr/testify/require"
 )
 
 func TestBuildBootstrapArgsIncludesResources(t *testing.T) {
+	noProxy := false
 	runner := &EphemeralRunner{
 		shaiConfig: &configpkg.Config{
 			User:      "shai",
@@ -22,9 +24,9 @@ func TestBuildBootstrapArgsIncludesResources(t *testing.T) {
 					Vars: []configpkg.VarMapping{
 						{Source: "TOKEN", Target: "INSIDE_TOKEN"},
 					},
-					HTTP: []string{"example.com"},
-					Ports: []configpkg.Port{
-						{Host: "github.com", Port: 443},
+					HTTP: []configpkg.HTTPRule{
+						{Host: "example.com"},
+						{Host: "github.com", Ports: []int{443}, Proxy: &noProxy},
 					},
 				},
 			},
@@ -116,6 +118,31 @@ func TestBuildBootstrapArgsIncludesExposedPorts(t *testing.T) {
 	require.Contains(t, args, "9000:9090/udp")
 }
 
+func TestBuildBootstrapArgsIncludesMCPClients(t *testing.T) {
+	runner := &EphemeralRunner{
+		shaiConfig: &configpkg.Config{
+			User:       "shai",
+			Workspace:  "/src",
+			MCPClients: []string{"claude", "codex"},
+		},
+		hostEnv: map[string]string{},
+	}
+
+	// The alias service always runs, so it says nothing about calls.
+	runner.aliasSvc = &alias.Service{}
+	args, err := runner.buildBootstrapArgs()
+	require.NoError(t, err)
+	require.NotContains(t, args, "--mcp-client", "no calls means nothing to register")
+
+	runner.resources = []*configpkg.ResolvedResource{
+		{Name: "empty", Spec: &configpkg.ResourceSet{}},
+		{Name: "tools", Spec: &configpkg.ResourceSet{Calls: []configpkg.Call{{Name: "build", Command: "make build"}}}},
+	}
+	args, err = runner.buildBootstrapArgs()
+	require.NoError(t, err)
+	require.Equal(t, []string{"--mcp-client", "claude", "--mcp-client", "codex"}, args[len(args)-4:])
+}
+
 func TestChooseImagePrecedence(t *testing.T) {
 	img, src := chooseImage("base", "cli-override", "apply-override")
 	require.Equal(t, "cli-override", img)
@@ -161,6 +188,27 @@ func TestResourceMountsSkipMissingPaths(t *testing.T) {
 	require.False(t, mounts[0].ReadOnly)
 }
 
+func TestResourceMountsLaterTargetWins(t *testing.T) {
+	tDir := t.TempDir()
+	for _, name := range []string{"parent", "child"} {
+		require.NoError(t, os.Mkdir(filepath.Join(tDir, name), 0o755))
+	}
+
+	runner := &EphemeralRunner{
+		config: EphemeralConfig{WorkingDir: tDir},
+		resources: []*configpkg.ResolvedResource{
+			{Name: "base", Spec: &configpkg.ResourceSet{Mounts: []configpkg.Mount{{Source: "parent", Target: "/data", Mode: "ro"}}}},
+			{Name: "repo", Spec: &configpkg.ResourceSet{Mounts: []configpkg.Mount{{Source: "child", Target: "/data", Mode: "rw"}}}},
+		},
+	}
+
+	mounts, err := runner.resourceMounts()
+	require.NoError(t, err)
+	require.Len(t, mounts, 1)
+	require.Equal(t, filepath.Join(tDir, "child"), mounts[0].Source)
+	require.False(t, mounts[0].ReadOnly)
+}
+
 func TestEffectiveWorkspaceSinglePath(t *testing.T) {
 	require.Equal(t, "/src/cmd", effectiveWorkspace("/src", []string{"cmd"}))
 	require.Equal(t, "/src", effectiveWorkspace("/src", []string{"."}))
@@ -344,3 +392,39 @@ func TestBuildDockerConfigsNoPortsWhenEmpty(t *testing.T) {
 	require.Nil(t, cfg.ExposedPorts)
 	require.Nil(t, hostCfg.PortBindings)
 }
+
+func TestNewEphemeralRunnerRejectsPolicyViolations(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ConfigDirName), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, DefaultConfigRelPath), []byte(`
+type: shai-sandbox
+version: 1
+image: example/image
+resources:
+  base:
+    options:
+      privileged: true
+    root-commands: ["apt-get install -y jq"]
+apply:
+  - path: ./
+    resources: [base]
+`), 0o644))
+	policyPath := filepath.Join(dir, "policy.yaml")
+	require.NoError(t, os.WriteFile(policyPath, []byte(`
+type: shai-policy
+version: 1
+deny:
+  privileged: true
+  root-commands: true
+`), 0o644))
+	t.Setenv("SHAI_ORG_CONFIG", filepath.Join(dir, "missing.yaml"))
+	t.Setenv("SHAI_USER_CONFIG", filepath.Join(dir, "missing.yaml"))
+
+	_, err := NewEphemeralRunner(EphemeralConfig{WorkingDir: dir, PolicyFile: policyPath})
+	var policyErr *configpkg.PolicyError
+	require.ErrorAs(t, err, &policyErr)
+	require.Equal(t, []string{
+		"resource base options.privileged is denied",
+		"resource base root-commands are denied",
+	}, policyErr.Violations)
+}
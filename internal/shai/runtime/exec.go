@@ -0,0 +1,167 @@
+package shai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/moby/term"
+)
+
+// execHelperPath is the script the bootstrap writes to start commands the
+// way it started the user's: with the same environment, including the
+// proxy settings from proxy-env.sh, in the workspace, as the target user.
+// Running as that user keeps the egress rules, which match on its uid.
+const execHelperPath = "/run/shai/exec.sh"
+
+// ExecSandbox runs a command in a running sandbox, found by name or ID, and
+// returns once it exits, with an *ExitError if it fails. It waits for the
+// bootstrap if the sandbox is still starting. With no command it starts the
+// user's login shell.
+func ExecSandbox(ctx context.Context, ref string, spec ExecSpec) error {
+	docker, err := newDockerClient()
+	if err != nil {
+		return fmt.Errorf("failed to create docker client: %w", err)
+	}
+	defer docker.Close()
+
+	summary, err := resolveSandbox(ctx, docker, ref)
+	if err != nil {
+		return err
+	}
+	if summary.State != container.StateRunning {
+		return fmt.Errorf("sandbox %s is not running", ref)
+	}
+	return execInContainer(ctx, docker, summary.ID, spec)
+}
+
+// Exec runs a command in the session's container; see ExecSandbox.
+func (s *Session) Exec(ctx context.Context, spec ExecSpec) error {
+	if s.ContainerID == "" {
+		return errors.New("session has no container")
+	}
+	return execInContainer(ctx, s.docker, s.ContainerID, spec)
+}
+
+// execArgs is the exec helper's command line for spec.
+func execArgs(spec ExecSpec) []string {
+	args := []string{execHelperPath}
+	for _, pair := range orderedKeyValuePairs(spec.Env) {
+		args = append(args, "--env", pair)
+	}
+	if spec.Workdir != "" {
+		args = append(args, "--workdir", spec.Workdir)
+	}
+	args = append(args, "--")
+	return append(args, spec.Command...)
+}
+
+func execInContainer(ctx context.Context, docker *client.Client, id string, spec ExecSpec) error {
+	if err := waitForBootstrap(ctx, docker, id); err != nil {
+		return err
+	}
+
+	created, err := docker.ContainerExecCreate(ctx, id, container.ExecOptions{
+		Tty:          spec.UseTTY,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          execArgs(spec),
+	})
+	if err != nil {
+		return fmt.Errorf("create exec: %w", err)
+	}
+	hijacked, err := docker.ContainerExecAttach(ctx, created.ID, container.ExecAttachOptions{Tty: spec.UseTTY})
+	if err != nil {
+		return fmt.Errorf("attach exec: %w", err)
+	}
+	defer hijacked.Close()
+
+	stdin, stdout, stderr := spec.Stdin, spec.Stdout, spec.Stderr
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	if stdin == nil {
+		stdin = os.Stdin
+		stdinFD := os.Stdin.Fd()
+		// The command has started by now, so Ctrl-C goes straight through
+		// rather than through ctrlCFilter.
+		if spec.UseTTY && term.IsTerminal(stdinFD) {
+			if st, err := term.MakeRaw(stdinFD); err == nil {
+				defer term.RestoreTerminal(stdinFD, st)
+			}
+			stop := watchTTYResize(ctx, stdinFD, func(size container.ResizeOptions) {
+				_ = docker.ContainerExecResize(context.Background(), created.ID, size)
+			})
+			if stop != nil {
+				defer stop()
+			}
+		}
+	}
+
+	go func() {
+		_, _ = io.Copy(hijacked.Conn, stdin)
+		_ = hijacked.CloseWrite()
+	}()
+	outDone := make(chan error, 1)
+	go func() {
+		var err error
+		if spec.UseTTY {
+			_, err = io.Copy(stdout, hijacked.Reader)
+		} else {
+			_, err = stdcopy.StdCopy(stdout, stderr, hijacked.Reader)
+		}
+		outDone <- err
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-outDone:
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+	}
+
+	inspect, err := docker.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		return fmt.Errorf("inspect exec: %w", err)
+	}
+	return classifyExit(inspect.ExitCode, true, false)
+}
+
+// waitForBootstrap follows the container's output until the bootstrap
+// prints the start marker, after which the exec helper is in place.
+func waitForBootstrap(ctx context.Context, docker *client.Client, id string) error {
+	logsCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	logs, err := docker.ContainerLogs(logsCtx, id, container.LogsOptions{ShowStdout: true, ShowStderr: true, Follow: true})
+	if err != nil {
+		return fmt.Errorf("read sandbox logs: %w", err)
+	}
+	defer logs.Close()
+
+	started := false
+	detector := newExecStartDetector(io.Discard, startMarkerPrefix, func() {
+		started = true
+		cancel()
+	})
+	_, err = io.Copy(detector, logs)
+	switch {
+	case started:
+		return nil
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case err != nil:
+		return fmt.Errorf("read sandbox logs: %w", err)
+	}
+	return errors.New("sandbox exited before its command started")
+}
@@ -0,0 +1,18 @@
+package shai
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecArgs(t *testing.T) {
+	require.Equal(t, []string{execHelperPath, "--"}, execArgs(ExecSpec{}))
+	require.Equal(t,
+		[]string{execHelperPath, "--env", "A=1", "--env", "B=2", "--workdir", "/src/app", "--", "npm", "test", "--", "--watch"},
+		execArgs(ExecSpec{
+			Command: []string{"npm", "test", "--", "--watch"},
+			Env:     map[string]string{"B": "2", "A": "1"},
+			Workdir: "/src/app",
+		}))
+}
@@ -0,0 +1,134 @@
+package shai
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+// ExitKind classifies why a sandbox exited with a non-zero status.
+type ExitKind string
+
+const (
+	// ExitCommand means the user's command exited with the status.
+	ExitCommand ExitKind = "command"
+	// ExitBootstrap means the sandbox setup failed before the user's
+	// command started.
+	ExitBootstrap ExitKind = "bootstrap"
+	// ExitOOM means the container was killed for running out of memory.
+	ExitOOM ExitKind = "oom"
+	// ExitSignal means the user's command was killed by a signal.
+	ExitSignal ExitKind = "signal"
+)
+
+// bootstrapExitCode is the status the bootstrap exits with when setup
+// fails; see die in bootstrap.sh.
+const bootstrapExitCode = 90
+
+// ExitError reports a non-zero exit of the sandbox, or of a command started
+// with Exec. Code is the exit status, which the CLI exits with too.
+type ExitError struct {
+	Code int
+	Kind ExitKind
+}
+
+func (e *ExitError) Error() string {
+	switch e.Kind {
+	case ExitBootstrap:
+		return fmt.Sprintf("sandbox setup failed (exit status %d)", e.Code)
+	case ExitOOM:
+		return fmt.Sprintf("sandbox ran out of memory and was killed (exit status %d)", e.Code)
+	case ExitSignal:
+		return fmt.Sprintf("command killed by signal %d (%s)", e.Signal(), e.Signal())
+	}
+	return fmt.Sprintf("command exited with status %d", e.Code)
+}
+
+// Signal returns the signal that killed the command, or 0 if it exited on
+// its own. Like a shell, it reads statuses above 128 as 128 plus the signal.
+func (e *ExitError) Signal() syscall.Signal {
+	if e.Code > 128 && e.Code <= 128+64 {
+		return syscall.Signal(e.Code - 128)
+	}
+	return 0
+}
+
+// classifyExit builds the error for an exit status. started reports whether
+// the bootstrap reached the user's command and oom whether Docker reported
+// the container out of memory.
+func classifyExit(code int, started, oom bool) error {
+	if code == 0 {
+		return nil
+	}
+	err := &ExitError{Code: code, Kind: ExitCommand}
+	switch {
+	case oom:
+		err.Kind = ExitOOM
+	case code == bootstrapExitCode && !started:
+		err.Kind = ExitBootstrap
+	case err.Signal() != 0:
+		err.Kind = ExitSignal
+	}
+	return err
+}
+
+// oomWatch follows the container's events to learn whether it is killed
+// for memory. An auto-removed container can't be inspected once it exits,
+// so the event is the only record.
+type oomWatch struct {
+	cancel context.CancelFunc
+	result chan bool
+}
+
+func watchOOM(ctx context.Context, docker *client.Client, id string) *oomWatch {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &oomWatch{cancel: cancel, result: make(chan bool, 1)}
+	msgs, errs := docker.Events(ctx, events.ListOptions{Filters: filters.NewArgs(
+		filters.Arg("type", string(events.ContainerEventType)),
+		filters.Arg("container", id),
+		filters.Arg("event", string(events.ActionOOM)),
+		filters.Arg("event", string(events.ActionDie)),
+	)})
+	go func() {
+		oom := false
+		for {
+			select {
+			case msg := <-msgs:
+				switch msg.Action {
+				case events.ActionOOM:
+					oom = true
+				case events.ActionDie:
+					w.result <- oom
+					return
+				}
+			case <-errs:
+				w.result <- oom
+				return
+			}
+		}
+	}()
+	return w
+}
+
+// Killed reports whether the container ran out of memory. Docker sends oom
+// before die, so the answer is final once die arrives; it waits briefly
+// for that.
+func (w *oomWatch) Killed() bool {
+	defer w.cancel()
+	select {
+	case oom := <-w.result:
+		return oom
+	case <-time.After(2 * time.Second):
+		return false
+	}
+}
+
+// Stop ends the watch without waiting for an answer.
+func (w *oomWatch) Stop() {
+	w.cancel()
+}
@@ -0,0 +1,50 @@
+package shai
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyExit(t *testing.T) {
+	require.NoError(t, classifyExit(0, false, false))
+
+	cases := []struct {
+		code    int
+		started bool
+		oom     bool
+		kind    ExitKind
+		message string
+	}{
+		{2, true, false, ExitCommand, "command exited with status 2"},
+		{90, false, false, ExitBootstrap, "sandbox setup failed (exit status 90)"},
+		{90, true, false, ExitCommand, "command exited with status 90"},
+		{137, true, true, ExitOOM, "sandbox ran out of memory and was killed (exit status 137)"},
+		{143, true, false, ExitSignal, "command killed by signal 15 (terminated)"},
+		{1, false, false, ExitCommand, "command exited with status 1"},
+	}
+	for _, tc := range cases {
+		err := classifyExit(tc.code, tc.started, tc.oom)
+		var exitErr *ExitError
+		require.True(t, errors.As(fmt.Errorf("wrapped: %w", err), &exitErr))
+		require.Equal(t, tc.code, exitErr.Code)
+		require.Equal(t, tc.kind, exitErr.Kind, "code %d", tc.code)
+		require.Equal(t, tc.message, err.Error())
+	}
+}
+
+func TestExitErrorSignal(t *testing.T) {
+	require.Equal(t, syscall.SIGKILL, (&ExitError{Code: 137}).Signal())
+	require.Equal(t, syscall.Signal(0), (&ExitError{Code: 2}).Signal())
+	require.Equal(t, syscall.Signal(0), (&ExitError{Code: 255}).Signal())
+}
+
+func TestExitStatusErrorReportsWaitError(t *testing.T) {
+	err := exitStatusError(container.WaitResponse{Error: &container.WaitExitError{Message: "boom"}}, true, nil)
+	require.EqualError(t, err, "boom")
+	require.NoError(t, exitStatusError(container.WaitResponse{}, true, nil))
+}
@@ -0,0 +1,247 @@
+package shai
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	configpkg "github.com/colony-2/shai/internal/shai/runtime/config"
+)
+
+// Explanation describes what a sandbox would start with, and why, without
+// starting anything.
+type Explanation struct {
+	Paths         []PathExplanation `json:"paths"`
+	ResourceSets  []ExplainedSet    `json:"resourceSets"`
+	Image         ExplainedImage    `json:"image"`
+	HTTP          []ExplainedEntry  `json:"http"`
+	Ports         []ExplainedEntry  `json:"ports"`
+	Mounts        []ExplainedMount  `json:"mounts"`
+	Vars          []ExplainedVar    `json:"vars"`
+	Calls         []ExplainedEntry  `json:"calls"`
+	RootCommands  []ExplainedEntry  `json:"rootCommands"`
+	Expose        []ExplainedEntry  `json:"expose"`
+	BootstrapArgs []string          `json:"bootstrapArgs"`
+}
+
+// PathExplanation lists how every apply rule treats one workspace path.
+type PathExplanation struct {
+	Path  string                `json:"path"`
+	Rules []configpkg.RuleMatch `json:"rules"`
+}
+
+// ExplainedSet is an active resource set. Via is "--resource-set" for sets
+// requested explicitly and "apply" for sets selected by apply rules.
+type ExplainedSet struct {
+	Name   string           `json:"name"`
+	Via    string           `json:"via"`
+	Source configpkg.Source `json:"source"`
+}
+
+// ExplainedImage is the chosen image and what chose it.
+type ExplainedImage struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+// ExplainedEntry is a merged value and the resource set it came from.
+type ExplainedEntry struct {
+	Value string `json:"value"`
+	Set   string `json:"set"`
+}
+
+// ExplainedMount is a merged mount and the resource set it came from.
+type ExplainedMount struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Mode   string `json:"mode"`
+	Set    string `json:"set"`
+}
+
+// ExplainedVar is a merged var mapping and the resource set it came from.
+// Source is the host variable, or the secret source as "keyring:<ref>".
+// HostSet reports whether the host variable or secret file exists; commands
+// and keyring entries are only read at start and count as set. As is "file"
+// for vars delivered as files.
+type ExplainedVar struct {
+	Source  string `json:"source"`
+	Target  string `json:"target"`
+	Set     string `json:"set"`
+	HostSet bool   `json:"hostSet"`
+	As      string `json:"as,omitempty"`
+}
+
+// Explain resolves cfg the way NewEphemeralRunner does and reports the
+// result. Var values in the bootstrap args are shown as ${NAME} rather than
+// the host's values.
+func Explain(cfg EphemeralConfig) (*Explanation, error) {
+	if cfg.WorkingDir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get working directory: %w", err)
+		}
+		cfg.WorkingDir = wd
+	}
+	shaiCfg, err := LoadConfig(cfg.WorkingDir, cfg.ConfigFile, cfg.TemplateVars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load shai config: %w", err)
+	}
+	mountBuilder, err := NewMountBuilder(cfg.WorkingDir, cfg.ReadWritePaths)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mount builder: %w", err)
+	}
+	workspace := effectiveWorkspace(shaiCfg.Workspace, mountBuilder.ReadWritePaths)
+	shaiCfg.Workspace = workspace
+
+	resources, resourceNames, applyImageOverride, err := resolvedResources(shaiCfg, mountBuilder.ReadWritePaths, cfg.ResourceSets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve resources: %w", err)
+	}
+	image, imageSource := chooseImage(shaiCfg.Image, cfg.ImageOverride, applyImageOverride)
+
+	out := &Explanation{}
+	orderedPaths := orderedResourcePaths(mountBuilder.ReadWritePaths)
+	for _, p := range orderedPaths {
+		// The root only decides the resources when nothing else is writable.
+		if p == "." && len(orderedPaths) > 1 {
+			continue
+		}
+		out.Paths = append(out.Paths, PathExplanation{Path: p, Rules: shaiCfg.ExplainPath(p)})
+	}
+
+	requested := make(map[string]bool, len(cfg.ResourceSets))
+	for _, name := range cfg.ResourceSets {
+		requested[strings.TrimSpace(name)] = true
+	}
+	for _, res := range resources {
+		via := "apply"
+		if requested[res.Name] {
+			via = "--resource-set"
+		}
+		out.ResourceSets = append(out.ResourceSets, ExplainedSet{Name: res.Name, Via: via, Source: res.Spec.Source()})
+	}
+
+	out.Image = ExplainedImage{Name: image, Source: "config"}
+	switch imageSource {
+	case "cli":
+		out.Image.Source = "--image"
+	case "apply":
+		for _, p := range orderedPaths {
+			if p == "." {
+				continue
+			}
+			if rule, ok := shaiCfg.ImageRuleForPath(p); ok {
+				out.Image.Source = fmt.Sprintf("apply rule %d (path %s) at %s", rule.Index, rule.Path, rule.Source)
+				break
+			}
+		}
+	}
+
+	placeholders := map[string]string{}
+	explainEntries(out, resources, placeholders, cfg.WorkingDir)
+
+	runner := &EphemeralRunner{
+		config:        cfg,
+		shaiConfig:    shaiCfg,
+		resources:     resources,
+		resourceNames: resourceNames,
+		image:         image,
+		workspace:     workspace,
+		hostEnv:       placeholders,
+	}
+	out.BootstrapArgs, err = runner.buildBootstrapArgs()
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// explainEntries merges the active sets' entries with the same precedence
+// the runner applies, recording which set each came from. Every host var
+// gets a ${NAME} placeholder in placeholders; secret files are looked for
+// relative to workingDir.
+func explainEntries(out *Explanation, resources []*configpkg.ResolvedResource, placeholders map[string]string, workingDir string) {
+	seenHTTP := map[string]bool{}
+	seenPorts := map[string]bool{}
+	seenCalls := map[string]bool{}
+	seenExpose := map[string]bool{}
+	mountIndex := map[string]int{}
+	varIndex := map[string]int{}
+	for _, res := range resources {
+		if res == nil || res.Spec == nil {
+			continue
+		}
+		set := res.Spec
+		for _, host := range set.Hosts() {
+			host = strings.TrimSpace(host)
+			if host != "" && !seenHTTP[host] {
+				seenHTTP[host] = true
+				out.HTTP = append(out.HTTP, ExplainedEntry{Value: host, Set: res.Name})
+			}
+		}
+		for _, p := range set.Ports() {
+			host := strings.TrimSpace(p.Host)
+			key := fmt.Sprintf("%s:%d", host, p.Port)
+			if host != "" && p.Port != 0 && !seenPorts[key] {
+				seenPorts[key] = true
+				out.Ports = append(out.Ports, ExplainedEntry{Value: key, Set: res.Name})
+			}
+		}
+		for _, m := range set.Mounts {
+			entry := ExplainedMount{Source: m.Source, Target: m.Target, Mode: m.Mode, Set: res.Name}
+			if i, ok := mountIndex[m.Target]; ok {
+				out.Mounts[i] = entry
+				continue
+			}
+			mountIndex[m.Target] = len(out.Mounts)
+			out.Mounts = append(out.Mounts, entry)
+		}
+		for _, v := range set.Vars {
+			source := v.Origin()
+			target := v.Name()
+			var hostSet bool
+			switch kind, ref, secret := v.Secret(); {
+			case !secret:
+				_, hostSet = os.LookupEnv(source)
+				placeholders[source] = "${" + source + "}"
+			case kind == configpkg.SecretFile:
+				files := fileSecrets{dir: workingDir, home: os.Getenv("HOME")}
+				_, err := os.Stat(files.path(ref))
+				hostSet = err == nil
+			default:
+				hostSet = true
+			}
+			entry := ExplainedVar{Source: source, Target: target, Set: res.Name, HostSet: hostSet}
+			if v.As == configpkg.DeliverFile {
+				entry.As = configpkg.DeliverFile
+			}
+			if i, ok := varIndex[target]; ok {
+				out.Vars[i] = entry
+				continue
+			}
+			varIndex[target] = len(out.Vars)
+			out.Vars = append(out.Vars, entry)
+		}
+		for _, call := range set.Calls {
+			if !seenCalls[call.Name] {
+				seenCalls[call.Name] = true
+				out.Calls = append(out.Calls, ExplainedEntry{Value: call.Name, Set: res.Name})
+			}
+		}
+		for _, cmd := range set.RootCommands {
+			if cmd = strings.TrimSpace(cmd); cmd != "" {
+				out.RootCommands = append(out.RootCommands, ExplainedEntry{Value: cmd, Set: res.Name})
+			}
+		}
+		for _, exp := range set.Expose {
+			key := fmt.Sprintf("%d/%s", exp.Host, exp.Protocol)
+			if !seenExpose[key] {
+				seenExpose[key] = true
+				out.Expose = append(out.Expose, ExplainedEntry{Value: fmt.Sprintf("%d:%d/%s", exp.Host, exp.Container, exp.Protocol), Set: res.Name})
+			}
+		}
+	}
+	sort.Slice(out.HTTP, func(i, j int) bool { return out.HTTP[i].Value < out.HTTP[j].Value })
+	sort.Slice(out.Ports, func(i, j int) bool { return out.Ports[i].Value < out.Ports[j].Value })
+}
@@ -0,0 +1,88 @@
+package shai
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplain(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SHAI_ORG_CONFIG", filepath.Join(dir, "missing.yaml"))
+	t.Setenv("SHAI_USER_CONFIG", filepath.Join(dir, "missing.yaml"))
+	t.Setenv("EXPLAIN_TOKEN", "secret-value")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "frontend"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ConfigDirName), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, DefaultConfigRelPath), []byte(`
+type: shai-sandbox
+version: 1
+image: example/base
+mcp-clients: [claude]
+resources:
+  base:
+    http: [b.example.com, a.example.com]
+    vars:
+      - source: EXPLAIN_TOKEN
+        target: TOKEN
+    mounts:
+      - source: /tmp
+        target: /data
+  frontend:
+    http: [a.example.com, cdn.example.com]
+    mounts:
+      - source: /var
+        target: /data
+        mode: rw
+    calls:
+      - name: build
+        command: make build
+  extra:
+    root-commands: ["apt-get install -y jq"]
+apply:
+  - path: ./
+    resources: [base]
+  - path: frontend
+    resources: [frontend]
+    image: example/node
+  - path: backend
+    resources: [base]
+`), 0o644))
+
+	e, err := Explain(EphemeralConfig{WorkingDir: dir, ReadWritePaths: []string{"frontend"}, ResourceSets: []string{"extra"}})
+	require.NoError(t, err)
+
+	require.Len(t, e.Paths, 1, "the root only counts when nothing else is writable")
+	frontend := e.Paths[0]
+	require.Equal(t, "frontend", frontend.Path)
+	require.True(t, frontend.Rules[0].Matched && frontend.Rules[1].Matched)
+	require.False(t, frontend.Rules[2].Matched)
+	require.Equal(t, "exact match", frontend.Rules[1].Reason)
+
+	require.Equal(t, []ExplainedSet{
+		{Name: "extra", Via: "--resource-set", Source: e.ResourceSets[0].Source},
+		{Name: "base", Via: "apply", Source: e.ResourceSets[1].Source},
+		{Name: "frontend", Via: "apply", Source: e.ResourceSets[2].Source},
+	}, e.ResourceSets)
+	require.Equal(t, "example/node", e.Image.Name)
+	require.Contains(t, e.Image.Source, "apply rule 1 (path frontend)")
+
+	require.Equal(t, []ExplainedEntry{
+		{Value: "a.example.com", Set: "base"},
+		{Value: "b.example.com", Set: "base"},
+		{Value: "cdn.example.com", Set: "frontend"},
+	}, e.HTTP)
+	require.Equal(t, []ExplainedMount{{Source: "/var", Target: "/data", Mode: "rw", Set: "frontend"}}, e.Mounts, "the later mount for a target wins")
+	require.Equal(t, []ExplainedVar{{Source: "EXPLAIN_TOKEN", Target: "TOKEN", Set: "base", HostSet: true}}, e.Vars)
+	require.Equal(t, []ExplainedEntry{{Value: "build", Set: "frontend"}}, e.Calls)
+	require.Equal(t, []ExplainedEntry{{Value: "apt-get install -y jq", Set: "extra"}}, e.RootCommands)
+
+	args := strings.Join(e.BootstrapArgs, " ")
+	require.Contains(t, args, "--exec-env TOKEN=${EXPLAIN_TOKEN}")
+	require.NotContains(t, args, "secret-value")
+	require.Contains(t, args, "--image-name example/node")
+	require.True(t, slices.Contains(e.BootstrapArgs, "--mcp-client"), "dry runs describe the alias service")
+}
@@ -16,6 +16,8 @@ const testImage = "ghcr.io/colony-2/shai-base:latest"
 func TestMain(m *testing.M) {
 	// Force verbose mode during tests so setup logs remain visible.
 	_ = os.Setenv("SHAI_FORCE_VERBOSE", "1")
+	// Tests write throwaway workspace configs that are never trusted.
+	_ = os.Setenv("SHAI_TRUST_ALL", "1")
 
 	fmt.Printf("Pre-pulling Docker image %s (this may take a while)...\n", testImage)
 	if err := pullDockerImage(testImage); err != nil {
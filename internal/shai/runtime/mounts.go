@@ -13,6 +13,10 @@ import (
 type MountBuilder struct {
 	WorkingDir     string
 	ReadWritePaths []string
+	// OverlayDir, when set by UseOverlay, holds the upper and work
+	// directories of an overlayfs that makes the whole workspace writable
+	// without touching WorkingDir.
+	OverlayDir string
 }
 
 // NewMountBuilder creates a mount builder for selective RW access
@@ -55,6 +59,32 @@ func NewMountBuilder(workingDir string, rwPaths []string) (*MountBuilder, error)
 	return mb, nil
 }
 
+// UseOverlay makes the whole workspace writable through an overlayfs with
+// WorkingDir as its lower layer and its upper and work directories under
+// dir, so writes collect there for review; see WorkspaceChanges. It can't
+// be combined with read-write paths.
+func (m *MountBuilder) UseOverlay(dir string) error {
+	if !overlaySupported {
+		return fmt.Errorf("overlay workspaces need a Linux host")
+	}
+	if len(m.ReadWritePaths) > 0 {
+		return fmt.Errorf("an overlay workspace is already writable; drop the read-write paths")
+	}
+	workingDir, err := filepath.Abs(m.WorkingDir)
+	if err != nil {
+		return err
+	}
+	m.WorkingDir = workingDir
+	// The paths are passed as overlay mount options, which split on these.
+	for _, p := range []string{m.WorkingDir, dir} {
+		if strings.ContainsAny(p, ",:") {
+			return fmt.Errorf("overlay workspaces can't use %q: the path contains ',' or ':'", p)
+		}
+	}
+	m.OverlayDir = dir
+	return nil
+}
+
 // BuildMounts creates Docker mount specifications
 // Base directory is read-only, specific paths are read-write
 func (m *MountBuilder) BuildMounts() []mount.Mount {
@@ -70,6 +100,11 @@ func (m *MountBuilder) BuildMounts() []mount.Mount {
 
 	protectConfigDir := false
 
+	if m.OverlayDir != "" {
+		mounts[0] = overlayMount(m.WorkingDir, m.OverlayDir)
+		protectConfigDir = true
+	}
+
 	// Add read-write overlays
 	// These will override the read-only base mount for specific paths
 	for _, rwPath := range m.ReadWritePaths {
@@ -145,7 +180,8 @@ func isParentPath(parent, child string) bool {
 	return strings.HasPrefix(child, parent)
 }
 
-// BuildMountStrings returns mount specifications as strings for Docker CLI
+// BuildMountStrings returns mount specifications as strings for Docker CLI.
+// The -v syntax can't express an overlay workspace, so OverlayDir is ignored.
 func (m *MountBuilder) BuildMountStrings() []string {
 	var mountStrings []string
 
@@ -310,3 +310,59 @@ func contains(s, substr string) bool {
 	return len(s) >= len(substr) && s[:len(substr)] == substr ||
 		len(s) > len(substr) && contains(s[1:], substr)
 }
+
+func TestBuildMountsOverlay(t *testing.T) {
+	if !overlaySupported {
+		t.Skip("overlay workspaces need Linux")
+	}
+	tempDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tempDir, ".shai"), 0755)
+
+	mb, err := NewMountBuilder(tempDir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mb.UseOverlay("/state/overlay"); err != nil {
+		t.Fatalf("UseOverlay() error: %v", err)
+	}
+
+	mounts := mb.BuildMounts()
+	if len(mounts) != 2 {
+		t.Fatalf("BuildMounts() = %v, want the overlay and .shai", mounts)
+	}
+	base := mounts[0]
+	if base.Type != mount.TypeVolume || base.Target != "/src" || base.VolumeOptions == nil || base.VolumeOptions.DriverConfig == nil {
+		t.Fatalf("base mount = %+v, want an overlay volume on /src", base)
+	}
+	wantOpts := "lowerdir=" + tempDir + ",upperdir=/state/overlay/upper,workdir=/state/overlay/work,userxattr"
+	if got := base.VolumeOptions.DriverConfig.Options["o"]; got != wantOpts {
+		t.Errorf("overlay options = %q, want %q", got, wantOpts)
+	}
+	if config := mounts[1]; config.Target != "/src/.shai" || !config.ReadOnly {
+		t.Errorf(".shai mount = %+v, want it read-only", config)
+	}
+}
+
+func TestUseOverlayRejects(t *testing.T) {
+	if !overlaySupported {
+		t.Skip("overlay workspaces need Linux")
+	}
+	tempDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tempDir, "dir1"), 0755)
+
+	mb, err := NewMountBuilder(tempDir, []string{"dir1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mb.UseOverlay("/state/overlay"); err == nil {
+		t.Error("UseOverlay() with read-write paths succeeded")
+	}
+
+	mb, err = NewMountBuilder(tempDir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mb.UseOverlay("/state/a,b"); err == nil {
+		t.Error("UseOverlay() with a comma in the path succeeded")
+	}
+}
@@ -0,0 +1,748 @@
+package shai
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// ChangeKind says how a path in an overlay workspace differs from the
+// real workspace.
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeModified ChangeKind = "modified"
+	ChangeDeleted  ChangeKind = "deleted"
+)
+
+// WorkspaceChange is a pending change in an overlay workspace. Path is
+// relative to the workspace and uses forward slashes.
+type WorkspaceChange struct {
+	Path string     `json:"path"`
+	Kind ChangeKind `json:"kind"`
+	Dir  bool       `json:"dir,omitempty"`
+}
+
+var overlayNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// OverlayDir is where an overlay session of workspace keeps its changes: a
+// directory under OverlayStateDir named for the workspace path. Every
+// overlay session of a workspace shares it, so changes build up until they
+// are applied or discarded.
+func OverlayDir(workspace string) (string, error) {
+	abs, err := filepath.Abs(workspace)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	name := overlayNameUnsafe.ReplaceAllString(filepath.Base(abs), "_") + "-" + hex.EncodeToString(sum[:6])
+	return filepath.Join(OverlayStateDir(), name), nil
+}
+
+func overlayUpperDir(dir string) string { return filepath.Join(dir, "upper") }
+func overlayWorkDir(dir string) string  { return filepath.Join(dir, "work") }
+
+// overlayMount mounts the workspace at /src through an anonymous volume
+// that the local driver backs with overlayfs, so the Docker daemon does the
+// mount and the container needs no extra capabilities. userxattr keeps the
+// overlay's markers in the user xattr namespace, where WorkspaceChanges can
+// read them without root, and turns off metacopy and redirect_dir, so the
+// upper directory always holds whole files.
+func overlayMount(workingDir, dir string) mount.Mount {
+	return mount.Mount{
+		Type:   mount.TypeVolume,
+		Target: "/src",
+		VolumeOptions: &mount.VolumeOptions{
+			NoCopy: true,
+			DriverConfig: &mount.Driver{
+				Name: "local",
+				Options: map[string]string{
+					"type":   "overlay",
+					"device": "overlay",
+					"o": fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s,userxattr",
+						workingDir, overlayUpperDir(dir), overlayWorkDir(dir)),
+				},
+			},
+		},
+	}
+}
+
+// prepareOverlayDir creates the upper and work directories. The upper
+// directory stands in for the workspace root, so it takes its mode.
+func prepareOverlayDir(workingDir, dir string) error {
+	info, err := os.Stat(workingDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(overlayWorkDir(dir), 0o700); err != nil {
+		return fmt.Errorf("create overlay directory: %w", err)
+	}
+	upper := overlayUpperDir(dir)
+	if err := os.Mkdir(upper, info.Mode().Perm()); err != nil && !errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("create overlay directory: %w", err)
+	}
+	return nil
+}
+
+// overlayUser returns the running sandbox using the overlay in dir, or ""
+// if there is none. Two mounts of one upper directory corrupt it, and
+// changing it under a mounted overlay is undefined.
+func overlayUser(ctx context.Context, docker *client.Client, dir string) (string, error) {
+	list, err := docker.ContainerList(ctx, container.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", LabelOverlay+"="+dir)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("list sandboxes: %w", err)
+	}
+	if len(list) == 0 {
+		return "", nil
+	}
+	if name := list[0].Labels[LabelName]; name != "" {
+		return name, nil
+	}
+	return list[0].ID[:12], nil
+}
+
+// checkOverlayIdle fails if a running sandbox uses the workspace's overlay.
+func checkOverlayIdle(ctx context.Context, dir string) error {
+	docker, err := newDockerClient()
+	if err != nil {
+		return fmt.Errorf("failed to create docker client: %w", err)
+	}
+	defer docker.Close()
+	user, err := overlayUser(ctx, docker, dir)
+	if err != nil {
+		return err
+	}
+	if user != "" {
+		return fmt.Errorf("sandbox %s is still using the overlay; stop it with `shai stop %s` first", user, user)
+	}
+	return nil
+}
+
+// WorkspaceChanges lists the pending changes of workspace's overlay at or
+// under paths, or all of them, parents before children. Files that were
+// copied up but match the workspace are left out, and so is everything
+// under .shai, which is never applied.
+func WorkspaceChanges(workspace string, paths []string) ([]WorkspaceChange, error) {
+	workspace, dir, err := overlayPaths(workspace)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := overlayChanges(workspace, overlayUpperDir(dir))
+	if err != nil {
+		return nil, err
+	}
+	return selectChanges(changes, paths)
+}
+
+func overlayPaths(workspace string) (string, string, error) {
+	abs, err := filepath.Abs(workspace)
+	if err != nil {
+		return "", "", err
+	}
+	dir, err := OverlayDir(abs)
+	if err != nil {
+		return "", "", err
+	}
+	return abs, dir, nil
+}
+
+func overlayChanges(workspace, upper string) ([]WorkspaceChange, error) {
+	var changes []WorkspaceChange
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		entries, err := os.ReadDir(filepath.Join(upper, rel))
+		if err != nil {
+			if rel == "" && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		for _, entry := range entries {
+			p := path.Join(rel, entry.Name())
+			if isConfigPath(p) {
+				continue
+			}
+			upperPath := filepath.Join(upper, filepath.FromSlash(p))
+			info, err := os.Lstat(upperPath)
+			if err != nil {
+				return err
+			}
+			lower, exists, err := lstatWorkspace(filepath.Join(workspace, filepath.FromSlash(p)))
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case isWhiteout(info):
+				if exists {
+					changes = append(changes, WorkspaceChange{Path: p, Kind: ChangeDeleted, Dir: lower.IsDir()})
+				}
+			case info.IsDir():
+				switch {
+				case !exists:
+					changes = append(changes, WorkspaceChange{Path: p, Kind: ChangeAdded, Dir: true})
+				case !lower.IsDir():
+					changes = append(changes, WorkspaceChange{Path: p, Kind: ChangeModified, Dir: true})
+				case info.Mode().Perm() != lower.Mode().Perm():
+					changes = append(changes, WorkspaceChange{Path: p, Kind: ChangeModified, Dir: true})
+				}
+				if exists && lower.IsDir() && isOpaqueDir(upperPath) {
+					// An opaque directory hides everything below it in the
+					// workspace, so what it lacks was deleted.
+					deleted, err := opaqueDeletions(filepath.Join(workspace, filepath.FromSlash(p)), upperPath, p)
+					if err != nil {
+						return err
+					}
+					changes = append(changes, deleted...)
+				}
+				if err := walk(p); err != nil {
+					return err
+				}
+			default:
+				if !exists {
+					changes = append(changes, WorkspaceChange{Path: p, Kind: ChangeAdded})
+					continue
+				}
+				same, err := sameEntry(upperPath, info, filepath.Join(workspace, filepath.FromSlash(p)), lower)
+				if err != nil {
+					return err
+				}
+				if !same {
+					changes = append(changes, WorkspaceChange{Path: p, Kind: ChangeModified})
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(""); err != nil {
+		return nil, fmt.Errorf("read overlay: %w", err)
+	}
+	return changes, nil
+}
+
+// lstatWorkspace looks up a path in the workspace. exists is false when it
+// is missing, including when a parent is now a file rather than a
+// directory.
+func lstatWorkspace(p string) (info fs.FileInfo, exists bool, err error) {
+	info, err = os.Lstat(p)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return info, true, nil
+}
+
+func opaqueDeletions(lowerDir, upperDir, rel string) ([]WorkspaceChange, error) {
+	entries, err := os.ReadDir(lowerDir)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []WorkspaceChange
+	for _, entry := range entries {
+		p := path.Join(rel, entry.Name())
+		if isConfigPath(p) {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(upperDir, entry.Name())); err == nil {
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		deleted = append(deleted, WorkspaceChange{Path: p, Kind: ChangeDeleted, Dir: entry.IsDir()})
+	}
+	return deleted, nil
+}
+
+// sameEntry reports whether two non-directory entries have the same type,
+// permissions and contents.
+func sameEntry(aPath string, a fs.FileInfo, bPath string, b fs.FileInfo) (bool, error) {
+	if a.Mode() != b.Mode() {
+		return false, nil
+	}
+	if a.Mode()&fs.ModeSymlink != 0 {
+		aTarget, err := os.Readlink(aPath)
+		if err != nil {
+			return false, err
+		}
+		bTarget, err := os.Readlink(bPath)
+		if err != nil {
+			return false, err
+		}
+		return aTarget == bTarget, nil
+	}
+	if !a.Mode().IsRegular() || a.Size() != b.Size() {
+		return false, nil
+	}
+	aData, err := os.ReadFile(aPath)
+	if err != nil {
+		return false, err
+	}
+	bData, err := os.ReadFile(bPath)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(aData, bData), nil
+}
+
+// isConfigPath reports whether rel is in the workspace's .shai directory,
+// which sandboxes can't change; see MountBuilder.BuildMounts.
+func isConfigPath(rel string) bool {
+	return rel == ConfigDirName || strings.HasPrefix(rel, ConfigDirName+"/")
+}
+
+// selectChanges returns the changes at or under paths, or all of them when
+// paths is empty. Each path must match at least one change.
+func selectChanges(changes []WorkspaceChange, paths []string) ([]WorkspaceChange, error) {
+	if len(paths) == 0 {
+		return changes, nil
+	}
+	prefixes := make([]string, 0, len(paths))
+	for _, p := range paths {
+		clean := filepath.ToSlash(filepath.Clean(p))
+		if filepath.IsAbs(p) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return nil, fmt.Errorf("path %q is outside the workspace", p)
+		}
+		if isConfigPath(clean) {
+			return nil, fmt.Errorf("changes under %s are never applied", ConfigDirName)
+		}
+		prefixes = append(prefixes, clean)
+	}
+
+	matched := make([]bool, len(prefixes))
+	var selected []WorkspaceChange
+	for _, change := range changes {
+		hit := false
+		for i, prefix := range prefixes {
+			if prefix == "." || change.Path == prefix || strings.HasPrefix(change.Path, prefix+"/") {
+				matched[i] = true
+				hit = true
+			}
+		}
+		if hit {
+			selected = append(selected, change)
+		}
+	}
+	for i, ok := range matched {
+		if !ok {
+			return nil, fmt.Errorf("no pending changes to %s", paths[i])
+		}
+	}
+	return selected, nil
+}
+
+// ApplyWorkspaceChanges copies the changes at or under paths from
+// workspace's overlay into the workspace and returns them. With no paths
+// it applies every change and clears the overlay, dropping anything under
+// .shai. It refuses while a sandbox is using the overlay.
+func ApplyWorkspaceChanges(ctx context.Context, workspace string, paths []string) ([]WorkspaceChange, error) {
+	workspace, dir, err := overlayPaths(workspace)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOverlayIdle(ctx, dir); err != nil {
+		return nil, err
+	}
+	return applyOverlay(workspace, dir, paths)
+}
+
+func applyOverlay(workspace, dir string, paths []string) ([]WorkspaceChange, error) {
+	upper := overlayUpperDir(dir)
+	changes, err := overlayChanges(workspace, upper)
+	if err != nil {
+		return nil, err
+	}
+	selected, err := selectChanges(changes, paths)
+	if err != nil {
+		return nil, err
+	}
+	for i, change := range selected {
+		if err := applyChange(workspace, upper, change); err != nil {
+			return selected[:i], fmt.Errorf("apply %s: %w", change.Path, err)
+		}
+	}
+	if len(paths) == 0 {
+		if err := os.RemoveAll(dir); err != nil {
+			return selected, fmt.Errorf("clear overlay: %w", err)
+		}
+		return selected, nil
+	}
+	if err := pruneOverlay(workspace, upper, "", false); err != nil {
+		return selected, fmt.Errorf("clear applied changes from overlay: %w", err)
+	}
+	return selected, nil
+}
+
+// DiscardWorkspaceChanges drops every pending change in workspace's
+// overlay. It refuses while a sandbox is using the overlay.
+func DiscardWorkspaceChanges(ctx context.Context, workspace string) error {
+	_, dir, err := overlayPaths(workspace)
+	if err != nil {
+		return err
+	}
+	if err := checkOverlayIdle(ctx, dir); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("clear overlay: %w", err)
+	}
+	return nil
+}
+
+func applyChange(workspace, upper string, change WorkspaceChange) error {
+	rel := filepath.FromSlash(change.Path)
+	target := filepath.Join(workspace, rel)
+	if err := ensureParents(workspace, upper, rel); err != nil {
+		return err
+	}
+	if change.Kind == ChangeDeleted {
+		return os.RemoveAll(target)
+	}
+
+	source := filepath.Join(upper, rel)
+	info, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	existing, exists, err := lstatWorkspace(target)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.IsDir():
+		if exists && !existing.IsDir() {
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+			exists = false
+		}
+		if !exists {
+			if err := os.Mkdir(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+		return os.Chmod(target, info.Mode().Perm())
+	case info.Mode()&fs.ModeSymlink != 0:
+		link, err := os.Readlink(source)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+		return os.Symlink(link, target)
+	case info.Mode().IsRegular():
+		if exists && existing.IsDir() {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+		return copyFileAtomic(source, target, info.Mode().Perm())
+	}
+	return fmt.Errorf("unsupported file type %s", info.Mode().Type())
+}
+
+// ensureParents creates the directories above rel that the workspace lacks,
+// with the overlay's permissions, and refuses to write through a parent
+// that is not a directory, such as a symlink out of the workspace.
+func ensureParents(workspace, upper, rel string) error {
+	parts := strings.Split(filepath.Dir(rel), string(filepath.Separator))
+	current := ""
+	for _, part := range parts {
+		if part == "." {
+			continue
+		}
+		current = filepath.Join(current, part)
+		target := filepath.Join(workspace, current)
+		info, err := os.Lstat(target)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory in the workspace", filepath.ToSlash(current))
+			}
+			continue
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		perm := fs.FileMode(0o755)
+		if upperInfo, err := os.Stat(filepath.Join(upper, current)); err == nil && upperInfo.IsDir() {
+			perm = upperInfo.Mode().Perm()
+		}
+		if err := os.Mkdir(target, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFileAtomic(source, target string, perm fs.FileMode) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".shai-apply-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+// pruneOverlay removes what no longer makes a difference from the upper
+// directory after a partial apply: whiteouts of paths that are gone, files
+// that match the workspace and directories left empty. Inside an opaque
+// directory matching files stay, since without them the workspace's copy
+// would be hidden.
+func pruneOverlay(workspace, upper, rel string, inOpaque bool) error {
+	entries, err := os.ReadDir(filepath.Join(upper, rel))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		p := filepath.Join(rel, entry.Name())
+		upperPath := filepath.Join(upper, p)
+		info, err := os.Lstat(upperPath)
+		if err != nil {
+			return err
+		}
+		lowerPath := filepath.Join(workspace, p)
+		lower, exists, err := lstatWorkspace(lowerPath)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case isWhiteout(info):
+			if !exists {
+				if err := os.Remove(upperPath); err != nil {
+					return err
+				}
+			}
+		case info.IsDir():
+			opaque := isOpaqueDir(upperPath)
+			if err := pruneOverlay(workspace, upper, p, inOpaque || opaque); err != nil {
+				return err
+			}
+			if !exists || !lower.IsDir() || lower.Mode().Perm() != info.Mode().Perm() {
+				continue
+			}
+			if empty, err := isEmptyDir(upperPath); err != nil || !empty {
+				continue
+			}
+			if opaque {
+				if lowerEmpty, err := isEmptyDir(lowerPath); err != nil || !lowerEmpty {
+					continue
+				}
+			}
+			if err := os.Remove(upperPath); err != nil {
+				return err
+			}
+		default:
+			if !exists || inOpaque {
+				continue
+			}
+			same, err := sameEntry(upperPath, info, lowerPath, lower)
+			if err != nil {
+				return err
+			}
+			if same {
+				if err := os.Remove(upperPath); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func isEmptyDir(dir string) (bool, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	_, err = f.Readdirnames(1)
+	if errors.Is(err, io.EOF) {
+		return true, nil
+	}
+	return false, err
+}
+
+// WriteWorkspaceDiff writes the changes at or under paths in workspace's
+// overlay, or all of them, as a unified diff against the workspace.
+func WriteWorkspaceDiff(w io.Writer, workspace string, paths []string) error {
+	workspace, dir, err := overlayPaths(workspace)
+	if err != nil {
+		return err
+	}
+	upper := overlayUpperDir(dir)
+	changes, err := overlayChanges(workspace, upper)
+	if err != nil {
+		return err
+	}
+	selected, err := selectChanges(changes, paths)
+	if err != nil {
+		return err
+	}
+	for _, change := range selected {
+		if err := writeChangeDiff(w, workspace, upper, change); err != nil {
+			return fmt.Errorf("diff %s: %w", change.Path, err)
+		}
+	}
+	return nil
+}
+
+// binarySniffLen is how much of a file is checked for NUL bytes, as git
+// does, to decide whether it is binary.
+const binarySniffLen = 8000
+
+func writeChangeDiff(w io.Writer, workspace, upper string, change WorkspaceChange) error {
+	rel := filepath.FromSlash(change.Path)
+	oldPath := filepath.Join(workspace, rel)
+	newPath := filepath.Join(upper, rel)
+
+	old, oldMode, oldOK, err := diffSide(oldPath)
+	if err != nil {
+		return err
+	}
+	var newContent string
+	var newMode fs.FileMode
+	newOK := false
+	if change.Kind != ChangeDeleted {
+		newContent, newMode, newOK, err = diffSide(newPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	if change.Dir {
+		switch change.Kind {
+		case ChangeAdded:
+			fmt.Fprintf(w, "new directory %s/\n", change.Path)
+		case ChangeDeleted:
+			fmt.Fprintf(w, "deleted directory %s/\n", change.Path)
+		default:
+			if oldOK {
+				fmt.Fprintf(w, "replaced %s with a directory\n", change.Path)
+			} else {
+				fmt.Fprintf(w, "mode change %04o => %04o %s/\n", oldMode.Perm(), newMode.Perm(), change.Path)
+			}
+		}
+		return nil
+	}
+	if change.Kind == ChangeModified && !oldOK {
+		fmt.Fprintf(w, "deleted directory %s/\n", change.Path)
+	}
+	if oldOK && newOK && oldMode != newMode {
+		fmt.Fprintf(w, "mode change %04o => %04o %s\n", oldMode.Perm(), newMode.Perm(), change.Path)
+		if old == newContent {
+			return nil
+		}
+	}
+
+	from, to := "a/"+change.Path, "b/"+change.Path
+	if !oldOK {
+		from = "/dev/null"
+	}
+	if !newOK {
+		to = "/dev/null"
+	}
+	if isBinary(old) || isBinary(newContent) {
+		fmt.Fprintf(w, "Binary files %s and %s differ\n", from, to)
+		return nil
+	}
+	var buf bytes.Buffer
+	err = difflib.WriteUnifiedDiff(&buf, difflib.UnifiedDiff{
+		A:        splitDiffLines(old),
+		B:        splitDiffLines(newContent),
+		FromFile: from,
+		ToFile:   to,
+		Context:  3,
+	})
+	if err != nil {
+		return err
+	}
+	if buf.Len() == 0 {
+		// Empty files have no hunks, but the headers still say what changed.
+		fmt.Fprintf(&buf, "--- %s\n+++ %s\n", from, to)
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// diffSide reads one side of a file diff: a regular file's contents or a
+// symlink's target. ok is false when there is no such file, or it is a
+// directory.
+func diffSide(p string) (content string, mode fs.FileMode, ok bool, err error) {
+	info, exists, err := lstatWorkspace(p)
+	if err != nil || !exists {
+		return "", 0, false, err
+	}
+	switch {
+	case info.IsDir():
+		return "", info.Mode(), false, nil
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(p)
+		return target, info.Mode(), err == nil, err
+	}
+	data, err := os.ReadFile(p)
+	return string(data), info.Mode(), err == nil, err
+}
+
+// splitDiffLines splits s into lines that keep their newline, adding one
+// to a last line that lacks it.
+func splitDiffLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if last := len(lines) - 1; lines[last] == "" {
+		lines = lines[:last]
+	} else {
+		lines[last] += "\n"
+	}
+	return lines
+}
+
+func isBinary(s string) bool {
+	if len(s) > binarySniffLen {
+		s = s[:binarySniffLen]
+	}
+	return strings.IndexByte(s, 0) >= 0
+}
@@ -0,0 +1,29 @@
+//go:build linux
+
+package shai
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+const overlaySupported = true
+
+// isWhiteout reports whether info is an overlayfs whiteout: a character
+// device numbered 0/0 that marks a deleted path.
+func isWhiteout(info fs.FileInfo) bool {
+	if info.Mode()&fs.ModeCharDevice == 0 {
+		return false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && st.Rdev == 0
+}
+
+// isOpaqueDir reports whether overlayfs marked dir opaque, hiding the
+// workspace directory beneath it. The marker is a user xattr because the
+// overlay is mounted with userxattr; see overlayMount.
+func isOpaqueDir(dir string) bool {
+	buf := make([]byte, 1)
+	n, err := syscall.Getxattr(dir, "user.overlay.opaque", buf)
+	return err == nil && n == 1 && buf[0] == 'y'
+}
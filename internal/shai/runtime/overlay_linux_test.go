@@ -0,0 +1,63 @@
+//go:build linux
+
+package shai
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverlayChangesWhiteout(t *testing.T) {
+	workspace, dir := overlayFixture(t)
+	if err := syscall.Mknod(filepath.Join(overlayUpperDir(dir), "main.go"), syscall.S_IFCHR, 0); err != nil {
+		t.Skipf("can't create a whiteout: %v", err)
+	}
+
+	changes, err := overlayChanges(workspace, overlayUpperDir(dir))
+	require.NoError(t, err)
+	require.Contains(t, changes, WorkspaceChange{Path: "main.go", Kind: ChangeDeleted})
+
+	_, err = applyOverlay(workspace, dir, []string{"main.go"})
+	require.NoError(t, err)
+	require.NoFileExists(t, filepath.Join(workspace, "main.go"))
+	_, err = os.Lstat(filepath.Join(overlayUpperDir(dir), "main.go"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestOverlayChangesOpaqueDir(t *testing.T) {
+	workspace, dir := overlayFixture(t)
+	upper := overlayUpperDir(dir)
+	writeFile(t, filepath.Join(workspace, "docs", "a.md"), "a\n")
+	writeFile(t, filepath.Join(workspace, "docs", "b.md"), "b\n")
+	writeFile(t, filepath.Join(upper, "docs", "b.md"), "b\n")
+	writeFile(t, filepath.Join(upper, "docs", "c.md"), "c\n")
+	if err := syscall.Setxattr(filepath.Join(upper, "docs"), "user.overlay.opaque", []byte("y"), 0); err != nil {
+		t.Skipf("can't set user xattrs here: %v", err)
+	}
+
+	changes, err := overlayChanges(workspace, upper)
+	require.NoError(t, err)
+	selected, err := selectChanges(changes, []string{"docs"})
+	require.NoError(t, err)
+	require.Equal(t, []WorkspaceChange{
+		{Path: "docs/a.md", Kind: ChangeDeleted},
+		{Path: "docs/c.md", Kind: ChangeAdded},
+	}, selected)
+
+	_, err = applyOverlay(workspace, dir, []string{"docs"})
+	require.NoError(t, err)
+	entries, err := os.ReadDir(filepath.Join(workspace, "docs"))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "b.md", entries[0].Name())
+	require.Equal(t, "c.md", entries[1].Name())
+
+	changes, err = overlayChanges(workspace, upper)
+	require.NoError(t, err)
+	_, err = selectChanges(changes, []string{"docs"})
+	require.ErrorContains(t, err, "no pending changes")
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package shai
+
+import "io/fs"
+
+// Overlay workspaces rely on the Docker daemon mounting overlayfs over host
+// paths, which only a Linux host can do.
+const overlaySupported = false
+
+func isWhiteout(fs.FileInfo) bool { return false }
+
+func isOpaqueDir(string) bool { return false }
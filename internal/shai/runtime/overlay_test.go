@@ -0,0 +1,161 @@
+package shai
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// overlayFixture lays out a workspace and an overlay upper directory as
+// overlayfs would leave them after a session.
+func overlayFixture(t *testing.T) (workspace, dir string) {
+	t.Helper()
+	workspace = t.TempDir()
+	dir = t.TempDir()
+	upper := overlayUpperDir(dir)
+
+	writeFile(t, filepath.Join(workspace, "README.md"), "hello\n")
+	writeFile(t, filepath.Join(workspace, "main.go"), "package main\n")
+	writeFile(t, filepath.Join(workspace, "same.txt"), "unchanged\n")
+	writeFile(t, filepath.Join(workspace, ".shai", "config.yaml"), "image: base\n")
+
+	writeFile(t, filepath.Join(upper, "README.md"), "hello\nworld\n")
+	writeFile(t, filepath.Join(upper, "same.txt"), "unchanged\n")
+	writeFile(t, filepath.Join(upper, "pkg", "new.go"), "package pkg\n")
+	writeFile(t, filepath.Join(upper, ".shai", "config.yaml"), "image: evil\n")
+	return workspace, dir
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+func TestOverlayChanges(t *testing.T) {
+	workspace, dir := overlayFixture(t)
+
+	changes, err := overlayChanges(workspace, overlayUpperDir(dir))
+	require.NoError(t, err)
+	require.Equal(t, []WorkspaceChange{
+		{Path: "README.md", Kind: ChangeModified},
+		{Path: "pkg", Kind: ChangeAdded, Dir: true},
+		{Path: "pkg/new.go", Kind: ChangeAdded},
+	}, changes)
+}
+
+func TestOverlayChangesMissingOverlay(t *testing.T) {
+	changes, err := overlayChanges(t.TempDir(), filepath.Join(t.TempDir(), "upper"))
+	require.NoError(t, err)
+	require.Empty(t, changes)
+}
+
+func TestSelectChanges(t *testing.T) {
+	changes := []WorkspaceChange{
+		{Path: "README.md", Kind: ChangeModified},
+		{Path: "pkg", Kind: ChangeAdded, Dir: true},
+		{Path: "pkg/new.go", Kind: ChangeAdded},
+		{Path: "pkgs.txt", Kind: ChangeAdded},
+	}
+
+	selected, err := selectChanges(changes, []string{"pkg/"})
+	require.NoError(t, err)
+	require.Equal(t, changes[1:3], selected)
+
+	selected, err = selectChanges(changes, nil)
+	require.NoError(t, err)
+	require.Equal(t, changes, selected)
+
+	_, err = selectChanges(changes, []string{"missing"})
+	require.ErrorContains(t, err, "no pending changes to missing")
+	_, err = selectChanges(changes, []string{"../elsewhere"})
+	require.ErrorContains(t, err, "outside the workspace")
+	_, err = selectChanges(changes, []string{".shai/config.yaml"})
+	require.ErrorContains(t, err, "never applied")
+}
+
+func TestApplyOverlayPaths(t *testing.T) {
+	workspace, dir := overlayFixture(t)
+
+	applied, err := applyOverlay(workspace, dir, []string{"pkg"})
+	require.NoError(t, err)
+	require.Len(t, applied, 2)
+
+	data, err := os.ReadFile(filepath.Join(workspace, "pkg", "new.go"))
+	require.NoError(t, err)
+	require.Equal(t, "package pkg\n", string(data))
+	data, err = os.ReadFile(filepath.Join(workspace, "README.md"))
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", string(data), "changes outside the paths stay in the overlay")
+
+	// Applied and unchanged entries are pruned; the rest stay pending.
+	upper := overlayUpperDir(dir)
+	require.NoDirExists(t, filepath.Join(upper, "pkg"))
+	require.NoFileExists(t, filepath.Join(upper, "same.txt"))
+	changes, err := overlayChanges(workspace, upper)
+	require.NoError(t, err)
+	require.Equal(t, []WorkspaceChange{{Path: "README.md", Kind: ChangeModified}}, changes)
+}
+
+func TestApplyOverlayAll(t *testing.T) {
+	workspace, dir := overlayFixture(t)
+
+	applied, err := applyOverlay(workspace, dir, nil)
+	require.NoError(t, err)
+	require.Len(t, applied, 3)
+
+	data, err := os.ReadFile(filepath.Join(workspace, "README.md"))
+	require.NoError(t, err)
+	require.Equal(t, "hello\nworld\n", string(data))
+	data, err = os.ReadFile(filepath.Join(workspace, ".shai", "config.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, "image: base\n", string(data), ".shai is never applied")
+	require.NoDirExists(t, dir)
+}
+
+func TestApplyOverlayRefusesSymlinkedParent(t *testing.T) {
+	workspace, dir := overlayFixture(t)
+	outside := t.TempDir()
+	require.NoError(t, os.Symlink(outside, filepath.Join(workspace, "pkg")))
+
+	_, err := applyOverlay(workspace, dir, []string{"pkg/new.go"})
+	require.ErrorContains(t, err, "not a directory")
+	require.NoFileExists(t, filepath.Join(outside, "new.go"))
+}
+
+func TestWriteChangeDiff(t *testing.T) {
+	workspace, dir := overlayFixture(t)
+	upper := overlayUpperDir(dir)
+
+	var out bytes.Buffer
+	require.NoError(t, writeChangeDiff(&out, workspace, upper, WorkspaceChange{Path: "README.md", Kind: ChangeModified}))
+	require.Equal(t, "--- a/README.md\n+++ b/README.md\n@@ -1 +1,2 @@\n hello\n+world\n", out.String())
+
+	out.Reset()
+	require.NoError(t, writeChangeDiff(&out, workspace, upper, WorkspaceChange{Path: "pkg", Kind: ChangeAdded, Dir: true}))
+	require.Equal(t, "new directory pkg/\n", out.String())
+
+	out.Reset()
+	require.NoError(t, writeChangeDiff(&out, workspace, upper, WorkspaceChange{Path: "main.go", Kind: ChangeDeleted}))
+	require.Contains(t, out.String(), "+++ /dev/null\n")
+	require.Contains(t, out.String(), "-package main\n")
+
+	writeFile(t, filepath.Join(upper, "blob.bin"), "a\x00b")
+	out.Reset()
+	require.NoError(t, writeChangeDiff(&out, workspace, upper, WorkspaceChange{Path: "blob.bin", Kind: ChangeAdded}))
+	require.Equal(t, "Binary files /dev/null and b/blob.bin differ\n", out.String())
+}
+
+func TestOverlayDirIsPerWorkspace(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	a, err := OverlayDir("/work/my repo")
+	require.NoError(t, err)
+	b, err := OverlayDir("/other/my repo")
+	require.NoError(t, err)
+	require.NotEqual(t, a, b)
+	require.Equal(t, OverlayStateDir(), filepath.Dir(a))
+	require.Regexp(t, `^my_repo-[0-9a-f]{12}$`, filepath.Base(a))
+}
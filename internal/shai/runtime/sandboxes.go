@@ -0,0 +1,357 @@
+package shai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Labels shai sets on the containers it creates.
+const (
+	// LabelSandbox marks every shai sandbox container.
+	LabelSandbox = "shai.sandbox"
+	// LabelName holds the name given with EphemeralConfig.Name.
+	LabelName = "shai.name"
+	// LabelBootstrapDir holds the host directory mounted into the
+	// container for the bootstrap, so RemoveSandbox can delete it when
+	// the process that owned it is gone.
+	LabelBootstrapDir = "shai.bootstrap-dir"
+	// LabelWorkspace holds the host workspace directory.
+	LabelWorkspace = "shai.workspace"
+	// LabelReadWrite holds the read-write paths, relative to the
+	// workspace, as a JSON array.
+	LabelReadWrite = "shai.read-write"
+	// LabelResourceSets holds the active resource sets as a JSON array.
+	LabelResourceSets = "shai.resource-sets"
+	// LabelImage holds the image the sandbox was started from.
+	LabelImage = "shai.image"
+	// LabelVersion holds EphemeralConfig.Version.
+	LabelVersion = "shai.version"
+	// LabelOverlay holds the overlay directory of a sandbox started with
+	// EphemeralConfig.Overlay.
+	LabelOverlay = "shai.overlay"
+)
+
+// SandboxInfo describes a sandbox container, mostly from its labels.
+type SandboxInfo struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name,omitempty"`
+	Container    string    `json:"container"`
+	State        string    `json:"state"`
+	Status       string    `json:"status"`
+	Created      time.Time `json:"created"`
+	Workspace    string    `json:"workspace"`
+	ReadWrite    []string  `json:"readWrite"`
+	ResourceSets []string  `json:"resourceSets"`
+	Image        string    `json:"image"`
+	Version      string    `json:"version,omitempty"`
+	Overlay      string    `json:"overlay,omitempty"`
+}
+
+// Running reports whether the sandbox's container is running.
+func (s SandboxInfo) Running() bool {
+	return s.State == string(container.StateRunning)
+}
+
+// startMarkerPrefix begins the line the bootstrap prints when the user's
+// command starts; see buildStartMarker.
+const startMarkerPrefix = "Shai sandbox started using ["
+
+var sandboxNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ValidateSandboxName checks that name can be used in a container name:
+// letters, digits, '_', '.' and '-', starting with a letter or digit.
+func ValidateSandboxName(name string) error {
+	if !sandboxNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid sandbox name %q (use letters, digits, '_', '.' and '-', starting with a letter or digit)", name)
+	}
+	return nil
+}
+
+// sandboxContainerName is the container name for a named sandbox.
+func sandboxContainerName(name string) string {
+	return "shai-" + name
+}
+
+// listLabel encodes a list for a label. Paths may contain commas, so lists
+// are stored as JSON.
+func listLabel(values []string) string {
+	if values == nil {
+		values = []string{}
+	}
+	data, _ := json.Marshal(values)
+	return string(data)
+}
+
+func parseListLabel(value string) []string {
+	var values []string
+	if err := json.Unmarshal([]byte(value), &values); err != nil || values == nil {
+		return []string{}
+	}
+	return values
+}
+
+func sandboxInfo(c container.Summary) SandboxInfo {
+	name := ""
+	if len(c.Names) > 0 {
+		name = strings.TrimPrefix(c.Names[0], "/")
+	}
+	return SandboxInfo{
+		ID:           c.ID,
+		Name:         c.Labels[LabelName],
+		Container:    name,
+		State:        string(c.State),
+		Status:       c.Status,
+		Created:      time.Unix(c.Created, 0),
+		Workspace:    c.Labels[LabelWorkspace],
+		ReadWrite:    parseListLabel(c.Labels[LabelReadWrite]),
+		ResourceSets: parseListLabel(c.Labels[LabelResourceSets]),
+		Image:        c.Labels[LabelImage],
+		Version:      c.Labels[LabelVersion],
+		Overlay:      c.Labels[LabelOverlay],
+	}
+}
+
+func listSandboxContainers(ctx context.Context, docker *client.Client, all bool) ([]container.Summary, error) {
+	list, err := docker.ContainerList(ctx, container.ListOptions{
+		All:     all,
+		Filters: filters.NewArgs(filters.Arg("label", LabelSandbox)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list sandboxes: %w", err)
+	}
+	return list, nil
+}
+
+// ListSandboxes returns the running sandboxes, newest first, or every
+// sandbox container that still exists when all is set.
+func ListSandboxes(ctx context.Context, all bool) ([]SandboxInfo, error) {
+	docker, err := newDockerClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create docker client: %w", err)
+	}
+	defer docker.Close()
+
+	list, err := listSandboxContainers(ctx, docker, all)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]SandboxInfo, 0, len(list))
+	for _, c := range list {
+		infos = append(infos, sandboxInfo(c))
+	}
+	sort.SliceStable(infos, func(i, j int) bool { return infos[i].Created.After(infos[j].Created) })
+	return infos, nil
+}
+
+// resolveSandbox finds a sandbox by name, or else by a unique prefix of its
+// container ID.
+func resolveSandbox(ctx context.Context, docker *client.Client, ref string) (container.Summary, error) {
+	list, err := listSandboxContainers(ctx, docker, true)
+	if err != nil {
+		return container.Summary{}, err
+	}
+	return matchSandbox(list, ref)
+}
+
+func matchSandbox(list []container.Summary, ref string) (container.Summary, error) {
+	if ref == "" {
+		return container.Summary{}, errors.New("no sandbox name or ID given")
+	}
+	for _, c := range list {
+		if c.Labels[LabelName] == ref {
+			return c, nil
+		}
+	}
+	var matches []container.Summary
+	for _, c := range list {
+		if strings.HasPrefix(c.ID, ref) {
+			matches = append(matches, c)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return container.Summary{}, fmt.Errorf("no sandbox named %q", ref)
+	case 1:
+		return matches[0], nil
+	}
+	return container.Summary{}, fmt.Errorf("sandbox ID %q is ambiguous", ref)
+}
+
+// StopSandbox stops a running sandbox, found by name or ID, killing it if
+// it has not exited after timeout. A sandbox started without --detach is
+// removed once it stops.
+func StopSandbox(ctx context.Context, ref string, timeout time.Duration) error {
+	docker, err := newDockerClient()
+	if err != nil {
+		return fmt.Errorf("failed to create docker client: %w", err)
+	}
+	defer docker.Close()
+
+	summary, err := resolveSandbox(ctx, docker, ref)
+	if err != nil {
+		return err
+	}
+	if summary.State != container.StateRunning {
+		return fmt.Errorf("sandbox %s is not running", ref)
+	}
+	secs := int(timeout / time.Second)
+	if err := docker.ContainerStop(ctx, summary.ID, container.StopOptions{Timeout: &secs}); err != nil {
+		return fmt.Errorf("stop sandbox %s: %w", ref, err)
+	}
+	return nil
+}
+
+// SandboxLogs copies a sandbox's output, found by name or ID, to stdout and
+// stderr. With follow it keeps copying until the sandbox exits or ctx is
+// done.
+func SandboxLogs(ctx context.Context, ref string, follow bool, stdout, stderr io.Writer) error {
+	docker, err := newDockerClient()
+	if err != nil {
+		return fmt.Errorf("failed to create docker client: %w", err)
+	}
+	defer docker.Close()
+
+	summary, err := resolveSandbox(ctx, docker, ref)
+	if err != nil {
+		return err
+	}
+	info, err := docker.ContainerInspect(ctx, summary.ID)
+	if err != nil {
+		return fmt.Errorf("inspect sandbox %s: %w", ref, err)
+	}
+	logs, err := docker.ContainerLogs(ctx, summary.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true, Follow: follow})
+	if err != nil {
+		return fmt.Errorf("read sandbox logs: %w", err)
+	}
+	defer logs.Close()
+	// TTY sandboxes have a single raw stream; others are multiplexed.
+	if info.Config != nil && info.Config.Tty {
+		_, err = io.Copy(stdout, logs)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, logs)
+	}
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("read sandbox logs: %w", err)
+	}
+	return nil
+}
+
+// findSandbox returns the container of the named sandbox, running or not.
+func findSandbox(ctx context.Context, docker *client.Client, name string) (container.Summary, error) {
+	list, err := docker.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", LabelName+"="+name)),
+	})
+	if err != nil {
+		return container.Summary{}, fmt.Errorf("list sandboxes: %w", err)
+	}
+	if len(list) == 0 {
+		return container.Summary{}, fmt.Errorf("no sandbox named %q", name)
+	}
+	return list[0], nil
+}
+
+// AttachSandbox connects the terminal to a sandbox, found by name or ID,
+// with the same TTY handling as the session that started it. It returns
+// when the sandbox exits or the Docker detach keys (ctrl-p ctrl-q) are
+// pressed.
+func AttachSandbox(ctx context.Context, ref string) error {
+	docker, err := newDockerClient()
+	if err != nil {
+		return fmt.Errorf("failed to create docker client: %w", err)
+	}
+	defer docker.Close()
+
+	summary, err := resolveSandbox(ctx, docker, ref)
+	if err != nil {
+		return err
+	}
+	info, err := docker.ContainerInspect(ctx, summary.ID)
+	if err != nil {
+		return fmt.Errorf("inspect sandbox %s: %w", ref, err)
+	}
+	if info.State == nil || !info.State.Running {
+		status := 0
+		if info.State != nil {
+			status = info.State.ExitCode
+		}
+		return fmt.Errorf("sandbox %s is not running (exited with status %d); remove it with `shai rm %s`", ref, status, ref)
+	}
+	started, err := bootstrapStarted(ctx, docker, summary.ID)
+	if err != nil {
+		return err
+	}
+	useTTY := info.Config != nil && info.Config.Tty
+	return attachContainer(ctx, docker, summary.ID, attachSpec{
+		useTTY:     useTTY,
+		marker:     startMarkerPrefix,
+		started:    started,
+		detachable: true,
+	})
+}
+
+// bootstrapStarted reports whether the container has printed the start
+// marker, so a reattached terminal can pass Ctrl-C straight through.
+func bootstrapStarted(ctx context.Context, docker *client.Client, id string) (bool, error) {
+	logs, err := docker.ContainerLogs(ctx, id, container.LogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return false, fmt.Errorf("read sandbox logs: %w", err)
+	}
+	defer logs.Close()
+	started := false
+	detector := newExecStartDetector(io.Discard, startMarkerPrefix, func() { started = true })
+	if _, err := io.Copy(detector, logs); err != nil {
+		return false, fmt.Errorf("read sandbox logs: %w", err)
+	}
+	return started, nil
+}
+
+// RemoveSandbox deletes a sandbox's container, found by name or ID, and its
+// anonymous volumes, such as an overlay workspace's mount. A running
+// sandbox is only removed with force, which kills it first.
+func RemoveSandbox(ctx context.Context, ref string, force bool) error {
+	docker, err := newDockerClient()
+	if err != nil {
+		return fmt.Errorf("failed to create docker client: %w", err)
+	}
+	defer docker.Close()
+
+	summary, err := resolveSandbox(ctx, docker, ref)
+	if err != nil {
+		return err
+	}
+	if summary.State == container.StateRunning && !force {
+		return fmt.Errorf("sandbox %s is running; stop it with `shai stop %s` or remove it with --force", ref, ref)
+	}
+	if err := docker.ContainerRemove(ctx, summary.ID, container.RemoveOptions{Force: force, RemoveVolumes: true}); err != nil {
+		return fmt.Errorf("remove sandbox %s: %w", ref, err)
+	}
+	if dir := summary.Labels[LabelBootstrapDir]; isBootstrapDir(dir) {
+		_ = os.RemoveAll(dir)
+	}
+	return nil
+}
+
+// isBootstrapDir reports whether dir is one ensureBootstrapScript creates,
+// so a label can't direct RemoveSandbox elsewhere.
+func isBootstrapDir(dir string) bool {
+	if dir == "" || !filepath.IsAbs(dir) {
+		return false
+	}
+	dir = filepath.Clean(dir)
+	return filepath.Dir(dir) == filepath.Clean(os.TempDir()) && strings.HasPrefix(filepath.Base(dir), "shai-")
+}
@@ -0,0 +1,119 @@
+package shai
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	configpkg "github.com/colony-2/shai/internal/shai/runtime/config"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSandboxName(t *testing.T) {
+	for _, name := range []string{"api-agent", "a", "agent_2.dev"} {
+		require.NoError(t, ValidateSandboxName(name), name)
+	}
+	for _, name := range []string{"", "-agent", ".hidden", "has space", "a/b"} {
+		require.Error(t, ValidateSandboxName(name), name)
+	}
+}
+
+func TestNewEphemeralRunnerDetachNeedsName(t *testing.T) {
+	_, err := NewEphemeralRunner(EphemeralConfig{WorkingDir: t.TempDir(), Detach: true})
+	require.ErrorContains(t, err, "needs a name")
+}
+
+func TestIsBootstrapDir(t *testing.T) {
+	require.True(t, isBootstrapDir(filepath.Join(os.TempDir(), "shai-123")))
+	require.False(t, isBootstrapDir(""))
+	require.False(t, isBootstrapDir("shai-123"))
+	require.False(t, isBootstrapDir(filepath.Join(os.TempDir(), "other")))
+	require.False(t, isBootstrapDir(filepath.Join(os.TempDir(), "x", "shai-123")))
+	require.False(t, isBootstrapDir("/home/user/shai-123"))
+}
+
+func TestBuildDockerConfigsNamedDetached(t *testing.T) {
+	tDir := t.TempDir()
+	mountBuilder, err := NewMountBuilder(tDir, nil)
+	require.NoError(t, err)
+
+	runner := &EphemeralRunner{
+		config: EphemeralConfig{
+			WorkingDir: tDir,
+			Name:       "api-agent",
+			Detach:     true,
+		},
+		shaiConfig: &configpkg.Config{
+			User:      "shai",
+			Workspace: "/src",
+		},
+		mountBuilder:  mountBuilder,
+		image:         "example",
+		resourceNames: []string{"base"},
+		hostEnv:       map[string]string{},
+	}
+	t.Cleanup(func() { _ = runner.Close() })
+
+	cfg, hostCfg, err := runner.buildDockerConfigs(true, sandboxContainerName("api-agent"))
+	require.NoError(t, err)
+	require.False(t, hostCfg.AutoRemove)
+	require.Equal(t, "true", cfg.Labels[LabelSandbox])
+	require.Equal(t, "api-agent", cfg.Labels[LabelName])
+	require.Equal(t, runner.bootstrapDir, cfg.Labels[LabelBootstrapDir])
+	require.True(t, isBootstrapDir(cfg.Labels[LabelBootstrapDir]))
+	require.Equal(t, tDir, cfg.Labels[LabelWorkspace])
+	require.Equal(t, `[]`, cfg.Labels[LabelReadWrite])
+	require.Equal(t, `["base"]`, cfg.Labels[LabelResourceSets])
+	require.Equal(t, "example", cfg.Labels[LabelImage])
+
+	runner.config.Detach = false
+	_, hostCfg, err = runner.buildDockerConfigs(true, sandboxContainerName("api-agent"))
+	require.NoError(t, err)
+	require.True(t, hostCfg.AutoRemove)
+}
+
+func TestSandboxInfoFromLabels(t *testing.T) {
+	info := sandboxInfo(container.Summary{
+		ID:     "0123456789abcdef",
+		Names:  []string{"/shai-api"},
+		State:  container.StateRunning,
+		Status: "Up 1 minute",
+		Labels: map[string]string{
+			LabelSandbox:      "true",
+			LabelName:         "api",
+			LabelWorkspace:    "/home/dev/api",
+			LabelReadWrite:    listLabel([]string{"src", "a,b"}),
+			LabelResourceSets: listLabel(nil),
+			LabelImage:        "shai-base",
+			LabelVersion:      "1.2.3",
+		},
+	})
+	require.True(t, info.Running())
+	require.Equal(t, "api", info.Name)
+	require.Equal(t, "shai-api", info.Container)
+	require.Equal(t, "/home/dev/api", info.Workspace)
+	require.Equal(t, []string{"src", "a,b"}, info.ReadWrite)
+	require.Equal(t, []string{}, info.ResourceSets)
+	require.Equal(t, "1.2.3", info.Version)
+}
+
+func TestMatchSandbox(t *testing.T) {
+	list := []container.Summary{
+		{ID: "abc123", Labels: map[string]string{LabelName: "api"}},
+		{ID: "abd456"},
+		{ID: "api999"},
+	}
+	got, err := matchSandbox(list, "api")
+	require.NoError(t, err)
+	require.Equal(t, "abc123", got.ID, "names win over ID prefixes")
+
+	got, err = matchSandbox(list, "abd")
+	require.NoError(t, err)
+	require.Equal(t, "abd456", got.ID)
+
+	_, err = matchSandbox(list, "ab")
+	require.ErrorContains(t, err, "ambiguous")
+	_, err = matchSandbox(list, "zzz")
+	require.ErrorContains(t, err, "no sandbox")
+}
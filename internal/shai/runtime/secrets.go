@@ -0,0 +1,190 @@
+package shai
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	configpkg "github.com/colony-2/shai/internal/shai/runtime/config"
+)
+
+// SecretProvider resolves the reference in a var's file, command or keyring
+// field to the secret's value.
+type SecretProvider interface {
+	Secret(ctx context.Context, ref string) (string, error)
+}
+
+// SecretProviderFunc adapts a function to SecretProvider.
+type SecretProviderFunc func(ctx context.Context, ref string) (string, error)
+
+// Secret calls f.
+func (f SecretProviderFunc) Secret(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+// secretsMountDir is where the bootstrap finds the secrets the runner
+// writes into its mount, one file per var.
+const secretsMountDir = "secrets"
+
+// secretProviders returns the built-in providers, replaced by any in
+// overrides. Relative file paths and commands resolve against workingDir.
+func secretProviders(workingDir string, hostEnv map[string]string, overrides map[string]SecretProvider) (map[string]SecretProvider, error) {
+	providers := map[string]SecretProvider{
+		configpkg.SecretFile:    fileSecrets{dir: workingDir, home: hostEnv["HOME"]},
+		configpkg.SecretCommand: commandSecrets{dir: workingDir},
+		configpkg.SecretKeyring: SecretProviderFunc(keyringSecret),
+	}
+	for name, provider := range overrides {
+		if _, ok := providers[name]; !ok {
+			return nil, fmt.Errorf("unknown secret provider %q (must be file, command or keyring)", name)
+		}
+		if provider != nil {
+			providers[name] = provider
+		}
+	}
+	return providers, nil
+}
+
+// fileSecrets reads a host file and trims surrounding whitespace. A leading
+// ~/ expands to home.
+type fileSecrets struct {
+	dir  string
+	home string
+}
+
+func (f fileSecrets) Secret(_ context.Context, ref string) (string, error) {
+	data, err := os.ReadFile(f.path(ref))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (f fileSecrets) path(ref string) string {
+	path := ref
+	if rest, ok := strings.CutPrefix(path, "~/"); ok && f.home != "" {
+		path = filepath.Join(f.home, rest)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(f.dir, path)
+	}
+	return path
+}
+
+// commandSecrets runs a host command, split like an argv call, and uses its
+// trimmed stdout.
+type commandSecrets struct {
+	dir string
+}
+
+func (c commandSecrets) Secret(ctx context.Context, ref string) (string, error) {
+	argv, err := configpkg.SplitCommand(ref)
+	if err != nil {
+		return "", err
+	}
+	if len(argv) == 0 {
+		return "", errors.New("empty command")
+	}
+	return runSecretCommand(ctx, c.dir, argv)
+}
+
+// keyringSecret reads from the OS keyring. ref is a service, optionally
+// followed by /account; the account is everything after the last slash.
+func keyringSecret(ctx context.Context, ref string) (string, error) {
+	service, account := ref, ""
+	if i := strings.LastIndex(ref, "/"); i > 0 {
+		service, account = ref[:i], ref[i+1:]
+	}
+	var argv []string
+	switch runtime.GOOS {
+	case "darwin":
+		argv = []string{"security", "find-generic-password", "-s", service, "-w"}
+		if account != "" {
+			argv = append(argv, "-a", account)
+		}
+	case "linux", "freebsd", "openbsd":
+		argv = []string{"secret-tool", "lookup", "service", service}
+		if account != "" {
+			argv = append(argv, "account", account)
+		}
+	default:
+		return "", fmt.Errorf("no keyring support on %s", runtime.GOOS)
+	}
+	if _, err := exec.LookPath(argv[0]); err != nil {
+		return "", fmt.Errorf("keyring lookup needs %s: %w", argv[0], err)
+	}
+	return runSecretCommand(ctx, "", argv)
+}
+
+// runSecretCommand runs argv and returns its trimmed stdout. Stderr is kept
+// for the error, since stdout may hold part of the secret.
+func runSecretCommand(ctx context.Context, dir string, argv []string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %w: %s", argv[0], err, msg)
+		}
+		return "", fmt.Errorf("%s: %w", argv[0], err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// secretValue resolves a hidden var: from its secret source, or from the
+// host env for a host var delivered as a file. Errors never include the
+// value.
+func (r *EphemeralRunner) secretValue(ctx context.Context, v configpkg.VarMapping) (string, error) {
+	source, ref, ok := v.Secret()
+	if !ok {
+		value, found := r.hostEnv[strings.TrimSpace(v.Source)]
+		if !found {
+			return "", fmt.Errorf("host env %q not set", v.Source)
+		}
+		return value, nil
+	}
+	provider := r.secretProviders[source]
+	if provider == nil {
+		return "", fmt.Errorf("var %s: no %s secret provider", v.Name(), source)
+	}
+	value, err := provider.Secret(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("var %s: read %s secret %q: %w", v.Name(), source, ref, err)
+	}
+	return value, nil
+}
+
+// writeSecrets resolves the vars kept out of the bootstrap argv and writes
+// them, one file per var, into the bootstrap mount. The bootstrap exports
+// them or moves them onto the secrets tmpfs, then deletes the copies.
+func (r *EphemeralRunner) writeSecrets(ctx context.Context) error {
+	_, secrets, err := r.collectEnvMappings()
+	if err != nil || len(secrets) == 0 {
+		return err
+	}
+	if err := r.ensureBootstrapScript(); err != nil {
+		return err
+	}
+	dir := filepath.Join(r.bootstrapMount, secretsMountDir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("create secrets dir: %w", err)
+	}
+	for _, v := range secrets {
+		value, err := r.secretValue(ctx, v)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, v.Name()), []byte(value), 0o600); err != nil {
+			return fmt.Errorf("write secret %s: %w", v.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package shai
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	configpkg "github.com/colony-2/shai/internal/shai/runtime/config"
+	"github.com/stretchr/testify/require"
+)
+
+func secretsRunner(t *testing.T, vars ...configpkg.VarMapping) *EphemeralRunner {
+	t.Helper()
+	dir := t.TempDir()
+	mountBuilder, err := NewMountBuilder(dir, nil)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "token.txt"), []byte("  file-secret\n"), 0o600))
+
+	providers, err := secretProviders(dir, map[string]string{"HOME": dir}, map[string]SecretProvider{
+		configpkg.SecretKeyring: SecretProviderFunc(func(_ context.Context, ref string) (string, error) {
+			if ref != "npm/token" {
+				return "", errors.New("no such entry")
+			}
+			return "keyring-secret", nil
+		}),
+	})
+	require.NoError(t, err)
+
+	runner := &EphemeralRunner{
+		config:       EphemeralConfig{WorkingDir: dir},
+		shaiConfig:   &configpkg.Config{User: "shai", Workspace: "/src"},
+		mountBuilder: mountBuilder,
+		image:        "example",
+		resources: []*configpkg.ResolvedResource{
+			{Name: "base", Spec: &configpkg.ResourceSet{Vars: vars}},
+		},
+		hostEnv:         map[string]string{"PLAIN": "plain-value", "HOST_KEY": "host-secret"},
+		secretProviders: providers,
+	}
+	t.Cleanup(func() { _ = runner.Close() })
+	return runner
+}
+
+func TestSecretsStayOutOfBootstrapArgs(t *testing.T) {
+	runner := secretsRunner(t,
+		configpkg.VarMapping{Source: "PLAIN"},
+		configpkg.VarMapping{File: "token.txt", Target: "FILE_TOKEN"},
+		configpkg.VarMapping{Keyring: "npm/token", Target: "NPM_TOKEN", As: configpkg.DeliverFile},
+		configpkg.VarMapping{Source: "HOST_KEY", As: configpkg.DeliverFile},
+	)
+
+	args, err := runner.buildBootstrapArgs()
+	require.NoError(t, err)
+	joined := strings.Join(args, " ")
+	require.Contains(t, joined, "--exec-env PLAIN=plain-value")
+	require.Contains(t, joined, "--secret-env FILE_TOKEN --secret-file NPM_TOKEN --secret-file HOST_KEY")
+	for _, value := range []string{"file-secret", "keyring-secret", "host-secret"} {
+		require.NotContains(t, joined, value)
+	}
+
+	require.NoError(t, runner.writeSecrets(context.Background()))
+	dir := filepath.Join(runner.bootstrapMount, secretsMountDir)
+	for name, want := range map[string]string{
+		"FILE_TOKEN": "file-secret",
+		"NPM_TOKEN":  "keyring-secret",
+		"HOST_KEY":   "host-secret",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		require.Equal(t, want, string(data))
+		info, err := os.Stat(filepath.Join(dir, name))
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	}
+	_, err = os.Stat(filepath.Join(dir, "PLAIN"))
+	require.True(t, os.IsNotExist(err), "host env vars stay bootstrap args")
+
+	_, hostCfg, err := runner.buildDockerConfigs(false, "sandbox-test")
+	require.NoError(t, err)
+	require.Contains(t, hostCfg.Tmpfs, configpkg.SecretsDir)
+}
+
+func TestSecretLaterVarReplacesEarlier(t *testing.T) {
+	runner := secretsRunner(t,
+		configpkg.VarMapping{Source: "PLAIN", Target: "TOKEN"},
+		configpkg.VarMapping{Keyring: "npm/token", Target: "TOKEN"},
+	)
+	envs, secrets, err := runner.collectEnvMappings()
+	require.NoError(t, err)
+	require.Empty(t, envs)
+	require.Len(t, secrets, 1)
+	require.Equal(t, "npm/token", secrets[0].Keyring)
+
+	_, hostCfg, err := runner.buildDockerConfigs(false, "sandbox-test")
+	require.NoError(t, err)
+	require.Empty(t, hostCfg.Tmpfs, "no tmpfs without file secrets")
+}
+
+func TestSecretErrorsOmitValues(t *testing.T) {
+	runner := secretsRunner(t,
+		configpkg.VarMapping{Keyring: "missing", Target: "TOKEN"},
+	)
+	err := runner.writeSecrets(context.Background())
+	require.ErrorContains(t, err, `var TOKEN: read keyring secret "missing": no such entry`)
+
+	runner = secretsRunner(t,
+		configpkg.VarMapping{Command: `sh -c 'echo oops >&2; exit 3'`, Target: "TOKEN"},
+	)
+	err = runner.writeSecrets(context.Background())
+	require.ErrorContains(t, err, "exit status 3: oops")
+}
+
+func TestCommandSecretTrimsOutput(t *testing.T) {
+	value, err := commandSecrets{dir: t.TempDir()}.Secret(context.Background(), `printf ' s3cret \n'`)
+	require.NoError(t, err)
+	require.Equal(t, "s3cret", value)
+}
+
+func TestSecretProvidersRejectUnknownName(t *testing.T) {
+	_, err := secretProviders(t.TempDir(), nil, map[string]SecretProvider{"vault": SecretProviderFunc(nil)})
+	require.ErrorContains(t, err, `unknown secret provider "vault"`)
+}
@@ -3,7 +3,9 @@ package shai_test
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -13,6 +15,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/colony-2/shai/internal/shai/runtime/alias/mcp"
 )
 
 type rpcRequest struct {
@@ -132,6 +136,56 @@ func TestShaiRemoteExecStreamsOutput(t *testing.T) {
 	}
 }
 
+func TestShaiRemoteUsage(t *testing.T) {
+	srv := newAliasServer(t, "test-token", func(t *testing.T, body []byte) []byte {
+		var req rpcRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Fatalf("decode request: %v", err)
+		}
+		if req.Method != "listTools" {
+			t.Fatalf("expected listTools method, got %q", req.Method)
+		}
+		resp := map[string]any{
+			"jsonrpc": "2.0",
+			"id":      req.ID,
+			"result": map[string]any{
+				"tools": []map[string]any{
+					{
+						"name":        "deploy",
+						"description": "Deploy the app",
+						"inputSchema": map[string]any{
+							"type": "object",
+							"properties": map[string]any{
+								"dry-run": map[string]any{"type": "boolean"},
+								"env":     map[string]any{"type": "string", "enum": []string{"staging", "prod"}, "description": "Target environment"},
+							},
+							"required": []string{"env"},
+						},
+					},
+				},
+			},
+		}
+		out, _ := json.Marshal(resp)
+		return out
+	})
+	defer srv.Close()
+
+	stdout, stderr, code := runShaiRemote(t, []string{
+		"SHAI_ALIAS_ENDPOINT=" + srv.URL,
+		"SHAI_ALIAS_TOKEN=test-token",
+	}, "usage", "deploy")
+
+	if code != 0 {
+		t.Fatalf("usage exited with %d stderr=%q", code, stderr)
+	}
+	if !strings.HasPrefix(stdout, "Usage: shai-remote call deploy [--dry-run] --env=<staging|prod>\n") {
+		t.Fatalf("unexpected usage line in %q", stdout)
+	}
+	if !strings.Contains(stdout, "Target environment") {
+		t.Fatalf("usage missing parameter description: %q", stdout)
+	}
+}
+
 func TestShaiRemoteExecError(t *testing.T) {
 	const overrideToken = "override-token"
 	srv := newAliasServer(t, overrideToken, func(t *testing.T, body []byte) []byte {
@@ -167,6 +221,231 @@ func TestShaiRemoteExecError(t *testing.T) {
 	}
 }
 
+func TestShaiRemoteApprovalDenied(t *testing.T) {
+	srv := newAliasServer(t, "token", func(t *testing.T, body []byte) []byte {
+		var req rpcRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		out, _ := json.Marshal(map[string]any{
+			"jsonrpc": "2.0",
+			"id":      req.ID,
+			"error":   map[string]any{"code": -32005, "message": `call "flash" was denied on the host`},
+		})
+		return out
+	})
+	defer srv.Close()
+
+	_, stderr, code := runShaiRemote(t, nil, "call", "--endpoint", srv.URL, "--token", "token", "flash")
+	if code != 77 {
+		t.Fatalf("expected exit 77 for a denied call, got %d", code)
+	}
+	if !strings.Contains(stderr, "denied on the host") {
+		t.Fatalf("stderr %q missing denial", stderr)
+	}
+}
+
+type stdioExecutor struct{}
+
+func (stdioExecutor) Tools() []mcp.Tool {
+	return []mcp.Tool{{Name: "hosthello", Description: "echo hello"}}
+}
+
+func (stdioExecutor) Execute(ctx context.Context, req mcp.CallRequest, streams mcp.Streams) (int, error) {
+	fmt.Fprintf(streams.Stdout, "hello %s\n", strings.Join(req.Args, " "))
+	return 0, nil
+}
+
+func TestShaiRemoteMCPStdio(t *testing.T) {
+	server, err := mcp.NewServer(mcp.Config{
+		Token:     "test-token",
+		SessionID: "session-1",
+		Executor:  stdioExecutor{},
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	server.Start()
+	defer server.Close(context.Background())
+
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"hosthello","arguments":{"args":["world"]}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"missing","arguments":{}}}`,
+	}, "\n") + "\n"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, scriptPath(t), "mcp-stdio")
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("SHAI_ALIAS_ENDPOINT=http://127.0.0.1:%d/mcp", server.Port()),
+		"SHAI_ALIAS_TOKEN=test-token",
+		"SHAI_ALIAS_SESSION_ID=session-1",
+	)
+	cmd.Stdin = strings.NewReader(input)
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("mcp-stdio failed: %v\nstderr: %s", err, errBuf.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(outBuf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 responses (notification has none), got %d: %q", len(lines), outBuf.String())
+	}
+	var responses []map[string]any
+	for _, line := range lines {
+		var resp map[string]any
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatalf("response %q is not single-line JSON: %v", line, err)
+		}
+		responses = append(responses, resp)
+	}
+	if got := responses[0]["result"].(map[string]any)["protocolVersion"]; got != "2025-03-26" {
+		t.Fatalf("unexpected negotiated version %v", got)
+	}
+	tools := responses[1]["result"].(map[string]any)["tools"].([]any)
+	if len(tools) != 1 || tools[0].(map[string]any)["name"] != "hosthello" {
+		t.Fatalf("unexpected tools %v", tools)
+	}
+	content := responses[2]["result"].(map[string]any)["content"].([]any)
+	if text := content[0].(map[string]any)["text"]; text != "hello world\n" {
+		t.Fatalf("unexpected call output %v", text)
+	}
+	if code := responses[3]["error"].(map[string]any)["code"]; code != float64(-32602) {
+		t.Fatalf("expected invalid params error for unknown tool, got %v", responses[3])
+	}
+}
+
+type streamingExecutor struct{}
+
+func (streamingExecutor) Tools() []mcp.Tool {
+	return []mcp.Tool{{Name: "build", MaxOutputBytes: 64}}
+}
+
+func (streamingExecutor) Execute(ctx context.Context, req mcp.CallRequest, streams mcp.Streams) (int, error) {
+	fmt.Fprint(streams.Stdout, "step 1\n")
+	fmt.Fprint(streams.Stderr, "warn\n")
+	if len(req.Args) > 0 && req.Args[0] == "--noisy" {
+		fmt.Fprint(streams.Stdout, strings.Repeat("x", 100))
+	}
+	return 3, nil
+}
+
+func TestShaiRemoteCallStreamsFromServer(t *testing.T) {
+	server, err := mcp.NewServer(mcp.Config{
+		Token:     "test-token",
+		SessionID: "session-1",
+		Executor:  streamingExecutor{},
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	server.Start()
+	defer server.Close(context.Background())
+	env := []string{
+		fmt.Sprintf("SHAI_ALIAS_ENDPOINT=http://127.0.0.1:%d/mcp", server.Port()),
+		"SHAI_ALIAS_TOKEN=test-token",
+	}
+
+	stdout, stderr, code := runShaiRemote(t, env, "call", "build")
+	if code != 3 {
+		t.Fatalf("expected remote exit code 3, got %d stderr=%q", code, stderr)
+	}
+	if stdout != "step 1\n" || stderr != "warn\n" {
+		t.Fatalf("unexpected output stdout=%q stderr=%q", stdout, stderr)
+	}
+
+	stdout, stderr, _ = runShaiRemote(t, env, "call", "build", "--noisy")
+	// 64 bytes total: "step 1\n" (7) + "warn\n" (5) leaves 52 bytes of x.
+	if stdout != "step 1\n"+strings.Repeat("x", 52) {
+		t.Fatalf("expected output capped at 64 bytes, got stdout=%q stderr=%q", stdout, stderr)
+	}
+	if !strings.Contains(stderr, "[shai: output truncated after 64 bytes]") {
+		t.Fatalf("missing truncation marker in stderr %q", stderr)
+	}
+}
+
+// uppercaseExecutor uppercases an uploaded file and returns it as an output.
+type uppercaseExecutor struct{}
+
+func (uppercaseExecutor) Tools() []mcp.Tool {
+	return []mcp.Tool{{
+		Name: "upper",
+		InputSchema: &mcp.InputSchema{
+			Type: "object",
+			Properties: map[string]*mcp.PropertySchema{
+				"src":  {Type: "string", ContentEncoding: "base64"},
+				"mode": {Type: "string"},
+			},
+			Required: []string{"src"},
+		},
+		OutputFiles: []mcp.OutputFile{{Name: "result"}},
+	}}
+}
+
+func (uppercaseExecutor) Execute(ctx context.Context, req mcp.CallRequest, streams mcp.Streams) (int, error) {
+	data, err := base64.StdEncoding.DecodeString(req.Params["src"].(string))
+	if err != nil {
+		return 0, err
+	}
+	fmt.Fprintf(streams.Stdout, "mode=%v\n", req.Params["mode"])
+	return 0, streams.File("result", bytes.NewReader(bytes.ToUpper(data)))
+}
+
+func TestShaiRemoteTransfersFiles(t *testing.T) {
+	server, err := mcp.NewServer(mcp.Config{
+		Token:     "test-token",
+		SessionID: "session-1",
+		Executor:  uppercaseExecutor{},
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	server.Start()
+	defer server.Close(context.Background())
+	env := []string{
+		fmt.Sprintf("SHAI_ALIAS_ENDPOINT=http://127.0.0.1:%d/mcp", server.Port()),
+		"SHAI_ALIAS_TOKEN=test-token",
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "notes.txt")
+	content := strings.Repeat("hello world\n", 10000)
+	if err := os.WriteFile(src, []byte(content), 0o644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+	dst := filepath.Join(dir, "notes.upper")
+
+	stdout, stderr, code := runShaiRemote(t, env, "call", "upper", "--src="+src, "--mode=fast", "--result="+dst)
+	if code != 0 {
+		t.Fatalf("expected success, got %d stderr=%q", code, stderr)
+	}
+	if stdout != "mode=fast\n" {
+		t.Fatalf("unexpected stdout %q", stdout)
+	}
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if string(got) != strings.ToUpper(content) {
+		t.Fatalf("output file does not match: %d bytes", len(got))
+	}
+
+	_, stderr, code = runShaiRemote(t, env, "call", "upper", "--src="+filepath.Join(dir, "missing"))
+	if code != 64 || !strings.Contains(stderr, "not found") {
+		t.Fatalf("expected a usage error for a missing input, got %d stderr=%q", code, stderr)
+	}
+
+	stdout, _, _ = runShaiRemote(t, env, "usage", "upper")
+	if !strings.Contains(stdout, "--src=<file>") || !strings.Contains(stdout, "[--result=<path>]") {
+		t.Fatalf("expected file flags in usage, got %q", stdout)
+	}
+}
+
 func newAliasServer(t *testing.T, expectedToken string, responder func(t *testing.T, body []byte) []byte) *httptest.Server {
 	t.Helper()
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
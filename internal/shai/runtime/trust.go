@@ -0,0 +1,265 @@
+package shai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	configpkg "github.com/colony-2/shai/internal/shai/runtime/config"
+)
+
+// TrustDecision answers a TrustRequest.
+type TrustDecision int
+
+const (
+	// TrustDeny refuses to run the config.
+	TrustDeny TrustDecision = iota
+	// TrustOnce runs the config without recording it.
+	TrustOnce
+	// TrustAlways runs the config and records it in the trust store.
+	TrustAlways
+)
+
+// TrustRequest describes a workspace config that has not been trusted in
+// its current form.
+type TrustRequest struct {
+	ConfigPath string
+	// Digest hashes the workspace config and the files it includes.
+	Digest string
+	// Changed reports that an earlier version of the config was trusted.
+	Changed bool
+	// Summary lists the settings that reach the host: mounts, calls,
+	// privileged mode, exposed ports and vars.
+	Summary string
+}
+
+// UntrustedConfigError is returned when a workspace config has not been
+// trusted and no trust decision was supplied.
+type UntrustedConfigError struct {
+	TrustRequest
+}
+
+func (e *UntrustedConfigError) Error() string {
+	var b strings.Builder
+	if e.Changed {
+		fmt.Fprintf(&b, "shai config %s has changed since it was trusted", e.ConfigPath)
+	} else {
+		fmt.Fprintf(&b, "shai config %s is not trusted", e.ConfigPath)
+	}
+	if e.Summary != "" {
+		b.WriteString("; it asks for:\n")
+		b.WriteString(e.Summary)
+	} else {
+		b.WriteString("\n")
+	}
+	b.WriteString("Review it, then run `shai trust` to allow it.")
+	return b.String()
+}
+
+// TrustStorePath resolves the trust store: $SHAI_TRUST_STORE, then
+// $XDG_DATA_HOME/shai/trust.json, then ~/.local/share/shai/trust.json.
+func TrustStorePath() string {
+	if path := strings.TrimSpace(os.Getenv("SHAI_TRUST_STORE")); path != "" {
+		return path
+	}
+	if dir := strings.TrimSpace(os.Getenv("XDG_DATA_HOME")); dir != "" {
+		return filepath.Join(dir, "shai", "trust.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "shai", "trust.json")
+	}
+	return filepath.Join(home, ".local", "share", "shai", "trust.json")
+}
+
+// trustStore maps absolute config paths to the digest that was trusted.
+type trustStore struct {
+	path    string
+	Configs map[string]trustEntry `json:"configs"`
+}
+
+type trustEntry struct {
+	Digest  string    `json:"digest"`
+	Trusted time.Time `json:"trusted"`
+}
+
+func loadTrustStore(path string) (*trustStore, error) {
+	store := &trustStore{path: path, Configs: map[string]trustEntry{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read trust store: %w", err)
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("parse trust store %s: %w", path, err)
+	}
+	if store.Configs == nil {
+		store.Configs = map[string]trustEntry{}
+	}
+	return store, nil
+}
+
+// check reports whether digest is trusted for path, and whether another
+// digest was trusted before.
+func (s *trustStore) check(path, digest string) (trusted, changed bool) {
+	entry, ok := s.Configs[path]
+	if !ok {
+		return false, false
+	}
+	return entry.Digest == digest, entry.Digest != digest
+}
+
+func (s *trustStore) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("write trust store: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("write trust store: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("write trust store: %w", err)
+	}
+	return nil
+}
+
+// ReviewConfig loads the workspace config and reports whether it is trusted.
+// The request is nil when there is no workspace config to trust, as when the
+// embedded default is used.
+func ReviewConfig(workingDir, configFile string, vars map[string]string) (*TrustRequest, bool, error) {
+	cfg, err := LoadConfig(workingDir, configFile, vars)
+	if err != nil {
+		return nil, false, err
+	}
+	return reviewConfig(workspaceConfigPath(workingDir, configFile), cfg)
+}
+
+func reviewConfig(path string, cfg *configpkg.Config) (*TrustRequest, bool, error) {
+	digest := cfg.Digest(configpkg.LayerWorkspace)
+	if digest == "" {
+		return nil, true, nil
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	store, err := loadTrustStore(TrustStorePath())
+	if err != nil {
+		return nil, false, err
+	}
+	trusted, changed := store.check(path, digest)
+	return &TrustRequest{
+		ConfigPath: path,
+		Digest:     digest,
+		Changed:    changed,
+		Summary:    trustSummary(cfg),
+	}, trusted, nil
+}
+
+// TrustConfig records the request's config as trusted.
+func TrustConfig(req TrustRequest) error {
+	store, err := loadTrustStore(TrustStorePath())
+	if err != nil {
+		return err
+	}
+	store.Configs[req.ConfigPath] = trustEntry{Digest: req.Digest, Trusted: time.Now().UTC()}
+	return store.save()
+}
+
+// RevokeTrust forgets the config at path. It reports whether it was trusted.
+func RevokeTrust(path string) (bool, error) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	store, err := loadTrustStore(TrustStorePath())
+	if err != nil {
+		return false, err
+	}
+	if _, ok := store.Configs[path]; !ok {
+		return false, nil
+	}
+	delete(store.Configs, path)
+	return true, store.save()
+}
+
+// verifyTrust refuses untrusted workspace configs unless decide allows them.
+// SHAI_TRUST_ALL=1 skips the check, for CI and throwaway environments.
+func verifyTrust(path string, cfg *configpkg.Config, decide func(TrustRequest) TrustDecision) error {
+	if os.Getenv("SHAI_TRUST_ALL") == "1" {
+		return nil
+	}
+	req, trusted, err := reviewConfig(path, cfg)
+	if err != nil || trusted {
+		return err
+	}
+	if decide == nil {
+		return &UntrustedConfigError{TrustRequest: *req}
+	}
+	switch decide(*req) {
+	case TrustAlways:
+		return TrustConfig(*req)
+	case TrustOnce:
+		return nil
+	default:
+		return &UntrustedConfigError{TrustRequest: *req}
+	}
+}
+
+// trustSummary lists the host-impacting settings of the resource sets the
+// workspace layer declares. Org and user sets are already trusted.
+func trustSummary(cfg *configpkg.Config) string {
+	names := make([]string, 0, len(cfg.Resources))
+	for name, set := range cfg.Resources {
+		if set != nil && set.Source().Layer == configpkg.LayerWorkspace {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		set := cfg.Resources[name]
+		var lines []string
+		if set.Options.Privileged {
+			lines = append(lines, "privileged mode")
+		}
+		for _, m := range set.Mounts {
+			lines = append(lines, fmt.Sprintf("mount %s -> %s (%s)", m.Source, m.Target, m.Mode))
+		}
+		for _, call := range set.Calls {
+			command := call.Command
+			if command == "" {
+				command = "(no command)"
+			}
+			lines = append(lines, fmt.Sprintf("call %s: %s", call.Name, command))
+		}
+		for _, port := range set.Expose {
+			lines = append(lines, fmt.Sprintf("expose host port %d -> %d/%s", port.Host, port.Container, port.Protocol))
+		}
+		for _, v := range set.Vars {
+			if v.Name() == v.Origin() {
+				lines = append(lines, "var "+v.Origin())
+			} else {
+				lines = append(lines, fmt.Sprintf("var %s -> %s", v.Origin(), v.Name()))
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "  resource set %s (%s)\n", name, set.Source())
+		for _, line := range lines {
+			fmt.Fprintf(&b, "    %s\n", line)
+		}
+	}
+	return b.String()
+}
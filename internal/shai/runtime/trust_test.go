@@ -0,0 +1,111 @@
+package shai
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTrustConfig(t *testing.T, dir, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(dir, ConfigDirName), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, DefaultConfigRelPath), []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyTrust(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SHAI_TRUST_ALL", "")
+	t.Setenv("SHAI_TRUST_STORE", filepath.Join(dir, "trust.json"))
+	t.Setenv("SHAI_ORG_CONFIG", filepath.Join(dir, "missing.yaml"))
+	t.Setenv("SHAI_USER_CONFIG", filepath.Join(dir, "missing.yaml"))
+	workspace := filepath.Join(dir, "ws")
+	config := `
+type: shai-sandbox
+version: 1
+image: example
+resources:
+  repo:
+    options:
+      privileged: true
+    mounts:
+      - source: /tmp
+        target: /host-tmp
+        mode: rw
+    calls:
+      - name: deploy
+        command: ./deploy.sh
+    expose: [8080]
+    vars:
+      - source: TOKEN
+apply:
+  - path: ./
+    resources: [repo]
+`
+	writeTrustConfig(t, workspace, config)
+	verify := func(decide func(TrustRequest) TrustDecision) error {
+		t.Helper()
+		cfg, err := LoadConfig(workspace, "", nil)
+		if err != nil {
+			t.Fatalf("LoadConfig: %v", err)
+		}
+		return verifyTrust(workspaceConfigPath(workspace, ""), cfg, decide)
+	}
+
+	var untrusted *UntrustedConfigError
+	if err := verify(nil); !errors.As(err, &untrusted) {
+		t.Fatalf("expected an untrusted config error, got %v", err)
+	}
+	for _, want := range []string{"privileged mode", "mount /tmp -> /host-tmp (rw)", "call deploy: ./deploy.sh", "expose host port 8080 -> 8080/tcp", "var TOKEN"} {
+		if !strings.Contains(untrusted.Summary, want) {
+			t.Fatalf("summary %q missing %q", untrusted.Summary, want)
+		}
+	}
+
+	if err := verify(func(TrustRequest) TrustDecision { return TrustOnce }); err != nil {
+		t.Fatalf("TrustOnce: %v", err)
+	}
+	if err := verify(nil); err == nil {
+		t.Fatalf("TrustOnce must not be recorded")
+	}
+	if err := verify(func(TrustRequest) TrustDecision { return TrustDeny }); !errors.As(err, &untrusted) {
+		t.Fatalf("expected TrustDeny to refuse, got %v", err)
+	}
+	if err := verify(func(TrustRequest) TrustDecision { return TrustAlways }); err != nil {
+		t.Fatalf("TrustAlways: %v", err)
+	}
+	if err := verify(nil); err != nil {
+		t.Fatalf("expected the recorded config to be trusted, got %v", err)
+	}
+
+	writeTrustConfig(t, workspace, config+"# edited\n")
+	if err := verify(nil); !errors.As(err, &untrusted) || !untrusted.Changed {
+		t.Fatalf("expected a changed config to be refused, got %v", err)
+	}
+	if removed, err := RevokeTrust(filepath.Join(workspace, DefaultConfigRelPath)); err != nil || !removed {
+		t.Fatalf("RevokeTrust: removed=%v err=%v", removed, err)
+	}
+	if err := verify(nil); !errors.As(err, &untrusted) || untrusted.Changed {
+		t.Fatalf("expected a revoked config to be untrusted, got %v", err)
+	}
+}
+
+func TestVerifyTrustSkipsEmbeddedDefault(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SHAI_TRUST_ALL", "")
+	t.Setenv("SHAI_TRUST_STORE", filepath.Join(dir, "trust.json"))
+	t.Setenv("SHAI_ORG_CONFIG", filepath.Join(dir, "missing.yaml"))
+	t.Setenv("SHAI_USER_CONFIG", filepath.Join(dir, "missing.yaml"))
+	cfg, err := LoadConfig(dir, "", nil)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if err := verifyTrust(workspaceConfigPath(dir, ""), cfg, nil); err != nil {
+		t.Fatalf("expected the embedded default to need no trust, got %v", err)
+	}
+}
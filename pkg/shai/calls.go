@@ -0,0 +1,21 @@
+package shai
+
+import (
+	runtimepkg "github.com/colony-2/shai/internal/shai/runtime"
+	"github.com/colony-2/shai/internal/shai/runtime/alias"
+)
+
+// CallRecord is one entry of the host call audit log.
+type CallRecord = alias.AuditRecord
+
+// CallAuditDir resolves the directory holding call audit logs. An empty
+// override falls back to $SHAI_AUDIT_DIR and then the user's state directory.
+func CallAuditDir(override string) string {
+	return runtimepkg.AuditDir(override)
+}
+
+// ReadCallLog returns the audit records in dir ordered by time. A non-empty
+// session limits the result to that sandbox session.
+func ReadCallLog(dir, session string) ([]CallRecord, error) {
+	return alias.ReadAuditLog(dir, session)
+}
@@ -0,0 +1,66 @@
+package shai
+
+import (
+	runtimepkg "github.com/colony-2/shai/internal/shai/runtime"
+	configpkg "github.com/colony-2/shai/internal/shai/runtime/config"
+)
+
+// Config is a resolved shai config: the org, user and workspace layers
+// merged in that order.
+type Config = configpkg.Config
+
+// ConfigSource records the layer, file and line an entry was declared in.
+type ConfigSource = configpkg.Source
+
+// Config layer names reported by ConfigSource.
+const (
+	ConfigLayerDefault   = configpkg.LayerDefault
+	ConfigLayerOrg       = configpkg.LayerOrg
+	ConfigLayerUser      = configpkg.LayerUser
+	ConfigLayerWorkspace = configpkg.LayerWorkspace
+)
+
+// LoadConfig loads the layered config for workingDir. An empty configFile
+// uses <workingDir>/.shai/config.yaml for the workspace layer.
+func LoadConfig(workingDir, configFile string, vars map[string]string) (*Config, error) {
+	return runtimepkg.LoadConfig(workingDir, configFile, vars)
+}
+
+// ConfigVersion is the newest config format version.
+const ConfigVersion = configpkg.CurrentVersion
+
+// MigrateConfig rewrites config file contents to ConfigVersion, keeping
+// comments. It reports whether anything changed.
+func MigrateConfig(data []byte) ([]byte, bool, error) {
+	return configpkg.Migrate(data)
+}
+
+// ConfigValidationError lists every problem LoadConfig found in a config:
+// unknown fields, invalid values and missing settings.
+type ConfigValidationError = configpkg.ValidationError
+
+// ConfigSchema returns the JSON Schema for shai-sandbox config files.
+func ConfigSchema() ([]byte, error) {
+	return configpkg.JSONSchema()
+}
+
+// PolicyError lists every way a sandbox breaks the policy it is checked
+// against. NewSandbox returns it when the policy rejects a config.
+type PolicyError = configpkg.PolicyError
+
+// Explanation describes what a sandbox would start with and why: the apply
+// rules that matched, the chosen image, the merged resources with the set
+// each came from, and the bootstrap arguments.
+type Explanation = runtimepkg.Explanation
+
+// ExplainSandbox resolves cfg as NewSandbox would, without starting
+// anything.
+func ExplainSandbox(cfg SandboxConfig) (*Explanation, error) {
+	if err := cfg.normalize(); err != nil {
+		return nil, err
+	}
+	return runtimepkg.Explain(cfg.runtimeConfig())
+}
+
+// ExplainedEntry is a merged value and the resource set it came from.
+type ExplainedEntry = runtimepkg.ExplainedEntry
@@ -0,0 +1,21 @@
+package shai
+
+import (
+	runtimepkg "github.com/colony-2/shai/internal/shai/runtime"
+)
+
+// ExitError reports a non-zero exit of the sandbox or of an Exec'd command.
+// Run, SandboxSession.Wait and Exec return it; use errors.As to read the
+// status.
+type ExitError = runtimepkg.ExitError
+
+// ExitKind classifies an ExitError.
+type ExitKind = runtimepkg.ExitKind
+
+// Exit kinds.
+const (
+	ExitCommand   = runtimepkg.ExitCommand
+	ExitBootstrap = runtimepkg.ExitBootstrap
+	ExitOOM       = runtimepkg.ExitOOM
+	ExitSignal    = runtimepkg.ExitSignal
+)
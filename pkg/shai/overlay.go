@@ -0,0 +1,52 @@
+package shai
+
+import (
+	"context"
+	"io"
+
+	runtimepkg "github.com/colony-2/shai/internal/shai/runtime"
+)
+
+// ChangeKind says how a path in an overlay workspace differs from the
+// workspace.
+type ChangeKind = runtimepkg.ChangeKind
+
+const (
+	ChangeAdded    = runtimepkg.ChangeAdded
+	ChangeModified = runtimepkg.ChangeModified
+	ChangeDeleted  = runtimepkg.ChangeDeleted
+)
+
+// WorkspaceChange is a pending change left by a sandbox started with
+// SandboxConfig.Overlay.
+type WorkspaceChange = runtimepkg.WorkspaceChange
+
+// OverlayDir is the directory holding workspace's pending overlay changes.
+func OverlayDir(workspace string) (string, error) {
+	return runtimepkg.OverlayDir(workspace)
+}
+
+// WorkspaceChanges lists the pending overlay changes to workspace at or
+// under paths, or all of them, leaving out everything under .shai, which is
+// never applied.
+func WorkspaceChanges(workspace string, paths []string) ([]WorkspaceChange, error) {
+	return runtimepkg.WorkspaceChanges(workspace, paths)
+}
+
+// WriteWorkspaceDiff writes the pending changes at or under paths, or all
+// of them, as a unified diff against workspace.
+func WriteWorkspaceDiff(w io.Writer, workspace string, paths []string) error {
+	return runtimepkg.WriteWorkspaceDiff(w, workspace, paths)
+}
+
+// ApplyWorkspaceChanges copies the pending changes at or under paths into
+// workspace and returns them. With no paths it applies all of them and
+// clears the overlay.
+func ApplyWorkspaceChanges(ctx context.Context, workspace string, paths []string) ([]WorkspaceChange, error) {
+	return runtimepkg.ApplyWorkspaceChanges(ctx, workspace, paths)
+}
+
+// DiscardWorkspaceChanges drops workspace's pending overlay changes.
+func DiscardWorkspaceChanges(ctx context.Context, workspace string) error {
+	return runtimepkg.DiscardWorkspaceChanges(ctx, workspace)
+}
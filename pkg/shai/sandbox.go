@@ -2,6 +2,7 @@ package shai
 
 import (
 	"context"
+	"errors"
 
 	runtimepkg "github.com/colony-2/shai/internal/shai/runtime"
 )
@@ -36,6 +37,16 @@ func (s *SandboxSession) Stop(ctx context.Context) error {
 	return s.session.Stop(ctx)
 }
 
+// Exec runs a command in the sandbox as its user, in the workspace and with
+// the same environment as the main command, and waits for it to exit. With
+// no command it starts the user's login shell.
+func (s *SandboxSession) Exec(ctx context.Context, exec SandboxExec) error {
+	if s == nil || s.session == nil {
+		return errors.New("sandbox session is not running")
+	}
+	return s.session.Exec(ctx, *convertExec(&exec))
+}
+
 // Close releases session resources.
 func (s *SandboxSession) Close() error {
 	if s == nil || s.session == nil {
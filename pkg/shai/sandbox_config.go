@@ -28,14 +28,49 @@ type SandboxConfig struct {
 	HostGID             string
 	Privileged          bool
 	ShowProgress        bool
+	// ApprovalHook is a shell command that decides calls requiring
+	// approval when no one is at the terminal; exit status 0 approves.
+	ApprovalHook string
+	// ApprovalAllowlist names a file of pre-approved calls.
+	ApprovalAllowlist string
+	// AuditDir receives the per-session call audit log; see CallAuditDir.
+	AuditDir string
+	// PolicyFile names the policy the sandbox must satisfy. Empty uses
+	// $SHAI_POLICY, then /etc/shai/policy.yaml.
+	PolicyFile string
+	// Trust decides whether to run a workspace config that has not been
+	// trusted with `shai trust`. Nil refuses such configs.
+	Trust func(TrustRequest) TrustDecision
+	// SecretProviders replaces the built-in providers for vars that read
+	// from file, command or keyring, keyed by that field name.
+	SecretProviders map[string]SecretProvider
+	// Name names the sandbox so it can be found with AttachSandbox and
+	// RemoveSandbox. It must pass ValidateSandboxName.
+	Name string
+	// Detach leaves the terminal unattached and keeps the container after
+	// it exits, for a later AttachSandbox or RemoveSandbox. It needs a Name.
+	Detach bool
+	// Version identifies the program starting the sandbox; ListSandboxes
+	// reports it.
+	Version string
+	// Overlay makes the whole workspace writable while keeping the changes
+	// out of it until ApplyWorkspaceChanges; see WorkspaceChanges. It
+	// can't be combined with ReadWritePaths.
+	Overlay bool
 }
 
-// SandboxExec describes a command to run inside the sandbox after setup.
+// SandboxExec describes a command to run inside the sandbox after setup,
+// or in a running sandbox with SandboxSession.Exec or ExecSandbox.
 type SandboxExec struct {
 	Command []string
 	Env     map[string]string
 	Workdir string
 	UseTTY  bool
+	// Stdin, Stdout and Stderr connect a command started with Exec; nil
+	// uses the process's own. PostSetupExec ignores them.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
 }
 
 // SandboxConfigOption mutates a SandboxConfig during construction.
@@ -127,6 +162,75 @@ func WithGracefulStopTimeout(d time.Duration) SandboxConfigOption {
 	}
 }
 
+// WithApprovalHook sets the command consulted for calls that require approval.
+func WithApprovalHook(command string) SandboxConfigOption {
+	return func(cfg *SandboxConfig) {
+		cfg.ApprovalHook = command
+	}
+}
+
+// WithApprovalAllowlist sets the file of pre-approved calls.
+func WithApprovalAllowlist(path string) SandboxConfigOption {
+	return func(cfg *SandboxConfig) {
+		cfg.ApprovalAllowlist = path
+	}
+}
+
+// WithAuditDir sets the directory for call audit logs.
+func WithAuditDir(dir string) SandboxConfigOption {
+	return func(cfg *SandboxConfig) {
+		cfg.AuditDir = dir
+	}
+}
+
+// WithPolicyFile sets the policy the sandbox is checked against.
+func WithPolicyFile(path string) SandboxConfigOption {
+	return func(cfg *SandboxConfig) {
+		cfg.PolicyFile = path
+	}
+}
+
+// WithTrust supplies the decision for workspace configs that are not in the
+// trust store.
+func WithTrust(decide func(TrustRequest) TrustDecision) SandboxConfigOption {
+	return func(cfg *SandboxConfig) {
+		cfg.Trust = decide
+	}
+}
+
+// WithSecretProvider replaces the provider for vars that read from source,
+// one of SecretFile, SecretCommand or SecretKeyring.
+func WithSecretProvider(source string, provider SecretProvider) SandboxConfigOption {
+	return func(cfg *SandboxConfig) {
+		if cfg.SecretProviders == nil {
+			cfg.SecretProviders = map[string]SecretProvider{}
+		}
+		cfg.SecretProviders[source] = provider
+	}
+}
+
+// WithName names the sandbox.
+func WithName(name string) SandboxConfigOption {
+	return func(cfg *SandboxConfig) {
+		cfg.Name = name
+	}
+}
+
+// WithDetach runs the sandbox without attaching the terminal.
+func WithDetach(detach bool) SandboxConfigOption {
+	return func(cfg *SandboxConfig) {
+		cfg.Detach = detach
+	}
+}
+
+// WithOverlay collects the sandbox's writes in an overlay for review
+// instead of writing to the workspace.
+func WithOverlay(overlay bool) SandboxConfigOption {
+	return func(cfg *SandboxConfig) {
+		cfg.Overlay = overlay
+	}
+}
+
 func (cfg SandboxConfig) runtimeConfig() runtimepkg.EphemeralConfig {
 	normalized := cfg
 	_ = normalized.normalize()
@@ -147,6 +251,16 @@ func (cfg SandboxConfig) runtimeConfig() runtimepkg.EphemeralConfig {
 		HostGID:             normalized.HostGID,
 		Privileged:          normalized.Privileged,
 		ShowProgress:        normalized.ShowProgress,
+		ApprovalHook:        normalized.ApprovalHook,
+		ApprovalAllowlist:   normalized.ApprovalAllowlist,
+		AuditDir:            normalized.AuditDir,
+		PolicyFile:          normalized.PolicyFile,
+		Trust:               normalized.Trust,
+		SecretProviders:     normalized.SecretProviders,
+		Name:                normalized.Name,
+		Detach:              normalized.Detach,
+		Version:             normalized.Version,
+		Overlay:             normalized.Overlay,
 	}
 }
 
@@ -159,6 +273,9 @@ func convertExec(exec *SandboxExec) *runtimepkg.ExecSpec {
 		Env:     exec.Env,
 		Workdir: exec.Workdir,
 		UseTTY:  exec.UseTTY,
+		Stdin:   exec.Stdin,
+		Stdout:  exec.Stdout,
+		Stderr:  exec.Stderr,
 	}
 }
 
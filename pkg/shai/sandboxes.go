@@ -0,0 +1,74 @@
+package shai
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"time"
+
+	runtimepkg "github.com/colony-2/shai/internal/shai/runtime"
+)
+
+// Labels set on every sandbox container.
+const (
+	LabelSandbox      = runtimepkg.LabelSandbox
+	LabelName         = runtimepkg.LabelName
+	LabelBootstrapDir = runtimepkg.LabelBootstrapDir
+	LabelWorkspace    = runtimepkg.LabelWorkspace
+	LabelReadWrite    = runtimepkg.LabelReadWrite
+	LabelResourceSets = runtimepkg.LabelResourceSets
+	LabelImage        = runtimepkg.LabelImage
+	LabelVersion      = runtimepkg.LabelVersion
+	LabelOverlay      = runtimepkg.LabelOverlay
+)
+
+// SandboxInfo describes a sandbox container; see ListSandboxes.
+type SandboxInfo = runtimepkg.SandboxInfo
+
+// ValidateSandboxName reports whether name can be used as SandboxConfig.Name.
+func ValidateSandboxName(name string) error {
+	return runtimepkg.ValidateSandboxName(name)
+}
+
+// ListSandboxes returns the running sandboxes, newest first. all includes
+// stopped sandboxes whose containers were kept.
+func ListSandboxes(ctx context.Context, all bool) ([]SandboxInfo, error) {
+	return runtimepkg.ListSandboxes(ctx, all)
+}
+
+// AttachSandbox connects the terminal to a running sandbox, found by name
+// or container ID. It returns when the sandbox exits or the terminal
+// detaches.
+func AttachSandbox(ctx context.Context, ref string) error {
+	return runtimepkg.AttachSandbox(ctx, ref)
+}
+
+// ExecSandbox runs a command in a running sandbox, found by name or
+// container ID, as SandboxSession.Exec does.
+func ExecSandbox(ctx context.Context, ref string, exec SandboxExec) error {
+	return runtimepkg.ExecSandbox(ctx, ref, *convertExec(&exec))
+}
+
+// StopSandbox stops a running sandbox, found by name or container ID,
+// killing it after timeout.
+func StopSandbox(ctx context.Context, ref string, timeout time.Duration) error {
+	return runtimepkg.StopSandbox(ctx, ref, timeout)
+}
+
+// SandboxLogs copies a sandbox's output to stdout and stderr, following it
+// until the sandbox exits when follow is set.
+func SandboxLogs(ctx context.Context, ref string, follow bool, stdout, stderr io.Writer) error {
+	return runtimepkg.SandboxLogs(ctx, ref, follow, stdout, stderr)
+}
+
+// RemoveSandbox deletes a sandbox, found by name or container ID. A running
+// sandbox is only removed with force.
+func RemoveSandbox(ctx context.Context, ref string, force bool) error {
+	return runtimepkg.RemoveSandbox(ctx, ref, force)
+}
+
+// SandboxLogPath is the file that receives the output of the process
+// supervising a detached sandbox.
+func SandboxLogPath(name string) string {
+	return filepath.Join(runtimepkg.SandboxStateDir(), name+".log")
+}
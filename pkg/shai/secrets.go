@@ -0,0 +1,23 @@
+package shai
+
+import (
+	runtimepkg "github.com/colony-2/shai/internal/shai/runtime"
+	configpkg "github.com/colony-2/shai/internal/shai/runtime/config"
+)
+
+// SecretProvider resolves the reference in a var's file, command or keyring
+// field to the secret's value; see WithSecretProvider.
+type SecretProvider = runtimepkg.SecretProvider
+
+// SecretProviderFunc adapts a function to SecretProvider.
+type SecretProviderFunc = runtimepkg.SecretProviderFunc
+
+// Secret sources a SecretProvider can replace.
+const (
+	SecretFile    = configpkg.SecretFile
+	SecretCommand = configpkg.SecretCommand
+	SecretKeyring = configpkg.SecretKeyring
+)
+
+// SecretsDir is the tmpfs in the sandbox that holds vars delivered as files.
+const SecretsDir = configpkg.SecretsDir
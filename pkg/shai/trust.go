@@ -0,0 +1,40 @@
+package shai
+
+import (
+	runtimepkg "github.com/colony-2/shai/internal/shai/runtime"
+)
+
+// TrustDecision answers a TrustRequest; see WithTrust.
+type TrustDecision = runtimepkg.TrustDecision
+
+// Trust decisions.
+const (
+	TrustDeny   = runtimepkg.TrustDeny
+	TrustOnce   = runtimepkg.TrustOnce
+	TrustAlways = runtimepkg.TrustAlways
+)
+
+// TrustRequest describes a workspace config that is not trusted in its
+// current form, with a summary of the settings that reach the host.
+type TrustRequest = runtimepkg.TrustRequest
+
+// UntrustedConfigError is returned by NewSandbox for an untrusted workspace
+// config when no trust decision was supplied.
+type UntrustedConfigError = runtimepkg.UntrustedConfigError
+
+// ReviewConfig loads the workspace config and reports whether it is trusted.
+// The request is nil when there is no workspace config.
+func ReviewConfig(workingDir, configFile string, vars map[string]string) (*TrustRequest, bool, error) {
+	return runtimepkg.ReviewConfig(workingDir, configFile, vars)
+}
+
+// TrustConfig records the request's config in the trust store.
+func TrustConfig(req TrustRequest) error {
+	return runtimepkg.TrustConfig(req)
+}
+
+// RevokeTrust removes the config at path from the trust store and reports
+// whether it was there.
+func RevokeTrust(path string) (bool, error) {
+	return runtimepkg.RevokeTrust(path)
+}